@@ -0,0 +1,20 @@
+package geminiwebtools
+
+import "context"
+
+// auditIDKey is the context key of the audit ID.
+type auditIDKey struct{}
+
+// ContextWithAuditID returns a context whose searches and fetches echo auditID in the
+// AuditID field of their result metadata, so that results can be linked to the
+// originating request in the caller's audit records. The ID is never sent upstream.
+func ContextWithAuditID(ctx context.Context, auditID string) context.Context {
+	return context.WithValue(ctx, auditIDKey{}, auditID)
+}
+
+// AuditIDFromContext returns the audit ID set with ContextWithAuditID, or an empty
+// string if none is set.
+func AuditIDFromContext(ctx context.Context) string {
+	auditID, _ := ctx.Value(auditIDKey{}).(string)
+	return auditID
+}
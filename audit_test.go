@@ -0,0 +1,64 @@
+package geminiwebtools
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuditIDInMetadata(t *testing.T) {
+	const auditID = "audit-7f3a"
+	var calls atomic.Int32
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), auditID) {
+			t.Errorf("Expected the audit ID not to be sent upstream, got %s", body)
+		}
+		writeCodeAssistText(w, "result")
+	})
+
+	client, err := NewClient(
+		WithCredentialStore(&mockTokenStore{}),
+		WithCache(10, time.Minute),
+		func(c *Config) { c.CodeAssistEndpoint = codeAssist.URL },
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx := ContextWithAuditID(context.Background(), auditID)
+	search, err := client.Search(ctx, "golang")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if search.Metadata.AuditID != auditID {
+		t.Errorf("Search AuditID = %q, want %q", search.Metadata.AuditID, auditID)
+	}
+	fetch, err := client.Fetch(ctx, "Summarize https://example.com")
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if fetch.Metadata.AuditID != auditID {
+		t.Errorf("Fetch AuditID = %q, want %q", fetch.Metadata.AuditID, auditID)
+	}
+
+	// Cached results carry the audit ID of the request that is served, not the first one
+	cached, err := client.Search(ContextWithAuditID(context.Background(), "audit-other"), "golang")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if !cached.Metadata.CacheHit || cached.Metadata.AuditID != "audit-other" {
+		t.Errorf("Expected a cache hit with the new audit ID, got %+v", cached.Metadata)
+	}
+	if cached, _ := client.Search(context.Background(), "golang"); cached.Metadata.AuditID != "" {
+		t.Errorf("Expected no audit ID without one in the context, got %q", cached.Metadata.AuditID)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected 2 upstream calls, got %d", got)
+	}
+}
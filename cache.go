@@ -0,0 +1,161 @@
+package geminiwebtools
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// resultCache is a least recently used cache of search or fetch results whose entries
+// expire after a TTL. It is safe for concurrent use.
+type resultCache[T any] struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // of *cacheEntry[T], most recently used first
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a cached result with its key and expiry.
+type cacheEntry[T any] struct {
+	key     string
+	value   T
+	expires time.Time
+}
+
+// newResultCache creates a cache holding at most size entries for ttl each. Non-positive
+// values select constants.DefaultCacheSize and constants.DefaultCacheTTL.
+func newResultCache[T any](size int, ttl time.Duration) *resultCache[T] {
+	if size <= 0 {
+		size = constants.DefaultCacheSize
+	}
+	if ttl <= 0 {
+		ttl = constants.DefaultCacheTTL
+	}
+	return &resultCache[T]{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// newConfiguredCache creates the result cache described by the configuration, or
+// returns nil if caching is disabled.
+func newConfiguredCache[T any](config *Config) *resultCache[T] {
+	if !config.CacheEnabled {
+		return nil
+	}
+	return newResultCache[T](config.CacheSize, config.CacheTTL)
+}
+
+// get returns the cached value of key, if it has not expired. A nil cache has no entries.
+func (c *resultCache[T]) get(key string) (T, bool) {
+	var zero T
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	entry := element.Value.(*cacheEntry[T])
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// put caches value under key, evicting the least recently used entry if the cache is full.
+func (c *resultCache[T]) put(key string, value T) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry[T])
+		entry.value = value
+		entry.expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry[T]{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry[T]).key)
+	}
+}
+
+// clear removes every entry.
+func (c *resultCache[T]) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.entries)
+}
+
+// cacheKey returns a hash of the parts identifying a cached result.
+func cacheKey(parts ...string) string {
+	data, _ := json.Marshal(parts)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeCacheURL returns the URL in a form that is the same for equivalent URLs: the
+// scheme and host are lowercased and the fragment, which is never sent, is dropped.
+func normalizeCacheURL(rawURL string) string {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return rawURL
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	return parsed.String()
+}
+
+// cloneSearchResult returns a copy of a search result that shares no sources with it,
+// so that callers cannot modify cached results.
+func cloneSearchResult(result *types.WebSearchResult) *types.WebSearchResult {
+	clone := *result
+	clone.Sources = slices.Clone(result.Sources)
+	if result.Candidates != nil {
+		clone.Candidates = make([]types.SearchCandidate, len(result.Candidates))
+		for i, candidate := range result.Candidates {
+			candidate.Sources = slices.Clone(candidate.Sources)
+			clone.Candidates[i] = candidate
+		}
+	}
+	return &clone
+}
+
+// cloneFetchResult returns a copy of a fetch result that shares no sources with it.
+func cloneFetchResult(result *types.WebFetchResult) *types.WebFetchResult {
+	clone := *result
+	clone.Sources = slices.Clone(result.Sources)
+	return &clone
+}
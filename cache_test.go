@@ -0,0 +1,122 @@
+package geminiwebtools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResultCache(t *testing.T) {
+	cache := newResultCache[string](2, time.Hour)
+	cache.put("a", "1")
+	cache.put("b", "2")
+	if _, ok := cache.get("a"); !ok { // a is now the most recently used
+		t.Fatal("Expected a to be cached")
+	}
+	cache.put("c", "3")
+	if _, ok := cache.get("b"); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+
+	cache.clear()
+	if _, ok := cache.get("a"); ok {
+		t.Error("Expected clear to remove every entry")
+	}
+
+	expiring := newResultCache[string](2, time.Millisecond)
+	expiring.put("a", "1")
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := expiring.get("a"); ok {
+		t.Error("Expected the entry to expire")
+	}
+
+	var disabled *resultCache[string]
+	disabled.put("a", "1")
+	if _, ok := disabled.get("a"); ok {
+		t.Error("Expected a nil cache to cache nothing")
+	}
+}
+
+func TestNormalizeCacheURL(t *testing.T) {
+	if a, b := normalizeCacheURL("HTTPS://Example.COM/Path?q=1#section"), normalizeCacheURL("https://example.com/Path?q=1"); a != b {
+		t.Errorf("Expected equivalent URLs to normalize alike, got %q and %q", a, b)
+	}
+	if a, b := normalizeCacheURL("https://example.com/Path"), normalizeCacheURL("https://example.com/path"); a == b {
+		t.Error("Expected paths to stay case-sensitive")
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	var calls atomic.Int32
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeCodeAssistText(w, fmt.Sprintf("answer %d", calls.Add(1)))
+	})
+
+	tests := []struct {
+		name     string
+		opts     []ConfigOption
+		wantHits bool
+	}{
+		{name: "disabled by default"},
+		{name: "enabled", opts: []ConfigOption{WithCache(10, time.Hour)}, wantHits: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			client, err := NewClient(func(c *Config) { *c = *newTestConfig(codeAssist.URL, tt.opts...) })
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+
+			first, err := client.Search(context.Background(), "golang")
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			second, err := client.Search(context.Background(), "  golang ")
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if second.Metadata.CacheHit != tt.wantHits || (second.Content == first.Content) != tt.wantHits {
+				t.Errorf("Second search: CacheHit=%v, content %q after %q", second.Metadata.CacheHit, second.Content, first.Content)
+			}
+			if first.Metadata.CacheHit {
+				t.Error("Expected the first search to miss the cache")
+			}
+
+			if _, err := client.Fetch(context.Background(), "Summarize https://example.com/page"); err != nil {
+				t.Fatalf("Fetch failed: %v", err)
+			}
+			fetched, err := client.Fetch(context.Background(), "Summarize https://example.com/page")
+			if err != nil {
+				t.Fatalf("Fetch failed: %v", err)
+			}
+			if fetched.Metadata.CacheHit != tt.wantHits {
+				t.Errorf("Second fetch: CacheHit=%v, want %v", fetched.Metadata.CacheHit, tt.wantHits)
+			}
+			other, err := client.Fetch(context.Background(), "Translate https://example.com/page")
+			if err != nil {
+				t.Fatalf("Fetch failed: %v", err)
+			}
+			if other.Metadata.CacheHit {
+				t.Error("Expected another prompt for the same URL to miss the cache")
+			}
+
+			client.ClearCache()
+			cleared, err := client.Search(context.Background(), "golang")
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if cleared.Metadata.CacheHit {
+				t.Error("Expected ClearCache to remove the cached search")
+			}
+		})
+	}
+}
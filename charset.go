@@ -0,0 +1,78 @@
+package geminiwebtools
+
+import (
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/unicode"
+
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// charsetInfo describes the charset text content was transcoded from. It is empty when
+// the content was not transcoded, such as UTF-8 or non-text content.
+type charsetInfo struct {
+	name       string
+	confidence string
+}
+
+// decodeToUTF8 transcodes a text response body to UTF-8. The charset declared in
+// contentType is used if present. Otherwise bodies that are already valid UTF-8 are
+// kept, and the encoding of other bodies is detected from byte order marks and, for
+// HTML, <meta charset> declarations, defaulting to windows-1252. Bodies that are not
+// text, or whose charset is unknown, are returned unchanged.
+func decodeToUTF8(body []byte, contentType string) []byte {
+	decoded, _ := decodeText(body, contentType)
+	return decoded
+}
+
+// decodeText is like decodeToUTF8 but also reports the charset the body was
+// transcoded from and whether it was declared, detected or guessed.
+func decodeText(body []byte, contentType string) ([]byte, charsetInfo) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !isTextMediaType(mediaType) {
+		return body, charsetInfo{}
+	}
+
+	if label := params["charset"]; label != "" {
+		encoding, name := charset.Lookup(label)
+		if encoding == nil || name == "utf-8" {
+			return body, charsetInfo{}
+		}
+		return decodeWith(body, encoding.NewDecoder().Bytes), charsetInfo{name: name, confidence: types.CharsetDeclared}
+	}
+
+	if utf8.Valid(body) {
+		return body, charsetInfo{}
+	}
+	encoding, name, certain := charset.DetermineEncoding(body, contentType)
+	if encoding == unicode.UTF8 {
+		return body, charsetInfo{}
+	}
+	// Without a byte order mark or <meta> declaration the default windows-1252 is
+	// assumed, so an uncertain windows-1252 result is reported as guessed
+	confidence := types.CharsetDetected
+	if !certain && name == "windows-1252" {
+		confidence = types.CharsetGuessed
+	}
+	return decodeWith(body, encoding.NewDecoder().Bytes), charsetInfo{name: name, confidence: confidence}
+}
+
+// decodeWith applies decode to body, returning body unchanged if decoding fails.
+func decodeWith(body []byte, decode func([]byte) ([]byte, error)) []byte {
+	decoded, err := decode(body)
+	if err != nil {
+		return body
+	}
+	return decoded
+}
+
+// isTextMediaType reports whether a media type carries text that may need transcoding.
+func isTextMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		mediaType == "application/xml" ||
+		mediaType == "application/json"
+}
@@ -0,0 +1,167 @@
+package geminiwebtools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// encodeFixture encodes UTF-8 text in the given encoding.
+func encodeFixture(t *testing.T, enc encoding.Encoding, text string) []byte {
+	t.Helper()
+	encoded, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatalf("Failed to encode fixture: %v", err)
+	}
+	return encoded
+}
+
+func TestDecodeToUTF8(t *testing.T) {
+	const japaneseText = "こんにちは、世界"
+	const latinText = "Café crème à la française"
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		want        string
+	}{
+		{
+			name:        "shift_jis declared in header",
+			body:        encodeFixture(t, japanese.ShiftJIS, japaneseText),
+			contentType: "text/html; charset=shift_jis",
+			want:        japaneseText,
+		},
+		{
+			name:        "iso-8859-1 declared in header",
+			body:        encodeFixture(t, charmap.ISO8859_1, latinText),
+			contentType: "text/plain; charset=ISO-8859-1",
+			want:        latinText,
+		},
+		{
+			name:        "euc-jp declared in meta tag",
+			body:        append([]byte(`<html><head><meta charset="euc-jp"></head><body>`), append(encodeFixture(t, japanese.EUCJP, japaneseText), "</body></html>"...)...),
+			contentType: "text/html",
+			want:        `<html><head><meta charset="euc-jp"></head><body>` + japaneseText + "</body></html>",
+		},
+		{
+			name:        "undeclared invalid UTF-8 falls back to detection",
+			body:        encodeFixture(t, charmap.Windows1252, latinText),
+			contentType: "text/plain",
+			want:        latinText,
+		},
+		{
+			name:        "undeclared valid UTF-8 is kept",
+			body:        []byte(japaneseText),
+			contentType: "text/html",
+			want:        japaneseText,
+		},
+		{
+			name:        "utf-8 declared",
+			body:        []byte(latinText),
+			contentType: "text/html; charset=utf-8",
+			want:        latinText,
+		},
+		{
+			name:        "unknown charset is kept",
+			body:        []byte("plain"),
+			contentType: "text/plain; charset=x-unknown",
+			want:        "plain",
+		},
+		{
+			name:        "binary content is kept",
+			body:        []byte{0xff, 0xd8, 0xff},
+			contentType: "image/jpeg",
+			want:        string([]byte{0xff, 0xd8, 0xff}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(decodeToUTF8(tt.body, tt.contentType)); got != tt.want {
+				t.Errorf("decodeToUTF8() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchContentDecodesCharset(t *testing.T) {
+	body := encodeFixture(t, japanese.ShiftJIS, "日本語のページ")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=shift_jis")
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	config := DefaultHTTPClientConfig()
+	config.AllowPrivateIPs = true
+	hc := NewHTTPClient(config)
+
+	content, _, size, err := hc.FetchContent(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("FetchContent returned error: %v", err)
+	}
+	if content != "日本語のページ" {
+		t.Errorf("Expected content decoded to UTF-8, got %q", content)
+	}
+	if size != len(body) {
+		t.Errorf("Expected size of the body as received (%d), got %d", len(body), size)
+	}
+}
+
+func TestFetchWithHTTPReportsCharset(t *testing.T) {
+	const latinText = "Café crème"
+	pages := map[string]struct {
+		contentType string
+		body        []byte
+	}{
+		"/declared": {"text/plain; charset=shift_jis", encodeFixture(t, japanese.ShiftJIS, "日本語のページ")},
+		"/meta":     {"text/html", append([]byte(`<html><head><meta charset="euc-jp"></head><body>`), encodeFixture(t, japanese.EUCJP, "日本語")...)},
+		"/guessed":  {"text/plain", encodeFixture(t, charmap.Windows1252, latinText)},
+		"/utf8":     {"text/plain; charset=utf-8", []byte(latinText)},
+		"/binary":   {"image/png", []byte{0x89, 'P', 'N', 'G', 0xff}},
+	}
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := pages[r.URL.Path]
+		w.Header().Set("Content-Type", p.contentType)
+		_, _ = w.Write(p.body)
+	}))
+	defer page.Close()
+
+	fetcher, err := NewWebFetcher(newTestConfig(""))
+	if err != nil {
+		t.Fatalf("NewWebFetcher returned error: %v", err)
+	}
+	baseURL := useTestPageServer(fetcher, page)
+
+	tests := []struct {
+		path           string
+		wantCharset    string
+		wantConfidence string
+	}{
+		{"/declared", "shift_jis", types.CharsetDeclared},
+		{"/meta", "euc-jp", types.CharsetDetected},
+		{"/guessed", "windows-1252", types.CharsetGuessed},
+		{"/utf8", "", ""},
+		{"/binary", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			result, err := fetcher.fetchWithHTTP(context.Background(), baseURL+tt.path, "", time.Now())
+			if err != nil {
+				t.Fatalf("fetchWithHTTP returned error: %v", err)
+			}
+			if result.Metadata.DetectedCharset != tt.wantCharset || result.Metadata.CharsetConfidence != tt.wantConfidence {
+				t.Errorf("Charset = %q (%q), want %q (%q)", result.Metadata.DetectedCharset, result.Metadata.CharsetConfidence, tt.wantCharset, tt.wantConfidence)
+			}
+		})
+	}
+}
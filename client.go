@@ -30,60 +30,307 @@ package geminiwebtools
 import (
 	"context"
 	"fmt"
+	"io"
 
 	"github.com/d-kuro/geminiwebtools/pkg/auth"
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
 	"github.com/d-kuro/geminiwebtools/pkg/types"
 )
 
 // Client provides a unified interface for web search and web fetch operations.
 type Client struct {
-	auth     *auth.SharedAuthenticator
-	searcher *WebSearcher
-	fetcher  *WebFetcher
-	config   *Config
+	auth       *auth.SharedAuthenticator
+	codeAssist *auth.CodeAssistClient
+	searcher   *WebSearcher
+	fetcher    *WebFetcher
+	config     *Config
+
+	// limiter bounds and counts concurrent operations
+	limiter *requestLimiter
 }
 
 // NewClient creates a new client with the provided configuration options.
 // If no options are provided, default configuration will be used.
 func NewClient(opts ...ConfigOption) (*Client, error) {
-	config := NewConfig(opts...)
+	config, err := NewConfigE(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(config, newOAuth2Authenticator(config))
+}
+
+// NewClientSharedAuth creates a new client that uses an existing OAuth2 authenticator.
+// Multiple clients created with the same authenticator share its token cache and its
+// single background refresh goroutine, which reduces goroutine and network overhead
+// in servers running many clients. The caller owns the authenticator and is responsible
+// for calling its Shutdown method once all clients using it are no longer needed.
+//
+// The authenticator keeps its own credential store, background pool and refresh retry
+// settings: WithCredentialStore, WithBackgroundPool and the token refresh part of
+// WithRetryPolicy do not affect it. WithRetryPolicy still applies to CodeAssist calls
+// and HTTP fallback fetches. No default credential store is created, so the credential
+// directory is neither required nor touched.
+func NewClientSharedAuth(oauth2Auth *auth.OAuth2Authenticator, opts ...ConfigOption) (*Client, error) {
+	if oauth2Auth == nil {
+		return nil, fmt.Errorf("authenticator cannot be nil")
+	}
+
+	// The default credential store would create the credential directory for nothing
+	config, err := newConfig(false, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(config, oauth2Auth)
+}
 
-	// Create OAuth2 authenticator and wrap with shared authenticator
-	oauth2Auth := auth.NewOAuth2Authenticator(config.OAuth2Config, config.CredentialStore)
+// newClient creates a client whose searcher and fetcher share the given authenticator
+// and a single CodeAssist client, so that both use the same session.
+func newClient(config *Config, oauth2Auth *auth.OAuth2Authenticator) (*Client, error) {
 	sharedAuth := auth.NewSharedAuthenticator(oauth2Auth)
+	codeAssist := newCodeAssistClient(config, oauth2Auth)
 
 	// Create web searcher
-	searcher, err := NewWebSearcher(config)
+	searcher, err := newWebSearcher(config, oauth2Auth, codeAssist)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create web fetcher
-	fetcher, err := NewWebFetcher(config)
+	fetcher, err := newWebFetcher(config, oauth2Auth, codeAssist)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Client{
-		auth:     sharedAuth,
-		searcher: searcher,
-		fetcher:  fetcher,
-		config:   config,
+		auth:       sharedAuth,
+		codeAssist: codeAssist,
+		searcher:   searcher,
+		fetcher:    fetcher,
+		config:     config,
+		limiter:    newRequestLimiter(config.MaxConcurrentRequests, config.FailFastOnConcurrencyLimit),
 	}, nil
 }
 
+// newOAuth2Authenticator creates an OAuth2 authenticator from the configuration,
+// applying the configured background pool and retry policy to its refresh settings.
+func newOAuth2Authenticator(config *Config) *auth.OAuth2Authenticator {
+	refreshConfig := auth.DefaultRefreshConfig()
+	refreshConfig.BackgroundPool = config.BackgroundPool
+	refreshConfig.Metrics = config.RefreshMetrics
+	refreshConfig.PersistState = config.PersistRefreshState
+	refreshConfig.ApplyRetryPolicy(config.RetryPolicy)
+	return auth.NewOAuth2AuthenticatorWithConfig(config.OAuth2Config, config.CredentialStore, refreshConfig)
+}
+
+// newCodeAssistClient creates a CodeAssist client from the configuration.
+func newCodeAssistClient(config *Config, oauth2Auth *auth.OAuth2Authenticator) *auth.CodeAssistClient {
+	codeAssist := auth.NewCodeAssistClient(
+		oauth2Auth,
+		config.CodeAssistEndpoint,
+		config.DefaultModel,
+	)
+	codeAssist.SetRetryPolicy(config.RetryPolicy)
+	codeAssist.SetWrapUntrustedContent(config.WebFetch.WrapUntrustedContent)
+	codeAssist.SetPassThroughEndUserID(config.PassThroughEndUserID)
+	codeAssist.SetRateLimit(config.RateLimit)
+	codeAssist.SetLogger(config.Logger)
+	codeAssist.SetDebugCurl(config.DebugCurl)
+	codeAssist.SetHTTPClient(config.HTTPClient)
+	if config.ProxyURL != "" {
+		proxy, err := newProxyFunc(config.ProxyURL)
+		if err != nil {
+			proxy = failingProxy(err)
+		}
+		codeAssist.SetProxy(proxy)
+	}
+	return codeAssist
+}
+
 // Search performs a web search using the configured AI model.
 // Follows gemini-cli interface: accepts a simple query string.
 func (c *Client) Search(ctx context.Context, query string) (*types.WebSearchResult, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	return c.searcher.Search(ctx, query)
 }
 
+// SearchWithOptions is like Search but applies the given options. See WebSearcher.SearchWithOptions.
+func (c *Client) SearchWithOptions(ctx context.Context, query string, opts types.SearchOptions) (*types.WebSearchResult, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.searcher.SearchWithOptions(ctx, query, opts)
+}
+
+// SearchWithModel is like Search but uses the given model for this search only.
+func (c *Client) SearchWithModel(ctx context.Context, query, model string) (*types.WebSearchResult, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.searcher.SearchWithModel(ctx, query, model)
+}
+
+// SearchWithHistory is like Search but sends the prior turns of a conversation before
+// the query. See WebSearcher.SearchWithHistory.
+func (c *Client) SearchWithHistory(ctx context.Context, history []types.Content, query string) (*types.WebSearchResult, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.searcher.SearchWithHistory(ctx, history, query)
+}
+
+// SearchStream is like Search but streams the display text. See WebSearcher.SearchStream.
+// The stream holds a concurrency slot until its channel is closed.
+func (c *Client) SearchStream(ctx context.Context, query string) (<-chan types.SearchDelta, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deltas, err := c.searcher.SearchStream(ctx, query)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	out := make(chan types.SearchDelta)
+	go func() {
+		defer release()
+		defer close(out)
+		for delta := range deltas {
+			select {
+			case out <- delta:
+			case <-ctx.Done():
+				// The search stops once ctx is done; drain it so that it can finish
+				for range deltas {
+				}
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// SearchChan is like Search but reports its progress as events, for UIs that consume
+// searches and fetches alike. See progressEvents for the events and the channel.
+func (c *Client) SearchChan(ctx context.Context, query string) (<-chan types.SearchEvent, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return progressEvents(release, func() (*types.WebSearchResult, error) {
+		return c.searcher.Search(ctx, query)
+	}, func(event progressEvent[*types.WebSearchResult]) types.SearchEvent {
+		return types.SearchEvent(event)
+	}), nil
+}
+
 // Fetch retrieves and processes web content using AI, with fallback to direct HTTP.
 // Follows gemini-cli interface: accepts a prompt containing URLs and processing instructions.
 func (c *Client) Fetch(ctx context.Context, prompt string) (*types.WebFetchResult, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	return c.fetcher.Fetch(ctx, prompt)
 }
 
+// FetchChan is like Fetch but reports its progress as events. See SearchChan.
+func (c *Client) FetchChan(ctx context.Context, prompt string) (<-chan types.FetchEvent, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return progressEvents(release, func() (*types.WebFetchResult, error) {
+		return c.fetcher.Fetch(ctx, prompt)
+	}, func(event progressEvent[*types.WebFetchResult]) types.FetchEvent {
+		return types.FetchEvent(event)
+	}), nil
+}
+
+// FetchWithModel is like Fetch but uses the given model for this fetch only.
+func (c *Client) FetchWithModel(ctx context.Context, prompt, model string) (*types.WebFetchResult, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.fetcher.FetchWithModel(ctx, prompt, model)
+}
+
+// FetchMultiple retrieves every URL in the prompt. See WebFetcher.FetchMultiple.
+func (c *Client) FetchMultiple(ctx context.Context, prompt string) ([]*types.WebFetchResult, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.fetcher.FetchMultiple(ctx, prompt)
+}
+
+// Generate sends a fully formed content generation request, with any combination of
+// tools, and returns the raw response. It bypasses the search and fetch processing:
+// no citations, fallbacks or retries on empty answers are applied. Use
+// auth.CodeAssistClient.CreateRequest to build a request. The request uses the
+// client's model and session unless req.Model is set, and is bounded by the AI
+// request timeout.
+func (c *Client) Generate(ctx context.Context, req *types.GenerateContentRequest) (*types.GenerateContentResponse, error) {
+	if req == nil {
+		return nil, fmt.Errorf("request cannot be nil")
+	}
+	if len(req.Contents) == 0 {
+		return nil, fmt.Errorf("request has no contents")
+	}
+
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, constants.AIRequestTimeout)
+	defer cancel()
+	return c.codeAssist.GenerateContent(ctx, req)
+}
+
+// ListModels returns the models available to the account. It fails with an error
+// wrapping auth.ErrUnsupported if the server does not expose a models list.
+func (c *Client) ListModels(ctx context.Context) ([]types.ModelInfo, error) {
+	return c.codeAssist.ListModels(ctx)
+}
+
+// ClearCache removes every cached search and fetch result. See WithCache.
+func (c *Client) ClearCache() {
+	c.searcher.ClearCache()
+	c.fetcher.ClearCache()
+}
+
+// Stats returns the number of operations in flight and the concurrency limit.
+func (c *Client) Stats() ClientStats {
+	return c.limiter.stats()
+}
+
+// CodeAssist returns the CodeAssist client shared by the searcher and fetcher, whose
+// request builders can be used to create requests for Generate.
+func (c *Client) CodeAssist() *auth.CodeAssistClient {
+	return c.codeAssist
+}
+
+// ResetHTTPClient closes the idle connections of the client used for direct HTTP
+// fetches and rebuilds its transport. See WebFetcher.ResetHTTPClient.
+func (c *Client) ResetHTTPClient() {
+	c.fetcher.ResetHTTPClient()
+}
+
 // IsAuthenticated checks if the client has valid authentication.
 func (c *Client) IsAuthenticated() bool {
 	return c.auth.IsAuthenticated()
@@ -101,11 +348,63 @@ func (c *Client) AuthenticateWithBrowser(ctx context.Context) error {
 	return c.auth.AuthenticateWithBrowser(ctx)
 }
 
+// AuthenticateManual performs browser-based OAuth2 authentication on remote machines,
+// where the browser opens on another host and cannot reach a local callback server. It
+// prints the authorization URL and reads the code, or the URL the browser was redirected
+// to, from codeReader, typically os.Stdin. See auth.OAuth2Authenticator.AuthenticateManual.
+func (c *Client) AuthenticateManual(ctx context.Context, codeReader io.Reader) error {
+	return c.auth.AuthenticateManual(ctx, codeReader)
+}
+
+// AuthenticateWithDeviceCode performs OAuth2 device authorization for headless
+// environments such as servers, containers and SSH sessions. It prints a code and a
+// URL to open on any device with a browser, and stores the token once the user has
+// authorized the request. See auth.OAuth2Authenticator.AuthenticateWithDeviceCode.
+func (c *Client) AuthenticateWithDeviceCode(ctx context.Context) error {
+	return c.auth.AuthenticateWithDeviceCode(ctx)
+}
+
 // ClearAuthentication removes stored authentication credentials.
 func (c *Client) ClearAuthentication() error {
 	return c.auth.ClearAuthentication()
 }
 
+// Revoke revokes the stored credentials with Google and removes them locally, so that
+// they can no longer be used. See auth.OAuth2Authenticator.RevokeToken.
+func (c *Client) Revoke(ctx context.Context) error {
+	return c.auth.Revoke(ctx)
+}
+
+// Model returns the model used for search and fetch requests.
+func (c *Client) Model() string {
+	return c.codeAssist.Model()
+}
+
+// SetModel switches the model used for subsequent search and fetch requests.
+// It returns an error if the model is empty.
+func (c *Client) SetModel(model string) error {
+	return c.codeAssist.SetModel(model)
+}
+
+// SessionID returns the session ID sent with every search and fetch request of this client.
+// The server uses it to keep grounding consistent across follow-up requests, so results
+// of later calls can build on sources found by earlier ones.
+func (c *Client) SessionID() string {
+	return c.codeAssist.SessionID()
+}
+
+// ResetSession starts a new session so that subsequent requests are no longer treated
+// as follow-ups of earlier ones. It returns the new session ID.
+func (c *Client) ResetSession() string {
+	return c.codeAssist.ResetSession()
+}
+
+// ResetProject forgets the CodeAssist project, including the one persisted next to the
+// credentials, so that the next request runs project discovery and onboarding again.
+func (c *Client) ResetProject() {
+	c.codeAssist.ResetProject()
+}
+
 // GetConfig returns the client configuration.
 func (c *Client) GetConfig() *Config {
 	return c.config
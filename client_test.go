@@ -1,12 +1,23 @@
 package geminiwebtools
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"golang.org/x/oauth2"
 
+	"github.com/d-kuro/geminiwebtools/pkg/auth"
 	"github.com/d-kuro/geminiwebtools/pkg/storage"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
 )
 
 // Mock credential store for testing
@@ -213,3 +224,445 @@ func TestClientWithCustomConfig(t *testing.T) {
 		t.Errorf("Expected max content size %d, got %d", customMaxSize, config.MaxContentSize)
 	}
 }
+
+func TestNewClientSharedAuth(t *testing.T) {
+	store := &mockClientCredentialStore{}
+	config := NewConfig(WithCredentialStore(store))
+	oauth2Auth := auth.NewOAuth2Authenticator(config.OAuth2Config, store)
+	defer oauth2Auth.Shutdown()
+
+	client1, err := NewClientSharedAuth(oauth2Auth, WithCredentialStore(store))
+	if err != nil {
+		t.Fatalf("Failed to create first client: %v", err)
+	}
+	client2, err := NewClientSharedAuth(oauth2Auth, WithCredentialStore(store), WithTimeout(30*time.Second))
+	if err != nil {
+		t.Fatalf("Failed to create second client: %v", err)
+	}
+
+	for i, client := range []*Client{client1, client2} {
+		if client.auth.GetOAuth2Authenticator() != oauth2Auth {
+			t.Errorf("client %d: client auth should use the shared authenticator", i+1)
+		}
+		if client.searcher.auth.GetOAuth2Authenticator() != oauth2Auth {
+			t.Errorf("client %d: searcher should use the shared authenticator", i+1)
+		}
+		if client.fetcher.auth.GetOAuth2Authenticator() != oauth2Auth {
+			t.Errorf("client %d: fetcher should use the shared authenticator", i+1)
+		}
+	}
+
+	// Authentication state is shared between clients
+	if client1.IsAuthenticated() || client2.IsAuthenticated() {
+		t.Fatal("Expected clients to be unauthenticated initially")
+	}
+	if err := store.StoreToken(&oauth2.Token{AccessToken: "test-token"}); err != nil {
+		t.Fatalf("Failed to store token: %v", err)
+	}
+	if !client1.IsAuthenticated() || !client2.IsAuthenticated() {
+		t.Error("Expected both clients to observe the shared authentication state")
+	}
+
+	if _, err := NewClientSharedAuth(nil); err == nil {
+		t.Error("Expected error for nil authenticator")
+	}
+}
+
+func TestNewClientAppliesBackgroundPool(t *testing.T) {
+	pool := auth.NewBackgroundPool(2)
+
+	client, err := NewClient(WithCredentialStore(&mockClientCredentialStore{}), WithBackgroundPool(pool))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	oauth2Auth := client.auth.GetOAuth2Authenticator()
+	defer oauth2Auth.Shutdown()
+
+	if oauth2Auth.GetRefreshConfig().BackgroundPool != pool {
+		t.Error("Expected authenticator to use the configured background pool")
+	}
+	if client.searcher.auth.GetOAuth2Authenticator() != oauth2Auth || client.fetcher.auth.GetOAuth2Authenticator() != oauth2Auth {
+		t.Error("Expected searcher and fetcher to share the client authenticator")
+	}
+}
+
+func TestClientSessionID(t *testing.T) {
+	var mu sync.Mutex
+	var sessionIDs []string
+
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Request struct {
+				SessionID string `json:"session_id"`
+			} `json:"request"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		mu.Lock()
+		sessionIDs = append(sessionIDs, req.Request.SessionID)
+		mu.Unlock()
+		writeCodeAssistText(w, "result")
+	})
+
+	client, err := NewClient(
+		WithCredentialStore(&mockTokenStore{}),
+		func(c *Config) { c.CodeAssistEndpoint = codeAssist.URL },
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	sessionID := client.SessionID()
+	if sessionID == "" {
+		t.Fatal("Expected a non-empty session ID")
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := client.Search(ctx, "golang"); err != nil {
+			t.Fatalf("Search %d failed: %v", i+1, err)
+		}
+	}
+	if _, err := client.Fetch(ctx, "Summarize https://example.com"); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	if len(sessionIDs) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(sessionIDs))
+	}
+	for i, id := range sessionIDs {
+		if id != sessionID {
+			t.Errorf("request %d: session ID = %q, want %q", i+1, id, sessionID)
+		}
+	}
+
+	newSessionID := client.ResetSession()
+	if newSessionID == "" || newSessionID == sessionID {
+		t.Errorf("Expected ResetSession to generate a new session ID, got %q", newSessionID)
+	}
+	if client.SessionID() != newSessionID {
+		t.Errorf("SessionID() = %q, want %q", client.SessionID(), newSessionID)
+	}
+
+	if _, err := client.Search(ctx, "golang"); err != nil {
+		t.Fatalf("Search after reset failed: %v", err)
+	}
+	if got := sessionIDs[len(sessionIDs)-1]; got != newSessionID {
+		t.Errorf("session ID after reset = %q, want %q", got, newSessionID)
+	}
+}
+
+func TestClientConversation(t *testing.T) {
+	var mu sync.Mutex
+	var sessionIDs []string
+
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Request struct {
+				SessionID string `json:"session_id"`
+			} `json:"request"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		mu.Lock()
+		sessionIDs = append(sessionIDs, req.Request.SessionID)
+		mu.Unlock()
+		writeCodeAssistText(w, "result")
+	})
+
+	client, err := NewClient(
+		WithCredentialStore(&mockTokenStore{}),
+		func(c *Config) { c.CodeAssistEndpoint = codeAssist.URL },
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	first, second := client.NewConversation(), client.NewConversation()
+	if first.SessionID() == "" || first.SessionID() == second.SessionID() || first.SessionID() == client.SessionID() {
+		t.Fatalf("Expected distinct session IDs, got %q, %q and the client's %q", first.SessionID(), second.SessionID(), client.SessionID())
+	}
+
+	ctx := context.Background()
+	// The same query is not answered from the cache of another conversation
+	if _, err := first.Search(ctx, "golang"); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if _, err := second.Search(ctx, "golang"); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if _, err := first.Fetch(ctx, "Summarize https://example.com"); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if _, err := client.Search(ctx, "golang"); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	want := []string{first.SessionID(), second.SessionID(), first.SessionID(), client.SessionID()}
+	if len(sessionIDs) != len(want) {
+		t.Fatalf("Expected %d requests, got %d", len(want), len(sessionIDs))
+	}
+	for i, id := range sessionIDs {
+		if id != want[i] {
+			t.Errorf("request %d: session ID = %q, want %q", i+1, id, want[i])
+		}
+	}
+
+	if resumed := client.ResumeConversation(first.SessionID()); resumed.SessionID() != first.SessionID() {
+		t.Errorf("ResumeConversation() session ID = %q, want %q", resumed.SessionID(), first.SessionID())
+	}
+}
+
+func TestClientModel(t *testing.T) {
+	var mu sync.Mutex
+	var models []string
+
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		mu.Lock()
+		models = append(models, req.Model)
+		mu.Unlock()
+		writeCodeAssistText(w, "result")
+	})
+
+	client, err := NewClient(
+		WithCredentialStore(&mockTokenStore{}),
+		WithModel("gemini-2.5-pro"),
+		func(c *Config) { c.CodeAssistEndpoint = codeAssist.URL },
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if client.Model() != "gemini-2.5-pro" {
+		t.Errorf("Model() = %q, want %q", client.Model(), "gemini-2.5-pro")
+	}
+
+	ctx := context.Background()
+	if _, err := client.Search(ctx, "golang"); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if _, err := client.SearchWithModel(ctx, "golang", "gemini-2.5-flash"); err != nil {
+		t.Fatalf("SearchWithModel failed: %v", err)
+	}
+	if _, err := client.FetchWithModel(ctx, "Summarize https://example.com", "gemini-2.5-flash"); err != nil {
+		t.Fatalf("FetchWithModel failed: %v", err)
+	}
+	if err := client.SetModel("gemini-2.5-flash-lite"); err != nil {
+		t.Fatalf("SetModel failed: %v", err)
+	}
+	if _, err := client.Fetch(ctx, "Summarize https://example.com"); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	want := []string{"gemini-2.5-pro", "gemini-2.5-flash", "gemini-2.5-flash", "gemini-2.5-flash-lite"}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(models, ",") != strings.Join(want, ",") {
+		t.Errorf("Requested models = %q, want %q", models, want)
+	}
+
+	if err := client.SetModel(""); err == nil {
+		t.Error("Expected SetModel to reject an empty model")
+	}
+	if _, err := client.SearchWithModel(ctx, "golang", " "); err == nil {
+		t.Error("Expected SearchWithModel to reject an empty model")
+	}
+	if _, err := client.FetchWithModel(ctx, "Summarize https://example.com", ""); err == nil {
+		t.Error("Expected FetchWithModel to reject an empty model")
+	}
+	if _, err := NewConfigE(WithCredentialStore(&mockTokenStore{}), WithModel("")); err == nil {
+		t.Error("Expected NewConfigE to reject an empty model")
+	}
+}
+
+func TestClientGenerate(t *testing.T) {
+	var tools []map[string]any
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Request struct {
+				Tools []map[string]any `json:"tools"`
+			} `json:"request"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		tools = req.Request.Tools
+		writeCodeAssistText(w, "combined answer")
+	})
+
+	client, err := NewClient(
+		WithCredentialStore(&mockTokenStore{}),
+		func(c *Config) { c.CodeAssistEndpoint = codeAssist.URL },
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	req := client.CodeAssist().CreateRequest("Compare https://go.dev with recent news about Go",
+		types.Tool{GoogleSearch: &types.GoogleSearchTool{}},
+		types.Tool{URLContext: &types.URLContextTool{}},
+	)
+	resp, err := client.Generate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if got := resp.Candidates[0].Content.Parts[0].Text; got != "combined answer" {
+		t.Errorf("Unexpected response text %q", got)
+	}
+
+	if len(tools) != 2 {
+		t.Fatalf("Expected 2 tools in the request, got %v", tools)
+	}
+	if _, ok := tools[0]["googleSearch"]; !ok {
+		t.Errorf("Expected the first tool to be googleSearch, got %v", tools[0])
+	}
+	if _, ok := tools[1]["urlContext"]; !ok {
+		t.Errorf("Expected the second tool to be urlContext, got %v", tools[1])
+	}
+
+	if _, err := client.Generate(context.Background(), nil); err == nil {
+		t.Error("Expected Generate to reject a nil request")
+	}
+	if _, err := client.Generate(context.Background(), &types.GenerateContentRequest{}); err == nil {
+		t.Error("Expected Generate to reject a request without contents")
+	}
+}
+
+func TestClientEndUserID(t *testing.T) {
+	var mu sync.Mutex
+	var labels []map[string]string
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Request struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"request"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		mu.Lock()
+		labels = append(labels, req.Request.Labels)
+		mu.Unlock()
+		writeGroundedCodeAssistText(w, "answer")
+	})
+
+	for _, passThrough := range []bool{false, true} {
+		client, err := NewClient(
+			WithCredentialStore(&mockTokenStore{}),
+			WithPassThroughEndUserID(passThrough),
+			func(c *Config) { c.CodeAssistEndpoint = codeAssist.URL },
+		)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		ctx := auth.WithEndUserID(context.Background(), "user-42")
+		if _, err := client.Search(ctx, "query"); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if _, err := client.Search(context.Background(), "query"); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(labels) != 4 {
+		t.Fatalf("Expected 4 requests, got %d", len(labels))
+	}
+	hashed := labels[0]["end_user_id"]
+	if len(hashed) != 32 || strings.Contains(hashed, "user-42") {
+		t.Errorf("Expected a hashed end-user ID by default, got %q", hashed)
+	}
+	if labels[2]["end_user_id"] != "user-42" {
+		t.Errorf("Expected the end-user ID to be passed through, got %v", labels[2])
+	}
+	if labels[1] != nil || labels[3] != nil {
+		t.Errorf("Expected no labels without an end-user ID, got %v and %v", labels[1], labels[3])
+	}
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	codeAssist := http.NewServeMux()
+	codeAssist.HandleFunc("/v1internal:loadCodeAssist", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"cloudaicompanionProject": "test-project"})
+	})
+	codeAssist.HandleFunc("/v1internal:onboardUser", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	})
+	codeAssist.HandleFunc("/v1internal:generateContent", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-access-token" {
+			t.Errorf("Authorization = %q, want the stored token", got)
+		}
+		writeCodeAssistText(w, "search answer")
+	})
+
+	// Serve every request in process, without network access
+	var hosts []string
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		recorder := httptest.NewRecorder()
+		if req.URL.Host == "codeassist.test" {
+			codeAssist.ServeHTTP(recorder, req)
+		} else {
+			_, _ = recorder.WriteString("page content")
+		}
+		return recorder.Result(), nil
+	})
+
+	client, err := NewClient(
+		WithCredentialStore(&mockTokenStore{}),
+		WithHTTPClient(&http.Client{Transport: transport}),
+		func(c *Config) { c.CodeAssistEndpoint = "https://codeassist.test" },
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	result, err := client.Search(context.Background(), "golang")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if !strings.Contains(result.Content, "search answer") {
+		t.Errorf("Unexpected search content %q", result.Content)
+	}
+
+	fetched, err := client.fetcher.fetchWithHTTP(context.Background(), "https://example.com/page", "", time.Now())
+	if err != nil {
+		t.Fatalf("fetchWithHTTP failed: %v", err)
+	}
+	if !strings.Contains(fetched.Content, "page content") {
+		t.Errorf("Unexpected fetch content %q", fetched.Content)
+	}
+
+	if len(hosts) == 0 || hosts[len(hosts)-1] != "example.com" || !slices.Contains(hosts, "codeassist.test") {
+		t.Errorf("Expected every request to use the custom client, got hosts %v", hosts)
+	}
+}
+
+func TestNewClientSharedAuthUnwritableHome(t *testing.T) {
+	// A home below a regular file cannot be created, not even by root
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	t.Setenv("HOME", filepath.Join(file, "home"))
+
+	store := &mockClientCredentialStore{}
+	if _, err := NewClient(); err == nil {
+		t.Fatal("Expected NewClient to fail creating the default credential store")
+	}
+	oauth2Auth := auth.NewOAuth2Authenticator(NewConfig(WithCredentialStore(store)).OAuth2Config, store)
+	defer oauth2Auth.Shutdown()
+
+	if _, err := NewClientSharedAuth(oauth2Auth); err != nil {
+		t.Fatalf("NewClientSharedAuth returned error: %v", err)
+	}
+}
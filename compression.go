@@ -0,0 +1,115 @@
+package geminiwebtools
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// ErrDecompressionLimit is returned when a compressed response body expands beyond the
+// decompression limits of the HTTP fallback, as decompression bombs do.
+var ErrDecompressionLimit = errors.New("decompressed content exceeds the decompression limit")
+
+// decompressBody wraps body in readers that undo the given Content-Encoding. Multiple
+// encodings are undone in reverse order of application. An empty or identity encoding
+// returns body unchanged; an unsupported encoding is an error.
+func decompressBody(body io.Reader, contentEncoding string) (io.Reader, error) {
+	encodings := strings.Split(contentEncoding, ",")
+	reader := body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		var err error
+		switch encoding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(reader)
+		case "deflate":
+			reader, err = newDeflateReader(reader)
+		case "br":
+			reader = brotli.NewReader(reader)
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s content: %w", encoding, err)
+		}
+	}
+	return reader, nil
+}
+
+// newDeflateReader returns a reader for "deflate" content. The encoding is defined as
+// zlib-wrapped deflate, but some servers send raw deflate data, so the zlib header is
+// checked before choosing the reader.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements io.Reader.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// limitedDecoder fails with ErrDecompressionLimit as soon as the content decoded from
+// compressed exceeds maxSize bytes, or exceeds maxRatio times the compressed bytes
+// read once more than constants.DecompressionRatioMinSize bytes are decoded. The limits
+// are checked while streaming, so a decompression bomb is never held in memory.
+type limitedDecoder struct {
+	r          io.Reader
+	compressed *countingReader
+	decoded    int64
+	maxRatio   int64
+	maxSize    int64
+}
+
+// newLimitedDecoder returns a limitedDecoder of r, which decodes compressed. Limits of
+// zero or less use constants.DefaultMaxDecompressionRatio and
+// constants.DefaultMaxDecompressedSize.
+func newLimitedDecoder(r io.Reader, compressed *countingReader, maxRatio int, maxSize int64) *limitedDecoder {
+	if maxRatio <= 0 {
+		maxRatio = constants.DefaultMaxDecompressionRatio
+	}
+	if maxSize <= 0 {
+		maxSize = constants.DefaultMaxDecompressedSize
+	}
+	return &limitedDecoder{r: r, compressed: compressed, maxRatio: int64(maxRatio), maxSize: maxSize}
+}
+
+// Read implements io.Reader.
+func (d *limitedDecoder) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.decoded += int64(n)
+	if d.decoded > d.maxSize {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrDecompressionLimit, d.maxSize)
+	}
+	if d.decoded > constants.DecompressionRatioMinSize && d.decoded > d.maxRatio*d.compressed.n {
+		return n, fmt.Errorf("%w: %d bytes decoded from %d bytes, more than %d times the compressed size",
+			ErrDecompressionLimit, d.decoded, d.compressed.n, d.maxRatio)
+	}
+	return n, err
+}
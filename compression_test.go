@@ -0,0 +1,162 @@
+package geminiwebtools
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressFixture compresses data with the writer returned by newWriter.
+func compressFixture(t *testing.T, data string, newWriter func(io.Writer) io.WriteCloser) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatalf("Failed to compress fixture: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to compress fixture: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetchContentDecompression(t *testing.T) {
+	const page = "<html><body>Compressed page content</body></html>"
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{name: "identity", encoding: "", body: []byte(page)},
+		{
+			name:     "gzip",
+			encoding: "gzip",
+			body:     compressFixture(t, page, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }),
+		},
+		{
+			name:     "deflate",
+			encoding: "deflate",
+			body:     compressFixture(t, page, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }),
+		},
+		{
+			name:     "raw deflate",
+			encoding: "deflate",
+			body: compressFixture(t, page, func(w io.Writer) io.WriteCloser {
+				fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+				return fw
+			}),
+		},
+		{
+			name:     "brotli",
+			encoding: "br",
+			body:     compressFixture(t, page, func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var acceptEncoding string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+				w.Header().Set("Content-Type", "text/html")
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				_, _ = w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			config := DefaultHTTPClientConfig()
+			config.AllowPrivateIPs = true
+			content, _, size, err := NewHTTPClient(config).FetchContent(context.Background(), server.URL)
+			if err != nil {
+				t.Fatalf("FetchContent returned error: %v", err)
+			}
+			if content != page {
+				t.Errorf("Content = %q, want %q", content, page)
+			}
+			if size != len(page) {
+				t.Errorf("Size = %d, want the decoded size %d", size, len(page))
+			}
+			if acceptEncoding != "gzip, deflate, br" {
+				t.Errorf("Accept-Encoding = %q, want %q", acceptEncoding, "gzip, deflate, br")
+			}
+		})
+	}
+}
+
+func TestFetchContentDecompressedSizeLimit(t *testing.T) {
+	page := strings.Repeat("a", 10000)
+	body := compressFixture(t, page, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	config := DefaultHTTPClientConfig()
+	config.AllowPrivateIPs = true
+	config.MaxContentSize = 1000
+	content, _, size, err := NewHTTPClient(config).FetchContent(context.Background(), server.URL)
+	if err == nil || !strings.Contains(err.Error(), "content truncated") {
+		t.Fatalf("Expected the decoded content to be truncated, got %v", err)
+	}
+	if len(content) != 1000 || size != 1000 {
+		t.Errorf("Expected 1000 decoded bytes, got content of %d bytes and size %d", len(content), size)
+	}
+}
+
+func TestFetchContentDecompressionLimit(t *testing.T) {
+	// 20 MiB of zeros compress to about 20 KiB, a ratio of about 1000
+	bomb := compressFixture(t, strings.Repeat("\x00", 20*1024*1024), func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(bomb)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		maxRatio int
+		maxSize  int64
+		wantErr  bool
+	}{
+		{name: "default ratio", wantErr: true},
+		{name: "absolute limit", maxRatio: 10000, maxSize: 2 * 1024 * 1024, wantErr: true},
+		{name: "generous limits", maxRatio: 10000, maxSize: 1 << 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultHTTPClientConfig()
+			config.AllowPrivateIPs = true
+			config.MaxContentSize = 30 * 1024 * 1024
+			config.MaxDecompressionRatio = tt.maxRatio
+			config.MaxDecompressedSize = tt.maxSize
+
+			content, _, _, err := NewHTTPClient(config).FetchContent(context.Background(), server.URL)
+			if got := errors.Is(err, ErrDecompressionLimit); got != tt.wantErr {
+				t.Fatalf("FetchContent returned %v, want ErrDecompressionLimit: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(content) != 20*1024*1024 {
+				t.Errorf("Expected the full content, got %d bytes", len(content))
+			}
+		})
+	}
+}
+
+func TestDecompressBodyUnsupportedEncoding(t *testing.T) {
+	if _, err := decompressBody(strings.NewReader("data"), "compress"); err == nil {
+		t.Error("Expected an error for an unsupported encoding")
+	}
+}
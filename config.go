@@ -3,11 +3,19 @@
 package geminiwebtools
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/d-kuro/geminiwebtools/pkg/auth"
 	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/retry"
 	"github.com/d-kuro/geminiwebtools/pkg/storage"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
 )
 
 // Config holds all configuration options for the web tools library.
@@ -26,18 +34,93 @@ type Config struct {
 	Timeout        time.Duration `json:"timeout,omitempty"`
 	MaxContentSize int           `json:"maxContentSize,omitempty"`
 
-	// Cache Configuration (for future extension)
+	// ProxyURL routes HTTP fallback fetches, CodeAssist calls and the token refreshes
+	// they trigger through an http, https or socks5 proxy. Hosts listed in the
+	// NO_PROXY environment variable are connected to directly.
+	ProxyURL string `json:"proxyUrl,omitempty"`
+
+	// RetryPolicy controls retries of token refreshes, CodeAssist calls and
+	// HTTP fallback fetches. If nil, CodeAssist calls and fetches are not retried
+	// and token refreshes keep the retries of auth.DefaultRefreshConfig.
+	RetryPolicy *retry.RetryPolicy `json:"retryPolicy,omitempty"`
+
+	// CacheEnabled makes Search and Fetch reuse the results of identical earlier calls,
+	// for at most CacheSize results of CacheTTL each. Failed calls are not cached.
 	CacheEnabled bool          `json:"cacheEnabled,omitempty"`
 	CacheSize    int           `json:"cacheSize,omitempty"`
 	CacheTTL     time.Duration `json:"cacheTTL,omitempty"`
 
+	// HTTPClient replaces the HTTP clients of CodeAssist calls and of pages fetched
+	// directly over HTTP, for instrumented or test transports. Its transport is used
+	// as it is: ProxyURL, the private IP checks and certificate pins of the fallback
+	// and HTTP/2 downgrades do not apply. If nil, the built-in pooled clients are used.
+	HTTPClient *http.Client `json:"-"`
+
+	// Logger receives diagnostic messages, such as HTTP/2 downgrades of the HTTP
+	// fallback and the warning about enabled file:// URLs. If nil, nothing is logged.
+	Logger *slog.Logger `json:"-"`
+
+	// DebugCurl logs every CodeAssist call as a curl command that replays it, at debug
+	// level to Logger, to reproduce issues against the server directly. The commands
+	// contain the request bodies but not the access token, which the Authorization
+	// header reads from the ACCESS_TOKEN environment variable. Nothing is logged unless
+	// Logger has debug level enabled. Keep it disabled in production.
+	DebugCurl bool `json:"debugCurl,omitempty"`
+
 	// Credential Storage
 	CredentialStore storage.CredentialStore `json:"-"` // Not serialized
 
+	// Account selects a named account of the default credential directory, using a
+	// storage.MultiAccountStore. Empty selects the default account, whose token file is
+	// shared with gemini-cli. It cannot be combined with a custom CredentialStore.
+	Account string `json:"account,omitempty"`
+
+	// BackgroundPool bounds concurrent background work (such as token refreshes)
+	// across every authenticator created with this pool. Share one pool between
+	// configurations to bound background work process-wide. Nil means unbounded.
+	BackgroundPool *auth.BackgroundPool `json:"-"` // Not serialized
+
+	// RefreshMetrics optionally receives token refresh durations and grace period uses.
+	RefreshMetrics auth.RefreshMetrics `json:"-"` // Not serialized
+
+	// PersistRefreshState keeps the token refresh state across restarts.
+	// See auth.RefreshConfig.PersistState.
+	PersistRefreshState bool `json:"persistRefreshState,omitempty"`
+
+	// PassThroughEndUserID sends the end-user IDs set with auth.WithEndUserID as
+	// given instead of hashing them. See auth.CodeAssistClient.SetPassThroughEndUserID.
+	PassThroughEndUserID bool `json:"passThroughEndUserId,omitempty"`
+
+	// MaxConcurrentRequests limits how many searches, fetches and generate calls a
+	// Client runs at the same time. Further calls wait for a slot, or fail with
+	// ErrConcurrencyLimit if FailFastOnConcurrencyLimit is set. Zero means unlimited.
+	MaxConcurrentRequests      int  `json:"maxConcurrentRequests,omitempty"`
+	FailFastOnConcurrencyLimit bool `json:"failFastOnConcurrencyLimit,omitempty"`
+
+	// RateLimit limits the rate and concurrency of CodeAssist calls, to stay within
+	// the per-minute quota of the server. Calls beyond the limits wait until they may
+	// proceed or their context is done. If nil, CodeAssist calls are not limited.
+	RateLimit *auth.RateLimitConfig `json:"rateLimit,omitempty"`
+
+	// MaxOperationTime bounds the total time of each search and fetch, including AI
+	// attempts, token refreshes, retries and the HTTP fallback. It takes precedence
+	// over the inner timeouts: they still apply, but none can extend an operation past
+	// its budget. A search stream is closed when its budget runs out. Waiting for a
+	// concurrency slot is not counted. Zero means unlimited, the default.
+	MaxOperationTime time.Duration `json:"maxOperationTime,omitempty"`
+
 	// Processing Configuration
 	CitationStyle string `json:"citationStyle,omitempty"`
 	MaxSources    int    `json:"maxSources,omitempty"`
 
+	// MaxDisplayLength limits the DisplayText of search and fetch results to this
+	// many runes, ending truncated text with an ellipsis. The limit is applied after
+	// citation markers and the sources list are inserted, so markers are placed
+	// against the full text and none is orphaned by cutting the text it cites; the
+	// sources list at the end may be cut. Content and Sources are not affected.
+	// Zero or less means unlimited, the default.
+	MaxDisplayLength int `json:"maxDisplayLength,omitempty"`
+
 	// Tool-specific Configuration
 	WebFetch  WebFetchConfig  `json:"webFetch,omitempty"`
 	WebSearch WebSearchConfig `json:"webSearch,omitempty"`
@@ -46,17 +129,134 @@ type Config struct {
 // WebFetchConfig holds WebFetch-specific configuration options.
 type WebFetchConfig struct {
 	// Content processing options
+	// ConvertHTML converts HTML pages fetched by the HTTP fallback to markdown
 	ConvertHTML     bool `json:"convertHtml,omitempty"`
 	TruncateContent bool `json:"truncateContent,omitempty"`
 	TruncateLength  int  `json:"truncateLength,omitempty"`
 
+	// ExtractPDF replaces the raw bytes of PDF documents fetched by the HTTP fallback
+	// with their extracted text. Encrypted and image-only documents yield a short
+	// notice instead of an error.
+	ExtractPDF bool `json:"extractPdf,omitempty"`
+
+	// Sanitize strips control and zero-width characters, normalizes Unicode
+	// whitespace and collapses blank lines in extracted text
+	Sanitize bool `json:"sanitize,omitempty"`
+
 	// Security options
 	AllowPrivateIPs bool `json:"allowPrivateIps,omitempty"`
 	FollowRedirects bool `json:"followRedirects,omitempty"`
 
+	// WrapUntrustedContent frames fetched content as untrusted data in the prompt sent
+	// to the AI, asking the model not to follow instructions found in it. This is a
+	// best-effort defense against prompt injection, not a guarantee.
+	WrapUntrustedContent bool `json:"wrapUntrustedContent,omitempty"`
+
+	// PinnedCertHashes maps hosts to allowed SPKI SHA-256 hashes (base64) for
+	// the HTTP fallback. See HTTPClientConfig.PinnedCertHashes.
+	PinnedCertHashes map[string][]string `json:"pinnedCertHashes,omitempty"`
+
+	// LargePages makes the fetcher download pages itself and send their content to the
+	// model inline instead of relying on the URL context tool, which may truncate very
+	// large pages. Content larger than one request is split into chunks that are
+	// summarized one by one and then synthesized into the final answer.
+	LargePages bool `json:"largePages,omitempty"`
+
+	// LargePageChunkSize is the maximum encoded size in bytes of page content sent per
+	// request in large page mode. If zero, constants.DefaultLargePageChunkSize is used.
+	// It is capped so that every request stays within constants.MaxAPIRequestSize.
+	LargePageChunkSize int `json:"largePageChunkSize,omitempty"`
+
+	// RewriteGitHubBlob makes pages fetched directly over HTTP (the fallback, large page
+	// mode and FetchOutline) download GitHub blob URLs from raw.githubusercontent.com.
+	// Disable it to get the rendered GitHub page instead. The AI path is not affected:
+	// the model retrieves the URL as given in the prompt.
+	RewriteGitHubBlob bool `json:"rewriteGitHubBlob,omitempty"`
+
+	// MaxURLs is the maximum number of URLs extracted from a prompt, in document order.
+	// URLs beyond the limit are ignored. Zero or less means no limit.
+	MaxURLs int `json:"maxUrls,omitempty"`
+
+	// DeduplicateContent makes FetchMultiple drop the fallback results whose content is
+	// a near duplicate of another result's, such as the AMP or mobile variant of a page.
+	// The longest content of each group is kept and lists the dropped URLs in
+	// WebFetchMetadata.DuplicateURLs. Contents are near duplicates if the Jaccard
+	// similarity of their word shingles is at least DeduplicationThreshold, or
+	// constants.DefaultDeduplicationThreshold if zero.
+	DeduplicateContent     bool    `json:"deduplicateContent,omitempty"`
+	DeduplicationThreshold float64 `json:"deduplicationThreshold,omitempty"`
+
+	// IncludePromptInDisplay frames the DisplayText of HTTP fallback results with the
+	// page URL and the user request. Disable it to get only the content. The Content
+	// field never includes the framing.
+	IncludePromptInDisplay bool `json:"includePromptInDisplay,omitempty"`
+
+	// AllowFileScheme permits file:// URLs for local testing of the processing pipeline.
+	// Files are read directly, without the AI, and only from within FileSchemeRoot;
+	// paths and symbolic links leading outside of it are rejected. It is disabled by
+	// default and must never be enabled for untrusted prompts.
+	AllowFileScheme bool   `json:"allowFileScheme,omitempty"`
+	FileSchemeRoot  string `json:"fileSchemeRoot,omitempty"`
+
+	// RespectRobotsTxt makes the HTTP fallback check the site's robots.txt before
+	// fetching a page, and fail with a RobotsDisallowedError if the rules for the
+	// "geminiwebtools" user agent, or for "*", disallow it. The parsed robots.txt of
+	// each site is cached for RobotsTxtCacheTTL, or constants.DefaultRobotsTxtCacheTTL
+	// if zero. The AI path is not affected.
+	RespectRobotsTxt  bool          `json:"respectRobotsTxt,omitempty"`
+	RobotsTxtCacheTTL time.Duration `json:"robotsTxtCacheTTL,omitempty"`
+
+	// AllowedDomains restricts fetching to URLs whose hosts match one of these domains,
+	// if not empty. BlockedDomains rejects URLs whose hosts match one of them and takes
+	// precedence over AllowedDomains. A bare domain such as "example.com" matches the
+	// domain and its subdomains; "*.example.com" matches subdomains only. The policy
+	// applies to the requested URLs, their rewritten fallback URLs and redirects, and
+	// violations fail with a DomainPolicyError.
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+	BlockedDomains []string `json:"blockedDomains,omitempty"`
+
+	// UserAgent is the User-Agent header of pages fetched directly over HTTP. If
+	// empty, constants.DefaultUserAgent is used. It must not contain control characters.
+	UserAgent string `json:"userAgent,omitempty"`
+
+	// DebugHeaders records the request headers sent by the HTTP fallback in
+	// WebFetchMetadata.RequestHeaders, with sensitive values redacted
+	DebugHeaders bool `json:"debugHeaders,omitempty"`
+
+	// HonorRefresh makes the HTTP fallback follow Refresh response headers and
+	// <meta http-equiv="refresh"> elements like redirects. See HTTPClientConfig.HonorRefresh.
+	HonorRefresh bool `json:"honorRefresh,omitempty"`
+
+	// CookieJar keeps the cookies set by pages fetched by the HTTP fallback and sends
+	// them with later fetches. If nil, cookies are ignored. See HTTPClientConfig.CookieJar.
+	CookieJar http.CookieJar `json:"-"`
+
+	// MaxConcurrentDNSLookups bounds the host lookups in flight of the HTTP fallback.
+	// See HTTPClientConfig.MaxConcurrentDNSLookups.
+	MaxConcurrentDNSLookups int `json:"maxConcurrentDnsLookups,omitempty"`
+
+	// MaxDecompressionRatio and MaxDecompressedSize limit how far compressed pages of
+	// the HTTP fallback may expand. See HTTPClientConfig.MaxDecompressionRatio.
+	MaxDecompressionRatio int   `json:"maxDecompressionRatio,omitempty"`
+	MaxDecompressedSize   int64 `json:"maxDecompressedSize,omitempty"`
+
+	// AllowHTTP2Downgrade makes the HTTP fallback retry a fetch once over HTTP/1.1 when
+	// it fails with an HTTP/2 transport error. See HTTPClientConfig.AllowHTTP2Downgrade.
+	AllowHTTP2Downgrade bool `json:"allowHttp2Downgrade,omitempty"`
+
+	// ReturnPartialOnTimeout makes the HTTP fallback return the content received so
+	// far, marked with WebFetchMetadata.Partial, when its deadline expires while the
+	// body is being read. By default the content is discarded and the fetch fails.
+	ReturnPartialOnTimeout bool `json:"returnPartialOnTimeout,omitempty"`
+
 	// Fallback behavior
 	EnableFallback  bool          `json:"enableFallback,omitempty"`
 	FallbackTimeout time.Duration `json:"fallbackTimeout,omitempty"`
+
+	// ResultAcceptor decides whether a successful AI fetch result is usable.
+	// When it returns false the HTTP fallback is attempted as if the AI path had failed.
+	// If nil, DefaultResultAcceptor is used.
+	ResultAcceptor func(*types.WebFetchResult) bool `json:"-"`
 }
 
 // WebSearchConfig holds WebSearch-specific configuration options.
@@ -65,14 +265,38 @@ type WebSearchConfig struct {
 	MaxResults     int      `json:"maxResults,omitempty"`
 	DefaultDomains []string `json:"defaultDomains,omitempty"`
 
-	// Citation processing
+	// PreferredDomains are domains the search should favor. Unlike a domain
+	// filter this is a soft preference: the search instruction asks the model
+	// to prioritize these domains and matching sources are ranked first.
+	PreferredDomains []string `json:"preferredDomains,omitempty"`
+
+	// Citation processing. InsertCitations inserts numbered citation markers after
+	// the grounded segments of search and fetch answers; see
+	// GroundingProcessor.SetInsertCitations.
 	InsertCitations bool   `json:"insertCitations,omitempty"`
 	CitationFormat  string `json:"citationFormat,omitempty"`
+
+	// FaviconURL derives the favicon URL set on each search and fetch source.
+	// If nil, DirectFaviconURL is used.
+	FaviconURL FaviconURLFunc `json:"-"`
+
+	// RetryOnEmpty retries a search once, after a short backoff, when the
+	// response has no text or no grounding sources. Off by default, since
+	// each retry is another model request.
+	RetryOnEmpty bool `json:"retryOnEmpty,omitempty"`
 }
 
 // ConfigOption defines a functional option for configuring the Config.
 type ConfigOption func(*Config)
 
+// WithConfig starts from a copy of base, such as a configuration returned by
+// LoadConfigFromFile, instead of the defaults. Options after it still apply.
+func WithConfig(base *Config) ConfigOption {
+	return func(c *Config) {
+		*c = *base
+	}
+}
+
 // WithCredentialStore sets a custom credential store.
 func WithCredentialStore(store storage.CredentialStore) ConfigOption {
 	return func(c *Config) {
@@ -80,6 +304,14 @@ func WithCredentialStore(store storage.CredentialStore) ConfigOption {
 	}
 }
 
+// WithAccount selects the named account of the default credential directory, so that
+// several Google accounts can stay signed in side by side.
+func WithAccount(name string) ConfigOption {
+	return func(c *Config) {
+		c.Account = name
+	}
+}
+
 // WithTimeout sets the HTTP timeout.
 func WithTimeout(timeout time.Duration) ConfigOption {
 	return func(c *Config) {
@@ -87,6 +319,81 @@ func WithTimeout(timeout time.Duration) ConfigOption {
 	}
 }
 
+// WithProxy routes outgoing requests through the given http, https or socks5 proxy URL,
+// such as "http://proxy.example.com:8080" or "socks5://127.0.0.1:1080".
+// Hosts listed in the NO_PROXY environment variable are connected to directly.
+func WithProxy(proxyURL string) ConfigOption {
+	return func(c *Config) {
+		c.ProxyURL = proxyURL
+	}
+}
+
+// WithRedirectPort sets a fixed local port for the browser authentication callback,
+// for OAuth apps that only allow a fixed redirect URI. If the port is in use,
+// browser authentication fails instead of choosing another port.
+func WithRedirectPort(port int) ConfigOption {
+	return func(c *Config) {
+		c.OAuth2Config.RedirectPort = port
+	}
+}
+
+// WithInlineAuthSuccessPage makes browser authentication end on a local page that tries
+// to close its tab, instead of redirecting to Google's success page.
+func WithInlineAuthSuccessPage(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.OAuth2Config.InlineSuccessPage = enabled
+	}
+}
+
+// WithAuthLandingPages makes browser authentication end on the given HTML pages, served
+// locally by the callback, instead of redirecting to Google's success and failure pages.
+// An empty page keeps the default for that outcome.
+func WithAuthLandingPages(successHTML, failureHTML string) ConfigOption {
+	return func(c *Config) {
+		c.OAuth2Config.SuccessHTML = successHTML
+		c.OAuth2Config.FailureHTML = failureHTML
+	}
+}
+
+// WithAuthLandingURLs makes browser authentication redirect to the given pages instead
+// of Google's success and failure pages. An empty URL keeps the default for that outcome.
+func WithAuthLandingURLs(successURL, failureURL string) ConfigOption {
+	return func(c *Config) {
+		c.OAuth2Config.SuccessURL = successURL
+		c.OAuth2Config.FailureURL = failureURL
+	}
+}
+
+// WithOpenURLFunc replaces how browser authentication opens the authorization URL, for
+// environments where neither $BROWSER nor the default browser of the operating system
+// works. The function should return once the URL is launched.
+func WithOpenURLFunc(open func(url string) error) ConfigOption {
+	return func(c *Config) {
+		c.OAuth2Config.OpenURLFunc = open
+	}
+}
+
+// WithCache enables the result cache: Search and Fetch return the result of an identical
+// earlier call, with the same query or URL and prompt and the same model, instead of
+// calling the API again. At most size results are kept, the least recently used are
+// evicted first, and each result expires after ttl. Non-positive values select the
+// defaults of 100 results and constants.DefaultCacheTTL.
+func WithCache(size int, ttl time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.CacheEnabled = true
+		c.CacheSize = size
+		c.CacheTTL = ttl
+	}
+}
+
+// WithModel sets the model used for search and fetch requests.
+// NewConfigE returns an error if the model is empty.
+func WithModel(model string) ConfigOption {
+	return func(c *Config) {
+		c.DefaultModel = model
+	}
+}
+
 // WithMaxContentSize sets the maximum content size.
 func WithMaxContentSize(size int) ConfigOption {
 	return func(c *Config) {
@@ -94,10 +401,317 @@ func WithMaxContentSize(size int) ConfigOption {
 	}
 }
 
+// WithRetryPolicy sets the retry policy shared by token refresh, CodeAssist calls and HTTP fallback.
+func WithRetryPolicy(policy *retry.RetryPolicy) ConfigOption {
+	return func(c *Config) {
+		c.RetryPolicy = policy
+	}
+}
+
+// WithPreferredDomains sets domains that web search should favor.
+func WithPreferredDomains(domains ...string) ConfigOption {
+	return func(c *Config) {
+		c.WebSearch.PreferredDomains = domains
+	}
+}
+
+// WithMaxOperationTime bounds the total time of each search and fetch.
+func WithMaxOperationTime(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.MaxOperationTime = d
+	}
+}
+
+// WithMaxDisplayLength limits the display text of results to the given number of runes.
+func WithMaxDisplayLength(length int) ConfigOption {
+	return func(c *Config) {
+		c.MaxDisplayLength = length
+	}
+}
+
+// WithPersistRefreshState sets whether the token refresh state is kept across restarts
+// by the credential store, so that GetRefreshState shows refreshes that have been
+// failing before the process started.
+func WithPersistRefreshState(persist bool) ConfigOption {
+	return func(c *Config) {
+		c.PersistRefreshState = persist
+	}
+}
+
+// WithRefreshMetrics sets the receiver of token refresh measurements.
+func WithRefreshMetrics(metrics auth.RefreshMetrics) ConfigOption {
+	return func(c *Config) {
+		c.RefreshMetrics = metrics
+	}
+}
+
+// WithPassThroughEndUserID sets whether end-user IDs set with auth.WithEndUserID are
+// sent as given instead of hashed.
+func WithPassThroughEndUserID(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.PassThroughEndUserID = enabled
+	}
+}
+
+// WithMaxConcurrentRequests limits how many operations a Client runs at the same time.
+// Calls beyond the limit wait for a slot until their context is done. Zero means unlimited.
+func WithMaxConcurrentRequests(limit int) ConfigOption {
+	return func(c *Config) {
+		c.MaxConcurrentRequests = limit
+	}
+}
+
+// WithRateLimit limits CodeAssist calls to perMinute calls per minute and maxConcurrent
+// calls in flight. Calls beyond the limits wait until they may proceed or their context
+// is done. Zero disables either limit.
+func WithRateLimit(perMinute, maxConcurrent int) ConfigOption {
+	return func(c *Config) {
+		c.RateLimit = &auth.RateLimitConfig{
+			RequestsPerMinute: perMinute,
+			MaxConcurrent:     maxConcurrent,
+		}
+	}
+}
+
+// WithFailFastOnConcurrencyLimit makes calls beyond MaxConcurrentRequests fail
+// immediately with ErrConcurrencyLimit instead of waiting for a slot.
+func WithFailFastOnConcurrencyLimit(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.FailFastOnConcurrencyLimit = enabled
+	}
+}
+
+// WithInsertCitations sets whether numbered citation markers are inserted after the
+// grounded segments of search and fetch answers.
+func WithInsertCitations(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebSearch.InsertCitations = enabled
+	}
+}
+
+// WithRetryOnEmpty enables a single search retry when the response is empty or ungrounded.
+func WithRetryOnEmpty(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebSearch.RetryOnEmpty = enabled
+	}
+}
+
+// WithBackgroundPool sets a shared pool that bounds concurrent background operations.
+func WithBackgroundPool(pool *auth.BackgroundPool) ConfigOption {
+	return func(c *Config) {
+		c.BackgroundPool = pool
+	}
+}
+
+// WithFaviconURL sets the strategy used to derive favicon URLs for search and fetch sources.
+func WithFaviconURL(fn FaviconURLFunc) ConfigOption {
+	return func(c *Config) {
+		c.WebSearch.FaviconURL = fn
+	}
+}
+
+// WithWrapUntrustedContent sets whether fetched content is framed as untrusted data in AI prompts.
+func WithWrapUntrustedContent(wrap bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.WrapUntrustedContent = wrap
+	}
+}
+
+// WithLargePages enables large page mode, which sends page content to the model in chunks.
+func WithLargePages(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.LargePages = enabled
+	}
+}
+
+// WithFileScheme permits fetching file:// URLs of files within root, for testing the
+// processing pipeline against local fixtures. Do not use it with untrusted prompts.
+func WithFileScheme(root string) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.AllowFileScheme = true
+		c.WebFetch.FileSchemeRoot = root
+	}
+}
+
+// WithRespectRobotsTxt sets whether the HTTP fallback honors the robots.txt of the
+// sites it fetches from.
+func WithRespectRobotsTxt(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.RespectRobotsTxt = enabled
+	}
+}
+
+// WithAllowedDomains restricts fetching to the given domains and their subdomains.
+func WithAllowedDomains(domains ...string) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.AllowedDomains = domains
+	}
+}
+
+// WithBlockedDomains prevents fetching from the given domains and their subdomains.
+func WithBlockedDomains(domains ...string) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.BlockedDomains = domains
+	}
+}
+
+// WithUserAgent sets the User-Agent header of pages fetched directly over HTTP.
+func WithUserAgent(userAgent string) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.UserAgent = userAgent
+	}
+}
+
+// WithExtractPDF sets whether text is extracted from PDF documents fetched directly.
+func WithExtractPDF(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.ExtractPDF = enabled
+	}
+}
+
+// WithRewriteGitHubBlob sets whether GitHub blob URLs are fetched from their raw file
+// URLs when pages are downloaded directly over HTTP.
+func WithRewriteGitHubBlob(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.RewriteGitHubBlob = enabled
+	}
+}
+
+// WithMaxURLs sets the maximum number of URLs extracted from a fetch prompt.
+func WithMaxURLs(maxURLs int) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.MaxURLs = maxURLs
+	}
+}
+
+// WithDeduplicateContent sets whether FetchMultiple drops fallback results whose
+// content is a near duplicate of another result's.
+func WithDeduplicateContent(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.DeduplicateContent = enabled
+	}
+}
+
+// WithDeduplicationThreshold sets the minimum similarity, between 0 and 1, of contents
+// that WithDeduplicateContent treats as near duplicates.
+func WithDeduplicationThreshold(threshold float64) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.DeduplicationThreshold = threshold
+	}
+}
+
+// WithHonorRefresh sets whether the HTTP fallback follows refresh directives of pages.
+func WithHonorRefresh(honor bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.HonorRefresh = honor
+	}
+}
+
+// WithCookieJar sets the cookie jar of the HTTP fallback. Fetches of fetchers sharing
+// the jar send the cookies set by each other's pages.
+func WithCookieJar(jar http.CookieJar) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.CookieJar = jar
+	}
+}
+
+// WithHTTP2Downgrade sets whether the HTTP fallback retries fetches over HTTP/1.1
+// after HTTP/2 transport errors.
+func WithHTTP2Downgrade(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.AllowHTTP2Downgrade = enabled
+	}
+}
+
+// WithMaxConcurrentDNSLookups bounds the host lookups in flight of the HTTP fallback.
+// Zero or less uses constants.DefaultMaxConcurrentDNSLookups.
+func WithMaxConcurrentDNSLookups(limit int) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.MaxConcurrentDNSLookups = limit
+	}
+}
+
+// WithDecompressionLimits fails fallback fetches of compressed pages that decode to more
+// than maxRatio times their compressed size or to more than maxSize bytes. Zero keeps
+// the default of each limit.
+func WithDecompressionLimits(maxRatio int, maxSize int64) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.MaxDecompressionRatio = maxRatio
+		c.WebFetch.MaxDecompressedSize = maxSize
+	}
+}
+
+// WithHTTPClient sets the HTTP client of CodeAssist calls and direct fetches.
+func WithHTTPClient(client *http.Client) ConfigOption {
+	return func(c *Config) {
+		c.HTTPClient = client
+	}
+}
+
+// WithLogger sets the logger of diagnostic messages.
+func WithLogger(logger *slog.Logger) ConfigOption {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
+
+// WithDebugCurl sets whether CodeAssist calls are logged at debug level as curl
+// commands, with the access token replaced by a placeholder.
+func WithDebugCurl(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.DebugCurl = enabled
+	}
+}
+
+// WithReturnPartialOnTimeout sets whether the HTTP fallback returns the content
+// received before its deadline expired instead of failing.
+func WithReturnPartialOnTimeout(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.ReturnPartialOnTimeout = enabled
+	}
+}
+
+// WithIncludePromptInDisplay sets whether the display text of HTTP fallback results
+// repeats the page URL and the user request around the content.
+func WithIncludePromptInDisplay(include bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.IncludePromptInDisplay = include
+	}
+}
+
+// WithResultAcceptor sets the function that decides whether an AI fetch result is usable.
+func WithResultAcceptor(acceptor func(*types.WebFetchResult) bool) ConfigOption {
+	return func(c *Config) {
+		c.WebFetch.ResultAcceptor = acceptor
+	}
+}
+
 // NewConfig creates a new configuration with the provided options.
 // If no options are provided, returns a configuration with sensible defaults
 // that match the gemini-cli implementation behavior.
+//
+// NewConfig panics if no credential store is provided and the default filesystem
+// store cannot be created, for example when the home directory cannot be resolved,
+// or if an option sets an empty model. Use NewConfigE in environments where that may happen.
 func NewConfig(opts ...ConfigOption) *Config {
+	config, err := NewConfigE(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+// NewConfigE is like NewConfig but returns an error instead of panicking when the
+// default filesystem credential store cannot be created or the model is empty. The
+// default store is only created when no credential store is provided through
+// WithCredentialStore.
+func NewConfigE(opts ...ConfigOption) (*Config, error) {
+	return newConfig(true, opts...)
+}
+
+// newConfig implements NewConfigE. Without defaultStore, the default credential store
+// is not created, for clients whose authenticator brings its own store.
+func newConfig(defaultStore bool, opts ...ConfigOption) (*Config, error) {
 	// Start with default configuration
 	config := &Config{
 		// API endpoints (matching gemini-cli defaults)
@@ -106,11 +720,13 @@ func NewConfig(opts ...ConfigOption) *Config {
 
 		// OAuth2 configuration (matching gemini-cli)
 		OAuth2Config: auth.OAuth2Config{
-			ClientID:     constants.DefaultOAuthClientID,
-			ClientSecret: constants.DefaultOAuthClientSecret,
-			AuthURL:      constants.DefaultOAuthAuthURL,
-			TokenURL:     constants.DefaultOAuthTokenURL,
-			Scopes:       constants.DefaultOAuthScopes,
+			ClientID:      constants.DefaultOAuthClientID,
+			ClientSecret:  constants.DefaultOAuthClientSecret,
+			AuthURL:       constants.DefaultOAuthAuthURL,
+			TokenURL:      constants.DefaultOAuthTokenURL,
+			RevokeURL:     constants.DefaultOAuthRevokeURL,
+			DeviceAuthURL: constants.DefaultOAuthDeviceURL,
+			Scopes:        constants.DefaultOAuthScopes,
 		},
 
 		// Model configuration
@@ -122,7 +738,7 @@ func NewConfig(opts ...ConfigOption) *Config {
 
 		// Cache configuration (disabled by default for compatibility)
 		CacheEnabled: false,
-		CacheSize:    100,
+		CacheSize:    constants.DefaultCacheSize,
 		CacheTTL:     constants.DefaultCacheTTL,
 
 		// Processing configuration
@@ -131,13 +747,20 @@ func NewConfig(opts ...ConfigOption) *Config {
 
 		// WebFetch defaults (matching gemini-cli behavior)
 		WebFetch: WebFetchConfig{
-			ConvertHTML:     true,
-			TruncateContent: true,
-			TruncateLength:  constants.DefaultTruncateLength,
-			AllowPrivateIPs: false,
-			FollowRedirects: true,
-			EnableFallback:  true,
-			FallbackTimeout: constants.DefaultFallbackTimeout,
+			ConvertHTML:            true,
+			ExtractPDF:             true,
+			TruncateContent:        true,
+			TruncateLength:         constants.DefaultTruncateLength,
+			Sanitize:               true,
+			AllowPrivateIPs:        false,
+			FollowRedirects:        true,
+			WrapUntrustedContent:   true,
+			MaxURLs:                constants.DefaultMaxPromptURLs,
+			RewriteGitHubBlob:      true,
+			IncludePromptInDisplay: true,
+			EnableFallback:         true,
+			FallbackTimeout:        constants.DefaultFallbackTimeout,
+			UserAgent:              constants.DefaultUserAgent,
 		},
 
 		// WebSearch defaults
@@ -146,9 +769,6 @@ func NewConfig(opts ...ConfigOption) *Config {
 			InsertCitations: true,
 			CitationFormat:  constants.DefaultCitationStyle,
 		},
-
-		// Set default credential store (use filesystem store for gemini-cli compatibility)
-		CredentialStore: storage.MustNewFileSystemStore(""),
 	}
 
 	// Apply options
@@ -156,7 +776,41 @@ func NewConfig(opts ...ConfigOption) *Config {
 		opt(config)
 	}
 
-	return config
+	if validateModel(config.DefaultModel) != nil {
+		return nil, &ConfigError{Field: "DefaultModel", Message: constants.ValidationErrorEmpty}
+	}
+	if validateUserAgent(config.WebFetch.UserAgent) != nil {
+		return nil, &ConfigError{Field: "WebFetch.UserAgent", Message: constants.ValidationErrorControl}
+	}
+
+	// Set default credential store (use filesystem store for gemini-cli compatibility)
+	if !defaultStore {
+		return config, nil
+	}
+	if config.CredentialStore == nil {
+		store, err := defaultCredentialStore(config.Account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create default credential store: %w", err)
+		}
+		config.CredentialStore = store
+	} else if config.Account != "" {
+		return nil, &ConfigError{Field: "Account", Message: constants.ValidationErrorAccount}
+	}
+
+	return config, nil
+}
+
+// defaultCredentialStore creates the credential store of the account in the default
+// credential directory. An empty account uses the directory's own token file.
+func defaultCredentialStore(account string) (storage.CredentialStore, error) {
+	if account == "" {
+		return storage.NewFileSystemStore("")
+	}
+	accounts, err := storage.NewMultiAccountStore("")
+	if err != nil {
+		return nil, err
+	}
+	return accounts.Account(account)
 }
 
 // Validate ensures the configuration is valid and complete.
@@ -176,6 +830,57 @@ func (c *Config) Validate() error {
 	if c.CredentialStore == nil {
 		return &ConfigError{Field: "CredentialStore", Message: constants.ValidationErrorRequired}
 	}
+	if validateModel(c.DefaultModel) != nil {
+		return &ConfigError{Field: "DefaultModel", Message: constants.ValidationErrorEmpty}
+	}
+	if c.WebFetch.AllowFileScheme && c.WebFetch.FileSchemeRoot == "" {
+		return &ConfigError{Field: "WebFetch.FileSchemeRoot", Message: constants.ValidationErrorRequired}
+	}
+	if validateUserAgent(c.WebFetch.UserAgent) != nil {
+		return &ConfigError{Field: "WebFetch.UserAgent", Message: constants.ValidationErrorControl}
+	}
+	if t := c.WebFetch.DeduplicationThreshold; t < 0 || t > 1 {
+		return &ConfigError{Field: "WebFetch.DeduplicationThreshold", Message: constants.ValidationErrorRatio}
+	}
+	if c.WebFetch.MaxDecompressionRatio < 0 {
+		return &ConfigError{Field: "WebFetch.MaxDecompressionRatio", Message: constants.ValidationErrorNegative}
+	}
+	if c.WebFetch.MaxDecompressedSize < 0 {
+		return &ConfigError{Field: "WebFetch.MaxDecompressedSize", Message: constants.ValidationErrorNegative}
+	}
+	if rl := c.RateLimit; rl != nil && (rl.RequestsPerMinute < 0 || rl.Burst < 0 || rl.MaxConcurrent < 0) {
+		return &ConfigError{Field: "RateLimit", Message: constants.ValidationErrorNegative}
+	}
+	if c.ProxyURL != "" {
+		if _, err := parseProxyURL(c.ProxyURL); err != nil {
+			return &ConfigError{Field: "ProxyURL", Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// operationContext returns ctx bounded by MaxOperationTime, if set.
+func (c *Config) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.MaxOperationTime <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.MaxOperationTime)
+}
+
+// validateModel returns an error if the model name is empty.
+func validateModel(model string) error {
+	if strings.TrimSpace(model) == "" {
+		return fmt.Errorf("model cannot be empty")
+	}
+	return nil
+}
+
+// validateUserAgent returns an error if the user agent contains control characters,
+// which could split or corrupt the request header.
+func validateUserAgent(userAgent string) error {
+	if strings.IndexFunc(userAgent, unicode.IsControl) >= 0 {
+		return fmt.Errorf("user agent contains control characters")
+	}
 	return nil
 }
 
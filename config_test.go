@@ -1,12 +1,15 @@
 package geminiwebtools
 
 import (
+	"errors"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"golang.org/x/oauth2"
 
 	"github.com/d-kuro/geminiwebtools/pkg/auth"
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
 	"github.com/d-kuro/geminiwebtools/pkg/storage"
 )
 
@@ -142,6 +145,106 @@ func TestWithCredentialStore(t *testing.T) {
 	}
 }
 
+func TestNewConfigEHomeDirFailure(t *testing.T) {
+	// os.UserHomeDir fails on Unix when $HOME is not set
+	t.Setenv("HOME", "")
+
+	if _, err := NewConfigE(); err == nil {
+		t.Error("Expected error when the home directory cannot be resolved")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected NewConfig to panic when the home directory cannot be resolved")
+			}
+		}()
+		NewConfig()
+	}()
+
+	if _, err := NewClient(); err == nil {
+		t.Error("Expected NewClient to return an error when the home directory cannot be resolved")
+	}
+
+	store := &mockCredentialStore{}
+	config, err := NewConfigE(WithCredentialStore(store))
+	if err != nil {
+		t.Fatalf("Expected custom credential store to avoid the home directory, got: %v", err)
+	}
+	if config.CredentialStore != store {
+		t.Error("Expected credential store to be set correctly")
+	}
+}
+
+func TestWithRedirectPort(t *testing.T) {
+	config := NewConfig(WithCredentialStore(&mockCredentialStore{}), WithRedirectPort(8085))
+
+	if config.OAuth2Config.RedirectPort != 8085 {
+		t.Errorf("Expected redirect port 8085, got %d", config.OAuth2Config.RedirectPort)
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	config := NewConfig(WithCredentialStore(&mockCredentialStore{}))
+	if config.WebFetch.UserAgent != constants.DefaultUserAgent {
+		t.Errorf("Expected default user agent %q, got %q", constants.DefaultUserAgent, config.WebFetch.UserAgent)
+	}
+
+	config = NewConfig(WithCredentialStore(&mockCredentialStore{}), WithUserAgent("my-agent/1.0"))
+	if config.WebFetch.UserAgent != "my-agent/1.0" {
+		t.Errorf("Expected user agent my-agent/1.0, got %q", config.WebFetch.UserAgent)
+	}
+
+	for _, userAgent := range []string{"bad\r\nX-Injected: 1", "tab\tagent", "nul\x00"} {
+		_, err := NewConfigE(WithCredentialStore(&mockCredentialStore{}), WithUserAgent(userAgent))
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) || configErr.Field != "WebFetch.UserAgent" {
+			t.Errorf("NewConfigE with user agent %q returned %v, want a WebFetch.UserAgent error", userAgent, err)
+		}
+		config.WebFetch.UserAgent = userAgent
+		if err := config.Validate(); !errors.As(err, &configErr) || configErr.Field != "WebFetch.UserAgent" {
+			t.Errorf("Validate with user agent %q returned %v, want a WebFetch.UserAgent error", userAgent, err)
+		}
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	config := NewConfig(WithCredentialStore(&mockCredentialStore{}))
+	if config.RateLimit != nil {
+		t.Errorf("Expected no rate limit by default, got %+v", config.RateLimit)
+	}
+
+	config = NewConfig(WithCredentialStore(&mockCredentialStore{}), WithRateLimit(60, 4))
+	if rl := config.RateLimit; rl == nil || rl.RequestsPerMinute != 60 || rl.MaxConcurrent != 4 {
+		t.Errorf("Expected 60 calls per minute and 4 concurrent calls, got %+v", rl)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate returned error: %v", err)
+	}
+
+	config.RateLimit.MaxConcurrent = -1
+	var configErr *ConfigError
+	if err := config.Validate(); !errors.As(err, &configErr) || configErr.Field != "RateLimit" {
+		t.Errorf("Validate with a negative limit returned %v, want a RateLimit error", err)
+	}
+}
+
+func TestWithDecompressionLimits(t *testing.T) {
+	config := NewConfig(WithCredentialStore(&mockCredentialStore{}), WithDecompressionLimits(50, 1<<20))
+	if config.WebFetch.MaxDecompressionRatio != 50 || config.WebFetch.MaxDecompressedSize != 1<<20 {
+		t.Errorf("Unexpected decompression limits: %d and %d", config.WebFetch.MaxDecompressionRatio, config.WebFetch.MaxDecompressedSize)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate returned error: %v", err)
+	}
+
+	config.WebFetch.MaxDecompressedSize = -1
+	var configErr *ConfigError
+	if err := config.Validate(); !errors.As(err, &configErr) || configErr.Field != "WebFetch.MaxDecompressedSize" {
+		t.Errorf("Validate with a negative size returned %v, want a WebFetch.MaxDecompressedSize error", err)
+	}
+}
+
 func TestConfigValidate(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -261,3 +364,59 @@ func TestConfigError(t *testing.T) {
 		t.Errorf("Expected error message %q, got %q", expected, err.Error())
 	}
 }
+
+func TestWithAccount(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	config, err := NewConfigE(WithAccount("work"))
+	if err != nil {
+		t.Fatalf("NewConfigE returned error: %v", err)
+	}
+	if got, want := config.CredentialStore.GetStoragePath(), filepath.Join(home, constants.DefaultStorageDir, constants.AccountsDirName, "work"); got != want {
+		t.Errorf("Expected the store of the work account at %q, got %q", want, got)
+	}
+
+	config, err = NewConfigE()
+	if err != nil {
+		t.Fatalf("NewConfigE returned error: %v", err)
+	}
+	if got, want := config.CredentialStore.GetStoragePath(), filepath.Join(home, constants.DefaultStorageDir); got != want {
+		t.Errorf("Expected the default account at %q, got %q", want, got)
+	}
+
+	var configErr *ConfigError
+	if _, err := NewConfigE(WithAccount("work"), WithCredentialStore(&mockCredentialStore{})); !errors.As(err, &configErr) || configErr.Field != "Account" {
+		t.Errorf("Expected an Account error with a custom store, got %v", err)
+	}
+	if _, err := NewConfigE(WithAccount("../work")); err == nil {
+		t.Error("Expected an error for an invalid account name")
+	}
+}
+
+func TestWithInsertCitations(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		searcher, err := NewWebSearcher(NewConfig(WithCredentialStore(&mockCredentialStore{}), WithInsertCitations(enabled)))
+		if err != nil {
+			t.Fatalf("NewWebSearcher returned error: %v", err)
+		}
+		if got := searcher.Grounding().InsertCitations(); got != enabled {
+			t.Errorf("InsertCitations() = %v, want %v", got, enabled)
+		}
+	}
+}
+
+func TestDefaultRetryPolicy(t *testing.T) {
+	config := NewConfig(WithCredentialStore(&mockCredentialStore{}))
+	if config.RetryPolicy != nil {
+		t.Errorf("Expected no retries by default, got %+v", config.RetryPolicy)
+	}
+
+	// Token refreshes keep their own retries without a policy
+	refreshConfig := auth.DefaultRefreshConfig()
+	want := refreshConfig.RetryMaxAttempts
+	refreshConfig.ApplyRetryPolicy(config.RetryPolicy)
+	if refreshConfig.RetryMaxAttempts != want || want <= 1 {
+		t.Errorf("RetryMaxAttempts = %d, want the default %d", refreshConfig.RetryMaxAttempts, want)
+	}
+}
@@ -0,0 +1,88 @@
+package geminiwebtools
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// LoadConfigFromFile returns the default configuration of NewConfigE overridden by the
+// JSON file at path, whose keys are the json tags of Config. Fields missing from the
+// file keep their defaults; durations are given in nanoseconds. Fields that cannot be
+// serialized, such as CredentialStore, keep their defaults as well; an account named
+// in the file selects its credential store. The result is validated.
+func LoadConfigFromFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	config, err := NewConfigE()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	if config.Account != "" {
+		store, err := defaultCredentialStore(config.Account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create credential store of account %q: %w", config.Account, err)
+		}
+		config.CredentialStore = store
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadConfigFromEnv returns the default configuration of NewConfigE overridden by the
+// environment variables read by ApplyEnv.
+func LoadConfigFromEnv() (*Config, error) {
+	config, err := NewConfigE()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ApplyEnv overrides the configuration with the environment variables that are set
+// and not empty, and validates the result. Call it on the result of LoadConfigFromFile
+// to override a configuration file with the environment.
+//
+//	GEMINIWEBTOOLS_CODE_ASSIST_ENDPOINT  CodeAssistEndpoint
+//	GEMINIWEBTOOLS_MODEL                 DefaultModel
+//	GEMINIWEBTOOLS_TIMEOUT               Timeout, as a duration such as "30s"
+//	GEMINIWEBTOOLS_CLIENT_ID             OAuth2Config.ClientID
+//	GEMINIWEBTOOLS_CLIENT_SECRET         OAuth2Config.ClientSecret
+func (c *Config) ApplyEnv() error {
+	for name, field := range map[string]*string{
+		constants.EnvCodeAssistEndpoint: &c.CodeAssistEndpoint,
+		constants.EnvModel:              &c.DefaultModel,
+		constants.EnvClientID:           &c.OAuth2Config.ClientID,
+		constants.EnvClientSecret:       &c.OAuth2Config.ClientSecret,
+	} {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	if value := os.Getenv(constants.EnvTimeout); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return &ConfigError{Field: "Timeout", Message: fmt.Sprintf("invalid duration %q in %s", value, constants.EnvTimeout)}
+		}
+		c.Timeout = timeout
+	}
+
+	return c.Validate()
+}
@@ -0,0 +1,117 @@
+package geminiwebtools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/storage"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	path := writeConfigFile(t, `{
+		"codeAssistEndpoint": "https://codeassist.example.com",
+		"defaultModel": "gemini-2.5-pro",
+		"timeout": 5000000000,
+		"oauth2Config": {"clientId": "file-client"},
+		"webFetch": {"convertHtml": false, "userAgent": "file-agent/1.0"}
+	}`)
+	config, err := LoadConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile returned error: %v", err)
+	}
+
+	if config.CodeAssistEndpoint != "https://codeassist.example.com" || config.DefaultModel != "gemini-2.5-pro" || config.Timeout != 5*time.Second {
+		t.Errorf("File values were not applied: %+v", config)
+	}
+	if config.OAuth2Config.ClientID != "file-client" || config.WebFetch.ConvertHTML || config.WebFetch.UserAgent != "file-agent/1.0" {
+		t.Errorf("Nested file values were not applied: %+v", config)
+	}
+
+	// Fields missing from the file keep their defaults
+	if config.OAuth2Config.ClientSecret != constants.DefaultOAuthClientSecret || config.GeminiAPIEndpoint != constants.DefaultGeminiAPIEndpoint {
+		t.Errorf("Defaults were not kept: %+v", config)
+	}
+	if !config.WebFetch.ExtractPDF || config.RetryPolicy != nil {
+		t.Errorf("Defaults were not kept: %+v", config)
+	}
+	if _, ok := config.CredentialStore.(*storage.FileSystemStore); !ok {
+		t.Errorf("Expected the default credential store, got %T", config.CredentialStore)
+	}
+
+	client, err := NewClient(WithConfig(config), WithMaxDisplayLength(100))
+	if err != nil {
+		t.Fatalf("NewClient returned error: %v", err)
+	}
+	if client.config.DefaultModel != "gemini-2.5-pro" || client.config.MaxDisplayLength != 100 {
+		t.Errorf("Expected the loaded configuration with later options applied, got %+v", client.config)
+	}
+}
+
+func TestLoadConfigFromFileErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if _, err := LoadConfigFromFile(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a not-exist error for a missing file, got %v", err)
+	}
+	if _, err := LoadConfigFromFile(writeConfigFile(t, `{"defaultModel": `)); err == nil {
+		t.Error("Expected an error for malformed JSON")
+	}
+
+	var configErr *ConfigError
+	if _, err := LoadConfigFromFile(writeConfigFile(t, `{"defaultModel": " "}`)); !errors.As(err, &configErr) || configErr.Field != "DefaultModel" {
+		t.Errorf("Expected a DefaultModel validation error, got %v", err)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(constants.EnvCodeAssistEndpoint, "https://env.example.com")
+	t.Setenv(constants.EnvModel, "gemini-env")
+	t.Setenv(constants.EnvTimeout, "45s")
+	t.Setenv(constants.EnvClientID, "env-client")
+	t.Setenv(constants.EnvClientSecret, "env-secret")
+
+	config, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("LoadConfigFromEnv returned error: %v", err)
+	}
+	if config.CodeAssistEndpoint != "https://env.example.com" || config.DefaultModel != "gemini-env" || config.Timeout != 45*time.Second {
+		t.Errorf("Environment values were not applied: %+v", config)
+	}
+	if config.OAuth2Config.ClientID != "env-client" || config.OAuth2Config.ClientSecret != "env-secret" {
+		t.Errorf("OAuth2 environment values were not applied: %+v", config.OAuth2Config)
+	}
+
+	// The environment overrides a configuration file
+	fileConfig, err := LoadConfigFromFile(writeConfigFile(t, `{"defaultModel": "gemini-file", "maxSources": 3}`))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile returned error: %v", err)
+	}
+	if err := fileConfig.ApplyEnv(); err != nil {
+		t.Fatalf("ApplyEnv returned error: %v", err)
+	}
+	if fileConfig.DefaultModel != "gemini-env" || fileConfig.MaxSources != 3 {
+		t.Errorf("Expected the environment to override the file, got model %q and max sources %d", fileConfig.DefaultModel, fileConfig.MaxSources)
+	}
+
+	t.Setenv(constants.EnvTimeout, "soon")
+	var configErr *ConfigError
+	if _, err := LoadConfigFromEnv(); !errors.As(err, &configErr) || configErr.Field != "Timeout" {
+		t.Errorf("Expected a Timeout error for an invalid duration, got %v", err)
+	}
+}
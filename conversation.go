@@ -0,0 +1,56 @@
+package geminiwebtools
+
+import (
+	"context"
+
+	"github.com/d-kuro/geminiwebtools/pkg/auth"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// Conversation is a series of searches and fetches sharing a session ID of its own, so
+// that follow-up queries retain the context of earlier ones. Conversations share the
+// client, its authentication, limits and cache, but not the client's session; cached
+// results are not shared across conversations. A Conversation is safe for concurrent use.
+type Conversation struct {
+	client    *Client
+	sessionID string
+}
+
+// NewConversation starts a conversation with a newly generated session ID.
+func (c *Client) NewConversation() *Conversation {
+	return c.ResumeConversation("")
+}
+
+// ResumeConversation continues the conversation with the given session ID, for example
+// one saved from Conversation.SessionID. An empty ID starts a new conversation.
+func (c *Client) ResumeConversation(sessionID string) *Conversation {
+	if sessionID == "" {
+		sessionID = auth.NewSessionID()
+	}
+	return &Conversation{client: c, sessionID: sessionID}
+}
+
+// SessionID returns the session ID sent with every request of the conversation.
+func (conv *Conversation) SessionID() string {
+	return conv.sessionID
+}
+
+// Search is like Client.Search but runs as part of the conversation.
+func (conv *Conversation) Search(ctx context.Context, query string) (*types.WebSearchResult, error) {
+	return conv.client.Search(conv.context(ctx), query)
+}
+
+// SearchWithOptions is like Client.SearchWithOptions but runs as part of the conversation.
+func (conv *Conversation) SearchWithOptions(ctx context.Context, query string, opts types.SearchOptions) (*types.WebSearchResult, error) {
+	return conv.client.SearchWithOptions(conv.context(ctx), query, opts)
+}
+
+// Fetch is like Client.Fetch but runs as part of the conversation.
+func (conv *Conversation) Fetch(ctx context.Context, prompt string) (*types.WebFetchResult, error) {
+	return conv.client.Fetch(conv.context(ctx), prompt)
+}
+
+// context returns ctx carrying the session ID of the conversation.
+func (conv *Conversation) context(ctx context.Context) context.Context {
+	return auth.WithSessionID(ctx, conv.sessionID)
+}
@@ -0,0 +1,111 @@
+package geminiwebtools
+
+import (
+	"hash/fnv"
+	"strings"
+	"unicode"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// deduplicateResults drops the results whose content is a near duplicate of an
+// earlier kept result's, if WebFetchConfig.DeduplicateContent is set. Of each group of
+// near duplicates the longest content is kept, as page variants such as AMP or mobile
+// pages tend to be stripped down, and the URLs of the dropped results are listed in
+// its metadata. Failed and empty results are never dropped. The order is preserved.
+func (wf *WebFetcher) deduplicateResults(results []*types.WebFetchResult) []*types.WebFetchResult {
+	if !wf.config.WebFetch.DeduplicateContent || len(results) < 2 {
+		return results
+	}
+	threshold := wf.config.WebFetch.DeduplicationThreshold
+	if threshold <= 0 {
+		threshold = constants.DefaultDeduplicationThreshold
+	}
+
+	shingleSets := make([]map[uint64]struct{}, len(results))
+	for i, result := range results {
+		if result != nil && result.Metadata.Error == "" && strings.TrimSpace(result.Content) != "" {
+			shingleSets[i] = shingles(result.Content)
+		}
+	}
+
+	dropped := make([]bool, len(results))
+	for i := range results {
+		if shingleSets[i] == nil || dropped[i] {
+			continue
+		}
+		group := []int{i}
+		for j := i + 1; j < len(results); j++ {
+			if shingleSets[j] != nil && !dropped[j] && jaccard(shingleSets[i], shingleSets[j]) >= threshold {
+				group = append(group, j)
+			}
+		}
+		if len(group) == 1 {
+			continue
+		}
+
+		kept := group[0]
+		for _, j := range group[1:] {
+			if len(results[j].Content) > len(results[kept].Content) {
+				kept = j
+			}
+		}
+		for _, j := range group {
+			if j != kept {
+				dropped[j] = true
+				results[kept].Metadata.DuplicateURLs = append(results[kept].Metadata.DuplicateURLs, results[j].Metadata.URL)
+			}
+		}
+	}
+
+	deduplicated := results[:0:0]
+	for i, result := range results {
+		if !dropped[i] {
+			deduplicated = append(deduplicated, result)
+		}
+	}
+	return deduplicated
+}
+
+// shingles returns the hashes of the runs of constants.DeduplicationShingleSize
+// consecutive words of text, compared case-insensitively and ignoring punctuation.
+// Text with fewer words yields a single shingle of all of them.
+func shingles(text string) map[uint64]struct{} {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	size := min(constants.DeduplicationShingleSize, len(words))
+
+	set := make(map[uint64]struct{})
+	for i := 0; i+size <= len(words); i++ {
+		h := fnv.New64a()
+		for _, word := range words[i : i+size] {
+			_, _ = h.Write([]byte(word))
+			_, _ = h.Write([]byte{0})
+		}
+		set[h.Sum64()] = struct{}{}
+		if size == 0 {
+			break
+		}
+	}
+	return set
+}
+
+// jaccard returns the Jaccard similarity of two shingle sets.
+func jaccard(a, b map[uint64]struct{}) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for shingle := range a {
+		if _, ok := b[shingle]; ok {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 1
+	}
+	return float64(shared) / float64(union)
+}
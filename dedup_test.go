@@ -0,0 +1,85 @@
+package geminiwebtools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFetchMultipleDeduplicatesContent(t *testing.T) {
+	var article strings.Builder
+	for i := range 40 {
+		fmt.Fprintf(&article, "Paragraph %d of the article explains how the release changes the scheduler. ", i)
+	}
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch r.URL.Path {
+		case "/article":
+			_, _ = fmt.Fprintf(w, "%sRelated articles: the previous release.", article.String())
+		case "/amp/article":
+			_, _ = fmt.Fprintf(w, "AMP %s", article.String())
+		default:
+			_, _ = fmt.Fprint(w, "A different page about the garbage collector and its pacer.")
+		}
+	}))
+	defer page.Close()
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeCodeAssistText(w, "")
+	})
+
+	prompt := "compare http://example.com/amp/article with http://example.com/article and http://example.com/other"
+	for _, deduplicate := range []bool{false, true} {
+		fetcher, err := NewWebFetcher(newTestConfig(codeAssist.URL, WithDeduplicateContent(deduplicate)))
+		if err != nil {
+			t.Fatalf("NewWebFetcher returned error: %v", err)
+		}
+		useTestPageServer(fetcher, page)
+
+		results, err := fetcher.FetchMultiple(context.Background(), prompt)
+		if err != nil {
+			t.Fatalf("FetchMultiple returned error: %v", err)
+		}
+		if !deduplicate {
+			if len(results) != 3 {
+				t.Errorf("Expected all 3 results without deduplication, got %d", len(results))
+			}
+			continue
+		}
+
+		// The longer canonical page is kept in place of its AMP variant
+		if len(results) != 2 {
+			t.Fatalf("Expected the near duplicate to be dropped, got %d results", len(results))
+		}
+		if !strings.Contains(results[0].Content, "Related articles") {
+			t.Errorf("Expected the canonical page to be kept, got %q", results[0].Content)
+		}
+		if want := []string{"http://example.com/amp/article"}; !slices.Equal(results[0].Metadata.DuplicateURLs, want) {
+			t.Errorf("DuplicateURLs = %v, want %v", results[0].Metadata.DuplicateURLs, want)
+		}
+		if !strings.Contains(results[1].Content, "garbage collector") || results[1].Metadata.DuplicateURLs != nil {
+			t.Errorf("Expected the distinct page to be kept as it is, got %+v", results[1])
+		}
+	}
+}
+
+func TestJaccardOfShingles(t *testing.T) {
+	text := "the quick brown fox jumps over the lazy dog near the river bank"
+	if got := jaccard(shingles(text), shingles(strings.ToUpper(text)+"!")); got != 1 {
+		t.Errorf("Expected case and punctuation to be ignored, got similarity %v", got)
+	}
+	if got := jaccard(shingles(text), shingles("an entirely unrelated sentence about compilers and linkers")); got != 0 {
+		t.Errorf("Expected unrelated texts to share no shingles, got similarity %v", got)
+	}
+	if got := jaccard(shingles("short text"), shingles("Short text.")); got != 1 {
+		t.Errorf("Expected texts shorter than a shingle to be compared whole, got similarity %v", got)
+	}
+
+	config := NewConfig(WithCredentialStore(&mockCredentialStore{}), WithDeduplicationThreshold(1.5))
+	if err := config.Validate(); err == nil {
+		t.Error("Expected a threshold above 1 to be rejected")
+	}
+}
@@ -0,0 +1,85 @@
+package geminiwebtools
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DomainPolicyError is returned when a URL to fetch is rejected by
+// WebFetchConfig.AllowedDomains or WebFetchConfig.BlockedDomains.
+type DomainPolicyError struct {
+	Host string
+
+	// Blocked is true if the host matches a blocked domain, and false if it matches
+	// none of the allowed domains.
+	Blocked bool
+}
+
+// Error implements the error interface.
+func (e *DomainPolicyError) Error() string {
+	if e.Blocked {
+		return fmt.Sprintf("fetching from %s is blocked by the domain policy", e.Host)
+	}
+	return fmt.Sprintf("fetching from %s is not allowed by the domain policy", e.Host)
+}
+
+// checkDomainPolicy returns a DomainPolicyError if host matches a blocked domain or,
+// when allowed is not empty, none of the allowed domains.
+func checkDomainPolicy(host string, allowed, blocked []string) error {
+	if matchesAnyDomain(host, blocked) {
+		return &DomainPolicyError{Host: host, Blocked: true}
+	}
+	if len(allowed) > 0 && !matchesAnyDomain(host, allowed) {
+		return &DomainPolicyError{Host: host}
+	}
+	return nil
+}
+
+// normalizeDomain lowercases a domain and strips a trailing dot and a leading "www.".
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimSuffix(domain, ".")
+	return strings.TrimPrefix(domain, "www.")
+}
+
+// matchesDomain reports whether host matches the given domain pattern.
+// A bare pattern such as "example.com" matches the domain itself and any of its
+// subdomains, while a wildcard pattern such as "*.example.com" matches subdomains only.
+func matchesDomain(host, pattern string) bool {
+	host = normalizeDomain(host)
+	if host == "" {
+		return false
+	}
+
+	if suffix, ok := strings.CutPrefix(strings.TrimSpace(pattern), "*."); ok {
+		suffix = normalizeDomain(suffix)
+		return suffix != "" && strings.HasSuffix(host, "."+suffix)
+	}
+
+	pattern = normalizeDomain(pattern)
+	if pattern == "" {
+		return false
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// matchesAnyDomain reports whether host matches any of the given domain patterns.
+func matchesAnyDomain(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchesDomain(host, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDomainPolicy checks the host of a validated http or https URL against the
+// configured domain policy.
+func (wf *WebFetcher) checkDomainPolicy(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL format: %w", err)
+	}
+	return checkDomainPolicy(parsedURL.Hostname(), wf.config.WebFetch.AllowedDomains, wf.config.WebFetch.BlockedDomains)
+}
@@ -0,0 +1,147 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+func TestMatchesDomain(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		pattern  string
+		expected bool
+	}{
+		{name: "exact match", host: "example.com", pattern: "example.com", expected: true},
+		{name: "subdomain of bare pattern", host: "docs.example.com", pattern: "example.com", expected: true},
+		{name: "www prefix ignored", host: "www.example.com", pattern: "example.com", expected: true},
+		{name: "case insensitive", host: "Docs.Example.COM", pattern: "example.com", expected: true},
+		{name: "suffix without dot boundary", host: "badexample.com", pattern: "example.com", expected: false},
+		{name: "wildcard matches subdomain", host: "docs.example.com", pattern: "*.example.com", expected: true},
+		{name: "wildcard excludes apex", host: "example.com", pattern: "*.example.com", expected: false},
+		{name: "different domain", host: "example.org", pattern: "example.com", expected: false},
+		{name: "empty host", host: "", pattern: "example.com", expected: false},
+		{name: "empty pattern", host: "example.com", pattern: "", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesDomain(tt.host, tt.pattern); got != tt.expected {
+				t.Errorf("matchesDomain(%q, %q) = %v, want %v", tt.host, tt.pattern, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGroundingChunkSourceDomain(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunk    types.GroundingChunk
+		expected string
+	}{
+		{
+			name:     "URI host",
+			chunk:    newTestChunk("Go", "https://www.go.dev/doc"),
+			expected: "go.dev",
+		},
+		{
+			name:     "grounding redirect falls back to title",
+			chunk:    newTestChunk("wikipedia.org", "https://vertexaisearch.cloud.google.com/grounding-api-redirect/abc"),
+			expected: "wikipedia.org",
+		},
+		{
+			name:     "grounding redirect with non-domain title",
+			chunk:    newTestChunk("Some Article", "https://vertexaisearch.cloud.google.com/grounding-api-redirect/abc"),
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.chunk.SourceDomain(); got != tt.expected {
+				t.Errorf("SourceDomain() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckDomainPolicy(t *testing.T) {
+	allowed := []string{"example.com", "*.docs.org"}
+	blocked := []string{"ads.example.com"}
+
+	tests := []struct {
+		name        string
+		host        string
+		wantErr     bool
+		wantBlocked bool
+	}{
+		{name: "exact allowed domain", host: "example.com"},
+		{name: "subdomain of allowed domain", host: "api.example.com"},
+		{name: "wildcard allowed subdomain", host: "go.docs.org"},
+		{name: "wildcard excludes apex", host: "docs.org", wantErr: true},
+		{name: "not allowed domain", host: "other.net", wantErr: true},
+		{name: "exact blocked domain", host: "ads.example.com", wantErr: true, wantBlocked: true},
+		{name: "subdomain of blocked domain", host: "cdn.ads.example.com", wantErr: true, wantBlocked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDomainPolicy(tt.host, allowed, blocked)
+			var policyErr *DomainPolicyError
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("checkDomainPolicy(%q) returned error: %v", tt.host, err)
+				}
+				return
+			}
+			if !errors.As(err, &policyErr) || policyErr.Blocked != tt.wantBlocked || policyErr.Host != tt.host {
+				t.Errorf("checkDomainPolicy(%q) = %v, want a DomainPolicyError with Blocked=%v", tt.host, err, tt.wantBlocked)
+			}
+		})
+	}
+
+	if err := checkDomainPolicy("anything.net", nil, blocked); err != nil {
+		t.Errorf("Expected hosts to be allowed without an allowlist, got %v", err)
+	}
+}
+
+func TestFetchBlockedDomain(t *testing.T) {
+	fetcher, err := NewWebFetcher(newTestConfig("", WithBlockedDomains("*.example.com")))
+	if err != nil {
+		t.Fatalf("NewWebFetcher returned error: %v", err)
+	}
+
+	result, err := fetcher.Fetch(context.Background(), "Summarize https://news.example.com/article")
+	var policyErr *DomainPolicyError
+	if !errors.As(err, &policyErr) || !policyErr.Blocked {
+		t.Fatalf("Expected a blocked DomainPolicyError, got %v", err)
+	}
+	if result == nil || result.Metadata.Error != err.Error() || result.Summary != "URL blocked by domain policy" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	results, err := fetcher.FetchMultiple(context.Background(), "Compare https://example.org and https://api.example.com/page")
+	if !errors.As(err, &policyErr) || len(results) != 1 || results[0].Metadata.URL != "https://api.example.com/page" {
+		t.Errorf("Expected FetchMultiple to reject the blocked URL, got %v", err)
+	}
+}
+
+func TestFetchOutlineAllowedDomains(t *testing.T) {
+	fetcher, err := NewWebFetcher(newTestConfig("", WithAllowedDomains("go.dev")))
+	if err != nil {
+		t.Fatalf("NewWebFetcher returned error: %v", err)
+	}
+
+	_, metadata, err := fetcher.FetchOutline(context.Background(), "https://example.com/")
+	var policyErr *DomainPolicyError
+	if !errors.As(err, &policyErr) || policyErr.Blocked {
+		t.Fatalf("Expected a not allowed DomainPolicyError, got %v", err)
+	}
+	if !strings.Contains(metadata.Error, "not allowed by the domain policy") {
+		t.Errorf("Unexpected metadata error: %q", metadata.Error)
+	}
+}
@@ -0,0 +1,29 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+)
+
+// contextErrorLabel returns a short label telling a timeout apart from a cancellation.
+func contextErrorLabel(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	}
+	return "failed"
+}
+
+// contextErrorMessage describes a context error for result metadata and display text,
+// telling a timeout apart from a cancellation by the caller.
+func contextErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "request timed out: " + err.Error()
+	case errors.Is(err, context.Canceled):
+		return "request was cancelled: " + err.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,72 @@
+package geminiwebtools
+
+import (
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// maxProgressEvents is the number of events an operation emits at most.
+const maxProgressEvents = 4
+
+// progressEvent has the fields of types.SearchEvent and types.FetchEvent, which it
+// converts to.
+type progressEvent[R any] struct {
+	Type    types.EventType
+	Time    time.Time
+	Sources []types.GroundingChunk
+	Result  R
+	Err     error
+}
+
+// sourced is a result with grounding sources.
+type sourced interface {
+	*types.WebSearchResult | *types.WebFetchResult
+}
+
+// progressEvents runs call in the background and reports its progress on the returned
+// channel. The backend answers at once, so EventFirstByte and EventGrounding are
+// synthesized when the answer arrives. Cancelling the context of call makes it fail
+// with EventError. The channel holds every event, so that call never waits for a slow
+// or gone consumer, and is closed after the last one; release is called when call
+// returns.
+func progressEvents[R sourced, E any](release func(), call func() (R, error), convert func(progressEvent[R]) E) <-chan E {
+	events := make(chan E, maxProgressEvents)
+	emit := func(event progressEvent[R]) {
+		event.Time = time.Now()
+		events <- convert(event)
+	}
+
+	emit(progressEvent[R]{Type: types.EventStarted})
+	go func() {
+		defer close(events)
+		result, err := call()
+		release()
+		if err != nil {
+			emit(progressEvent[R]{Type: types.EventError, Err: err})
+			return
+		}
+
+		emit(progressEvent[R]{Type: types.EventFirstByte})
+		if sources := resultSources(result); len(sources) > 0 {
+			emit(progressEvent[R]{Type: types.EventGrounding, Sources: sources})
+		}
+		emit(progressEvent[R]{Type: types.EventDone, Result: result})
+	}()
+	return events
+}
+
+// resultSources returns the grounding sources of a search or fetch result.
+func resultSources[R sourced](result R) []types.GroundingChunk {
+	switch result := any(result).(type) {
+	case *types.WebSearchResult:
+		if result != nil {
+			return result.Sources
+		}
+	case *types.WebFetchResult:
+		if result != nil {
+			return result.Sources
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package geminiwebtools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+func TestClientSearchChan(t *testing.T) {
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"response": {"candidates": [{"content": {"parts": [{"text": "Go is fast."}]}, "groundingMetadata": {"groundingChunks": [{"web": {"uri": "https://go.dev", "title": "go.dev"}}]}}]}}`)
+	})
+	client, err := NewClient(func(c *Config) { *c = *newTestConfig(codeAssist.URL) })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	events, err := client.SearchChan(context.Background(), "golang")
+	if err != nil {
+		t.Fatalf("SearchChan failed: %v", err)
+	}
+	var got []types.EventType
+	var last types.SearchEvent
+	for event := range events {
+		got = append(got, event.Type)
+		if event.Type == types.EventGrounding && (len(event.Sources) != 1 || event.Sources[0].Web.URI != "https://go.dev") {
+			t.Errorf("Unexpected grounding sources: %+v", event.Sources)
+		}
+		last = event
+	}
+
+	want := []types.EventType{types.EventStarted, types.EventFirstByte, types.EventGrounding, types.EventDone}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Events = %v, want %v", got, want)
+	}
+	if last.Result == nil || last.Result.Content == "" || last.Err != nil {
+		t.Errorf("Unexpected done event: %+v", last)
+	}
+}
+
+func TestClientFetchChan(t *testing.T) {
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeCodeAssistText(w, "summary")
+	})
+	client, err := NewClient(func(c *Config) { *c = *newTestConfig(codeAssist.URL) })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	events, err := client.FetchChan(context.Background(), "Summarize https://example.com")
+	if err != nil {
+		t.Fatalf("FetchChan failed: %v", err)
+	}
+	var got []types.EventType
+	var last types.FetchEvent
+	for event := range events {
+		got = append(got, event.Type)
+		last = event
+	}
+
+	// Without sources, there is no grounding event
+	want := []types.EventType{types.EventStarted, types.EventFirstByte, types.EventDone}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Events = %v, want %v", got, want)
+	}
+	if last.Result == nil || last.Result.Content == "" {
+		t.Errorf("Unexpected done event: %+v", last)
+	}
+}
+
+func TestClientSearchChanCancellation(t *testing.T) {
+	codeAssist := newFakeCodeAssistServer(t, blockUntilCancelled)
+	client, err := NewClient(func(c *Config) { *c = *newTestConfig(codeAssist.URL) })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := client.SearchChan(ctx, "golang")
+	if err != nil {
+		t.Fatalf("SearchChan failed: %v", err)
+	}
+	if event := <-events; event.Type != types.EventStarted {
+		t.Fatalf("First event = %v, want started", event.Type)
+	}
+	cancel()
+
+	select {
+	case event := <-events:
+		if event.Type != types.EventError || event.Err == nil {
+			t.Errorf("Expected an error event after cancellation, got %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No event after cancellation")
+	}
+	if _, ok := <-events; ok {
+		t.Error("Expected the channel to be closed after the error event")
+	}
+	if stats := client.Stats(); stats.InFlight != 0 {
+		t.Errorf("Expected the concurrency slot to be released, got %+v", stats)
+	}
+}
@@ -0,0 +1,44 @@
+package geminiwebtools
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// FaviconURLFunc derives a favicon URL for a source domain.
+// It returns an empty string if no URL can be derived.
+type FaviconURLFunc func(domain string) string
+
+// DirectFaviconURL returns the conventional favicon location on the domain itself.
+func DirectFaviconURL(domain string) string {
+	if domain == "" {
+		return ""
+	}
+	return fmt.Sprintf(constants.DirectFaviconURLFormat, domain)
+}
+
+// GoogleFaviconURL returns a favicon URL served by Google's favicon service,
+// which works for domains that do not host /favicon.ico.
+func GoogleFaviconURL(domain string) string {
+	if domain == "" {
+		return ""
+	}
+	return fmt.Sprintf(constants.GoogleFaviconURLFormat, url.QueryEscape(domain))
+}
+
+// applyFavicons returns a copy of the sources with FaviconURL set from each source domain.
+func applyFavicons(sources []types.GroundingChunk, faviconURL FaviconURLFunc) []types.GroundingChunk {
+	if faviconURL == nil {
+		faviconURL = DirectFaviconURL
+	}
+
+	result := make([]types.GroundingChunk, len(sources))
+	for i, source := range sources {
+		source.FaviconURL = faviconURL(source.SourceDomain())
+		result[i] = source
+	}
+	return result
+}
@@ -0,0 +1,164 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// isFileURL reports whether rawURL uses the file scheme.
+func isFileURL(rawURL string) bool {
+	return len(rawURL) >= len("file://") && strings.EqualFold(rawURL[:len("file://")], "file://")
+}
+
+// extractURLs extracts up to WebFetchConfig.MaxURLs URLs from the prompt, including
+// file:// URLs when the file scheme is enabled.
+func (wf *WebFetcher) extractURLs(prompt string) []string {
+	if !wf.config.WebFetch.AllowFileScheme {
+		return extractUrls(prompt, wf.config.WebFetch.MaxURLs)
+	}
+	limit := wf.config.WebFetch.MaxURLs
+	if limit <= 0 {
+		limit = -1
+	}
+	return regexp.MustCompile(constants.FileURLRegexPattern).FindAllString(prompt, limit)
+}
+
+// validateURL validates a URL to fetch and checks its host against the domain
+// policy. file:// URLs are accepted only when the file
+// scheme is enabled; their paths are checked against the root when the file is read.
+func (wf *WebFetcher) validateURL(rawURL string) error {
+	if !wf.config.WebFetch.AllowFileScheme || !isFileURL(rawURL) {
+		if err := validateURL(rawURL); err != nil {
+			return err
+		}
+		return wf.checkDomainPolicy(rawURL)
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL format: %w", err)
+	}
+	if parsedURL.Host != "" && parsedURL.Host != "localhost" {
+		return fmt.Errorf("file URLs must not name a remote host: %s", parsedURL.Host)
+	}
+	if parsedURL.Path == "" || strings.ContainsRune(parsedURL.Path, '\x00') {
+		return fmt.Errorf("file URL has an invalid path")
+	}
+	return nil
+}
+
+// hasFileURL reports whether any of the URLs uses the file scheme.
+func hasFileURL(urls []string) bool {
+	for _, u := range urls {
+		if isFileURL(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// readFileURL reads the file a validated file:// URL points to. The file must be within
+// root: paths that resolve outside of it, including through symbolic links, are
+// rejected. Files larger than maxSize bytes are rejected as well.
+func readFileURL(root, fileURL string, maxSize int64) (content, contentType string, size int, err error) {
+	if root == "" {
+		return "", "", 0, errors.New("file scheme requires a root directory")
+	}
+	parsedURL, err := url.Parse(fileURL)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("invalid URL format: %w", err)
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("invalid file root: %w", err)
+	}
+	path := filepath.Clean(filepath.FromSlash(parsedURL.Path))
+	rel, err := filepath.Rel(absRoot, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", 0, fmt.Errorf("file path is outside of the file root: %s", parsedURL.Path)
+	}
+
+	// os.Root also rejects symbolic links that lead outside of the root
+	dir, err := os.OpenRoot(absRoot)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to open file root: %w", err)
+	}
+	defer dir.Close()
+	file, err := dir.Open(rel)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return "", "", 0, fmt.Errorf("file URL points to a directory: %s", parsedURL.Path)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(body)) > maxSize {
+		return "", "", 0, fmt.Errorf("file exceeds maximum size of %d bytes", maxSize)
+	}
+
+	contentType = mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	return string(decodeToUTF8(body, contentType)), contentType, len(body), nil
+}
+
+// fetchFile reads a validated file:// URL and processes it like content fetched by the
+// HTTP fallback. The AI is not used, as it cannot reach local files.
+func (wf *WebFetcher) fetchFile(ctx context.Context, fileURL, prompt string, startTime time.Time) (*types.WebFetchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	maxSize := wf.httpClient.config.MaxContentSize
+	if maxSize <= 0 {
+		maxSize = constants.DefaultHTTPMaxContentSize
+	}
+	content, contentType, size, err := readFileURL(wf.config.WebFetch.FileSchemeRoot, fileURL, maxSize)
+	if err != nil {
+		return timedFetchResult(&types.WebFetchResult{
+			Summary:     fmt.Sprintf("File read failed: %s", fileURL),
+			Content:     "",
+			DisplayText: fmt.Sprintf("Error reading file: %v", err),
+			Metadata: types.WebFetchMetadata{
+				URL:          fileURL,
+				Prompt:       prompt,
+				APIUsed:      "fallback",
+				HasGrounding: false,
+				UsedFallback: true,
+				Error:        err.Error(),
+			},
+		}, startTime), fmt.Errorf("file fetch failed: %w", err)
+	}
+
+	result, err := wf.processHTTPResponse(content, contentType, size, fileURL, prompt, startTime)
+	if result != nil {
+		// No HTTP request was made
+		result.Metadata.StatusCode = 0
+	}
+	return result, err
+}
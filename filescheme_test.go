@@ -0,0 +1,86 @@
+package geminiwebtools
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchFileScheme(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatalf("Failed to create root: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "page.html"), []byte("<html><body><p>Local fixture content</p></body></html>"), 0o644); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Skipf("Symbolic links are not supported: %v", err)
+	}
+
+	server := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected file URLs not to be sent to the AI")
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	var logs bytes.Buffer
+	fetcher, err := NewWebFetcher(newTestConfig(server.URL, WithFileScheme(root), WithLogger(slog.New(slog.NewTextHandler(&logs, nil)))))
+	if err != nil {
+		t.Fatalf("NewWebFetcher returned error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "file://") {
+		t.Errorf("Expected a warning about file URLs, got %q", logs.String())
+	}
+	rootURL := "file://" + filepath.ToSlash(root)
+
+	result, err := fetcher.Fetch(context.Background(), "Summarize "+rootURL+"/page.html")
+	if err != nil {
+		t.Fatalf("Fetch returned error: %v", err)
+	}
+	if !strings.Contains(result.Content, "Local fixture content") || !result.Metadata.UsedFallback {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if result.Metadata.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("ContentType = %q, want the type of the file extension", result.Metadata.ContentType)
+	}
+
+	rejected := map[string]string{
+		"traversal":         rootURL + "/../secret.txt",
+		"encoded traversal": rootURL + "/%2e%2e/secret.txt",
+		"symbolic link":     rootURL + "/link.txt",
+		"remote host":       "file://example.com/etc/passwd",
+		"directory":         rootURL + "/",
+	}
+	for name, fileURL := range rejected {
+		t.Run(name, func(t *testing.T) {
+			result, err := fetcher.Fetch(context.Background(), "Read "+fileURL)
+			if err == nil {
+				t.Fatalf("Expected %s to be rejected", fileURL)
+			}
+			if strings.Contains(result.Content, "secret") || result.Metadata.Error == "" {
+				t.Errorf("Unexpected result: %+v", result)
+			}
+		})
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		fetcher, err := NewWebFetcher(newTestConfig(server.URL))
+		if err != nil {
+			t.Fatalf("NewWebFetcher returned error: %v", err)
+		}
+		if _, err := fetcher.Fetch(context.Background(), "Summarize "+rootURL+"/page.html"); err == nil {
+			t.Error("Expected file URLs to be ignored unless enabled")
+		}
+		if err := validateURL(rootURL + "/page.html"); err == nil {
+			t.Error("Expected validateURL to reject file URLs")
+		}
+	})
+}
@@ -5,3 +5,21 @@ go 1.24.0
 toolchain go1.24.5
 
 require golang.org/x/oauth2 v0.30.0
+
+require golang.org/x/net v0.42.0
+
+require golang.org/x/text v0.27.0
+
+require github.com/andybalholm/brotli v1.2.6
+
+require github.com/ledongthuc/pdf v0.0.0-20240201131950-da5b75280b06
+
+require github.com/zalando/go-keyring v0.2.6
+
+require golang.org/x/sys v0.34.0
+
+require (
+	al.essio.dev/pkg/shellescape v1.5.1 // indirect
+	github.com/danieljoos/wincred v1.2.2 // indirect
+	github.com/godbus/dbus/v5 v5.1.0 // indirect
+)
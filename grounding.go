@@ -2,17 +2,29 @@ package geminiwebtools
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/d-kuro/geminiwebtools/pkg/constants"
 	"github.com/d-kuro/geminiwebtools/pkg/types"
 )
 
 // GroundingProcessor handles processing of grounding metadata to enhance search results with citations.
+// Its settings can be changed at runtime and are safe for concurrent use; changes take
+// effect on the next call to ProcessGrounding.
 type GroundingProcessor struct {
+	mu sync.RWMutex
+
 	// Configuration for grounding processing
 	includeCitations bool
+	insertCitations  bool
 	maxCitations     int
+	citationStyle    string
+	maxTitleLength   int
+	maxURILength     int
 }
 
 // NewGroundingProcessor creates a new grounding processor with default settings.
@@ -20,21 +32,134 @@ func NewGroundingProcessor() *GroundingProcessor {
 	return &GroundingProcessor{
 		includeCitations: true,
 		maxCitations:     constants.DefaultMaxCitations,
+		citationStyle:    constants.CitationStyleBulleted,
+		maxTitleLength:   constants.DefaultMaxCitationTitleLength,
+		maxURILength:     constants.DefaultMaxCitationURILength,
 	}
 }
 
+// IncludeCitations reports whether citations are appended to processed content.
+func (gp *GroundingProcessor) IncludeCitations() bool {
+	gp.mu.RLock()
+	defer gp.mu.RUnlock()
+	return gp.includeCitations
+}
+
+// SetIncludeCitations enables or disables appending citations to processed content.
+func (gp *GroundingProcessor) SetIncludeCitations(include bool) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	gp.includeCitations = include
+}
+
+// InsertCitations reports whether citation markers are inserted into processed content.
+func (gp *GroundingProcessor) InsertCitations() bool {
+	gp.mu.RLock()
+	defer gp.mu.RUnlock()
+	return gp.insertCitations
+}
+
+// SetInsertCitations enables or disables inserting a citation marker such as "[1][2]"
+// after each segment of the content that grounding supports back. The numbers are the
+// positions of the sources in the appended list, which the numbered citation style shows.
+// Markers are only inserted while citations are included.
+func (gp *GroundingProcessor) SetInsertCitations(insert bool) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	gp.insertCitations = insert
+}
+
+// MaxCitations returns the maximum number of sources listed. Zero means no limit.
+func (gp *GroundingProcessor) MaxCitations() int {
+	gp.mu.RLock()
+	defer gp.mu.RUnlock()
+	return gp.maxCitations
+}
+
+// SetMaxCitations sets the maximum number of sources listed. Zero or a negative value means no limit.
+func (gp *GroundingProcessor) SetMaxCitations(maxCitations int) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	gp.maxCitations = maxCitations
+}
+
+// CitationStyle returns the style used to list sources.
+func (gp *GroundingProcessor) CitationStyle() string {
+	gp.mu.RLock()
+	defer gp.mu.RUnlock()
+	return gp.citationStyle
+}
+
+// SetCitationStyle sets the style used to list sources, either
+// constants.CitationStyleBulleted or constants.CitationStyleNumbered.
+func (gp *GroundingProcessor) SetCitationStyle(style string) error {
+	switch style {
+	case constants.CitationStyleBulleted, constants.CitationStyleNumbered:
+	default:
+		return fmt.Errorf("unsupported citation style: %q", style)
+	}
+
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	gp.citationStyle = style
+	return nil
+}
+
+// CitationLengthLimits returns the maximum rendered lengths in runes of source titles and URIs.
+func (gp *GroundingProcessor) CitationLengthLimits() (maxTitleLength, maxURILength int) {
+	gp.mu.RLock()
+	defer gp.mu.RUnlock()
+	return gp.maxTitleLength, gp.maxURILength
+}
+
+// SetCitationLengthLimits sets the maximum rendered lengths in runes of source titles and
+// URIs. Longer values are truncated with an ellipsis in the rendered citations only; the
+// structured sources of results keep the full values. Zero or a negative value means no limit.
+func (gp *GroundingProcessor) SetCitationLengthLimits(maxTitleLength, maxURILength int) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	gp.maxTitleLength = maxTitleLength
+	gp.maxURILength = maxURILength
+}
+
+// citationSettings is a snapshot of the settings used to render citations.
+type citationSettings struct {
+	maxCitations   int
+	style          string
+	maxTitleLength int
+	maxURILength   int
+}
+
 // ProcessGrounding processes grounding metadata and enhances the content with citations.
 func (gp *GroundingProcessor) ProcessGrounding(content string, metadata *types.GroundingMetadata) string {
-	if metadata == nil || !gp.includeCitations {
+	gp.mu.RLock()
+	includeCitations := gp.includeCitations
+	insertCitations := gp.insertCitations
+	settings := citationSettings{
+		maxCitations:   gp.maxCitations,
+		style:          gp.citationStyle,
+		maxTitleLength: gp.maxTitleLength,
+		maxURILength:   gp.maxURILength,
+	}
+	gp.mu.RUnlock()
+
+	if metadata == nil || !includeCitations {
 		return content
 	}
 
-	// Start with the original content
+	// Start with the original content, marking the supported segments
 	enhancedContent := content
+	if insertCitations {
+		listed := len(metadata.GroundingChunks)
+		if settings.maxCitations > 0 && listed > settings.maxCitations {
+			listed = settings.maxCitations
+		}
+		enhancedContent = insertCitationMarkers(content, metadata.GroundingSupports, listed)
+	}
 
 	// Add citations section if grounding chunks are available
 	if len(metadata.GroundingChunks) > 0 {
-		enhancedContent += gp.formatCitations(metadata.GroundingChunks)
+		enhancedContent += gp.formatCitations(metadata.GroundingChunks, settings)
 	}
 
 	// Add search queries information if available
@@ -45,8 +170,8 @@ func (gp *GroundingProcessor) ProcessGrounding(content string, metadata *types.G
 	return enhancedContent
 }
 
-// formatCitations formats grounding chunks as a citations section.
-func (gp *GroundingProcessor) formatCitations(chunks []types.GroundingChunk) string {
+// formatCitations formats grounding chunks as a citations section according to settings.
+func (gp *GroundingProcessor) formatCitations(chunks []types.GroundingChunk, settings citationSettings) string {
 	if len(chunks) == 0 {
 		return ""
 	}
@@ -55,14 +180,22 @@ func (gp *GroundingProcessor) formatCitations(chunks []types.GroundingChunk) str
 	citations.WriteString(constants.SourcesHeader)
 
 	maxCitations := len(chunks)
-	if gp.maxCitations > 0 && maxCitations > gp.maxCitations {
-		maxCitations = gp.maxCitations
+	if settings.maxCitations > 0 && maxCitations > settings.maxCitations {
+		maxCitations = settings.maxCitations
 	}
 
 	for i := 0; i < maxCitations; i++ {
 		chunk := chunks[i]
-		citations.WriteString(fmt.Sprintf("- [%s](%s)", chunk.Web.Title, chunk.Web.URI))
-		if chunk.Web.Domain != "" {
+		if settings.style == constants.CitationStyleNumbered {
+			citations.WriteString(fmt.Sprintf("%d. ", i+1))
+		} else {
+			citations.WriteString("- ")
+		}
+		title := truncateRunes(chunk.Web.Title, settings.maxTitleLength)
+		uri := truncateRunes(chunk.Web.URI, settings.maxURILength)
+		citations.WriteString(fmt.Sprintf("[%s](%s)", title, uri))
+		// Titles of grounding redirects are usually the domain already
+		if chunk.Web.Domain != "" && chunk.Web.Domain != chunk.Web.Title {
 			citations.WriteString(fmt.Sprintf(" (%s)", chunk.Web.Domain))
 		}
 		citations.WriteString("\n")
@@ -75,6 +208,61 @@ func (gp *GroundingProcessor) formatCitations(chunks []types.GroundingChunk) str
 	return citations.String()
 }
 
+// insertCitationMarkers inserts a marker such as "[1][2]" at the end index of each
+// grounding support segment, numbering the supporting chunks from 1. Only the first
+// listed chunks are referenced. End indices are UTF-8 byte offsets into content; those
+// outside content are ignored and those inside a character move to its end. Supports
+// may overlap and come in any order; the markers at the same offset are merged.
+func insertCitationMarkers(content string, supports []types.GroundingSupport, listed int) string {
+	markers := make(map[int][]int)
+	for _, support := range supports {
+		end := support.Segment.EndIndex
+		if end <= 0 || end > len(content) {
+			continue
+		}
+		for end < len(content) && !utf8.RuneStart(content[end]) {
+			end++
+		}
+		for _, index := range support.GroundingChunkIndices {
+			if index < 0 || index >= listed || slices.Contains(markers[end], index) {
+				continue
+			}
+			markers[end] = append(markers[end], index)
+		}
+	}
+	if len(markers) == 0 {
+		return content
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, end := range slices.Sorted(maps.Keys(markers)) {
+		b.WriteString(content[last:end])
+		indices := markers[end]
+		slices.Sort(indices)
+		for _, index := range indices {
+			fmt.Fprintf(&b, "[%d]", index+1)
+		}
+		last = end
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// truncateRunes shortens s to at most maxLength runes, ending it with an ellipsis
+// if it was cut. A maxLength of zero or less leaves s unchanged.
+func truncateRunes(s string, maxLength int) string {
+	if maxLength <= 0 || utf8.RuneCountInString(s) <= maxLength {
+		return s
+	}
+
+	suffix := []rune(constants.TruncationSuffix)
+	if maxLength <= len(suffix) {
+		return string([]rune(s)[:maxLength])
+	}
+	return string([]rune(s)[:maxLength-len(suffix)]) + constants.TruncationSuffix
+}
+
 // formatSearchQueries formats web search queries information.
 func (gp *GroundingProcessor) formatSearchQueries(queries []string) string {
 	if len(queries) == 0 {
@@ -96,3 +284,114 @@ func (gp *GroundingProcessor) formatSearchQueries(queries []string) string {
 
 	return queryInfo.String()
 }
+
+// attachSnippets sets Snippets on each source from the text of the grounding supports
+// that reference it, in support order and without duplicates. Sources are modified in
+// place, so callers pass a copy of the grounding chunks.
+func attachSnippets(sources []types.GroundingChunk, supports []types.GroundingSupport) {
+	for _, support := range supports {
+		text := strings.TrimSpace(support.Segment.Text)
+		if text == "" {
+			continue
+		}
+		for _, idx := range support.GroundingChunkIndices {
+			if idx < 0 || idx >= len(sources) || slices.Contains(sources[idx].Snippets, text) {
+				continue
+			}
+			sources[idx].Snippets = append(sources[idx].Snippets, text)
+		}
+	}
+}
+
+// reorderGroundingMetadata returns a copy of metadata whose grounding chunks are
+// arranged according to order, a list of indices into the original chunks.
+// Chunks not listed in order are dropped. Grounding support chunk indices are
+// remapped to the new positions, and supports left without any chunk are removed.
+func reorderGroundingMetadata(metadata *types.GroundingMetadata, order []int) *types.GroundingMetadata {
+	if metadata == nil {
+		return nil
+	}
+
+	reordered := *metadata
+	newIndex := make(map[int]int, len(order))
+	reordered.GroundingChunks = make([]types.GroundingChunk, 0, len(order))
+	for _, oldIndex := range order {
+		if oldIndex < 0 || oldIndex >= len(metadata.GroundingChunks) {
+			continue
+		}
+		newIndex[oldIndex] = len(reordered.GroundingChunks)
+		reordered.GroundingChunks = append(reordered.GroundingChunks, metadata.GroundingChunks[oldIndex])
+	}
+
+	reordered.GroundingSupports = nil
+	for _, support := range metadata.GroundingSupports {
+		indices := make([]int, 0, len(support.GroundingChunkIndices))
+		for _, idx := range support.GroundingChunkIndices {
+			if mapped, ok := newIndex[idx]; ok {
+				indices = append(indices, mapped)
+			}
+		}
+		if len(indices) == 0 {
+			continue
+		}
+		support.GroundingChunkIndices = indices
+		reordered.GroundingSupports = append(reordered.GroundingSupports, support)
+	}
+
+	return &reordered
+}
+
+// filterDomains drops grounding chunks whose domain matches one of the blocked domains
+// and, if allowed is not empty, chunks whose domain matches none of the allowed domains.
+func filterDomains(metadata *types.GroundingMetadata, allowed, blocked []string) *types.GroundingMetadata {
+	if metadata == nil || (len(allowed) == 0 && len(blocked) == 0) {
+		return metadata
+	}
+
+	order := make([]int, 0, len(metadata.GroundingChunks))
+	for i, chunk := range metadata.GroundingChunks {
+		domain := chunk.SourceDomain()
+		if matchesAnyDomain(domain, blocked) {
+			continue
+		}
+		if len(allowed) > 0 && !matchesAnyDomain(domain, allowed) {
+			continue
+		}
+		order = append(order, i)
+	}
+
+	return reorderGroundingMetadata(metadata, order)
+}
+
+// limitGroundingChunks keeps the first max grounding chunks. A max of zero or less keeps all chunks.
+func limitGroundingChunks(metadata *types.GroundingMetadata, max int) *types.GroundingMetadata {
+	if metadata == nil || max <= 0 || len(metadata.GroundingChunks) <= max {
+		return metadata
+	}
+
+	order := make([]int, max)
+	for i := range order {
+		order[i] = i
+	}
+	return reorderGroundingMetadata(metadata, order)
+}
+
+// prioritizeDomains moves grounding chunks whose domain matches one of the preferred
+// domains ahead of the others, preserving the relative order within each group.
+func prioritizeDomains(metadata *types.GroundingMetadata, preferred []string) *types.GroundingMetadata {
+	if metadata == nil || len(preferred) == 0 {
+		return metadata
+	}
+
+	order := make([]int, 0, len(metadata.GroundingChunks))
+	var rest []int
+	for i, chunk := range metadata.GroundingChunks {
+		if matchesAnyDomain(chunk.SourceDomain(), preferred) {
+			order = append(order, i)
+		} else {
+			rest = append(rest, i)
+		}
+	}
+
+	return reorderGroundingMetadata(metadata, append(order, rest...))
+}
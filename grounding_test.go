@@ -0,0 +1,321 @@
+package geminiwebtools
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+func TestGroundingProcessorSettings(t *testing.T) {
+	gp := NewGroundingProcessor()
+	metadata := &types.GroundingMetadata{
+		GroundingChunks: []types.GroundingChunk{
+			newTestChunk("Go", "https://go.dev/doc"),
+			newTestChunk("Go Blog", "https://go.dev/blog"),
+			newTestChunk("Example", "https://example.com/go"),
+		},
+	}
+
+	if !gp.IncludeCitations() || gp.MaxCitations() != constants.DefaultMaxCitations || gp.CitationStyle() != constants.CitationStyleBulleted {
+		t.Fatalf("Unexpected defaults: include=%v max=%d style=%q", gp.IncludeCitations(), gp.MaxCitations(), gp.CitationStyle())
+	}
+
+	got := gp.ProcessGrounding("text", metadata)
+	if !strings.Contains(got, "- [Go](https://go.dev/doc)") {
+		t.Errorf("Expected bulleted citations, got %q", got)
+	}
+
+	if err := gp.SetCitationStyle(constants.CitationStyleNumbered); err != nil {
+		t.Fatalf("SetCitationStyle returned error: %v", err)
+	}
+	gp.SetMaxCitations(2)
+	got = gp.ProcessGrounding("text", metadata)
+	if !strings.Contains(got, "1. [Go](https://go.dev/doc)") || !strings.Contains(got, "2. [Go Blog](https://go.dev/blog)") {
+		t.Errorf("Expected numbered citations, got %q", got)
+	}
+	if strings.Contains(got, "https://example.com/go") || !strings.Contains(got, "... and 1 more sources") {
+		t.Errorf("Expected citations to be capped at 2, got %q", got)
+	}
+
+	gp.SetIncludeCitations(false)
+	if got := gp.ProcessGrounding("text", metadata); got != "text" {
+		t.Errorf("Expected content without citations, got %q", got)
+	}
+
+	if err := gp.SetCitationStyle("footnotes"); err == nil {
+		t.Error("Expected error for unsupported citation style")
+	}
+	if gp.CitationStyle() != constants.CitationStyleNumbered {
+		t.Errorf("Expected unsupported style to leave the style unchanged, got %q", gp.CitationStyle())
+	}
+}
+
+func TestGroundingProcessorConcurrentUpdates(t *testing.T) {
+	gp := NewGroundingProcessor()
+	metadata := &types.GroundingMetadata{
+		GroundingChunks: []types.GroundingChunk{newTestChunk("Go", "https://go.dev/doc")},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			gp.SetIncludeCitations(i%2 == 0)
+			gp.SetMaxCitations(i)
+		}(i)
+		go func() {
+			defer wg.Done()
+			gp.ProcessGrounding("text", metadata)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSearcherGroundingRuntimeChanges(t *testing.T) {
+	searcher, err := NewWebSearcher(NewConfig(WithCredentialStore(&mockWebSearchCredentialStore{})))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+
+	resp := &types.GenerateContentResponse{
+		Candidates: []types.Candidate{
+			{
+				Content: types.CandidateContent{Parts: []types.CandidatePart{{Text: "Go is an open source language."}}},
+				GroundingMetadata: &types.GroundingMetadata{
+					GroundingChunks: []types.GroundingChunk{newTestChunk("Go", "https://go.dev/doc")},
+				},
+			},
+		},
+	}
+
+	result, err := searcher.processSearchResponse(resp, "golang", types.SearchOptions{}, time.Now())
+	if err != nil {
+		t.Fatalf("processSearchResponse returned error: %v", err)
+	}
+	if !strings.Contains(result.DisplayText, constants.SourcesHeader) {
+		t.Errorf("Expected citations by default, got %q", result.DisplayText)
+	}
+
+	searcher.Grounding().SetIncludeCitations(false)
+	result, err = searcher.processSearchResponse(resp, "golang", types.SearchOptions{}, time.Now())
+	if err != nil {
+		t.Fatalf("processSearchResponse returned error: %v", err)
+	}
+	if strings.Contains(result.DisplayText, constants.SourcesHeader) {
+		t.Errorf("Expected citations to be disabled on the next call, got %q", result.DisplayText)
+	}
+
+	fetcher, err := NewWebFetcher(NewConfig(WithCredentialStore(&mockWebSearchCredentialStore{})))
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	if fetcher.Grounding() != fetcher.grounding {
+		t.Error("Expected fetcher to expose its grounding processor")
+	}
+}
+
+func TestProcessSearchResponseSnippets(t *testing.T) {
+	searcher, err := NewWebSearcher(NewConfig(WithCredentialStore(&mockWebSearchCredentialStore{})))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+
+	newSupport := func(text string, indices ...int) types.GroundingSupport {
+		support := types.GroundingSupport{GroundingChunkIndices: indices}
+		support.Segment.Text = text
+		return support
+	}
+
+	resp := &types.GenerateContentResponse{
+		Candidates: []types.Candidate{
+			{
+				Content: types.CandidateContent{Parts: []types.CandidatePart{{Text: "Go is open source. It was designed at Google."}}},
+				GroundingMetadata: &types.GroundingMetadata{
+					GroundingChunks: []types.GroundingChunk{
+						newTestChunk("Go", "https://go.dev/doc"),
+						newTestChunk("Wikipedia", "https://en.wikipedia.org/wiki/Go"),
+						newTestChunk("Example", "https://example.com/go"),
+					},
+					GroundingSupports: []types.GroundingSupport{
+						newSupport("Go is open source.", 0, 1),
+						newSupport("It was designed at Google.", 1),
+						newSupport("Go is open source.", 1),
+						newSupport("", 2),
+						newSupport("Out of range.", 5),
+					},
+				},
+			},
+		},
+	}
+
+	result, err := searcher.processSearchResponse(resp, "golang", types.SearchOptions{}, time.Now())
+	if err != nil {
+		t.Fatalf("processSearchResponse returned error: %v", err)
+	}
+
+	expected := [][]string{
+		{"Go is open source."},
+		{"Go is open source.", "It was designed at Google."},
+		nil,
+	}
+	for i, want := range expected {
+		got := result.Sources[i].Snippets
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("Sources[%d].Snippets = %q, want %q", i, got, want)
+		}
+	}
+
+	if resp.Candidates[0].GroundingMetadata.GroundingChunks[1].Snippets != nil {
+		t.Error("Expected the response grounding chunks to be left unchanged")
+	}
+}
+
+func TestInsertCitationMarkers(t *testing.T) {
+	newSupport := func(start, end int, indices ...int) types.GroundingSupport {
+		support := types.GroundingSupport{GroundingChunkIndices: indices}
+		support.Segment.StartIndex = start
+		support.Segment.EndIndex = end
+		return support
+	}
+
+	content := "Go is open source. It was designed at Google."
+	tests := []struct {
+		name     string
+		content  string
+		supports []types.GroundingSupport
+		listed   int
+		want     string
+	}{
+		{
+			name:     "supports in order",
+			content:  content,
+			supports: []types.GroundingSupport{newSupport(0, 18, 0), newSupport(19, 45, 1, 2)},
+			listed:   3,
+			want:     "Go is open source.[1] It was designed at Google.[2][3]",
+		},
+		{
+			name:     "overlapping and out of order",
+			content:  content,
+			supports: []types.GroundingSupport{newSupport(19, 45, 2), newSupport(0, 45, 1, 0), newSupport(0, 18, 0)},
+			listed:   3,
+			want:     "Go is open source.[1] It was designed at Google.[1][2][3]",
+		},
+		{
+			name:     "invalid indices",
+			content:  content,
+			supports: []types.GroundingSupport{newSupport(0, 18, 0, 5, -1), newSupport(19, 100, 1), newSupport(0, 0, 1)},
+			listed:   3,
+			want:     "Go is open source.[1] It was designed at Google.",
+		},
+		{
+			name:     "unlisted chunks",
+			content:  content,
+			supports: []types.GroundingSupport{newSupport(0, 18, 0, 2)},
+			listed:   2,
+			want:     "Go is open source.[1] It was designed at Google.",
+		},
+		{
+			name:     "index inside a character",
+			content:  "Café au lait.",
+			supports: []types.GroundingSupport{newSupport(0, 4, 0)},
+			listed:   1,
+			want:     "Café[1] au lait.",
+		},
+		{
+			name:     "empty content",
+			content:  "",
+			supports: []types.GroundingSupport{newSupport(0, 18, 0)},
+			listed:   1,
+			want:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := insertCitationMarkers(tt.content, tt.supports, tt.listed); got != tt.want {
+				t.Errorf("insertCitationMarkers() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	gp := NewGroundingProcessor()
+	metadata := &types.GroundingMetadata{
+		GroundingChunks:   []types.GroundingChunk{newTestChunk("Go", "https://go.dev/doc")},
+		GroundingSupports: []types.GroundingSupport{newSupport(0, 18, 0)},
+	}
+	if got := gp.ProcessGrounding(content, metadata); !strings.HasPrefix(got, content) {
+		t.Errorf("Expected no markers by default, got %q", got)
+	}
+	gp.SetInsertCitations(true)
+	if got := gp.ProcessGrounding(content, metadata); !strings.HasPrefix(got, "Go is open source.[1] It was") {
+		t.Errorf("Expected inline markers, got %q", got)
+	}
+}
+
+func TestCitationLengthLimits(t *testing.T) {
+	searcher, err := NewWebSearcher(NewConfig(WithCredentialStore(&mockWebSearchCredentialStore{})))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+
+	longTitle := strings.Repeat("é", 10000)
+	longURI := "https://example.com/" + strings.Repeat("a", 10000)
+	resp := &types.GenerateContentResponse{
+		Candidates: []types.Candidate{
+			{
+				Content: types.CandidateContent{Parts: []types.CandidatePart{{Text: "Answer."}}},
+				GroundingMetadata: &types.GroundingMetadata{
+					GroundingChunks: []types.GroundingChunk{newTestChunk(longTitle, longURI)},
+				},
+			},
+		},
+	}
+
+	result, err := searcher.processSearchResponse(resp, "query", types.SearchOptions{}, time.Now())
+	if err != nil {
+		t.Fatalf("processSearchResponse returned error: %v", err)
+	}
+
+	wantTitle := strings.Repeat("é", constants.DefaultMaxCitationTitleLength-3) + "..."
+	wantURI := longURI[:constants.DefaultMaxCitationURILength-3] + "..."
+	if !strings.Contains(result.DisplayText, "["+wantTitle+"]("+wantURI+")") {
+		t.Errorf("Expected truncated title and URI in rendered citations, got %d bytes", len(result.DisplayText))
+	}
+	if result.Sources[0].Web.Title != longTitle || result.Sources[0].Web.URI != longURI {
+		t.Error("Expected structured sources to keep the full title and URI")
+	}
+
+	searcher.Grounding().SetCitationLengthLimits(0, 0)
+	result, err = searcher.processSearchResponse(resp, "query", types.SearchOptions{}, time.Now())
+	if err != nil {
+		t.Fatalf("processSearchResponse returned error: %v", err)
+	}
+	if !strings.Contains(result.DisplayText, "["+longTitle+"]("+longURI+")") {
+		t.Error("Expected no truncation when the limits are disabled")
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		input     string
+		maxLength int
+		want      string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"longer than ten", 10, "longer ..."},
+		{"日本語のタイトル", 5, "日本..."},
+		{"abcdef", 2, "ab"},
+		{"anything", 0, "anything"},
+	}
+
+	for _, tt := range tests {
+		if got := truncateRunes(tt.input, tt.maxLength); got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.input, tt.maxLength, got, tt.want)
+		}
+	}
+}
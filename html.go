@@ -0,0 +1,290 @@
+package geminiwebtools
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// skippedElements are elements whose content is never part of the extracted text.
+var skippedElements = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+}
+
+// blockElements are elements that start a new paragraph in the extracted text.
+var blockElements = map[atom.Atom]bool{
+	atom.Address:    true,
+	atom.Article:    true,
+	atom.Aside:      true,
+	atom.Blockquote: true,
+	atom.Dd:         true,
+	atom.Div:        true,
+	atom.Dl:         true,
+	atom.Dt:         true,
+	atom.Figcaption: true,
+	atom.Figure:     true,
+	atom.Footer:     true,
+	atom.Form:       true,
+	atom.Header:     true,
+	atom.Hr:         true,
+	atom.Li:         true,
+	atom.Main:       true,
+	atom.Nav:        true,
+	atom.Ol:         true,
+	atom.P:          true,
+	atom.Pre:        true,
+	atom.Section:    true,
+	atom.Table:      true,
+	atom.Tr:         true,
+	atom.Ul:         true,
+}
+
+// headingLevel returns the heading level (1-6) of the node, or 0 if it is not a heading.
+func headingLevel(n *html.Node) int {
+	if n.Type != html.ElementNode {
+		return 0
+	}
+	switch n.DataAtom {
+	case atom.H1:
+		return 1
+	case atom.H2:
+		return 2
+	case atom.H3:
+		return 3
+	case atom.H4:
+		return 4
+	case atom.H5:
+		return 5
+	case atom.H6:
+		return 6
+	}
+	return 0
+}
+
+// textBuilder accumulates extracted text, collapsing insignificant whitespace.
+// Separating spaces are kept pending until more text follows, so that they never
+// have to be trimmed from the end of the buffer.
+type textBuilder struct {
+	sb           strings.Builder
+	pendingSpace bool
+}
+
+// writeText appends text, collapsing whitespace runs into single spaces.
+func (b *textBuilder) writeText(text string) {
+	if text == "" {
+		return
+	}
+
+	if isSpaceByte(text[0]) {
+		b.space()
+	}
+	fields := strings.Fields(text)
+	for i, field := range fields {
+		if i > 0 {
+			b.space()
+		}
+		b.write(field)
+	}
+	if len(fields) > 0 && isSpaceByte(text[len(text)-1]) {
+		b.space()
+	}
+}
+
+// space requests a single separating space before the next text.
+// Spaces at the start of the text or of a line are dropped.
+func (b *textBuilder) space() {
+	if b.sb.Len() > 0 && !b.endsWith('\n') {
+		b.pendingSpace = true
+	}
+}
+
+// writeRaw appends text verbatim, used for preformatted content.
+func (b *textBuilder) writeRaw(text string) {
+	b.write(text)
+}
+
+// write appends text after any pending separating space.
+func (b *textBuilder) write(text string) {
+	if b.pendingSpace {
+		b.sb.WriteByte(' ')
+		b.pendingSpace = false
+	}
+	b.sb.WriteString(text)
+}
+
+// lineBreak ends the current line.
+func (b *textBuilder) lineBreak() {
+	b.pendingSpace = false
+	b.sb.WriteByte('\n')
+}
+
+// startLine ends the current line unless nothing has been written on it yet.
+func (b *textBuilder) startLine() {
+	b.pendingSpace = false
+	if b.sb.Len() > 0 && !b.endsWith('\n') {
+		b.sb.WriteByte('\n')
+	}
+}
+
+// paragraphBreak ends the current paragraph with a blank line.
+func (b *textBuilder) paragraphBreak() {
+	b.pendingSpace = false
+	if b.sb.Len() > 0 && !strings.HasSuffix(b.sb.String(), "\n\n") {
+		if b.endsWith('\n') {
+			b.sb.WriteByte('\n')
+		} else {
+			b.sb.WriteString("\n\n")
+		}
+	}
+}
+
+// String returns the accumulated text with surrounding whitespace removed.
+func (b *textBuilder) String() string {
+	return strings.TrimSpace(b.sb.String())
+}
+
+func (b *textBuilder) endsWith(c byte) bool {
+	s := b.sb.String()
+	return len(s) > 0 && s[len(s)-1] == c
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// nodeText returns the collapsed text content of a node.
+func nodeText(n *html.Node) string {
+	var b textBuilder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && skippedElements[n.DataAtom] {
+			return
+		}
+		if n.Type == html.TextNode {
+			b.writeText(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// findElement returns the first element with the given atom in document order.
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// extractTitle returns the document title, or an empty string if none is present.
+func extractTitle(doc *html.Node) string {
+	if title := findElement(doc, atom.Title); title != nil {
+		return nodeText(title)
+	}
+	return ""
+}
+
+// htmlTitle returns the title of an HTML document, or an empty string if it has none.
+func htmlTitle(htmlContent string) string {
+	doc, err := html.Parse(strings.NewReader(htmlContent))
+	if err != nil {
+		return ""
+	}
+	return extractTitle(doc)
+}
+
+// extractOutline splits an HTML document into sections by its heading hierarchy.
+// Content before the first heading becomes a level 0 section without a heading.
+// Pages without headings yield a single level 0 section titled with the document title.
+func extractOutline(htmlContent string) []types.Section {
+	doc, err := html.Parse(strings.NewReader(htmlContent))
+	if err != nil {
+		return []types.Section{{Content: strings.TrimSpace(htmlContent)}}
+	}
+
+	var sections []types.Section
+	current := types.Section{}
+	var body textBuilder
+
+	flush := func() {
+		current.Content = body.String()
+		if current.Heading != "" || current.Content != "" {
+			sections = append(sections, current)
+		}
+		body = textBuilder{}
+	}
+
+	var walk func(n *html.Node, inPre bool)
+	walk = func(n *html.Node, inPre bool) {
+		switch n.Type {
+		case html.ElementNode:
+			if skippedElements[n.DataAtom] {
+				return
+			}
+			if level := headingLevel(n); level > 0 {
+				flush()
+				current = types.Section{Level: level, Heading: nodeText(n)}
+				return
+			}
+			if n.DataAtom == atom.Br {
+				body.lineBreak()
+				return
+			}
+		case html.TextNode:
+			if inPre {
+				body.writeRaw(n.Data)
+			} else {
+				body.writeText(n.Data)
+			}
+			return
+		}
+
+		isBlock := n.Type == html.ElementNode && blockElements[n.DataAtom]
+		if isBlock {
+			body.paragraphBreak()
+		}
+		childInPre := inPre || (n.Type == html.ElementNode && n.DataAtom == atom.Pre)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, childInPre)
+		}
+		if isBlock {
+			body.paragraphBreak()
+		}
+	}
+	walk(doc, false)
+	flush()
+
+	hasHeadings := false
+	for _, section := range sections {
+		if section.Level > 0 {
+			hasHeadings = true
+			break
+		}
+	}
+	if !hasHeadings {
+		content := ""
+		if len(sections) > 0 {
+			content = sections[0].Content
+		}
+		return []types.Section{{Heading: extractTitle(doc), Content: content}}
+	}
+
+	return sections
+}
@@ -0,0 +1,98 @@
+package geminiwebtools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+func TestExtractOutline(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		expected []types.Section
+	}{
+		{
+			name: "nested headings",
+			html: `<html><head><title>Guide</title><style>p{}</style></head><body>
+				<p>Intro text</p>
+				<h1>Getting Started</h1>
+				<p>Install the <b>tool</b>.</p>
+				<h2>Requirements</h2>
+				<ul><li>Go 1.24</li><li>Git</li></ul>
+				<h3>Optional</h3>
+				<p>Docker<br>Make</p>
+				<h2>Usage</h2>
+				<pre>go run .
+  --flag</pre>
+				<script>ignored()</script>
+				<h1>Reference</h1>
+			</body></html>`,
+			expected: []types.Section{
+				{Level: 0, Heading: "", Content: "Intro text"},
+				{Level: 1, Heading: "Getting Started", Content: "Install the tool."},
+				{Level: 2, Heading: "Requirements", Content: "Go 1.24\n\nGit"},
+				{Level: 3, Heading: "Optional", Content: "Docker\nMake"},
+				{Level: 2, Heading: "Usage", Content: "go run .\n  --flag"},
+				{Level: 1, Heading: "Reference", Content: ""},
+			},
+		},
+		{
+			name: "heading with inline markup",
+			html: `<h2>The <code>Fetch</code> method</h2><p>Details</p>`,
+			expected: []types.Section{
+				{Level: 2, Heading: "The Fetch method", Content: "Details"},
+			},
+		},
+		{
+			name: "no headings",
+			html: `<html><head><title>Plain Page</title></head><body><p>First</p><p>Second</p></body></html>`,
+			expected: []types.Section{
+				{Level: 0, Heading: "Plain Page", Content: "First\n\nSecond"},
+			},
+		},
+		{
+			name: "empty document",
+			html: ``,
+			expected: []types.Section{
+				{Level: 0, Heading: "", Content: ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := extractOutline(tt.html)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("extractOutline() =\n%#v\nwant\n%#v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractOutlineLargeInput(t *testing.T) {
+	const blocks = 200000 // about 2.6MB
+	htmlContent := "<h1>Large</h1>\n" + strings.Repeat("<p>text </p>\n", blocks)
+
+	start := time.Now()
+	sections := extractOutline(htmlContent)
+	elapsed := time.Since(start)
+
+	if len(sections) != 1 {
+		t.Fatalf("Expected 1 section, got %d", len(sections))
+	}
+	if got := strings.Count(sections[0].Content, "text"); got != blocks {
+		t.Errorf("Expected %d paragraphs, got %d", blocks, got)
+	}
+	if strings.Contains(sections[0].Content, " \n") {
+		t.Error("Content should not contain trailing spaces")
+	}
+	// Extraction must stay linear in the input size; a quadratic builder takes
+	// tens of seconds on this input.
+	if elapsed > 10*time.Second {
+		t.Errorf("extractOutline took %v", elapsed)
+	}
+}
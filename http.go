@@ -2,22 +2,44 @@ package geminiwebtools
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/retry"
 )
 
 // HTTPClient provides secure HTTP functionality for web content fetching.
 type HTTPClient struct {
+	// mu protects client, which Reset replaces
+	mu     sync.RWMutex
 	client *http.Client
 	config *HTTPClientConfig
+
+	// http1 is the HTTP/1.1-only client of HTTP/2 downgrades, created on first use
+	http1 *http.Client
+
+	// pool is the pool the client was taken from; nil means the global pool
+	pool *ClientPool
+
+	// custom is set for clients supplied by the caller, which are not pooled
+	custom bool
 }
 
 // ClientPool manages a pool of reusable HTTP clients for different configurations.
@@ -38,6 +60,154 @@ type HTTPClientConfig struct {
 	AllowPrivateIPs bool
 	MaxContentSize  int64
 	UserAgent       string
+
+	// PinnedCertHashes maps a host to the base64-encoded SHA-256 hashes of the
+	// SubjectPublicKeyInfo of certificates allowed for it. Connections to a pinned
+	// host fail with a CertificatePinError unless a certificate in the chain matches
+	// one of the pins. Hosts without pins use standard verification only.
+	PinnedCertHashes map[string][]string
+
+	// ProxyURL routes requests through an http, https or socks5 proxy. Hosts listed
+	// in the NO_PROXY environment variable are connected to directly. The proxy itself
+	// may be on a private network; with AllowPrivateIPs false, proxied requests to
+	// hosts that resolve to private IPs are still rejected. An invalid URL fails every
+	// request instead of connecting directly.
+	ProxyURL string
+
+	// AllowedDomains and BlockedDomains are checked for every redirect target, as
+	// described for WebFetchConfig.AllowedDomains. Redirects to hosts they reject fail
+	// with a DomainPolicyError.
+	AllowedDomains []string
+	BlockedDomains []string
+
+	// HonorRefresh makes fetches follow the URL of a Refresh response header, or of a
+	// <meta http-equiv="refresh"> element of an HTML page, whatever its delay. The
+	// target is checked like a redirect and counts toward constants.MaxRedirects; a
+	// refresh that only reloads the page is ignored. By default, and whenever
+	// FollowRedirects is false, the page is returned as received.
+	HonorRefresh bool
+
+	// CookieJar stores the cookies of Set-Cookie response headers and sends them with
+	// later requests of clients sharing it, including redirects. If nil, Set-Cookie
+	// headers are ignored and no cookies are kept between requests.
+	CookieJar http.CookieJar
+
+	// AllowHTTP2Downgrade retries a request once over HTTP/1.1 when it fails with an
+	// HTTP/2-specific transport error, as caused by proxies and middleboxes that break
+	// HTTP/2. HTTP/2 is still attempted first.
+	AllowHTTP2Downgrade bool
+
+	// Logger receives a warning for every HTTP/2 downgrade. If nil, nothing is logged.
+	Logger *slog.Logger
+
+	// MaxConcurrentDNSLookups bounds the host lookups in flight when dialing, so that
+	// bursts of fetches to many distinct hosts do not overload the resolver. Dials
+	// beyond the limit wait for a slot until their context is done. If zero or less,
+	// constants.DefaultMaxConcurrentDNSLookups is used.
+	MaxConcurrentDNSLookups int
+
+	// MaxDecompressionRatio and MaxDecompressedSize guard against decompression bombs:
+	// a fetch fails with ErrDecompressionLimit as soon as a compressed body decodes to
+	// more than MaxDecompressionRatio times its compressed size, once past
+	// constants.DecompressionRatioMinSize, or to more than MaxDecompressedSize bytes.
+	// Zero or less uses constants.DefaultMaxDecompressionRatio and
+	// constants.DefaultMaxDecompressedSize.
+	MaxDecompressionRatio int
+	MaxDecompressedSize   int64
+}
+
+// CertificatePinError is returned when a pinned host presents no certificate matching its pins.
+type CertificatePinError struct {
+	Host string
+}
+
+// Error implements the error interface.
+func (e *CertificatePinError) Error() string {
+	return fmt.Sprintf("certificate pin mismatch for host %s", e.Host)
+}
+
+// HTTPStatusError is returned when a fetched URL responds with a status code outside
+// the 2xx range, including redirects that were not followed.
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+	URL        string
+}
+
+// Error implements the error interface.
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("HTTP error: %d %s", e.StatusCode, e.Status)
+}
+
+// IsRetryable reports whether the status code indicates a transient failure.
+func (e *HTTPStatusError) IsRetryable() bool {
+	return retry.IsRetryableStatus(e.StatusCode)
+}
+
+// redactedHeaders are request headers whose values are never recorded.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+}
+
+// headerRecorder records the request header fields written to the wire, as reported
+// by an httptrace.ClientTrace. Only the fields of the most recent request are kept,
+// so after redirects and retries it holds the headers of the final request.
+type headerRecorder struct {
+	mu      sync.Mutex
+	headers map[string]string
+}
+
+// withTrace returns a context that records the headers of requests made with it.
+func (r *headerRecorder) withTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			r.mu.Lock()
+			r.headers = make(map[string]string)
+			r.mu.Unlock()
+		},
+		WroteHeaderField: func(key string, value []string) {
+			// Skip HTTP/2 pseudo-header fields such as :authority
+			if strings.HasPrefix(key, ":") {
+				return
+			}
+			key = http.CanonicalHeaderKey(key)
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.headers == nil {
+				r.headers = make(map[string]string)
+			}
+			if redactedHeaders[key] {
+				r.headers[key] = constants.RedactedHeaderValue
+				return
+			}
+			joined := strings.Join(value, ", ")
+			if existing, ok := r.headers[key]; ok {
+				joined = existing + ", " + joined
+			}
+			r.headers[key] = joined
+		},
+	})
+}
+
+// recordedHeaders returns a copy of the recorded headers, or nil if none were recorded.
+// A nil recorder records nothing.
+func (r *headerRecorder) recordedHeaders() map[string]string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.headers) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(r.headers))
+	for key, value := range r.headers {
+		headers[key] = value
+	}
+	return headers
 }
 
 // DefaultHTTPClientConfig returns a default HTTP client configuration.
@@ -72,29 +242,45 @@ func (cp *ClientPool) getOrCreateClient(config *HTTPClientConfig) *http.Client {
 		return client
 	}
 
+	client := newPooledClient(config)
+	cp.clients[key] = client
+	return client
+}
+
+// resetClient closes the idle connections of the pooled client for the configuration
+// and replaces it in the pool with a client using a new transport, which is returned.
+func (cp *ClientPool) resetClient(config *HTTPClientConfig) *http.Client {
+	key := cp.configKey(config)
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if old, exists := cp.clients[key]; exists {
+		old.CloseIdleConnections()
+	}
+
+	client := newPooledClient(config)
+	cp.clients[key] = client
+	return client
+}
+
+// newPooledClient creates an HTTP client with its own transport for the configuration.
+func newPooledClient(config *HTTPClientConfig) *http.Client {
 	client := &http.Client{
 		Timeout: config.Timeout,
+		Jar:     config.CookieJar,
 	}
 
-	// Configure secure redirect policy
-	if !config.FollowRedirects {
-		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		}
-	} else {
-		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-			// Limit redirect count
-			if len(via) >= constants.MaxRedirects {
-				return fmt.Errorf("too many redirects (max: %d)", constants.MaxRedirects)
-			}
+	// Dials, proxy checks and redirect checks share the lookup limit
+	resolver := newHostResolver(config.MaxConcurrentDNSLookups)
 
-			// Validate redirect URL
-			if err := validateRedirectURL(req.URL, via); err != nil {
-				return fmt.Errorf("redirect validation failed: %w", err)
-			}
+	// Configure secure redirect policy
+	client.CheckRedirect = redirectPolicy(config, resolver)
 
-			return nil
-		}
+	// The configured proxy may be on a private network
+	var allowedProxyAddr string
+	if proxy, err := parseProxyURL(config.ProxyURL); err == nil {
+		allowedProxyAddr = proxyAddr(proxy)
 	}
 
 	// Configure transport with optimized connection pooling
@@ -108,19 +294,19 @@ func (cp *ClientPool) getOrCreateClient(config *HTTPClientConfig) *http.Client {
 		// Timeouts using constants
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 			// Extract host and port
-			host, _, err := net.SplitHostPort(addr)
+			host, port, err := net.SplitHostPort(addr)
 			if err != nil {
 				return nil, fmt.Errorf("invalid address: %w", err)
 			}
 
 			// Resolve the address
-			ips, err := net.LookupIP(host)
+			ips, err := resolver.lookup(ctx, host)
 			if err != nil {
 				return nil, fmt.Errorf("failed to resolve host: %w", err)
 			}
 
 			// Check for private IPs if not allowed
-			if !config.AllowPrivateIPs {
+			if !config.AllowPrivateIPs && addr != allowedProxyAddr {
 				for _, ip := range ips {
 					if isPrivateIP(ip) {
 						return nil, fmt.Errorf("private IP addresses are not allowed: %s", ip)
@@ -128,12 +314,22 @@ func (cp *ClientPool) getOrCreateClient(config *HTTPClientConfig) *http.Client {
 				}
 			}
 
-			// Use optimized dialer with keep-alive
+			// Dial the checked addresses rather than resolving the host again
 			dialer := &net.Dialer{
 				Timeout:   constants.DefaultDialerTimeout,
 				KeepAlive: constants.KeepAliveTimeout,
 			}
-			return dialer.DialContext(ctx, network, addr)
+			var conn net.Conn
+			for _, ip := range ips {
+				conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+				if err == nil {
+					return conn, nil
+				}
+			}
+			if err == nil {
+				err = fmt.Errorf("no addresses found for host %s", host)
+			}
+			return nil, err
 		},
 		TLSHandshakeTimeout:   constants.TLSHandshakeTimeout,
 		ResponseHeaderTimeout: constants.ResponseHeaderTimeout,
@@ -151,22 +347,181 @@ func (cp *ClientPool) getOrCreateClient(config *HTTPClientConfig) *http.Client {
 		ReadBufferSize:    32 * 1024, // 32KB read buffer
 	}
 
+	// Enforce certificate pins on top of standard verification
+	if len(config.PinnedCertHashes) > 0 {
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			VerifyConnection: verifyCertificatePins(config.PinnedCertHashes),
+		}
+	}
+
+	if config.ProxyURL != "" {
+		transport.Proxy = newTransportProxy(config, resolver)
+	}
+
 	client.Transport = transport
-	cp.clients[key] = client
 	return client
 }
 
+// redirectPolicy returns the CheckRedirect function of clients for the configuration.
+// Redirect hosts are resolved with resolver.
+func redirectPolicy(config *HTTPClientConfig, resolver *hostResolver) func(req *http.Request, via []*http.Request) error {
+	if !config.FollowRedirects {
+		return func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		// Limit redirect count
+		if len(via) >= constants.MaxRedirects {
+			return fmt.Errorf("too many redirects (max: %d)", constants.MaxRedirects)
+		}
+
+		// Validate redirect URL
+		if err := validateRedirectURL(req.Context(), resolver, req.URL, via); err != nil {
+			return fmt.Errorf("redirect validation failed: %w", err)
+		}
+		if err := checkDomainPolicy(req.URL.Hostname(), config.AllowedDomains, config.BlockedDomains); err != nil {
+			return fmt.Errorf("redirect validation failed: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// newHTTP1Client creates an HTTP client like newPooledClient whose transport only
+// speaks HTTP/1.1.
+func newHTTP1Client(config *HTTPClientConfig) *http.Client {
+	client := newPooledClient(config)
+	transport := client.Transport.(*http.Transport)
+	transport.ForceAttemptHTTP2 = false
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	transport.Protocols = protocols
+	return client
+}
+
+// newTransportProxy returns the Proxy function of a transport for the configuration.
+// Requests sent through the proxy are resolved by the proxy, so their target hosts are
+// checked for private IPs here rather than when dialing.
+func newTransportProxy(config *HTTPClientConfig, resolver *hostResolver) func(*http.Request) (*url.URL, error) {
+	proxyFunc, err := newProxyFunc(config.ProxyURL)
+	if err != nil {
+		return failingProxy(err)
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		proxy, err := proxyFunc(req)
+		if err != nil || proxy == nil || config.AllowPrivateIPs {
+			return proxy, err
+		}
+
+		// Hosts that do not resolve locally are left to the proxy
+		ips, lookupErr := resolver.lookup(req.Context(), req.URL.Hostname())
+		if lookupErr != nil {
+			return proxy, nil
+		}
+		for _, ip := range ips {
+			if isPrivateIP(ip) {
+				return nil, fmt.Errorf("private IP addresses are not allowed: %s", ip)
+			}
+		}
+		return proxy, nil
+	}
+}
+
 // configKey generates a unique key for the client configuration.
 func (cp *ClientPool) configKey(config *HTTPClientConfig) string {
-	return fmt.Sprintf("%v_%v_%v_%d_%s",
+	var jar string
+	if config.CookieJar != nil {
+		jar = fmt.Sprintf("%p", config.CookieJar)
+	}
+	return fmt.Sprintf("%v_%v_%v_%d_%s_%s_%s_%q_%q_%s_%d",
 		config.Timeout,
 		config.FollowRedirects,
 		config.AllowPrivateIPs,
 		config.MaxContentSize,
 		config.UserAgent,
+		pinsKey(config.PinnedCertHashes),
+		config.ProxyURL,
+		config.AllowedDomains,
+		config.BlockedDomains,
+		jar,
+		config.MaxConcurrentDNSLookups,
 	)
 }
 
+// pinsKey returns a deterministic representation of certificate pins for use in pool keys.
+func pinsKey(pins map[string][]string) string {
+	if len(pins) == 0 {
+		return ""
+	}
+
+	hosts := make([]string, 0, len(pins))
+	for host := range pins {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var sb strings.Builder
+	for _, host := range hosts {
+		hashes := append([]string(nil), pins[host]...)
+		sort.Strings(hashes)
+		sb.WriteString(strings.ToLower(host))
+		sb.WriteByte('=')
+		sb.WriteString(strings.Join(hashes, ","))
+		sb.WriteByte(';')
+	}
+	return sb.String()
+}
+
+// verifyCertificatePins returns a VerifyConnection callback that checks the verified
+// certificate chains against the pins configured for the connection's host.
+// Connections to IP addresses carry no server name; for those, the pins of every pinned
+// IP address the verified leaf certificate is valid for are enforced.
+// Hosts without pins are accepted after standard verification.
+func verifyCertificatePins(pins map[string][]string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+			return nil
+		}
+		leaf := cs.VerifiedChains[0][0]
+
+		host := cs.ServerName
+		var allowed []string
+		for pinnedHost, hashes := range pins {
+			matches := strings.EqualFold(pinnedHost, host)
+			if host == "" && net.ParseIP(pinnedHost) != nil {
+				matches = leaf.VerifyHostname(pinnedHost) == nil
+				if matches {
+					host = pinnedHost
+				}
+			}
+			if matches {
+				allowed = append(allowed, hashes...)
+			}
+		}
+		if len(allowed) == 0 {
+			return nil
+		}
+
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				if slices.Contains(allowed, certificateSPKIHash(cert)) {
+					return nil
+				}
+			}
+		}
+		return &CertificatePinError{Host: host}
+	}
+}
+
+// certificateSPKIHash returns the base64-encoded SHA-256 hash of the certificate's SubjectPublicKeyInfo.
+func certificateSPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
 // NewHTTPClient creates a new HTTP client with the specified configuration using connection pooling.
 func NewHTTPClient(config *HTTPClientConfig) *HTTPClient {
 	if config == nil {
@@ -179,39 +534,225 @@ func NewHTTPClient(config *HTTPClientConfig) *HTTPClient {
 	return &HTTPClient{
 		client: client,
 		config: config,
+		pool:   globalClientPool,
+	}
+}
+
+// NewHTTPClientWith creates an HTTP client that sends requests with the given client
+// instead of a pooled one, for instrumented or test transports. The transport is used
+// as it is, so the private IP checks, certificate pins and proxy of the configuration
+// do not apply, and HTTP/2 downgrades are not attempted. The configuration's redirect
+// policy and cookie jar are used if the client sets none.
+func NewHTTPClientWith(client *http.Client, config *HTTPClientConfig) *HTTPClient {
+	if config == nil {
+		config = DefaultHTTPClientConfig()
+	}
+
+	custom := *client
+	if custom.CheckRedirect == nil {
+		custom.CheckRedirect = redirectPolicy(config, newHostResolver(config.MaxConcurrentDNSLookups))
+	}
+	if custom.Jar == nil {
+		custom.Jar = config.CookieJar
+	}
+
+	return &HTTPClient{
+		client: &custom,
+		config: config,
+		custom: true,
+	}
+}
+
+// Reset closes the idle connections of the client and installs a new transport built
+// from its configuration, so that a long-running process stops reusing connections
+// made before a network, proxy or DNS change. The new transport also replaces the
+// pooled one for the same configuration, so clients created afterwards use it; other
+// existing clients keep their transport until they are reset themselves.
+// Requests in flight complete on the old transport. A client created with
+// NewHTTPClientWith only closes its idle connections.
+func (hc *HTTPClient) Reset() {
+	if hc.custom {
+		hc.httpClient().CloseIdleConnections()
+		return
+	}
+
+	pool := hc.pool
+	if pool == nil {
+		pool = globalClientPool
 	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.client.CloseIdleConnections()
+	hc.client = pool.resetClient(hc.config)
+	if hc.http1 != nil {
+		hc.http1.CloseIdleConnections()
+		hc.http1 = nil
+	}
+}
+
+// httpClient returns the HTTP client currently used for requests.
+func (hc *HTTPClient) httpClient() *http.Client {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.client
 }
 
 // FetchContent fetches content from a URL and returns the content, content type, and size.
+// Every 2xx response is a success; a 204 No Content response yields empty content.
+// Compressed responses are decompressed, and text content is transcoded to UTF-8 from
+// its declared or detected charset; the size is that of the decompressed content before
+// transcoding.
 func (hc *HTTPClient) FetchContent(ctx context.Context, urlStr string) (content, contentType string, contentSize int, err error) {
+	return hc.FetchContentWithHeaders(ctx, urlStr, nil)
+}
+
+// FetchContentWithHeaders is like FetchContent but sends the given headers in addition
+// to the default ones, for sites that require credentials, cookies or a referer. A
+// header given by the caller replaces the default header of the same name. Headers
+// whose names or values contain line breaks are rejected.
+func (hc *HTTPClient) FetchContentWithHeaders(ctx context.Context, urlStr string, headers http.Header) (content, contentType string, contentSize int, err error) {
+	fetched, err := hc.fetch(ctx, urlStr, headers)
+	return fetched.content, fetched.contentType, fetched.size, err
+}
+
+// fetchedContent is the content of a response fetched by an HTTPClient.
+type fetchedContent struct {
+	content     string
+	contentType string
+	size        int
+
+	// statusCode is the 2xx status code of the response
+	statusCode int
+
+	// refreshURL is the unresolved URL of the page's refresh directive, set only if
+	// HTTPClientConfig.HonorRefresh applies
+	refreshURL string
+
+	// charset describes the transcoding of text content to UTF-8
+	charset charsetInfo
+
+	// connection describes the protocol and TLS parameters of the response
+	connection connectionInfo
+}
+
+// connectionInfo describes how a response was received.
+type connectionInfo struct {
+	// protocol is the protocol of the response, such as "HTTP/2.0" or "HTTP/1.1"
+	protocol string
+
+	// tlsVersion and cipherSuite are the negotiated TLS version and cipher suite,
+	// empty for responses received without TLS
+	tlsVersion  string
+	cipherSuite string
+}
+
+// responseConnection returns the protocol and TLS parameters of a response.
+func responseConnection(resp *http.Response) connectionInfo {
+	info := connectionInfo{protocol: resp.Proto}
+	if resp.TLS != nil {
+		info.tlsVersion = tls.VersionName(resp.TLS.Version)
+		info.cipherSuite = tls.CipherSuiteName(resp.TLS.CipherSuite)
+	}
+	return info
+}
+
+// fetch implements FetchContentWithHeaders, also reporting the charset of the content.
+// Content truncated at the size limit, and the content read before the context ended
+// during the body read, are returned along with the error.
+func (hc *HTTPClient) fetch(ctx context.Context, urlStr string, headers http.Header) (fetchedContent, error) {
+	if err := validateHeaders(headers); err != nil {
+		return fetchedContent{}, err
+	}
+
 	// Check if context is already cancelled
 	select {
 	case <-ctx.Done():
-		return "", "", 0, ctx.Err()
+		return fetchedContent{}, ctx.Err()
 	default:
 	}
 
+	var via []*http.Request
+	for {
+		req, err := hc.newRequest(ctx, urlStr, headers)
+		if err != nil {
+			return fetchedContent{}, err
+		}
+		fetched, err := hc.fetchResponse(ctx, req)
+		if err != nil || fetched.refreshURL == "" {
+			return fetched, err
+		}
+
+		via = append(via, req)
+		target, err := hc.refreshTarget(req, fetched.refreshURL, via)
+		if err != nil || target == "" {
+			return fetched, err
+		}
+		urlStr = target
+	}
+}
+
+// refreshTarget resolves the refresh URL of the response to req and checks it with the
+// redirect policy of the client. It returns an empty string if the response is to be
+// kept: the refresh only reloads the page, leads to a URL that cannot be fetched, or
+// the redirect policy does not follow redirects.
+func (hc *HTTPClient) refreshTarget(req *http.Request, refreshURL string, via []*http.Request) (string, error) {
+	target, err := req.URL.Parse(refreshURL)
+	if err != nil || (target.Scheme != constants.SchemeHTTP && target.Scheme != constants.SchemeHTTPS) {
+		return "", nil
+	}
+	target.Fragment = ""
+	current := *req.URL
+	current.Fragment = ""
+	if target.String() == current.String() {
+		return "", nil
+	}
+
+	if len(via) >= constants.MaxRedirects {
+		return "", fmt.Errorf("too many redirects (max: %d)", constants.MaxRedirects)
+	}
+	next, err := http.NewRequestWithContext(req.Context(), http.MethodGet, target.String(), nil)
+	if err != nil {
+		return "", nil
+	}
+	if checkRedirect := hc.httpClient().CheckRedirect; checkRedirect != nil {
+		if err := checkRedirect(next, via); err != nil {
+			if errors.Is(err, http.ErrUseLastResponse) {
+				return "", nil
+			}
+			return "", fmt.Errorf("refresh to %s: %w", target, err)
+		}
+	}
+	return target.String(), nil
+}
+
+// newRequest creates a GET request for the URL with the default headers and the
+// caller's headers.
+func (hc *HTTPClient) newRequest(ctx context.Context, urlStr string, headers http.Header) (*http.Request, error) {
 	// Validate URL
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
-		return "", "", 0, fmt.Errorf("invalid URL: %w", err)
+		return nil, fmt.Errorf("invalid URL: %w", err)
 	}
 
 	// Only allow HTTP and HTTPS
 	if parsedURL.Scheme != constants.SchemeHTTP && parsedURL.Scheme != constants.SchemeHTTPS {
-		return "", "", 0, fmt.Errorf("unsupported scheme: %s", parsedURL.Scheme)
+		return nil, fmt.Errorf("unsupported scheme: %s", parsedURL.Scheme)
 	}
 
 	// Create request
 	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
 	if err != nil {
-		return "", "", 0, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set security headers
 	req.Header.Set("User-Agent", hc.config.UserAgent)
 	req.Header.Set("Accept", constants.DefaultAcceptHeader)
 	req.Header.Set("Accept-Language", constants.DefaultAcceptLanguageHeader)
+	// Setting Accept-Encoding turns off the transport's transparent gzip handling;
+	// the body is decoded below, so that brotli and deflate are supported as well
+	req.Header.Set("Accept-Encoding", constants.DefaultAcceptEncodingHeader)
 	req.Header.Set("DNT", "1")                           // Do Not Track
 	req.Header.Set("X-Requested-With", "geminiwebtools") // Identify as non-browser
 	req.Header.Set("Cache-Control", "no-cache")          // Prevent caching of requests
@@ -220,37 +761,109 @@ func (hc *HTTPClient) FetchContent(ctx context.Context, urlStr string) (content,
 	req.Header.Set("X-Frame-Options", "DENY")            // Prevent framing (if response is HTML)
 	req.Header.Set("Referrer-Policy", "no-referrer")     // Don't send referrer
 
-	// Make request
-	resp, err := hc.client.Do(req)
+	// Caller headers replace the defaults of the same name
+	for key, values := range headers {
+		req.Header.Del(key)
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	return req, nil
+}
+
+// http1Client returns the HTTP/1.1-only client used for HTTP/2 downgrades.
+func (hc *HTTPClient) http1Client() *http.Client {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.http1 == nil {
+		hc.http1 = newHTTP1Client(hc.config)
+	}
+	return hc.http1
+}
+
+// do sends the request, retrying it once over HTTP/1.1 if it fails with an HTTP/2
+// error and HTTPClientConfig.AllowHTTP2Downgrade is set.
+func (hc *HTTPClient) do(req *http.Request) (*http.Response, error) {
+	resp, err := hc.httpClient().Do(req)
+	if err == nil || !hc.config.AllowHTTP2Downgrade || hc.custom || !isHTTP2Error(err) || req.Context().Err() != nil {
+		return resp, err
+	}
+
+	if logger := hc.config.Logger; logger != nil {
+		logger.Warn("retrying request over HTTP/1.1 after HTTP/2 failure", "url", req.URL.String(), "error", err)
+	}
+	return hc.http1Client().Do(req.Clone(req.Context()))
+}
+
+// isHTTP2Error reports whether err comes from the HTTP/2 layer of the transport, such
+// as a stream or connection error, a GOAWAY frame or a lost HTTP/2 connection. The
+// HTTP/2 error types of net/http are not exported, so they are recognized by name.
+func isHTTP2Error(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if strings.Contains(fmt.Sprintf("%T", err), "http2") {
+			return true
+		}
+		message := err.Error()
+		if strings.HasPrefix(message, "http2: ") || strings.HasPrefix(message, "stream error: ") {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchResponse sends the request and reads the response like fetch, without following
+// its refresh directive.
+func (hc *HTTPClient) fetchResponse(ctx context.Context, req *http.Request) (fetchedContent, error) {
+	resp, err := hc.do(req)
 	if err != nil {
-		return "", "", 0, fmt.Errorf("request failed: %w", err)
+		return fetchedContent{}, fmt.Errorf("request failed: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		return "", "", 0, fmt.Errorf("HTTP error: %d %s", resp.StatusCode, resp.Status)
+	// Every 2xx status is a success; 3xx responses get here only if the redirect
+	// policy did not follow them
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fetchedContent{}, &HTTPStatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			URL:        req.URL.String(),
+		}
 	}
 
 	// Get content type
-	contentType = resp.Header.Get("Content-Type")
+	contentType := resp.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = constants.ContentTypePlain
 	}
 
-	// Read content with optimized size limit and streaming
-	var reader io.Reader = resp.Body
+	// A 204 response has no body by definition
+	if resp.StatusCode == http.StatusNoContent {
+		return fetchedContent{contentType: contentType, statusCode: resp.StatusCode, connection: responseConnection(resp)}, nil
+	}
+
+	// Decode the body; the size limit applies to the decoded content
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	compressed := &countingReader{r: resp.Body}
+	reader, err := decompressBody(compressed, contentEncoding)
+	if err != nil {
+		return fetchedContent{}, err
+	}
+	if reader != io.Reader(compressed) {
+		reader = newLimitedDecoder(reader, compressed, hc.config.MaxDecompressionRatio, hc.config.MaxDecompressedSize)
+	}
 	maxSize := hc.config.MaxContentSize
 	if maxSize <= 0 {
 		maxSize = constants.DefaultHTTPMaxContentSize
 	}
 
 	// Use a limited reader to avoid reading more than necessary
-	reader = io.LimitReader(resp.Body, maxSize+1) // +1 to detect truncation
+	reader = io.LimitReader(reader, maxSize+1) // +1 to detect truncation
 
-	// Pre-allocate buffer with estimated size based on Content-Length
+	// Pre-allocate buffer with estimated size based on Content-Length, which is the
+	// encoded size and therefore no estimate for compressed content
 	var buf []byte
-	if contentLength := resp.Header.Get("Content-Length"); contentLength != "" {
+	if contentLength := resp.Header.Get("Content-Length"); contentLength != "" && contentEncoding == "" {
 		if size, err := strconv.ParseInt(contentLength, 10, 64); err == nil && size > 0 {
 			// Use the smaller of Content-Length or max size
 			if size > maxSize {
@@ -274,6 +887,12 @@ func (hc *HTTPClient) FetchContent(ctx context.Context, urlStr string) (content,
 	chunk := make([]byte, chunkSize)
 	totalRead := int64(0)
 
+	// Transcode to UTF-8; the size remains that of the body as received
+	readContent := func() fetchedContent {
+		decoded, charsetInfo := decodeText(buf, contentType)
+		return fetchedContent{content: string(decoded), contentType: contentType, size: int(totalRead), statusCode: resp.StatusCode, charset: charsetInfo, connection: responseConnection(resp)}
+	}
+
 	for {
 		n, err := reader.Read(chunk)
 		if n > 0 {
@@ -285,7 +904,7 @@ func (hc *HTTPClient) FetchContent(ctx context.Context, urlStr string) (content,
 					buf = append(buf, chunk[:remaining]...)
 					totalRead += remaining
 				}
-				return string(buf), contentType, int(totalRead), fmt.Errorf("content truncated: exceeded maximum size of %d bytes", maxSize)
+				return readContent(), fmt.Errorf("content truncated: exceeded maximum size of %d bytes", maxSize)
 			}
 
 			buf = append(buf, chunk[:n]...)
@@ -296,21 +915,45 @@ func (hc *HTTPClient) FetchContent(ctx context.Context, urlStr string) (content,
 			break
 		}
 		if err != nil {
-			return "", "", 0, fmt.Errorf("failed to read response body: %w", err)
+			// The transport aborts the read when the context ends
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return readContent(), ctxErr
+			}
+			return fetchedContent{}, fmt.Errorf("failed to read response body: %w", err)
 		}
 
 		// Check for context cancellation during reading
 		select {
 		case <-ctx.Done():
-			return "", "", 0, ctx.Err()
+			return readContent(), ctx.Err()
 		default:
 		}
 	}
 
-	content = string(buf)
-	contentSize = int(totalRead)
+	fetched := readContent()
+	if hc.config.HonorRefresh && hc.config.FollowRedirects {
+		fetched.refreshURL = parseRefresh(resp.Header.Get("Refresh"))
+		if fetched.refreshURL == "" && isHTMLContent(contentType) {
+			fetched.refreshURL = metaRefresh(fetched.content)
+		}
+	}
+	return fetched, nil
+}
 
-	return content, contentType, contentSize, nil
+// validateHeaders rejects header names and values that contain line breaks, which
+// could be used to inject additional headers.
+func validateHeaders(headers http.Header) error {
+	for key, values := range headers {
+		if strings.ContainsAny(key, "\r\n") {
+			return fmt.Errorf("invalid header name %q: contains a line break", key)
+		}
+		for _, value := range values {
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("invalid value for header %q: contains a line break", key)
+			}
+		}
+	}
+	return nil
 }
 
 // isPrivateIP checks if an IP address is in a private range.
@@ -352,8 +995,9 @@ func isPrivateIP(ip net.IP) bool {
 	return false
 }
 
-// validateRedirectURL validates redirect URLs for security
-func validateRedirectURL(redirectURL *url.URL, via []*http.Request) error {
+// validateRedirectURL validates redirect URLs for security, resolving their host with
+// resolver.
+func validateRedirectURL(ctx context.Context, resolver *hostResolver, redirectURL *url.URL, via []*http.Request) error {
 	// Don't allow redirects to different schemes (downgrade attacks)
 	if len(via) > 0 {
 		originalScheme := via[0].URL.Scheme
@@ -367,7 +1011,7 @@ func validateRedirectURL(redirectURL *url.URL, via []*http.Request) error {
 
 	// Don't allow redirects to private IPs
 	host := redirectURL.Hostname()
-	ips, err := net.LookupIP(host)
+	ips, err := resolver.lookup(ctx, host)
 	if err != nil {
 		return fmt.Errorf("failed to resolve redirect host: %w", err)
 	}
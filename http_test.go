@@ -0,0 +1,454 @@
+package geminiwebtools
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertificatePinning(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	matchingPin := certificateSPKIHash(server.Certificate())
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	tests := []struct {
+		name        string
+		pins        map[string][]string
+		expectError bool
+	}{
+		{
+			name: "matching pin",
+			pins: map[string][]string{"127.0.0.1": {"bm90LXRoZS1yaWdodC1oYXNo", matchingPin}},
+		},
+		{
+			name:        "non-matching pin",
+			pins:        map[string][]string{"127.0.0.1": {"bm90LXRoZS1yaWdodC1oYXNo"}},
+			expectError: true,
+		},
+		{
+			name: "unpinned host",
+			pins: map[string][]string{"example.org": {"bm90LXRoZS1yaWdodC1oYXNo"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultHTTPClientConfig()
+			config.AllowPrivateIPs = true
+			config.PinnedCertHashes = tt.pins
+
+			pool := &ClientPool{clients: make(map[string]*http.Client)}
+			client := pool.getOrCreateClient(config)
+			// Trust the test server's certificate on top of the pooled transport's settings
+			client.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+
+			hc := &HTTPClient{client: client, config: config}
+			_, _, _, err := hc.FetchContent(context.Background(), server.URL)
+
+			if !tt.expectError {
+				if err != nil {
+					t.Fatalf("Expected request to succeed, got: %v", err)
+				}
+				return
+			}
+
+			var pinErr *CertificatePinError
+			if !errors.As(err, &pinErr) {
+				t.Fatalf("Expected CertificatePinError, got %T: %v", err, err)
+			}
+			if pinErr.Host != "127.0.0.1" {
+				t.Errorf("Host = %q, want %q", pinErr.Host, "127.0.0.1")
+			}
+		})
+	}
+}
+
+func TestPinnedClientPool(t *testing.T) {
+	pool := &ClientPool{clients: make(map[string]*http.Client)}
+	config := &HTTPClientConfig{PinnedCertHashes: map[string][]string{"example.com": {"hash"}}}
+
+	transport := pool.getOrCreateClient(config).Transport.(*http.Transport)
+	if transport.TLSClientConfig == nil || transport.TLSClientConfig.VerifyConnection == nil {
+		t.Error("Expected pinned transport to verify connections against pins")
+	}
+	if transport.TLSClientConfig.MinVersion < tls.VersionTLS12 {
+		t.Error("Expected pinned transport to require TLS 1.2 or later")
+	}
+	if transport.DialTLSContext != nil {
+		t.Error("Expected pinned transport to keep the standard TLS dial")
+	}
+
+	if pool.configKey(config) == pool.configKey(&HTTPClientConfig{}) {
+		t.Error("Expected pins to be part of the client pool key")
+	}
+}
+
+func TestHTTPClientReset(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			select {
+			case closed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	config := DefaultHTTPClientConfig()
+	config.AllowPrivateIPs = true
+	pool := &ClientPool{clients: make(map[string]*http.Client)}
+	hc := &HTTPClient{client: pool.getOrCreateClient(config), config: config, pool: pool}
+
+	// Leave an idle keep-alive connection in the old transport
+	if _, _, _, err := hc.FetchContent(context.Background(), server.URL); err != nil {
+		t.Fatalf("FetchContent returned error: %v", err)
+	}
+	oldTransport := hc.httpClient().Transport
+
+	hc.Reset()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the idle connection to be closed")
+	}
+
+	newClient := hc.httpClient()
+	if newClient.Transport == oldTransport {
+		t.Error("Expected a fresh transport after reset")
+	}
+	if pool.getOrCreateClient(config) != newClient {
+		t.Error("Expected the pool to hand out the reset client")
+	}
+	if _, _, _, err := hc.FetchContent(context.Background(), server.URL); err != nil {
+		t.Fatalf("FetchContent after reset returned error: %v", err)
+	}
+}
+
+func TestFetchContentWithHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	config := DefaultHTTPClientConfig()
+	config.AllowPrivateIPs = true
+	hc := NewHTTPClient(config)
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer secret")
+	headers.Set("Cookie", "session=abc")
+	headers.Set("Referrer-Policy", "origin")
+	if _, _, _, err := hc.FetchContentWithHeaders(context.Background(), server.URL, headers); err != nil {
+		t.Fatalf("FetchContentWithHeaders returned error: %v", err)
+	}
+
+	for key, want := range map[string]string{
+		"Authorization":   "Bearer secret",
+		"Cookie":          "session=abc",
+		"Referrer-Policy": "origin",
+		"Dnt":             "1",
+		"Cache-Control":   "no-cache",
+	} {
+		if got := received.Values(key); len(got) != 1 || got[0] != want {
+			t.Errorf("Header %s = %q, want %q", key, got, want)
+		}
+	}
+
+	for _, injected := range []http.Header{
+		{"X-Custom": {"value\r\nX-Injected: 1"}},
+		{"X-Custom\nX-Injected": {"value"}},
+	} {
+		if _, _, _, err := hc.FetchContentWithHeaders(context.Background(), server.URL, injected); err == nil {
+			t.Errorf("Expected headers %q to be rejected", injected)
+		}
+	}
+}
+
+func TestFetchWithHTTPSuccessStatusCodes(t *testing.T) {
+	tests := []struct {
+		status        int
+		wantContent   string
+		wantNoContent bool
+	}{
+		{status: http.StatusOK, wantContent: "page content"},
+		{status: http.StatusNonAuthoritativeInfo, wantContent: "page content"},
+		{status: http.StatusNoContent, wantNoContent: true},
+		{status: http.StatusPartialContent, wantContent: "page content"},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte("page content"))
+			}))
+			defer page.Close()
+
+			fetcher, err := NewWebFetcher(newTestConfig(""))
+			if err != nil {
+				t.Fatalf("NewWebFetcher returned error: %v", err)
+			}
+			pageURL := useTestPageServer(fetcher, page)
+
+			result, err := fetcher.fetchWithHTTP(context.Background(), pageURL, "", time.Now())
+			if err != nil {
+				t.Fatalf("fetchWithHTTP returned error: %v", err)
+			}
+			if result.Content != tt.wantContent {
+				t.Errorf("Content = %q, want %q", result.Content, tt.wantContent)
+			}
+			if result.Metadata.StatusCode != tt.status || result.Metadata.NoContent != tt.wantNoContent {
+				t.Errorf("StatusCode = %d, NoContent = %v, want %d and %v", result.Metadata.StatusCode, result.Metadata.NoContent, tt.status, tt.wantNoContent)
+			}
+		})
+	}
+}
+
+func TestFetchContentErrorStatusCodes(t *testing.T) {
+	for _, status := range []int{http.StatusNotModified, http.StatusNotFound, http.StatusServiceUnavailable} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+
+		config := DefaultHTTPClientConfig()
+		config.AllowPrivateIPs = true
+		_, _, _, err := NewHTTPClient(config).FetchContent(context.Background(), server.URL)
+		server.Close()
+
+		var statusErr *HTTPStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != status {
+			t.Errorf("Status %d: expected an HTTPStatusError, got %v", status, err)
+		}
+	}
+}
+
+func TestFetchContentHonorRefresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("Refresh", "0; url=/target")
+			_, _ = w.Write([]byte("start"))
+		case "/meta":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<html><head><meta http-equiv="Refresh" content="5; URL='/target'"></head><body>start</body></html>`))
+		case "/reload":
+			w.Header().Set("Refresh", "30")
+			_, _ = w.Write([]byte("start"))
+		case "/loop":
+			w.Header().Set("Refresh", "0; url=/loop#again")
+			_, _ = w.Write([]byte("start"))
+		default:
+			_, _ = w.Write([]byte("target"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path    string
+		honor   bool
+		want    string
+		wantErr bool
+	}{
+		{path: "/header", honor: false, want: "start"},
+		{path: "/header", honor: true, want: "target"},
+		{path: "/meta", honor: false, want: "start"},
+		{path: "/meta", honor: true, want: "target"},
+		{path: "/reload", honor: true, want: "start"},
+		{path: "/loop", honor: true, want: "start"},
+	}
+	for _, tt := range tests {
+		config := DefaultHTTPClientConfig()
+		config.HonorRefresh = tt.honor
+		hc := &HTTPClient{client: &http.Client{}, config: config}
+
+		content, _, _, err := hc.FetchContent(context.Background(), server.URL+tt.path)
+		if err != nil {
+			t.Fatalf("%s (honor %v): FetchContent returned error: %v", tt.path, tt.honor, err)
+		}
+		if !strings.Contains(content, tt.want) {
+			t.Errorf("%s (honor %v): content = %q, want %q", tt.path, tt.honor, content, tt.want)
+		}
+	}
+
+	// A refresh is checked by the redirect policy like a redirect
+	config := DefaultHTTPClientConfig()
+	config.AllowPrivateIPs = true
+	config.HonorRefresh = true
+	pool := &ClientPool{clients: make(map[string]*http.Client)}
+	hc := &HTTPClient{client: pool.getOrCreateClient(config), config: config, pool: pool}
+	if _, _, _, err := hc.FetchContent(context.Background(), server.URL+"/header"); err == nil || !strings.Contains(err.Error(), "redirect to private IP") {
+		t.Errorf("Expected the redirect policy to reject the refresh target, got %v", err)
+	}
+}
+
+func TestFetchContentCookieJar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			return
+		}
+		if cookie, err := r.Cookie("session"); err == nil {
+			_, _ = w.Write([]byte(cookie.Value))
+		}
+	}))
+	defer server.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar.New returned error: %v", err)
+	}
+	for _, jar := range []http.CookieJar{nil, jar} {
+		config := DefaultHTTPClientConfig()
+		config.AllowPrivateIPs = true
+		config.CookieJar = jar
+		hc := NewHTTPClient(config)
+
+		if _, _, _, err := hc.FetchContent(context.Background(), server.URL+"/login"); err != nil {
+			t.Fatalf("FetchContent returned error: %v", err)
+		}
+		content, _, _, err := hc.FetchContent(context.Background(), server.URL+"/page")
+		if err != nil {
+			t.Fatalf("FetchContent returned error: %v", err)
+		}
+		want := ""
+		if jar != nil {
+			want = "secret"
+		}
+		if content != want {
+			t.Errorf("With jar %v: cookie sent = %q, want %q", jar != nil, content, want)
+		}
+	}
+}
+
+// http2StreamError imitates the unexported stream error type of the HTTP/2 transport.
+type http2StreamError struct{}
+
+func (http2StreamError) Error() string { return "stream error: stream ID 1; PROTOCOL_ERROR" }
+
+func TestIsHTTP2Error(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: http2StreamError{}}, true},
+		{fmt.Errorf("request failed: %w", errors.New("http2: client connection lost")), true},
+		{errors.New("stream error: stream ID 3; INTERNAL_ERROR"), true},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("connection refused")}, false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		if got := isHTTP2Error(tt.err); got != tt.want {
+			t.Errorf("isHTTP2Error(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFetchContentHTTP2Downgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "HTTP/%d.%d", r.ProtoMajor, r.ProtoMinor)
+	}))
+	defer server.Close()
+
+	for _, downgrade := range []bool{true, false} {
+		var logs bytes.Buffer
+		config := DefaultHTTPClientConfig()
+		config.AllowPrivateIPs = true
+		config.AllowHTTP2Downgrade = downgrade
+		config.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+		hc := NewHTTPClient(config)
+
+		// The first attempt fails as if a middlebox broke the HTTP/2 stream
+		var attempts int
+		hc.client = &http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			attempts++
+			return nil, http2StreamError{}
+		})}
+
+		content, _, _, err := hc.FetchContent(context.Background(), server.URL)
+		if attempts != 1 {
+			t.Errorf("AllowHTTP2Downgrade=%v: expected 1 HTTP/2 attempt, got %d", downgrade, attempts)
+		}
+		if !downgrade {
+			if err == nil || logs.Len() != 0 {
+				t.Errorf("Expected the HTTP/2 error without downgrade, got %q, %v and logs %q", content, err, logs.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("FetchContent returned error: %v", err)
+		}
+		if content != "HTTP/1.1" {
+			t.Errorf("Expected the retry over HTTP/1.1, got %q", content)
+		}
+		if !strings.Contains(logs.String(), "HTTP/1.1") || !strings.Contains(logs.String(), "stream error") {
+			t.Errorf("Expected the downgrade to be logged, got %q", logs.String())
+		}
+	}
+}
+
+func TestFetchWithHTTPConnectionMetadata(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hello"))
+	})
+	secure := httptest.NewUnstartedServer(handler)
+	secure.EnableHTTP2 = true
+	secure.StartTLS()
+	defer secure.Close()
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+
+	tests := []struct {
+		server         *httptest.Server
+		wantProtocol   string
+		wantTLSVersion string
+	}{
+		{secure, "HTTP/2.0", "TLS 1.3"},
+		{plain, "HTTP/1.1", ""},
+	}
+	for _, tt := range tests {
+		fetcher, err := NewWebFetcher(newTestConfig(""))
+		if err != nil {
+			t.Fatalf("NewWebFetcher returned error: %v", err)
+		}
+		fetcher.httpClient = &HTTPClient{client: tt.server.Client(), config: DefaultHTTPClientConfig()}
+
+		result, err := fetcher.fetchWithHTTP(context.Background(), tt.server.URL, "", time.Now())
+		if err != nil {
+			t.Fatalf("fetchWithHTTP(%s) returned error: %v", tt.server.URL, err)
+		}
+		metadata := result.Metadata
+		if metadata.Protocol != tt.wantProtocol || metadata.TLSVersion != tt.wantTLSVersion {
+			t.Errorf("%s: Protocol = %q, TLSVersion = %q, want %q and %q", tt.server.URL, metadata.Protocol, metadata.TLSVersion, tt.wantProtocol, tt.wantTLSVersion)
+		}
+		if (metadata.TLSCipherSuite != "") != (tt.wantTLSVersion != "") {
+			t.Errorf("%s: unexpected TLSCipherSuite %q", tt.server.URL, metadata.TLSCipherSuite)
+		}
+	}
+}
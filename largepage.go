@@ -0,0 +1,204 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// fetchLargePage fetches the page directly and sends its content to the model inline,
+// split into chunks that each fit in a single API request. A page that fits in one chunk
+// is answered with a single request; otherwise every chunk is summarized with respect
+// to the prompt and the summaries are synthesized into the final answer.
+func (wf *WebFetcher) fetchLargePage(ctx context.Context, pageURL, prompt, model string, startTime time.Time) (*types.WebFetchResult, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, constants.HTTPFetchTimeout)
+	var content, contentType string
+	var contentSize int
+	err := wf.config.RetryPolicy.Do(fetchCtx, func(ctx context.Context) error {
+		var fetchErr error
+		content, contentType, contentSize, fetchErr = wf.httpClient.FetchContent(ctx, wf.directFetchURL(pageURL))
+		return fetchErr
+	})
+	cancel()
+	if err != nil {
+		return wf.largePageErrorResult(pageURL, prompt, startTime, err), fmt.Errorf("large page fetch failed: %w", err)
+	}
+
+	text := strings.TrimSpace(wf.prepareContent(content, contentType))
+	if text == "" {
+		err := errors.New("page has no content")
+		return wf.largePageErrorResult(pageURL, prompt, startTime, err), fmt.Errorf("large page fetch failed: %w", err)
+	}
+
+	chunks := splitContent(text, wf.largePageChunkSize())
+
+	var resp *types.GenerateContentResponse
+	var usage *types.UsageMetadata
+	if len(chunks) == 1 {
+		resp, err = wf.generate(ctx, model, wf.codeAssist.CreatePageContentRequest(pageURL, prompt, chunks[0], 1, 1))
+	} else {
+		summaries := make([]string, 0, len(chunks))
+		for i, chunk := range chunks {
+			var chunkResp *types.GenerateContentResponse
+			chunkResp, err = wf.generate(ctx, model, wf.codeAssist.CreatePageContentRequest(pageURL, prompt, chunk, i+1, len(chunks)))
+			if err != nil {
+				err = fmt.Errorf("chunk %d of %d: %w", i+1, len(chunks), err)
+				break
+			}
+			summaries = append(summaries, responseText(chunkResp))
+			usage = usage.Add(chunkResp.UsageMetadata)
+		}
+		if err == nil {
+			resp, err = wf.generate(ctx, model, wf.codeAssist.CreatePageSynthesisRequest(pageURL, prompt, summaries))
+		}
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return wf.contextErrorResult(ctxErr, pageURL, prompt, startTime), ctxErr
+		}
+		return wf.largePageErrorResult(pageURL, prompt, startTime, err), fmt.Errorf("web fetch failed: %w", err)
+	}
+
+	result, err := wf.processFetchResponse(resp, prompt, startTime, false)
+	if err != nil {
+		return result, err
+	}
+	result.Metadata.URL = pageURL
+	result.Metadata.ContentType = contentType
+	result.Metadata.ContentSize = contentSize
+	result.Metadata.ChunkCount = len(chunks)
+	result.Metadata.TokenUsage = usage.Add(resp.UsageMetadata)
+	return result, nil
+}
+
+// generate sends a content generation request with the given model, or the client's
+// model if empty, bounded by the AI request timeout.
+func (wf *WebFetcher) generate(ctx context.Context, model string, req *types.GenerateContentRequest) (*types.GenerateContentResponse, error) {
+	req.Model = model
+	aiCtx, cancel := context.WithTimeout(ctx, constants.AIRequestTimeout)
+	defer cancel()
+	return wf.codeAssist.GenerateContent(aiCtx, req)
+}
+
+// largePageChunkSize returns the configured chunk size, capped so that a chunk and its
+// prompt fit in a single API request.
+func (wf *WebFetcher) largePageChunkSize() int {
+	limit := constants.MaxAPIRequestSize - constants.LargePageRequestOverhead
+	size := wf.config.WebFetch.LargePageChunkSize
+	if size <= 0 {
+		size = constants.DefaultLargePageChunkSize
+	}
+	return min(size, limit)
+}
+
+// largePageErrorResult builds the result returned when a large page fetch fails.
+func (wf *WebFetcher) largePageErrorResult(pageURL, prompt string, startTime time.Time, err error) *types.WebFetchResult {
+	return timedFetchResult(&types.WebFetchResult{
+		Summary:     "Fetch failed",
+		Content:     "",
+		DisplayText: fmt.Sprintf("Error fetching content: %v", err),
+		Metadata: types.WebFetchMetadata{
+			URL:          pageURL,
+			Prompt:       prompt,
+			APIUsed:      "codeassist",
+			HasGrounding: false,
+			Error:        err.Error(),
+		},
+	}, startTime)
+}
+
+// responseText returns the concatenated text of the first candidate of a response.
+func responseText(resp *types.GenerateContentResponse) string {
+	if resp == nil || len(resp.Candidates) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		sb.WriteString(part.Text)
+	}
+	return sb.String()
+}
+
+// splitContent splits text into chunks whose JSON-encoded size does not exceed maxSize.
+// Chunks end at line breaks where possible; longer lines are split between runes.
+func splitContent(text string, maxSize int) []string {
+	var chunks []string
+	var current strings.Builder
+	currentSize := 0
+
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentSize = 0
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		size := encodedSize(line)
+		if currentSize+size > maxSize {
+			flush()
+		}
+		for size > maxSize {
+			head, rest := splitAtEncodedSize(line, maxSize)
+			chunks = append(chunks, head)
+			line = rest
+			size = encodedSize(line)
+		}
+		current.WriteString(line)
+		currentSize += size
+	}
+	flush()
+
+	return chunks
+}
+
+// splitAtEncodedSize splits s after the longest prefix whose JSON-encoded size fits in maxSize.
+// The prefix holds at least one rune.
+func splitAtEncodedSize(s string, maxSize int) (head, rest string) {
+	size := 0
+	for i := 0; i < len(s); {
+		runeSize, n := encodedRuneAt(s, i)
+		if i > 0 && size+runeSize > maxSize {
+			return s[:i], s[i:]
+		}
+		size += runeSize
+		i += n
+	}
+	return s, ""
+}
+
+// encodedSize returns the size of s once encoded as a JSON string by encoding/json,
+// excluding the surrounding quotes.
+func encodedSize(s string) int {
+	size := 0
+	for i := 0; i < len(s); {
+		runeSize, n := encodedRuneAt(s, i)
+		size += runeSize
+		i += n
+	}
+	return size
+}
+
+// encodedRuneAt returns the JSON-encoded size of the rune starting at s[i] and its
+// length in bytes. encoding/json escapes quotes, backslashes, control characters, the
+// HTML characters <, > and &, and U+2028 and U+2029, and replaces invalid UTF-8 with
+// an escaped U+FFFD.
+func encodedRuneAt(s string, i int) (size, n int) {
+	r, n := utf8.DecodeRuneInString(s[i:])
+	switch {
+	case r == utf8.RuneError && n == 1:
+		return len(`\ufffd`), n
+	case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+		return 2, n
+	case r < 0x20 || r == '<' || r == '>' || r == '&' || r == '\u2028' || r == '\u2029':
+		return len(`\u0000`), n
+	}
+	return n, n
+}
@@ -0,0 +1,119 @@
+package geminiwebtools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+func TestSplitContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		maxSize int
+		want    int
+	}{
+		{name: "fits in one chunk", text: "a\nb\nc", maxSize: 100, want: 1},
+		{name: "splits at lines", text: "aaaa\nbbbb\ncccc", maxSize: 10, want: 2},
+		{name: "splits long lines", text: strings.Repeat("x", 25), maxSize: 10, want: 3},
+		{name: "counts escaped characters", text: strings.Repeat("<", 10), maxSize: 30, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := splitContent(tt.text, tt.maxSize)
+			if len(chunks) != tt.want {
+				t.Errorf("Expected %d chunks, got %d: %q", tt.want, len(chunks), chunks)
+			}
+			if joined := strings.Join(chunks, ""); joined != tt.text {
+				t.Errorf("Chunks do not add up to the original text: %q", joined)
+			}
+			for i, chunk := range chunks {
+				encoded, _ := json.Marshal(chunk)
+				if size := len(encoded) - 2; size > tt.maxSize {
+					t.Errorf("Chunk %d encodes to %d bytes, more than %d", i, size, tt.maxSize)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchLargePage(t *testing.T) {
+	// A page well over the API request limit, with characters JSON escapes
+	var page strings.Builder
+	for i := 0; page.Len() < 3*constants.MaxAPIRequestSize/2; i++ {
+		fmt.Fprintf(&page, "Line %d: <b>bold</b> & \"quoted\" text\n", i)
+	}
+	pageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, page.String())
+	}))
+	defer pageServer.Close()
+
+	partPattern := regexp.MustCompile(`part (\d+) of (\d+) of the page`)
+	var mu sync.Mutex
+	var chunkRequests int
+	var synthesisPrompt string
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if len(body) > constants.MaxAPIRequestSize {
+			t.Errorf("Request body of %d bytes exceeds the API limit", len(body))
+		}
+
+		var req types.CodeAssistGenerateContentRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("Invalid request: %v", err)
+			return
+		}
+		text := req.Request.Contents[0].Parts[0].Text
+		if len(req.Request.Tools) != 0 {
+			t.Error("Large page requests should not use the URL context tool")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if match := partPattern.FindStringSubmatch(text); match != nil {
+			chunkRequests++
+			writeCodeAssistText(w, "summary of part "+match[1])
+			return
+		}
+		synthesisPrompt = text
+		writeCodeAssistText(w, "final answer")
+	})
+
+	fetcher, err := NewWebFetcher(newTestConfig(codeAssist.URL, WithLargePages(true)))
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	pageURL := useTestPageServer(fetcher, pageServer) + "/large"
+
+	result, err := fetcher.Fetch(context.Background(), "Summarize "+pageURL)
+	if err != nil {
+		t.Fatalf("Fetch returned error: %v", err)
+	}
+
+	if result.Content != "final answer" {
+		t.Errorf("Expected synthesized answer, got %q", result.Content)
+	}
+	if result.Metadata.UsedFallback {
+		t.Error("Large page fetch should not use the fallback")
+	}
+	if result.Metadata.ChunkCount < 2 || result.Metadata.ChunkCount != chunkRequests {
+		t.Errorf("ChunkCount = %d, chunk requests = %d", result.Metadata.ChunkCount, chunkRequests)
+	}
+	for i := 1; i <= chunkRequests; i++ {
+		if !strings.Contains(synthesisPrompt, fmt.Sprintf("summary of part %d", i)) {
+			t.Errorf("Synthesis prompt is missing the summary of part %d", i)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrConcurrencyLimit is returned by Client operations when Config.MaxConcurrentRequests
+// operations are already in flight and Config.FailFastOnConcurrencyLimit is set.
+var ErrConcurrencyLimit = errors.New("concurrent request limit reached")
+
+// ClientStats reports the current load of a Client.
+type ClientStats struct {
+	// InFlight is the number of searches, fetches and generate calls currently running,
+	// not counting calls waiting for a slot.
+	InFlight int
+
+	// MaxConcurrentRequests is the configured limit; zero means unlimited.
+	MaxConcurrentRequests int
+}
+
+// requestLimiter bounds the number of concurrent operations of a client. A limiter
+// with a nil semaphore only counts operations.
+type requestLimiter struct {
+	sem      chan struct{}
+	failFast bool
+	inFlight atomic.Int64
+}
+
+// newRequestLimiter creates a limiter that allows at most maxConcurrent operations at
+// the same time. Values less than 1 disable the limit.
+func newRequestLimiter(maxConcurrent int, failFast bool) *requestLimiter {
+	limiter := &requestLimiter{failFast: failFast}
+	if maxConcurrent > 0 {
+		limiter.sem = make(chan struct{}, maxConcurrent)
+	}
+	return limiter
+}
+
+// acquire waits for a slot and returns the function that releases it. It returns the
+// context error if the context is done first, or ErrConcurrencyLimit without waiting
+// if the limiter fails fast.
+func (l *requestLimiter) acquire(ctx context.Context) (func(), error) {
+	if l.sem != nil {
+		if l.failFast {
+			select {
+			case l.sem <- struct{}{}:
+			default:
+				return nil, ErrConcurrencyLimit
+			}
+		} else {
+			select {
+			case l.sem <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	l.inFlight.Add(1)
+	return func() {
+		l.inFlight.Add(-1)
+		if l.sem != nil {
+			<-l.sem
+		}
+	}, nil
+}
+
+// stats returns the current number of operations in flight and the limit.
+func (l *requestLimiter) stats() ClientStats {
+	return ClientStats{
+		InFlight:              int(l.inFlight.Load()),
+		MaxConcurrentRequests: cap(l.sem),
+	}
+}
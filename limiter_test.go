@@ -0,0 +1,125 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// storeMax raises v to n if n is larger.
+func storeMax(v *atomic.Int32, n int32) {
+	for {
+		current := v.Load()
+		if n <= current || v.CompareAndSwap(current, n) {
+			return
+		}
+	}
+}
+
+func TestClientMaxConcurrentRequests(t *testing.T) {
+	const limit = 2
+	var running, maxRunning atomic.Int32
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		storeMax(&maxRunning, running.Add(1))
+		defer running.Add(-1)
+		time.Sleep(20 * time.Millisecond)
+		writeGroundedCodeAssistText(w, "answer")
+	})
+
+	client, err := NewClient(
+		WithCredentialStore(&mockTokenStore{}),
+		WithMaxConcurrentRequests(limit),
+		func(c *Config) { c.CodeAssistEndpoint = codeAssist.URL },
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var maxInFlight atomic.Int32
+	for range 4 * limit {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Search(context.Background(), "query"); err != nil {
+				t.Errorf("Search failed: %v", err)
+			}
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			storeMax(&maxInFlight, int32(client.Stats().InFlight))
+		}()
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got > limit || got == 0 {
+		t.Errorf("Expected at most %d concurrent requests, observed %d", limit, got)
+	}
+	if got := maxInFlight.Load(); got > limit {
+		t.Errorf("Stats reported %d operations in flight, limit is %d", got, limit)
+	}
+	if stats := client.Stats(); stats.InFlight != 0 || stats.MaxConcurrentRequests != limit {
+		t.Errorf("Unexpected stats after all calls returned: %+v", stats)
+	}
+}
+
+func TestClientConcurrencyLimitFailFast(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-unblock
+		writeGroundedCodeAssistText(w, "answer")
+	})
+
+	newLimitedClient := func(failFast bool) *Client {
+		client, err := NewClient(
+			WithCredentialStore(&mockTokenStore{}),
+			WithMaxConcurrentRequests(1),
+			WithFailFastOnConcurrencyLimit(failFast),
+			func(c *Config) { c.CodeAssistEndpoint = codeAssist.URL },
+		)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		return client
+	}
+	client := newLimitedClient(true)
+
+	done := make(chan error)
+	go func() {
+		_, err := client.Search(context.Background(), "first")
+		done <- err
+	}()
+	<-started
+
+	if _, err := client.Fetch(context.Background(), "Summarize https://example.com"); !errors.Is(err, ErrConcurrencyLimit) {
+		t.Errorf("Expected ErrConcurrencyLimit, got %v", err)
+	}
+	if stats := client.Stats(); stats.InFlight != 1 {
+		t.Errorf("Expected one operation in flight, got %+v", stats)
+	}
+
+	// Without fail fast, waiting callers give up when their context is done
+	waiting := newLimitedClient(false)
+	release, err := waiting.limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := waiting.Search(ctx, "query"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+	release()
+
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Errorf("First search failed: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package geminiwebtools
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// convertHTMLToMarkdown converts an HTML document to markdown.
+// Headings, links, lists, emphasis, code and paragraphs are converted; other markup is
+// reduced to its text. Elements that carry no readable text, such as scripts and styles,
+// are dropped. Content that cannot be parsed is returned unchanged.
+func convertHTMLToMarkdown(htmlContent string) string {
+	doc, err := html.Parse(strings.NewReader(htmlContent))
+	if err != nil {
+		return htmlContent
+	}
+
+	var c markdownConverter
+	c.walk(doc)
+	return c.b.String()
+}
+
+// markdownConverter accumulates the markdown of a parsed HTML document.
+type markdownConverter struct {
+	b textBuilder
+
+	// lists holds the next item number of each enclosing list, 0 for unordered lists
+	lists []int
+}
+
+func (c *markdownConverter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.b.writeText(n.Data)
+		return
+	case html.ElementNode:
+		if skippedElements[n.DataAtom] {
+			return
+		}
+		if level := headingLevel(n); level > 0 {
+			c.writeBlock(strings.Repeat("#", level) + " " + nodeText(n))
+			return
+		}
+
+		switch n.DataAtom {
+		case atom.Br:
+			c.b.lineBreak()
+			return
+		case atom.Pre:
+			c.writeCodeBlock(n)
+			return
+		case atom.Code:
+			c.writeInline("`", nodeText(n), "`")
+			return
+		case atom.Strong, atom.B:
+			c.writeInline("**", nodeText(n), "**")
+			return
+		case atom.Em, atom.I:
+			c.writeInline("*", nodeText(n), "*")
+			return
+		case atom.A:
+			c.writeLink(n)
+			return
+		case atom.Ul, atom.Ol:
+			c.writeList(n)
+			return
+		case atom.Li:
+			c.writeListItem(n)
+			return
+		}
+	}
+
+	isBlock := n.Type == html.ElementNode && blockElements[n.DataAtom]
+	if isBlock {
+		c.b.paragraphBreak()
+	}
+	c.walkChildren(n)
+	if isBlock {
+		c.b.paragraphBreak()
+	}
+}
+
+func (c *markdownConverter) walkChildren(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
+	}
+}
+
+// writeBlock writes text as a paragraph of its own, unless the text is empty.
+func (c *markdownConverter) writeBlock(text string) {
+	if strings.TrimSpace(strings.TrimLeft(text, "#")) == "" {
+		return
+	}
+	c.b.paragraphBreak()
+	c.b.write(text)
+	c.b.paragraphBreak()
+}
+
+// writeInline writes text between the given markers, unless the text is empty.
+func (c *markdownConverter) writeInline(open, text, close string) {
+	if text == "" {
+		return
+	}
+	c.b.write(open + text + close)
+}
+
+// writeLink writes a link, or only its text if it has no usable target.
+func (c *markdownConverter) writeLink(n *html.Node) {
+	text := nodeText(n)
+	href := strings.TrimSpace(attribute(n, "href"))
+	if href == "" || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		c.b.writeText(text)
+		return
+	}
+	if text == "" {
+		text = href
+	}
+	c.b.write("[" + text + "](" + href + ")")
+}
+
+// writeList writes a list. Top-level lists are separate paragraphs; nested lists
+// continue the enclosing list item on the following lines.
+func (c *markdownConverter) writeList(n *html.Node) {
+	next := 0
+	if n.DataAtom == atom.Ol {
+		next = 1
+	}
+
+	topLevel := len(c.lists) == 0
+	if topLevel {
+		c.b.paragraphBreak()
+	} else {
+		c.b.startLine()
+	}
+
+	c.lists = append(c.lists, next)
+	c.walkChildren(n)
+	c.lists = c.lists[:len(c.lists)-1]
+
+	if topLevel {
+		c.b.paragraphBreak()
+	} else {
+		c.b.startLine()
+	}
+}
+
+// writeListItem writes a list item marker indented to the list depth, followed by the item.
+func (c *markdownConverter) writeListItem(n *html.Node) {
+	marker := "-"
+	depth := len(c.lists)
+	if depth > 0 && c.lists[depth-1] > 0 {
+		marker = strconv.Itoa(c.lists[depth-1]) + "."
+		c.lists[depth-1]++
+	}
+	if depth > 1 {
+		marker = strings.Repeat("  ", depth-1) + marker
+	}
+
+	c.b.startLine()
+	c.b.write(marker)
+	c.b.space()
+	c.walkChildren(n)
+	c.b.startLine()
+}
+
+// writeCodeBlock writes preformatted content as a fenced code block, using the
+// language of a nested <code class="language-..."> element if present.
+func (c *markdownConverter) writeCodeBlock(n *html.Node) {
+	code := rawText(n)
+	if strings.TrimSpace(code) == "" {
+		return
+	}
+	if !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+
+	language := ""
+	if codeElement := findElement(n, atom.Code); codeElement != nil {
+		for _, class := range strings.Fields(attribute(codeElement, "class")) {
+			if lang, ok := strings.CutPrefix(class, "language-"); ok {
+				language = lang
+				break
+			}
+		}
+	}
+
+	c.b.paragraphBreak()
+	c.b.write("```" + language + "\n" + code + "```")
+	c.b.paragraphBreak()
+}
+
+// rawText returns the text content of a node with whitespace preserved.
+func rawText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+// attribute returns the value of the named attribute, or an empty string.
+func attribute(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package geminiwebtools
+
+import "testing"
+
+func TestConvertHTMLToMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{
+			name:     "headings",
+			html:     `<h1>Title</h1><h2>Section <code>one</code></h2><h6>Small</h6>`,
+			expected: "# Title\n\n## Section one\n\n###### Small",
+		},
+		{
+			name:     "paragraphs and line breaks",
+			html:     "<p>First   paragraph\nwraps.</p><p>Second<br>line</p>",
+			expected: "First paragraph wraps.\n\nSecond\nline",
+		},
+		{
+			name:     "emphasis",
+			html:     `<p>Use <strong>bold</strong>, <b>also bold</b> and <em>italic</em> text.</p>`,
+			expected: "Use **bold**, **also bold** and *italic* text.",
+		},
+		{
+			name:     "links",
+			html:     `<p>See <a href="https://go.dev/doc">the docs</a>, <a href="javascript:void(0)">menu</a> and <a href="/faq"></a>.</p>`,
+			expected: "See [the docs](https://go.dev/doc), menu and [/faq](/faq).",
+		},
+		{
+			name:     "unordered list",
+			html:     `<ul><li>Go</li><li>Git <b>2.40</b></li></ul><p>After</p>`,
+			expected: "- Go\n- Git **2.40**\n\nAfter",
+		},
+		{
+			name:     "ordered and nested lists",
+			html:     `<ol><li>Install<ul><li>Linux</li><li>macOS</li></ul></li><li>Run</li></ol>`,
+			expected: "1. Install\n  - Linux\n  - macOS\n2. Run",
+		},
+		{
+			name:     "code",
+			html:     "<p>Call <code>Fetch</code>:</p><pre><code class=\"language-go\">func main() {\n\tfetch()\n}</code></pre>",
+			expected: "Call `Fetch`:\n\n```go\nfunc main() {\n\tfetch()\n}\n```",
+		},
+		{
+			name:     "skipped elements",
+			html:     `<html><head><title>Page</title><style>p { color: red }</style></head><body><script>alert(1)</script><noscript>Enable JS</noscript><p>Visible</p></body></html>`,
+			expected: "Visible",
+		},
+		{
+			name:     "empty document",
+			html:     ``,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := convertHTMLToMarkdown(tt.html); result != tt.expected {
+				t.Errorf("convertHTMLToMarkdown() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// FetchPaginated fetches a listing spread over several pages directly over HTTP,
+// without the AI. Starting at pageURL, it follows the link to the next page until a
+// page has none, maxPages pages were fetched, or constants.MaxPaginatedPages is reached.
+//
+// The next page is the href of the first element matching nextSelector, or of the
+// first <link> or <a> element with rel="next" if nextSelector is empty. A selector is
+// a tag name, "#id", ".class", "[attr]" or "[attr=value]", or a combination of them
+// such as `a.pager[rel="next"]`; combinators are not supported.
+//
+// Every page is checked like a URL in a prompt, including the domain policy, and
+// against robots.txt when enabled. Links to other hosts and pages already fetched are
+// not followed. The content of the pages is concatenated, each page preceded by a
+// delimiter with its number and URL, and every page is listed as a source.
+// Metadata.PagesFetched reports the number of pages. If a later page fails, the pages
+// fetched before it are returned along with the error.
+func (wf *WebFetcher) FetchPaginated(ctx context.Context, pageURL string, maxPages int, nextSelector string) (*types.WebFetchResult, error) {
+	result, err := wf.fetchPaginated(ctx, pageURL, maxPages, nextSelector)
+	if result != nil {
+		result.Metadata.AuditID = AuditIDFromContext(ctx)
+	}
+	return result, err
+}
+
+// fetchPaginated implements FetchPaginated.
+func (wf *WebFetcher) fetchPaginated(ctx context.Context, pageURL string, maxPages int, nextSelector string) (*types.WebFetchResult, error) {
+	startTime := time.Now()
+	ctx, cancel := wf.config.operationContext(ctx)
+	defer cancel()
+
+	err := wf.validateURL(pageURL)
+	if err == nil && isFileURL(pageURL) {
+		err = errors.New("file URLs cannot be paginated")
+	}
+	if err != nil {
+		return invalidURLResult("Invalid URL", pageURL, "", err, startTime), err
+	}
+	selector, err := parseSelector(nextSelector)
+	if err != nil {
+		return invalidURLResult("Invalid next page selector", pageURL, "", err, startTime), err
+	}
+	maxPages = max(1, min(maxPages, constants.MaxPaginatedPages))
+
+	firstURL, err := url.Parse(pageURL)
+	if err != nil {
+		return invalidURLResult("Invalid URL", pageURL, "", err, startTime), err
+	}
+
+	var pages []string
+	var sources []types.GroundingChunk
+	var contentType string
+	var contentSize int
+	visited := make(map[string]bool)
+	current := firstURL
+	for len(pages) < maxPages && current != nil {
+		visited[current.String()] = true
+		fetched, err := wf.fetchPage(ctx, current.String())
+		if err != nil {
+			if len(pages) == 0 {
+				return timedFetchResult(&types.WebFetchResult{
+					Summary:     fmt.Sprintf("HTTP fetch failed: %s", pageURL),
+					DisplayText: fmt.Sprintf("Error fetching content via HTTP: %v", err),
+					Metadata: types.WebFetchMetadata{
+						URL:     pageURL,
+						APIUsed: "http",
+						Error:   err.Error(),
+					},
+				}, startTime), fmt.Errorf("HTTP fetch failed: %w", err)
+			}
+			result := paginatedResult(pageURL, pages, sources, contentType, contentSize, startTime)
+			result.Metadata.Error = fmt.Sprintf("page %d: %v", len(pages)+1, err)
+			return result, fmt.Errorf("HTTP fetch of page %d failed: %w", len(pages)+1, err)
+		}
+
+		if len(pages) == 0 {
+			contentType = fetched.contentType
+		}
+		contentSize += fetched.size
+
+		source := types.GroundingChunk{Fetched: true}
+		source.Web.URI = current.String()
+		var next *url.URL
+		if isHTMLContent(fetched.contentType) {
+			doc, err := html.Parse(strings.NewReader(fetched.content))
+			if err == nil {
+				source.Web.Title = extractTitle(doc)
+				next = wf.nextPageURL(doc, current, selector, firstURL.Hostname(), visited)
+			}
+		}
+		sources = append(sources, source)
+		pages = append(pages, fmt.Sprintf(constants.PageDelimiterFormat+"\n\n%s", len(pages)+1, current, wf.prepareContent(fetched.content, fetched.contentType)))
+		current = next
+	}
+
+	return paginatedResult(pageURL, pages, sources, contentType, contentSize, startTime), nil
+}
+
+// fetchPage fetches a single page of a paginated listing, honoring robots.txt if enabled.
+func (wf *WebFetcher) fetchPage(ctx context.Context, pageURL string) (fetchedContent, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, constants.HTTPFetchTimeout)
+	defer cancel()
+
+	if wf.config.WebFetch.RespectRobotsTxt {
+		if err := wf.checkRobotsTxt(fetchCtx, pageURL); err != nil {
+			return fetchedContent{}, err
+		}
+	}
+
+	var fetched fetchedContent
+	err := wf.config.RetryPolicy.Do(fetchCtx, func(ctx context.Context) error {
+		var fetchErr error
+		fetched, fetchErr = wf.httpClient.fetch(ctx, pageURL, nil)
+		return fetchErr
+	})
+	return fetched, err
+}
+
+// nextPageURL returns the URL of the next page linked from the document, or nil if it
+// links to none that may be followed: links to other hosts, to pages already visited
+// and to URLs rejected by validation are ignored.
+func (wf *WebFetcher) nextPageURL(doc *html.Node, base *url.URL, selector *simpleSelector, host string, visited map[string]bool) *url.URL {
+	var href string
+	if selector != nil {
+		if n := findMatching(doc, selector.matches); n != nil {
+			href, _ = attrValue(n, "href")
+		}
+	} else if n := findMatching(doc, isNextLink); n != nil {
+		href, _ = attrValue(n, "href")
+	}
+	if strings.TrimSpace(href) == "" {
+		return nil
+	}
+
+	next, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return nil
+	}
+	next.Fragment = ""
+	if !strings.EqualFold(next.Hostname(), host) || visited[next.String()] || wf.validateURL(next.String()) != nil {
+		return nil
+	}
+	return next
+}
+
+// paginatedResult builds the result of the pages fetched by FetchPaginated.
+func paginatedResult(pageURL string, pages []string, sources []types.GroundingChunk, contentType string, contentSize int, startTime time.Time) *types.WebFetchResult {
+	content := strings.Join(pages, "\n\n")
+	if len(content) > constants.DefaultTruncateLength {
+		content = content[:constants.DefaultTruncateLength] + "..."
+	}
+	return timedFetchResult(&types.WebFetchResult{
+		Summary:     fmt.Sprintf("Fetched %d pages from: %s", len(pages), pageURL),
+		Content:     content,
+		DisplayText: content,
+		Sources:     sources,
+		Metadata: types.WebFetchMetadata{
+			URL:          pageURL,
+			ContentType:  contentType,
+			ContentSize:  contentSize,
+			APIUsed:      "http",
+			SourceCount:  len(sources),
+			PagesFetched: len(pages),
+		},
+	}, startTime)
+}
+
+// isNextLink reports whether n is a <link> or <a> element with rel="next".
+func isNextLink(n *html.Node) bool {
+	if n.DataAtom != atom.Link && n.DataAtom != atom.A {
+		return false
+	}
+	rel, _ := attrValue(n, "rel")
+	for _, value := range strings.Fields(rel) {
+		if strings.EqualFold(value, "next") {
+			return true
+		}
+	}
+	return false
+}
+
+// findMatching returns the first element in document order for which match is true.
+func findMatching(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findMatching(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// attrValue returns the value of the element's attribute and whether it is present.
+func attrValue(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// selectorAttr is an attribute condition of a simpleSelector. An empty value with
+// hasValue false only requires the attribute to be present.
+type selectorAttr struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// simpleSelector is a CSS compound selector of a tag name, IDs, classes and attributes.
+type simpleSelector struct {
+	tag     string
+	ids     []string
+	classes []string
+	attrs   []selectorAttr
+}
+
+// unsupportedSelectorChars are the characters of combinators, selector lists,
+// universal selectors and pseudo-classes, which parseSelector does not support.
+const unsupportedSelectorChars = " \t\n>+~,*:"
+
+var errUnsupportedSelector = errors.New("invalid selector: only compound selectors of a tag, #id, .class and [attr=value] are supported")
+
+// parseSelector parses a compound selector such as `a.next[rel="next"]`. An empty
+// selector yields nil.
+func parseSelector(s string) (*simpleSelector, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	sel := &simpleSelector{}
+	name := func(s string) (string, string) {
+		end := strings.IndexAny(s, "#.[")
+		if end < 0 {
+			end = len(s)
+		}
+		return s[:end], s[end:]
+	}
+	sel.tag, s = name(s)
+	sel.tag = strings.ToLower(sel.tag)
+	for s != "" {
+		var part string
+		switch s[0] {
+		case '#':
+			part, s = name(s[1:])
+			sel.ids = append(sel.ids, part)
+		case '.':
+			part, s = name(s[1:])
+			sel.classes = append(sel.classes, part)
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid selector: unterminated attribute in %q", s)
+			}
+			key, value, hasValue := strings.Cut(s[1:end], "=")
+			value = strings.Trim(strings.TrimSpace(value), `"'`)
+			sel.attrs = append(sel.attrs, selectorAttr{key: strings.ToLower(strings.TrimSpace(key)), value: value, hasValue: hasValue})
+			part, s = strings.TrimSpace(key), s[end+1:]
+		}
+		if part == "" || strings.ContainsAny(part, unsupportedSelectorChars) {
+			return nil, errUnsupportedSelector
+		}
+	}
+	if strings.ContainsAny(sel.tag, unsupportedSelectorChars) {
+		return nil, errUnsupportedSelector
+	}
+	return sel, nil
+}
+
+// matches reports whether the element matches the selector.
+func (sel *simpleSelector) matches(n *html.Node) bool {
+	if sel.tag != "" && n.Data != sel.tag {
+		return false
+	}
+	for _, id := range sel.ids {
+		if value, _ := attrValue(n, "id"); value != id {
+			return false
+		}
+	}
+	for _, class := range sel.classes {
+		value, _ := attrValue(n, "class")
+		found := false
+		for _, c := range strings.Fields(value) {
+			found = found || c == class
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, attr := range sel.attrs {
+		value, ok := attrValue(n, attr.key)
+		if !ok || (attr.hasValue && value != attr.value) {
+			return false
+		}
+	}
+	return true
+}
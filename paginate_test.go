@@ -0,0 +1,90 @@
+package geminiwebtools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchPaginated(t *testing.T) {
+	pages := map[string]string{
+		"/docs": `<html><head><title>Docs 1</title><link rel="next" href="/docs?page=2"></head>
+<body><p>First page</p><a class="pager" href="/docs/elsewhere">More</a></body></html>`,
+		"/docs?page=2": `<html><head><title>Docs 2</title></head>
+<body><p>Second page</p><a rel="next" href="http://other.example.org/docs?page=3">Next</a></body></html>`,
+		"/docs/elsewhere": `<html><body><p>Selected page</p><a class="pager" href="/docs">Back</a></body></html>`,
+	}
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := pages[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(content))
+	}))
+	defer page.Close()
+
+	fetcher, err := NewWebFetcher(newTestConfig(""))
+	if err != nil {
+		t.Fatalf("NewWebFetcher returned error: %v", err)
+	}
+	baseURL := useTestPageServer(fetcher, page)
+
+	// The link to another host on the second page is not followed
+	result, err := fetcher.FetchPaginated(context.Background(), baseURL+"/docs", 5, "")
+	if err != nil {
+		t.Fatalf("FetchPaginated returned error: %v", err)
+	}
+	if result.Metadata.PagesFetched != 2 || len(result.Sources) != 2 {
+		t.Fatalf("Expected 2 pages, got %d pages and %d sources", result.Metadata.PagesFetched, len(result.Sources))
+	}
+	first := strings.Index(result.Content, "--- Page 1: "+baseURL+"/docs ---")
+	second := strings.Index(result.Content, "--- Page 2: "+baseURL+"/docs?page=2 ---")
+	if first < 0 || second < first || !strings.Contains(result.Content[first:second], "First page") || !strings.Contains(result.Content[second:], "Second page") {
+		t.Errorf("Unexpected content: %q", result.Content)
+	}
+	if result.Sources[1].Web.Title != "Docs 2" || !result.Sources[1].Fetched {
+		t.Errorf("Unexpected source: %+v", result.Sources[1])
+	}
+
+	// The page cap is strict
+	result, err = fetcher.FetchPaginated(context.Background(), baseURL+"/docs", 1, "")
+	if err != nil || result.Metadata.PagesFetched != 1 || strings.Contains(result.Content, "Second page") {
+		t.Errorf("Expected only the first page, got %d pages, %v", result.Metadata.PagesFetched, err)
+	}
+
+	// A caller-supplied selector replaces rel="next", and visited pages are not fetched again
+	result, err = fetcher.FetchPaginated(context.Background(), baseURL+"/docs", 5, "a.pager[href]")
+	if err != nil {
+		t.Fatalf("FetchPaginated returned error: %v", err)
+	}
+	if result.Metadata.PagesFetched != 2 || !strings.Contains(result.Content, "Selected page") {
+		t.Errorf("Expected the selected page to follow the first, got %d pages: %q", result.Metadata.PagesFetched, result.Content)
+	}
+
+	if _, err := fetcher.FetchPaginated(context.Background(), baseURL+"/docs", 5, "div > a"); err == nil {
+		t.Error("Expected an error for an unsupported selector")
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	sel, err := parseSelector(`a#next.pager.button[rel="next"][data-page]`)
+	if err != nil {
+		t.Fatalf("parseSelector returned error: %v", err)
+	}
+	if sel.tag != "a" || len(sel.ids) != 1 || sel.ids[0] != "next" || len(sel.classes) != 2 || len(sel.attrs) != 2 {
+		t.Errorf("Unexpected selector: %+v", sel)
+	}
+	if sel.attrs[0] != (selectorAttr{key: "rel", value: "next", hasValue: true}) || sel.attrs[1].hasValue {
+		t.Errorf("Unexpected attributes: %+v", sel.attrs)
+	}
+
+	for _, invalid := range []string{"div a", "ul > li", "a, link", "[rel", ".", "a:first-child", "a.next:hover"} {
+		if _, err := parseSelector(invalid); err == nil {
+			t.Errorf("parseSelector(%q) expected an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package geminiwebtools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// isPDFContent checks if the content type indicates a PDF document.
+func isPDFContent(contentType string) bool {
+	return parseMediaType(contentType) == constants.ContentTypePDF
+}
+
+// extractPDFText returns the plain text of a PDF document, or a short notice if the
+// document is encrypted or has no extractable text, such as a scanned document. It
+// never fails: a document that cannot be parsed also yields the notice.
+func extractPDFText(data string) string {
+	text, err := readPDFText([]byte(data))
+	// Encryption schemes the reader does not support are reported without a sentinel error
+	if errors.Is(err, pdf.ErrInvalidPassword) || (err != nil && strings.Contains(err.Error(), "encryption")) {
+		return constants.PDFEncryptedMessage
+	}
+	if err != nil || strings.TrimSpace(text) == "" {
+		return constants.PDFNoTextMessage
+	}
+	return text
+}
+
+// readPDFText reads the text of every page of a PDF document.
+func readPDFText(data []byte) (text string, err error) {
+	// The PDF reader panics on some malformed documents
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed PDF document: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
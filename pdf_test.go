@@ -0,0 +1,89 @@
+package geminiwebtools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// buildPDF returns a minimal single-page PDF document showing text, with extra
+// entries added to its trailer.
+func buildPDF(text, trailerExtra string) string {
+	stream := fmt.Sprintf("BT /F1 12 Tf 72 712 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R %s>>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailerExtra, xref)
+	return b.String()
+}
+
+func TestExtractPDFText(t *testing.T) {
+	if got := extractPDFText(buildPDF("Hello from a PDF", "")); !strings.Contains(got, "Hello from a PDF") {
+		t.Errorf("extractPDFText() = %q, want the document text", got)
+	}
+	if got := extractPDFText(buildPDF("", "")); got != constants.PDFNoTextMessage {
+		t.Errorf("extractPDFText() of a document without text = %q", got)
+	}
+	encrypted := buildPDF("Secret", "/Encrypt << /Filter /Standard /V 1 /R 2 /O (00000000000000000000000000000000) /U (00000000000000000000000000000000) /P -4 >> /ID [(0123456789abcdef) (0123456789abcdef)] ")
+	if got := extractPDFText(encrypted); got != constants.PDFEncryptedMessage {
+		t.Errorf("extractPDFText() of an encrypted document = %q", got)
+	}
+	unsupported := strings.Replace(encrypted, "/V 1 /R 2", "/V 5 /R 6", 1)
+	if got := extractPDFText(unsupported); got != constants.PDFEncryptedMessage {
+		t.Errorf("extractPDFText() of a document with unsupported encryption = %q", got)
+	}
+	if got := extractPDFText("%PDF-1.4\ngarbage"); got != constants.PDFNoTextMessage {
+		t.Errorf("extractPDFText() of a malformed document = %q", got)
+	}
+}
+
+func TestFetchWithHTTPExtractsPDF(t *testing.T) {
+	document := buildPDF("Quarterly report", "")
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		_, _ = w.Write([]byte(document))
+	}))
+	defer page.Close()
+
+	for _, extract := range []bool{true, false} {
+		fetcher, err := NewWebFetcher(newTestConfig("", WithExtractPDF(extract)))
+		if err != nil {
+			t.Fatalf("NewWebFetcher returned error: %v", err)
+		}
+		pageURL := useTestPageServer(fetcher, page) + "/report.pdf"
+
+		result, err := fetcher.fetchWithHTTP(context.Background(), pageURL, "", time.Now())
+		if err != nil {
+			t.Fatalf("fetchWithHTTP returned error: %v", err)
+		}
+		if got := strings.Contains(result.Content, "Quarterly report") && !strings.Contains(result.Content, "%PDF"); got != extract {
+			t.Errorf("ExtractPDF=%v: unexpected content %q", extract, result.Content)
+		}
+		if result.Metadata.ContentType != "application/pdf" || result.Metadata.ContentSize != len(document) {
+			t.Errorf("Unexpected metadata: %+v", result.Metadata)
+		}
+	}
+}
@@ -3,24 +3,71 @@ package auth
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
 
 	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/retry"
+	"github.com/d-kuro/geminiwebtools/pkg/storage"
 	"github.com/d-kuro/geminiwebtools/pkg/types"
 )
 
 // CodeAssistClient provides access to Google's Code Assist Server with OAuth2 authentication.
+//
+// Every request carries the client's session ID, which the server uses to keep grounded
+// interactions (search and URL context) consistent across turns. Requests sharing a
+// session ID are treated as one conversation; call ResetSession to start a new one.
+//
+// If the authenticator's credential store implements storage.ProjectStore, the project
+// found by project discovery and onboarding is persisted and reused by later clients for
+// constants.DefaultProjectCacheTTL, as long as the endpoint and credentials are the same.
+// A reused project that the server rejects is discovered again; ResetProject forgets it.
 type CodeAssistClient struct {
 	auth       *OAuth2Authenticator
 	baseURL    string
 	apiVersion string
-	model      string
-	projectID  string
 	httpClient *http.Client
+
+	// customHTTPClient is set when httpClient was supplied with SetHTTPClient
+	customHTTPClient bool
+
+	// retryPolicy controls retries of content generation calls; nil disables retries
+	retryPolicy *retry.RetryPolicy
+
+	// wrapUntrustedContent frames URL context content as untrusted data
+	wrapUntrustedContent bool
+
+	// passThroughEndUserID sends end-user IDs as given instead of hashing them
+	passThroughEndUserID bool
+
+	// logger receives debug messages; with debugCurl, API calls are logged as curl commands
+	logger    *slog.Logger
+	debugCurl bool
+
+	// rateLimiter bounds the rate and concurrency of API calls; nil means unlimited
+	rateLimiter *rateLimiter
+
+	// mu protects model, projectID, projectFromStore and sessionID
+	mu        sync.RWMutex
+	model     string
+	projectID string
+	sessionID string
+
+	// projectFromStore is set when projectID was loaded from the project store
+	projectFromStore bool
 }
 
 // NewCodeAssistClient creates a new CodeAssist client with optimized HTTP settings.
@@ -38,8 +85,8 @@ func NewCodeAssistClient(auth *OAuth2Authenticator, baseURL, model string) *Code
 			Timeout:   constants.DefaultDialerTimeout,
 			KeepAlive: constants.KeepAliveTimeout,
 		}).DialContext,
+		// No response header timeout: generation calls are bounded by their own timeouts
 		TLSHandshakeTimeout:   constants.TLSHandshakeTimeout,
-		ResponseHeaderTimeout: constants.ResponseHeaderTimeout,
 		ExpectContinueTimeout: constants.ExpectContinueTimeout,
 
 		// Enable HTTP/2 for better API performance
@@ -63,17 +110,294 @@ func NewCodeAssistClient(auth *OAuth2Authenticator, baseURL, model string) *Code
 		apiVersion: constants.DefaultAPIVersion,
 		model:      model,
 		httpClient: client,
+		sessionID:  NewSessionID(),
+
+		wrapUntrustedContent: true,
+	}
+}
+
+// NewCodeAssistClientWithHTTPClient creates a new CodeAssist client that sends API calls,
+// and the token refreshes they trigger, with the given HTTP client, for example one
+// with an instrumented or in-process test transport. See SetHTTPClient.
+func NewCodeAssistClientWithHTTPClient(auth *OAuth2Authenticator, baseURL, model string, httpClient *http.Client) *CodeAssistClient {
+	client := NewCodeAssistClient(auth, baseURL, model)
+	client.SetHTTPClient(httpClient)
+	return client
+}
+
+// APIError is returned when the CodeAssist Server responds with a non-200 status code.
+// Use errors.As to branch on it, for example to back off from quota errors.
+type APIError struct {
+	StatusCode int
+	Status     string
+
+	// Body is the beginning of the response body, for diagnostics. At most
+	// constants.MaxErrorBodySize bytes are read.
+	Body string
+
+	// Message and Reason are decoded from a Google API error in Body, if any. Reason
+	// is the canonical error status, such as RESOURCE_EXHAUSTED or UNAUTHENTICATED.
+	Message string
+	Reason  string
+}
+
+// newAPIError returns the error of a non-200 response, reading a bounded part of its body.
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Status: resp.Status}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, constants.MaxErrorBodySize))
+	apiErr.Body = strings.TrimSpace(string(body))
+
+	var decoded struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &decoded) == nil {
+		apiErr.Message = decoded.Error.Message
+		apiErr.Reason = decoded.Error.Status
+	}
+	return apiErr
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("API error: %d %s: %s", e.StatusCode, e.Status, e.Message)
+	}
+	return fmt.Sprintf("API error: %d %s", e.StatusCode, e.Status)
+}
+
+// IsRetryable reports whether the status code indicates a transient failure.
+func (e *APIError) IsRetryable() bool {
+	return retry.IsRetryableStatus(e.StatusCode)
+}
+
+// IsRateLimited reports whether the request was rejected for exceeding a rate limit
+// or quota.
+func (e *APIError) IsRateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.Reason == constants.APIErrorResourceExhausted
+}
+
+// credentialsRejected reports whether the server rejected the access token of the
+// request, which a token refresh may fix.
+func (e *APIError) credentialsRejected() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// IsUnauthorized reports whether the request was rejected for missing or invalid
+// credentials, which signing in again may fix.
+func (e *APIError) IsUnauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.Reason == constants.APIErrorUnauthenticated
+}
+
+// SetRetryPolicy sets the retry policy used for content generation calls.
+// A nil policy disables retries.
+func (c *CodeAssistClient) SetRetryPolicy(policy *retry.RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// SetWrapUntrustedContent sets whether URL context requests frame the retrieved web
+// content as untrusted data that the model must not take instructions from. It is
+// enabled by default. The framing is a best-effort defense against prompt injection
+// in fetched pages; it lowers the risk but cannot guarantee the model ignores them.
+func (c *CodeAssistClient) SetWrapUntrustedContent(wrap bool) {
+	c.wrapUntrustedContent = wrap
+}
+
+// SetRateLimit limits the rate and concurrency of the client's API calls, including
+// streamed calls. Calls beyond the limits wait until they may proceed or their context
+// is done. It must be called before the client is used. A nil config removes the limits.
+func (c *CodeAssistClient) SetRateLimit(config *RateLimitConfig) {
+	c.rateLimiter = newRateLimiter(config)
+}
+
+// SetLogger sets the logger of the client's debug messages. A nil logger disables them.
+func (c *CodeAssistClient) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// SetDebugCurl sets whether API calls are logged at debug level as curl commands that
+// replay them, to reproduce issues against the server directly. The commands contain
+// the request bodies, but never the access token: the Authorization header reads it
+// from the ACCESS_TOKEN environment variable instead. Nothing is logged unless the
+// logger set with SetLogger has debug level enabled.
+func (c *CodeAssistClient) SetDebugCurl(enabled bool) {
+	c.debugCurl = enabled
+}
+
+// SetProxy sets the Proxy function of the client's transport, which API calls and the
+// token refreshes they trigger then use. It must be called before the client is used.
+// A nil function connects directly. It has no effect on a client set with SetHTTPClient.
+func (c *CodeAssistClient) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	if c.customHTTPClient {
+		return
+	}
+	c.httpClient.Transport.(*http.Transport).Proxy = proxy
+}
+
+// SetHTTPClient replaces the client's own transport with the given client, for
+// instrumented or test transports. API calls and the token refreshes they trigger are
+// sent with its transport, wrapped to authenticate them. It must be called before the
+// client is used. A nil client keeps the default transport.
+func (c *CodeAssistClient) SetHTTPClient(client *http.Client) {
+	if client == nil {
+		return
+	}
+	c.httpClient = client
+	c.customHTTPClient = true
+}
+
+// authenticatedClient returns an HTTP client that authenticates API calls. When a proxy
+// or a custom client is set, the client's transport is used as the base transport for
+// the calls and for token refreshes; otherwise the defaults of the OAuth2 library are kept.
+func (c *CodeAssistClient) authenticatedClient(ctx context.Context) (*http.Client, error) {
+	if c.customHTTPClient || c.httpClient.Transport.(*http.Transport).Proxy != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, c.httpClient)
+	}
+	return c.auth.GetAuthenticatedClient(ctx)
+}
+
+// Model returns the model used for requests that do not set their own.
+func (c *CodeAssistClient) Model() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model
+}
+
+// SetModel sets the model used for subsequent requests that do not set their own.
+// It returns an error if the model is empty.
+func (c *CodeAssistClient) SetModel(model string) error {
+	if strings.TrimSpace(model) == "" {
+		return fmt.Errorf("model cannot be empty")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model = model
+	return nil
+}
+
+// SessionID returns the session ID sent with every content generation request that
+// has no session ID of its own set with WithSessionID.
+func (c *CodeAssistClient) SessionID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sessionID
+}
+
+// SetSessionID sets the session ID sent with subsequent requests.
+// An empty session ID disables session threading.
+func (c *CodeAssistClient) SetSessionID(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessionID = sessionID
+}
+
+// ResetSession replaces the session ID with a newly generated one and returns it.
+// Subsequent requests are no longer grounded as follow-ups of earlier ones.
+func (c *CodeAssistClient) ResetSession() string {
+	sessionID := NewSessionID()
+	c.SetSessionID(sessionID)
+	return sessionID
+}
+
+// NewSessionID generates a cryptographically random UUID (version 4) to use as a
+// session ID.
+func NewSessionID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:]) // crypto/rand.Read never returns an error
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// getProjectID returns the initialized project ID, or an empty string if not initialized.
+func (c *CodeAssistClient) getProjectID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.projectID
+}
+
+// ResetProject forgets the initialized project, including the persisted one, so that
+// the next request runs project discovery and onboarding again.
+func (c *CodeAssistClient) ResetProject() {
+	c.mu.Lock()
+	c.projectID = ""
+	c.projectFromStore = false
+	c.mu.Unlock()
+
+	if ps := c.projectStore(); ps != nil {
+		_ = ps.ClearProject()
+	}
+}
+
+// projectStore returns the credential store if it can persist projects, or nil.
+func (c *CodeAssistClient) projectStore() storage.ProjectStore {
+	if c.auth == nil {
+		return nil
+	}
+	ps, _ := c.auth.store.(storage.ProjectStore)
+	return ps
+}
+
+// loadStoredProject returns the persisted project ID if it was initialized recently
+// against the same endpoint with the current credentials, or an empty string.
+func (c *CodeAssistClient) loadStoredProject() string {
+	ps := c.projectStore()
+	if ps == nil {
+		return ""
+	}
+
+	info, err := ps.LoadProject()
+	if err != nil || info.ProjectID == "" || info.Endpoint != c.baseURL {
+		return ""
+	}
+	if info.CredentialID == "" || info.CredentialID != c.auth.credentialID() {
+		return ""
+	}
+	if time.Since(info.UpdatedAt) > constants.DefaultProjectCacheTTL {
+		return ""
 	}
+	return info.ProjectID
+}
+
+// storeProject persists an initialized project. This is best effort: if it fails,
+// the project is discovered again by the next client.
+func (c *CodeAssistClient) storeProject(projectID string) {
+	ps := c.projectStore()
+	if ps == nil {
+		return
+	}
+
+	credentialID := c.auth.credentialID()
+	if credentialID == "" {
+		return
+	}
+	_ = ps.StoreProject(&storage.ProjectInfo{
+		ProjectID:    projectID,
+		Endpoint:     c.baseURL,
+		CredentialID: credentialID,
+		UpdatedAt:    time.Now(),
+	})
 }
 
 // InitializeProject initializes the CodeAssist project if needed.
 func (c *CodeAssistClient) InitializeProject(ctx context.Context) error {
-	if c.projectID != "" {
+	if c.getProjectID() != "" {
 		return nil // Already initialized
 	}
 
+	// Reuse a recently initialized project; it is validated by the next request
+	if projectID := c.loadStoredProject(); projectID != "" {
+		c.mu.Lock()
+		c.projectID = projectID
+		c.projectFromStore = true
+		c.mu.Unlock()
+		return nil
+	}
+
 	// Get authenticated HTTP client
-	httpClient, err := c.auth.GetAuthenticatedClient(ctx)
+	httpClient, err := c.authenticatedClient(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get authenticated client: %w", err)
 	}
@@ -95,21 +419,23 @@ func (c *CodeAssistClient) InitializeProject(ctx context.Context) error {
 	}
 
 	// Extract project ID
-	if projectID, ok := loadResp["cloudaicompanionProject"].(string); ok && projectID != "" {
-		c.projectID = projectID
-	} else {
+	projectID, ok := loadResp["cloudaicompanionProject"].(string)
+	if !ok || projectID == "" {
 		return fmt.Errorf("failed to get project ID from loadCodeAssist response")
 	}
+	c.mu.Lock()
+	c.projectID = projectID
+	c.mu.Unlock()
 
 	// Onboard user
 	onboardReq := map[string]interface{}{
 		"tierId":                  constants.TierIDFree,
-		"cloudaicompanionProject": c.projectID,
+		"cloudaicompanionProject": projectID,
 		"metadata": map[string]string{
 			"ideType":     "IDE_UNSPECIFIED",
 			"platform":    "PLATFORM_UNSPECIFIED",
 			"pluginType":  "GEMINI",
-			"duetProject": c.projectID,
+			"duetProject": projectID,
 		},
 	}
 
@@ -118,27 +444,69 @@ func (c *CodeAssistClient) InitializeProject(ctx context.Context) error {
 		return fmt.Errorf("failed to onboard user: %w", err)
 	}
 
+	c.storeProject(projectID)
 	return nil
 }
 
 // GenerateContent sends a content generation request to the CodeAssist Server.
 func (c *CodeAssistClient) GenerateContent(ctx context.Context, req *types.GenerateContentRequest) (*types.GenerateContentResponse, error) {
+	resp, err := c.generateContent(ctx, req)
+	if err != nil && c.rejectedStoredProject(err) {
+		// The persisted project is no longer usable, so discover it again and retry once
+		resp, err = c.generateContent(ctx, req)
+	}
+	return resp, err
+}
+
+// rejectedStoredProject reports whether err shows that the server rejected a project
+// loaded from the project store, in which case the project is forgotten.
+func (c *CodeAssistClient) rejectedStoredProject(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode != http.StatusForbidden && apiErr.StatusCode != http.StatusNotFound {
+		return false
+	}
+
+	c.mu.RLock()
+	fromStore := c.projectFromStore
+	c.mu.RUnlock()
+	if !fromStore {
+		return false
+	}
+
+	c.ResetProject()
+	return true
+}
+
+// generateContent initializes the project if needed and makes the content generation call.
+func (c *CodeAssistClient) generateContent(ctx context.Context, req *types.GenerateContentRequest) (*types.GenerateContentResponse, error) {
 	// Ensure project is initialized
 	if err := c.InitializeProject(ctx); err != nil {
 		return nil, err
 	}
 
 	// Get authenticated HTTP client
-	httpClient, err := c.auth.GetAuthenticatedClient(ctx)
+	httpClient, err := c.authenticatedClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get authenticated client: %w", err)
 	}
 
 	// Convert to CodeAssist format
-	caReq := c.convertToCodeAssistRequest(req)
-
-	// Make API call
-	respData, err := c.callAPI(ctx, httpClient, "generateContent", caReq)
+	caReq := c.convertToCodeAssistRequest(ctx, req)
+
+	// Make API call, retrying transient failures
+	var respData map[string]interface{}
+	attempt := 0
+	err = c.retryPolicy.Do(ctx, func(ctx context.Context) error {
+		timeout := attemptTimeout(ctx, c.retryPolicy.Attempts()-attempt)
+		attempt++
+
+		var callErr error
+		respData, callErr = c.callAPIWithTimeout(ctx, httpClient, "generateContent", caReq, timeout)
+		return callErr
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to call generateContent: %w", err)
 	}
@@ -158,44 +526,146 @@ func (c *CodeAssistClient) GenerateContent(ctx context.Context, req *types.Gener
 	return c.convertFromCodeAssistResponse(&caResp), nil
 }
 
-// CreateSearchRequest creates a request for web search.
-func (c *CodeAssistClient) CreateSearchRequest(query string) *types.GenerateContentRequest {
+// CreateRequest creates a request for a single user prompt that may use any of the
+// given tools, for example both Google Search and URL context. Without tools the model
+// answers from the prompt alone.
+func (c *CodeAssistClient) CreateRequest(prompt string, tools ...types.Tool) *types.GenerateContentRequest {
 	return &types.GenerateContentRequest{
 		Contents: []types.Content{
 			{
 				Role: "user",
 				Parts: []types.Part{
-					{Text: query},
+					{Text: prompt},
 				},
 			},
 		},
-		Tools: []types.Tool{
-			{GoogleSearch: &types.GoogleSearchTool{}},
-		},
+		Tools: tools,
 	}
 }
 
+// CreateSearchRequest creates a request for web search.
+func (c *CodeAssistClient) CreateSearchRequest(query string) *types.GenerateContentRequest {
+	return c.CreateRequest(query, types.Tool{GoogleSearch: &types.GoogleSearchTool{}})
+}
+
+// CreateSearchRequestWithHistory is like CreateSearchRequest but sends the prior turns
+// of a conversation before the query. The history is copied, not modified.
+func (c *CodeAssistClient) CreateSearchRequestWithHistory(history []types.Content, query string) *types.GenerateContentRequest {
+	return withHistory(history, c.CreateSearchRequest(query))
+}
+
 // CreateURLContextRequest creates a request for web fetch with URL context.
+// Unless disabled with SetWrapUntrustedContent, the prompt delimits the retrieved
+// content as untrusted data.
 func (c *CodeAssistClient) CreateURLContextRequest(url, prompt string) *types.GenerateContentRequest {
 	combinedPrompt := fmt.Sprintf("Please analyze the content from this URL: %s\n\nUser request: %s", url, prompt)
+	if c.wrapUntrustedContent {
+		combinedPrompt = fmt.Sprintf(constants.UntrustedContentFormat, url, prompt)
+	}
+
+	return c.CreateRequest(combinedPrompt, types.Tool{URLContext: &types.URLContextTool{}})
+}
+
+// CreateURLContextRequestWithHistory is like CreateURLContextRequest but sends the prior
+// turns of a conversation before the prompt. The history is copied, not modified.
+func (c *CodeAssistClient) CreateURLContextRequestWithHistory(history []types.Content, url, prompt string) *types.GenerateContentRequest {
+	return withHistory(history, c.CreateURLContextRequest(url, prompt))
+}
+
+// withHistory prepends the history to the contents of req.
+func withHistory(history []types.Content, req *types.GenerateContentRequest) *types.GenerateContentRequest {
+	contents := make([]types.Content, 0, len(history)+len(req.Contents))
+	for _, content := range history {
+		content.Parts = slices.Clone(content.Parts)
+		contents = append(contents, content)
+	}
+	req.Contents = append(contents, req.Contents...)
+	return req
+}
+
+// ValidateContents returns an error unless every turn of contents has the role "user"
+// or "model" and the last turn, the one to be answered, is a user turn.
+func ValidateContents(contents []types.Content) error {
+	if len(contents) == 0 {
+		return fmt.Errorf("contents cannot be empty")
+	}
+	for i, content := range contents {
+		if content.Role != "user" && content.Role != "model" {
+			return fmt.Errorf("turn %d has role %q, want \"user\" or \"model\"", i+1, content.Role)
+		}
+	}
+	if last := contents[len(contents)-1]; last.Role != "user" {
+		return fmt.Errorf("the last turn has role %q, want \"user\"", last.Role)
+	}
+	return nil
+}
+
+// CreatePageContentRequest creates a request that answers prompt from page content
+// supplied inline instead of through the URL context tool. part and parts identify the
+// content when a page is split into chunks: a single chunk (parts == 1) is answered
+// directly, otherwise the chunk is summarized for CreatePageSynthesisRequest.
+func (c *CodeAssistClient) CreatePageContentRequest(url, prompt, content string, part, parts int) *types.GenerateContentRequest {
+	instruction := constants.PageAnswerInstruction
+	if parts > 1 {
+		instruction = fmt.Sprintf(constants.PageChunkInstruction, part, parts)
+	}
+	return c.createInlineContentRequest(instruction, url, prompt, content)
+}
+
+// CreatePageSynthesisRequest creates a request that combines the chunk summaries made
+// from CreatePageContentRequest responses into a single answer to prompt.
+func (c *CodeAssistClient) CreatePageSynthesisRequest(url, prompt string, summaries []string) *types.GenerateContentRequest {
+	var sb strings.Builder
+	for i, summary := range summaries {
+		fmt.Fprintf(&sb, "Part %d:\n%s\n\n", i+1, summary)
+	}
+	instruction := fmt.Sprintf(constants.PageSynthesisInstruction, len(summaries))
+	return c.createInlineContentRequest(instruction, url, prompt, strings.TrimSpace(sb.String()))
+}
+
+// createInlineContentRequest creates a tool-less request carrying page content in the prompt,
+// framed as untrusted data unless disabled with SetWrapUntrustedContent.
+func (c *CodeAssistClient) createInlineContentRequest(instruction, url, prompt, content string) *types.GenerateContentRequest {
+	framed := fmt.Sprintf(constants.PageContentFormat, url, content)
+	if c.wrapUntrustedContent {
+		framed = fmt.Sprintf(constants.UntrustedPageContentFormat, url, content)
+	}
+	text := instruction + "\n\n" + framed + "\n\n" + fmt.Sprintf(constants.UserRequestFormat, prompt)
 
 	return &types.GenerateContentRequest{
 		Contents: []types.Content{
 			{
 				Role: "user",
 				Parts: []types.Part{
-					{Text: combinedPrompt},
+					{Text: text},
 				},
 			},
 		},
-		Tools: []types.Tool{
-			{URLContext: &types.URLContextTool{}},
-		},
 	}
 }
 
+// attemptTimeout returns the request timeout for an attempt with attemptsLeft attempts
+// remaining, including itself. Attempts before the last one get an equal share of the
+// time left until the caller's deadline, so that a slow attempt cannot leave the
+// remaining attempts without time to run. The last attempt may use all of it.
+func attemptTimeout(ctx context.Context, attemptsLeft int) time.Duration {
+	timeout := constants.APIRequestTimeout
+	if deadline, ok := ctx.Deadline(); ok && attemptsLeft > 1 {
+		if share := time.Until(deadline) / time.Duration(attemptsLeft); share < timeout {
+			timeout = share
+		}
+	}
+	return timeout
+}
+
 // callAPI makes a generic API call to the CodeAssist Server.
 func (c *CodeAssistClient) callAPI(ctx context.Context, httpClient *http.Client, method string, reqData interface{}) (map[string]interface{}, error) {
+	return c.callAPIWithTimeout(ctx, httpClient, method, reqData, constants.APIRequestTimeout)
+}
+
+// callAPIWithTimeout makes an API call to the CodeAssist Server that is abandoned after
+// timeout. A call rejected with 401 or 403 is retried once after forcing a token refresh.
+func (c *CodeAssistClient) callAPIWithTimeout(ctx context.Context, httpClient *http.Client, method string, reqData interface{}, timeout time.Duration) (map[string]interface{}, error) {
 	url := fmt.Sprintf("%s/%s:%s", c.baseURL, c.apiVersion, method)
 
 	reqBytes, err := json.Marshal(reqData)
@@ -208,6 +678,23 @@ func (c *CodeAssistClient) callAPI(ctx context.Context, httpClient *http.Client,
 		return nil, fmt.Errorf("request payload too large: %d bytes (max: %d)", len(reqBytes), constants.MaxAPIRequestSize)
 	}
 
+	result, err := c.sendAPIRequest(ctx, httpClient, url, reqBytes, timeout)
+	var apiErr *APIError
+	if c.auth == nil || !errors.As(err, &apiErr) || !apiErr.credentialsRejected() {
+		return result, err
+	}
+
+	// The access token may have been revoked server-side, so refresh it and retry once
+	c.auth.InvalidateCache()
+	httpClient, authErr := c.authenticatedClient(ctx)
+	if authErr != nil {
+		return nil, fmt.Errorf("%w; refreshing the rejected token failed: %w", err, authErr)
+	}
+	return c.sendAPIRequest(ctx, httpClient, url, reqBytes, timeout)
+}
+
+// sendAPIRequest sends an encoded API request and decodes the response.
+func (c *CodeAssistClient) sendAPIRequest(ctx context.Context, httpClient *http.Client, url string, reqBytes []byte, timeout time.Duration) (map[string]interface{}, error) {
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBytes))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -216,22 +703,36 @@ func (c *CodeAssistClient) callAPI(ctx context.Context, httpClient *http.Client,
 	req.Header.Set("Content-Type", constants.ContentTypeJSON)
 	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(reqBytes)))
 
+	c.logCurlCommand(ctx, req, reqBytes)
+
+	// Wait for the rate limit before the timeout starts, so that waiting is not counted
+	release, err := c.rateLimiter.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for rate limit: %w", err)
+	}
+	defer release()
+
 	// Apply timeout to the request
-	ctx, cancel := context.WithTimeout(ctx, constants.APIRequestTimeout)
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	req = req.WithContext(ctx)
+	req = req.WithContext(reqCtx)
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("request timeout after %v", constants.APIRequestTimeout)
+		// The caller's cancellation or deadline is reported as such; the per-request
+		// timeout is not, so that it stays retryable.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("request failed: %w", ctxErr)
+		}
+		if reqCtx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("request timeout after %v", timeout)
 		}
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error: %d %s", resp.StatusCode, resp.Status)
+		return nil, newAPIError(resp)
 	}
 
 	// Limit response body size
@@ -245,8 +746,10 @@ func (c *CodeAssistClient) callAPI(ctx context.Context, httpClient *http.Client,
 	return result, nil
 }
 
-// convertToCodeAssistRequest converts a standard request to CodeAssist format.
-func (c *CodeAssistClient) convertToCodeAssistRequest(req *types.GenerateContentRequest) *types.CodeAssistGenerateContentRequest {
+// convertToCodeAssistRequest converts a standard request to CodeAssist format, labeling
+// it with the end-user ID of the context, if any. The session ID of the context, if
+// any, takes precedence over the client's.
+func (c *CodeAssistClient) convertToCodeAssistRequest(ctx context.Context, req *types.GenerateContentRequest) *types.CodeAssistGenerateContentRequest {
 	// Pre-allocate slices with exact capacity to avoid reallocations
 	caContents := make([]types.CodeAssistContent, 0, len(req.Contents))
 	for _, content := range req.Contents {
@@ -272,12 +775,34 @@ func (c *CodeAssistClient) convertToCodeAssistRequest(req *types.GenerateContent
 		caTools = append(caTools, caTool)
 	}
 
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	model := c.model
+	if req.Model != "" {
+		model = req.Model
+	}
+
+	var caGenerationConfig *types.CodeAssistGenerationConfig
+	if req.GenerationConfig != nil {
+		generationConfig := types.CodeAssistGenerationConfig(*req.GenerationConfig)
+		caGenerationConfig = &generationConfig
+	}
+
+	sessionID := c.sessionID
+	if id := SessionIDFromContext(ctx); id != "" {
+		sessionID = id
+	}
+
 	return &types.CodeAssistGenerateContentRequest{
-		Model:   c.model,
+		Model:   model,
 		Project: c.projectID,
 		Request: types.CodeAssistVertexContentRequest{
-			Contents: caContents,
-			Tools:    caTools,
+			Contents:         caContents,
+			Tools:            caTools,
+			SessionID:        sessionID,
+			Labels:           c.requestLabels(ctx),
+			GenerationConfig: caGenerationConfig,
 		},
 	}
 }
@@ -310,9 +835,14 @@ func (c *CodeAssistClient) convertFromCodeAssistResponse(caResp *types.CodeAssis
 		candidates = append(candidates, candidate)
 	}
 
-	return &types.GenerateContentResponse{
+	resp := &types.GenerateContentResponse{
 		Candidates: candidates,
 	}
+	if caResp.Response.UsageMetadata != nil {
+		usage := types.UsageMetadata(*caResp.Response.UsageMetadata)
+		resp.UsageMetadata = &usage
+	}
+	return resp
 }
 
 // convertGroundingMetadata converts CodeAssist grounding metadata to standard format.
@@ -332,16 +862,10 @@ func (c *CodeAssistClient) convertGroundingMetadata(caMeta *types.CodeAssistGrou
 	if len(caMeta.GroundingChunks) > 0 {
 		meta.GroundingChunks = make([]types.GroundingChunk, 0, len(caMeta.GroundingChunks))
 		for _, caChunk := range caMeta.GroundingChunks {
-			meta.GroundingChunks = append(meta.GroundingChunks, types.GroundingChunk{
-				Web: struct {
-					URI    string `json:"uri"`
-					Title  string `json:"title"`
-					Domain string `json:"domain,omitempty"`
-				}{
-					URI:   caChunk.Web.URI,
-					Title: caChunk.Web.Title,
-				},
-			})
+			chunk := types.GroundingChunk{Web: caChunk.Web}
+			// The domain is usually omitted, so derive it once for all domain-based features
+			chunk.Web.Domain = chunk.SourceDomain()
+			meta.GroundingChunks = append(meta.GroundingChunks, chunk)
 		}
 	}
 
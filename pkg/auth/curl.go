@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// logCurlCommand logs the request as a curl command at debug level, if enabled with
+// SetDebugCurl and the logger accepts debug messages.
+func (c *CodeAssistClient) logCurlCommand(ctx context.Context, req *http.Request, body []byte) {
+	if !c.debugCurl || c.logger == nil || !c.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	c.logger.DebugContext(ctx, "CodeAssist request", "url", req.URL.String(), "curl", curlCommand(req, body))
+}
+
+// curlCommand returns a shell command that replays the request with curl. The access
+// token is added by the authenticating transport after this point, so the command
+// always carries a placeholder Authorization header read from the environment;
+// Authorization headers already on the request are replaced by it.
+func curlCommand(req *http.Request, body []byte) string {
+	var b strings.Builder
+	b.WriteString("curl -X ")
+	b.WriteString(req.Method)
+	b.WriteString(" ")
+	b.WriteString(shellQuote(req.URL.String()))
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		// curl sets Content-Length itself
+		if !strings.EqualFold(name, "Authorization") && !strings.EqualFold(name, "Content-Length") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			b.WriteString(" -H ")
+			b.WriteString(shellQuote(name + ": " + value))
+		}
+	}
+	// Double quotes let the shell expand the token variable
+	b.WriteString(` -H "Authorization: Bearer $`)
+	b.WriteString(constants.CurlAccessTokenVariable)
+	b.WriteString(`"`)
+
+	if len(body) > 0 {
+		b.WriteString(" --data-raw ")
+		b.WriteString(shellQuote(string(body)))
+	}
+	return b.String()
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCurlCommand(t *testing.T) {
+	body := []byte(`{"prompt":"it's here"}`)
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/v1internal:generateContent?alt=sse", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest returned error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", "22")
+	req.Header.Set("Authorization", "Bearer secret-access-token")
+	req.Header.Set("Accept", "text/event-stream")
+
+	want := `curl -X POST 'https://example.com/v1internal:generateContent?alt=sse'` +
+		` -H 'Accept: text/event-stream' -H 'Content-Type: application/json'` +
+		` -H "Authorization: Bearer $ACCESS_TOKEN"` +
+		` --data-raw '{"prompt":"it'\''s here"}'`
+	if got := curlCommand(req, body); got != want {
+		t.Errorf("curlCommand() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDebugCurlLogging(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		debugCurl bool
+		level     slog.Level
+		wantCurl  bool
+	}{
+		{"enabled at debug level", true, slog.LevelDebug, true},
+		{"enabled at info level", true, slog.LevelInfo, false},
+		{"disabled at debug level", false, slog.LevelDebug, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			client := NewCodeAssistClient(nil, server.URL, "test-model")
+			client.SetLogger(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: tt.level})))
+			client.SetDebugCurl(tt.debugCurl)
+
+			if _, err := client.callAPI(context.Background(), server.Client(), "listModels", map[string]string{"model": "test-model"}); err != nil {
+				t.Fatalf("callAPI returned error: %v", err)
+			}
+			output := logs.String()
+			if got := strings.Contains(output, "curl -X POST"); got != tt.wantCurl {
+				t.Errorf("Expected a logged curl command: %v, got %q", tt.wantCurl, output)
+			}
+			if tt.wantCurl && (!strings.Contains(output, server.URL+"/v1internal:listModels") || !strings.Contains(output, "$ACCESS_TOKEN")) {
+				t.Errorf("Expected the URL and the token placeholder in %q", output)
+			}
+		})
+	}
+}
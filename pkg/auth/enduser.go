@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// endUserIDKey is the context key of the end-user ID.
+type endUserIDKey struct{}
+
+// WithEndUserID returns a context that attributes the CodeAssist content generation
+// requests made with it to an end user of the operator's service, for the operator's
+// own abuse tracking and quota attribution. The ID is sent as the "end_user_id" label
+// of the request. It is hashed by default, so that an identifier such as an email
+// address is not sent as is; see CodeAssistClient.SetPassThroughEndUserID.
+// An empty ID sends no label.
+func WithEndUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, endUserIDKey{}, userID)
+}
+
+// EndUserIDFromContext returns the end-user ID set with WithEndUserID, or an empty
+// string if none is set.
+func EndUserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(endUserIDKey{}).(string)
+	return userID
+}
+
+// SetPassThroughEndUserID sets whether end-user IDs are sent as given. By default they
+// are replaced with the first 32 hex digits of their SHA-256 hash, which also keeps
+// them within the 63 characters allowed for label values. IDs passed through must
+// already be valid label values: lowercase letters, digits, underscores and dashes.
+func (c *CodeAssistClient) SetPassThroughEndUserID(passThrough bool) {
+	c.passThroughEndUserID = passThrough
+}
+
+// requestLabels returns the labels of a request made with ctx, or nil if there are none.
+func (c *CodeAssistClient) requestLabels(ctx context.Context) map[string]string {
+	userID := EndUserIDFromContext(ctx)
+	if userID == "" {
+		return nil
+	}
+	if !c.passThroughEndUserID {
+		userID = hashEndUserID(userID)
+	}
+	return map[string]string{constants.EndUserIDLabel: userID}
+}
+
+// hashEndUserID returns the opaque form of an end-user ID that is sent by default.
+func hashEndUserID(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// accountIdentity identifies the Google account of a token.
+type accountIdentity struct {
+	Email   string `json:"email"`
+	Subject string `json:"sub"`
+}
+
+// idTokenIdentity returns the account identity from the claims of the token's ID token.
+// The signature is not verified, which is fine for display. It reports whether the
+// token has an ID token; the identity of a malformed ID token is empty.
+func idTokenIdentity(token *oauth2.Token) (accountIdentity, bool) {
+	idToken, _ := token.Extra(constants.IDTokenField).(string)
+	if idToken == "" {
+		return accountIdentity{}, false
+	}
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return accountIdentity{}, true
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return accountIdentity{}, true
+	}
+	var identity accountIdentity
+	if err := json.Unmarshal(payload, &identity); err != nil {
+		return accountIdentity{}, true
+	}
+	return identity, true
+}
+
+// cachedUserInfo returns the account identity of the token from the userinfo endpoint.
+// The endpoint is called once per refresh token, or per access token for tokens without
+// one. Failures yield an empty identity and are not cached, so that a transient error
+// is retried on the next call.
+func (auth *OAuth2Authenticator) cachedUserInfo(token *oauth2.Token) accountIdentity {
+	key := token.RefreshToken
+	if key == "" {
+		key = token.AccessToken
+	}
+
+	auth.identityMu.Lock()
+	defer auth.identityMu.Unlock()
+	if auth.identityKey == key {
+		return auth.identity
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.UserInfoTimeout)
+	defer cancel()
+	identity, err := auth.fetchUserInfo(ctx, token)
+	if err != nil {
+		return accountIdentity{}
+	}
+	auth.identityKey = key
+	auth.identity = identity
+	return identity
+}
+
+// fetchUserInfo requests the account identity of the token from the userinfo endpoint.
+func (auth *OAuth2Authenticator) fetchUserInfo(ctx context.Context, token *oauth2.Token) (accountIdentity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, auth.userInfoURL, nil)
+	if err != nil {
+		return accountIdentity{}, fmt.Errorf("failed to create userinfo request: %w", err)
+	}
+	token.SetAuthHeader(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return accountIdentity{}, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, constants.MaxErrorBodySize))
+		return accountIdentity{}, fmt.Errorf("userinfo request rejected with status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var identity accountIdentity
+	if err := json.NewDecoder(resp.Body).Decode(&identity); err != nil {
+		return accountIdentity{}, fmt.Errorf("failed to parse userinfo response: %w", err)
+	}
+	return identity, nil
+}
@@ -3,7 +3,9 @@ package auth
 
 import (
 	"context"
+	"io"
 	"net/http"
+	"time"
 
 	"golang.org/x/oauth2"
 
@@ -41,6 +43,19 @@ type TokenProvider interface {
 	GetAuthenticatedClient(ctx context.Context) (*http.Client, error)
 }
 
+// RefreshMetrics receives token refresh measurements, for example to feed a duration
+// histogram and a grace period counter. Implementations must be safe for concurrent
+// use and should return quickly, since they are called on the refresh path.
+type RefreshMetrics interface {
+	// ObserveRefresh is called after each refresh with its duration, including retries,
+	// and its error, which is nil if the refresh succeeded.
+	ObserveRefresh(duration time.Duration, err error)
+
+	// ObserveGracePeriodUse is called when an expired token is used within the grace
+	// period because it could not be refreshed.
+	ObserveGracePeriodUse()
+}
+
 // WebSearchProvider defines the interface for components that provide web search functionality.
 type WebSearchProvider interface {
 	Authenticatable
@@ -101,11 +116,29 @@ func (sa *SharedAuthenticator) AuthenticateWithBrowser(ctx context.Context) erro
 	return sa.oauth2Auth.AuthenticateWithBrowser(ctx)
 }
 
+// AuthenticateManual performs browser-based OAuth2 authentication with a pasted code.
+// See OAuth2Authenticator.AuthenticateManual.
+func (sa *SharedAuthenticator) AuthenticateManual(ctx context.Context, codeReader io.Reader) error {
+	return sa.oauth2Auth.AuthenticateManual(ctx, codeReader)
+}
+
+// AuthenticateWithDeviceCode performs the OAuth2 device authorization flow.
+// See OAuth2Authenticator.AuthenticateWithDeviceCode.
+func (sa *SharedAuthenticator) AuthenticateWithDeviceCode(ctx context.Context) error {
+	return sa.oauth2Auth.AuthenticateWithDeviceCode(ctx)
+}
+
 // ClearAuthentication removes stored authentication credentials.
 func (sa *SharedAuthenticator) ClearAuthentication() error {
 	return sa.oauth2Auth.ClearAuthentication()
 }
 
+// Revoke revokes the stored credentials with the OAuth2 provider and removes them locally.
+// See OAuth2Authenticator.RevokeToken.
+func (sa *SharedAuthenticator) Revoke(ctx context.Context) error {
+	return sa.oauth2Auth.RevokeToken(ctx)
+}
+
 // GetValidToken returns a valid OAuth2 token, refreshing if necessary.
 func (sa *SharedAuthenticator) GetValidToken(ctx context.Context) (*oauth2.Token, error) {
 	return sa.oauth2Auth.GetValidToken(ctx)
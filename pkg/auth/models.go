@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// ErrUnsupported is returned when the CodeAssist Server does not expose a method, so
+// that callers can fall back, for example to a static list of models.
+var ErrUnsupported = errors.New("not supported by the CodeAssist Server")
+
+// ListModels returns the models available to the account and its tier. It fails with an
+// error wrapping ErrUnsupported if the server does not expose a models list.
+func (c *CodeAssistClient) ListModels(ctx context.Context) ([]types.ModelInfo, error) {
+	if err := c.InitializeProject(ctx); err != nil {
+		return nil, err
+	}
+
+	httpClient, err := c.authenticatedClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authenticated client: %w", err)
+	}
+
+	respData, err := c.callAPI(ctx, httpClient, "listModels", map[string]interface{}{
+		"project": c.getProjectID(),
+	})
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && unsupportedStatus(apiErr.StatusCode) {
+			return nil, fmt.Errorf("failed to list models: %w: %w", ErrUnsupported, err)
+		}
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+
+	var resp types.ListModelsResponse
+	respBytes, err := json.Marshal(respData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	models := make([]types.ModelInfo, 0, len(resp.Models))
+	for _, model := range resp.Models {
+		model.Name = strings.TrimPrefix(model.Name, "models/")
+		if model.Name != "" {
+			models = append(models, model)
+		}
+	}
+	return models, nil
+}
+
+// unsupportedStatus reports whether an HTTP status means the method does not exist.
+func unsupportedStatus(statusCode int) bool {
+	return statusCode == http.StatusNotFound || statusCode == http.StatusMethodNotAllowed || statusCode == http.StatusNotImplemented
+}
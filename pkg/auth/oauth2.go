@@ -57,11 +57,14 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -70,6 +73,8 @@ import (
 
 	"github.com/d-kuro/geminiwebtools/pkg/browser"
 	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/device"
+	"github.com/d-kuro/geminiwebtools/pkg/retry"
 	"github.com/d-kuro/geminiwebtools/pkg/storage"
 )
 
@@ -100,8 +105,62 @@ type RefreshConfig struct {
 	// BackgroundRefreshInterval is the interval for checking background refresh needs
 	BackgroundRefreshInterval time.Duration
 
+	// BackgroundRefreshTimeout bounds each background refresh check, including waiting for
+	// a BackgroundPool slot and all retry attempts. Shutdown cancels it early.
+	// If zero or negative, constants.BackgroundRefreshTimeout is used.
+	BackgroundRefreshTimeout time.Duration
+
 	// RefreshLockTimeout is the timeout for acquiring refresh lock
 	RefreshLockTimeout time.Duration
+
+	// BackgroundPool optionally bounds background refreshes across authenticators sharing it.
+	// If nil, background refreshes are not limited.
+	BackgroundPool *BackgroundPool
+
+	// RetryClassifier determines which refresh errors are retried.
+	// If nil, retry.DefaultClassifier is used.
+	RetryClassifier retry.Classifier
+
+	// Metrics optionally receives refresh durations and grace period uses.
+	Metrics RefreshMetrics
+
+	// OnRefresh is optionally called with the old and new token after every successful
+	// refresh, whether it was triggered by GetValidToken or by the background refresh.
+	// It runs after the authenticator's locks are released, so it may call back into
+	// the authenticator. It must not modify the tokens.
+	OnRefresh func(old, new *oauth2.Token)
+
+	// PersistState makes the refresh state survive restarts, for diagnosing refreshes
+	// that keep failing. The last attempt and success, the number of failed attempts and
+	// a redacted last error are stored after every refresh, if the credential store
+	// implements storage.RefreshStateStore, and loaded when the authenticator is created.
+	PersistState bool
+}
+
+// RetryPolicy returns the retry policy described by the refresh configuration.
+func (c *RefreshConfig) RetryPolicy() *retry.RetryPolicy {
+	return &retry.RetryPolicy{
+		MaxAttempts:   c.RetryMaxAttempts,
+		BaseDelay:     c.RetryBaseDelay,
+		MaxDelay:      c.RetryMaxDelay,
+		Multiplier:    c.RetryMultiplier,
+		JitterPercent: c.JitterPercent,
+		Retryable:     c.RetryClassifier,
+	}
+}
+
+// ApplyRetryPolicy copies the retry settings of the given policy into the refresh configuration.
+// A nil policy leaves the refresh retry settings unchanged.
+func (c *RefreshConfig) ApplyRetryPolicy(policy *retry.RetryPolicy) {
+	if policy == nil {
+		return
+	}
+	c.RetryMaxAttempts = policy.MaxAttempts
+	c.RetryBaseDelay = policy.BaseDelay
+	c.RetryMaxDelay = policy.MaxDelay
+	c.RetryMultiplier = policy.Multiplier
+	c.JitterPercent = policy.JitterPercent
+	c.RetryClassifier = policy.Retryable
 }
 
 // DefaultRefreshConfig returns the default refresh configuration.
@@ -115,6 +174,7 @@ func DefaultRefreshConfig() *RefreshConfig {
 		JitterPercent:              constants.RefreshJitterPercent,
 		GracePeriod:                constants.RefreshGracePeriod,
 		BackgroundRefreshInterval:  constants.BackgroundRefreshInterval,
+		BackgroundRefreshTimeout:   constants.BackgroundRefreshTimeout,
 		RefreshLockTimeout:         constants.RefreshLockTimeout,
 	}
 }
@@ -135,6 +195,14 @@ type RefreshState struct {
 
 	// LastError is the last error encountered during refresh
 	LastError error
+
+	// LastRefreshDuration is how long the last refresh took, including retries,
+	// whether or not it succeeded
+	LastRefreshDuration time.Duration
+
+	// GracePeriodUses is the number of times an expired token was used within the
+	// grace period because it could not be refreshed
+	GracePeriodUses int
 }
 
 // OAuth2Authenticator provides OAuth2 authentication compatible with gemini-cli.
@@ -144,6 +212,9 @@ type OAuth2Authenticator struct {
 	config        *oauth2.Config
 	store         storage.CredentialStore
 	refreshConfig *RefreshConfig
+	browserConfig browser.BrowserAuthConfig
+	revokeURL     string
+	userInfoURL   string
 
 	// Concurrent access protection
 	mu sync.RWMutex
@@ -152,15 +223,31 @@ type OAuth2Authenticator struct {
 	refreshState *RefreshState
 	refreshMu    sync.Mutex
 
+	// Successful refreshes whose OnRefresh callback has not run yet, guarded by refreshMu
+	pendingRefreshes []refreshEvent
+
 	// Background refresh management
 	backgroundCtx    context.Context
 	backgroundCancel context.CancelFunc
 	backgroundWg     sync.WaitGroup
 
+	// Cancellation and completion of the ongoing background refresh, guarded by refreshMu
+	backgroundRefreshCancel context.CancelFunc
+	backgroundRefreshDone   chan struct{}
+
 	// Cached token with its retrieval time
 	cachedToken     *oauth2.Token
 	cachedTokenTime time.Time
 	cacheValidFor   time.Duration
+
+	// invalidAccessToken is an access token rejected by the server, which the next
+	// token load refreshes even though it has not expired; guarded by mu
+	invalidAccessToken string
+
+	// Account identity from the userinfo endpoint, for the refresh or access token in identityKey
+	identityMu  sync.Mutex
+	identityKey string
+	identity    accountIdentity
 }
 
 // OAuth2Config holds OAuth2 authentication configuration.
@@ -170,6 +257,43 @@ type OAuth2Config struct {
 	AuthURL      string   `json:"authUrl,omitempty"`
 	TokenURL     string   `json:"tokenUrl,omitempty"`
 	Scopes       []string `json:"scopes,omitempty"`
+
+	// RedirectPort is the local port of the browser authentication callback. If zero,
+	// an available port is chosen. Set it when the OAuth app only allows a fixed
+	// redirect URI such as http://localhost:8085/oauth2callback.
+	RedirectPort int `json:"redirectPort,omitempty"`
+
+	// InlineSuccessPage makes browser authentication show a page that tries to close
+	// itself and asks the user to return to the terminal, instead of redirecting to
+	// Google's success page.
+	InlineSuccessPage bool `json:"inlineSuccessPage,omitempty"`
+
+	// SuccessHTML and FailureHTML are served as the page shown after browser
+	// authentication succeeds or fails, instead of redirecting to Google's pages.
+	// SuccessHTML takes precedence over InlineSuccessPage.
+	SuccessHTML string `json:"successHtml,omitempty"`
+	FailureHTML string `json:"failureHtml,omitempty"`
+
+	// SuccessURL and FailureURL replace Google's pages as the redirect targets after
+	// browser authentication succeeds or fails.
+	SuccessURL string `json:"successUrl,omitempty"`
+	FailureURL string `json:"failureUrl,omitempty"`
+
+	// OpenURLFunc opens the authorization URL of browser authentication. If nil, the
+	// $BROWSER command or the default browser of the operating system is used.
+	OpenURLFunc browser.BrowserOpener `json:"-"`
+
+	// RevokeURL is the endpoint RevokeToken posts tokens to.
+	// If empty, constants.DefaultOAuthRevokeURL is used.
+	RevokeURL string `json:"revokeUrl,omitempty"`
+
+	// DeviceAuthURL is the device authorization endpoint of AuthenticateWithDeviceCode.
+	// If empty, constants.DefaultOAuthDeviceURL is used.
+	DeviceAuthURL string `json:"deviceAuthUrl,omitempty"`
+
+	// UserInfoURL is the endpoint GetAuthStatus asks for the account of tokens without
+	// an ID token. If empty, constants.DefaultOAuthUserInfoURL is used.
+	UserInfoURL string `json:"userInfoUrl,omitempty"`
 }
 
 // NewOAuth2Authenticator creates a new OAuth2 authenticator with default refresh configuration.
@@ -183,33 +307,88 @@ func NewOAuth2AuthenticatorWithConfig(oauth2Config OAuth2Config, store storage.C
 		ClientID:     oauth2Config.ClientID,
 		ClientSecret: oauth2Config.ClientSecret,
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  oauth2Config.AuthURL,
-			TokenURL: oauth2Config.TokenURL,
+			AuthURL:       oauth2Config.AuthURL,
+			TokenURL:      oauth2Config.TokenURL,
+			DeviceAuthURL: oauth2Config.DeviceAuthURL,
 		},
 		Scopes: oauth2Config.Scopes,
 	}
 
+	if config.Endpoint.DeviceAuthURL == "" {
+		config.Endpoint.DeviceAuthURL = constants.DefaultOAuthDeviceURL
+	}
+
+	revokeURL := oauth2Config.RevokeURL
+	if revokeURL == "" {
+		revokeURL = constants.DefaultOAuthRevokeURL
+	}
+	userInfoURL := oauth2Config.UserInfoURL
+	if userInfoURL == "" {
+		userInfoURL = constants.DefaultOAuthUserInfoURL
+	}
+
 	backgroundCtx, backgroundCancel := context.WithCancel(context.Background())
 
 	auth := &OAuth2Authenticator{
-		config:           config,
-		store:            store,
-		refreshConfig:    refreshConfig,
+		config:        config,
+		store:         store,
+		refreshConfig: refreshConfig,
+		browserConfig: browser.BrowserAuthConfig{
+			RedirectPort:      oauth2Config.RedirectPort,
+			InlineSuccessPage: oauth2Config.InlineSuccessPage,
+			SuccessHTML:       oauth2Config.SuccessHTML,
+			FailureHTML:       oauth2Config.FailureHTML,
+			SuccessURL:        oauth2Config.SuccessURL,
+			FailureURL:        oauth2Config.FailureURL,
+			OpenURLFunc:       oauth2Config.OpenURLFunc,
+		},
+		revokeURL:        revokeURL,
+		userInfoURL:      userInfoURL,
 		refreshState:     &RefreshState{},
 		backgroundCtx:    backgroundCtx,
 		backgroundCancel: backgroundCancel,
 		cacheValidFor:    1 * time.Minute, // Cache tokens for 1 minute to reduce storage I/O
 	}
 
+	auth.restoreRefreshState()
+
 	// Start background refresh goroutine
 	auth.startBackgroundRefresh()
 
 	return auth
 }
 
-// GetAuthStatus checks the current authentication status.
+// GetAuthStatus checks the current authentication status. The account is taken from
+// the token's ID token; for an unexpired token without one, the userinfo endpoint is
+// asked once and the answer is cached.
 func (auth *OAuth2Authenticator) GetAuthStatus() (*AuthStatus, error) {
-	token, err := auth.store.LoadToken()
+	status, token := storeStatus(auth.store)
+	if token != nil && token.AccessToken != "" && !status.IsExpired {
+		if _, ok := idTokenIdentity(token); !ok {
+			identity := auth.cachedUserInfo(token)
+			status.Email = identity.Email
+			status.Subject = identity.Subject
+		}
+	}
+	return status, nil
+}
+
+// StatusForStores reports the authentication status of the token in each store, keyed
+// like the stores, for example by account name. Tokens are only loaded: expired tokens
+// are reported as expired and not refreshed, and the account is only taken from ID
+// tokens. A store that fails to load reports the error in its status.
+func StatusForStores(stores map[string]storage.CredentialStore) map[string]*AuthStatus {
+	statuses := make(map[string]*AuthStatus, len(stores))
+	for name, store := range stores {
+		statuses[name], _ = storeStatus(store)
+	}
+	return statuses
+}
+
+// storeStatus reports the authentication status of the token in a store, along with
+// the token, if one could be loaded.
+func storeStatus(store storage.CredentialStore) (*AuthStatus, *oauth2.Token) {
+	token, err := store.LoadToken()
 	if err != nil {
 		return &AuthStatus{
 			Authenticated: false,
@@ -224,11 +403,14 @@ func (auth *OAuth2Authenticator) GetAuthStatus() (*AuthStatus, error) {
 		}, nil
 	}
 
+	identity, _ := idTokenIdentity(token)
 	status := &AuthStatus{
 		Authenticated:   true,
 		TokenType:       token.TokenType,
 		HasRefreshToken: token.RefreshToken != "",
-		StoragePath:     auth.store.GetStoragePath(),
+		StoragePath:     store.GetStoragePath(),
+		Email:           identity.Email,
+		Subject:         identity.Subject,
 	}
 
 	if !token.Expiry.IsZero() {
@@ -237,13 +419,14 @@ func (auth *OAuth2Authenticator) GetAuthStatus() (*AuthStatus, error) {
 		status.IsExpired = token.Expiry.Before(time.Now())
 	}
 
-	return status, nil
+	return status, token
 }
 
 // IsAuthenticated checks if a valid token is available.
 func (auth *OAuth2Authenticator) IsAuthenticated() bool {
-	status, err := auth.GetAuthStatus()
-	return err == nil && status.Authenticated && !status.IsExpired
+	// The account is not needed, so the userinfo endpoint is never asked
+	status, _ := storeStatus(auth.store)
+	return status.Authenticated && !status.IsExpired
 }
 
 // GetValidToken returns a valid OAuth2 token, refreshing if necessary.
@@ -262,8 +445,16 @@ func (auth *OAuth2Authenticator) GetValidToken(ctx context.Context) (*oauth2.Tok
 
 	// Acquire write lock for token operations
 	auth.mu.Lock()
-	defer auth.mu.Unlock()
+	token, err := auth.loadValidToken(ctx)
+	auth.mu.Unlock()
 
+	auth.notifyRefreshes()
+	return token, err
+}
+
+// loadValidToken loads the stored token and refreshes it if it has expired.
+// The caller must hold auth.mu.
+func (auth *OAuth2Authenticator) loadValidToken(ctx context.Context) (*oauth2.Token, error) {
 	// Double-check cache after acquiring write lock
 	if auth.cachedToken != nil && time.Since(auth.cachedTokenTime) < auth.cacheValidFor {
 		if !IsTokenExpired(auth.cachedToken) {
@@ -297,7 +488,10 @@ func (auth *OAuth2Authenticator) GetValidToken(ctx context.Context) (*oauth2.Tok
 	}
 
 	// Check if token is expired or needs refresh
-	if IsTokenExpired(token) {
+	// A rejected token is refreshed once; if that fails, later calls use it again
+	invalidated := auth.invalidAccessToken != "" && token.AccessToken == auth.invalidAccessToken
+	auth.invalidAccessToken = ""
+	if IsTokenExpired(token) || invalidated {
 		if token.RefreshToken == "" {
 			return nil, &AuthError{
 				Op:      "refresh_token",
@@ -307,9 +501,10 @@ func (auth *OAuth2Authenticator) GetValidToken(ctx context.Context) (*oauth2.Tok
 
 		refreshedToken, err := auth.refreshTokenWithRetry(ctx, token)
 		if err != nil {
-			// Check if we can use the old token during grace period
-			if auth.canUseTokenDuringGracePeriod(token) {
+			// Check if we can use the old token during grace period; a rejected token cannot be used
+			if !invalidated && auth.canUseTokenDuringGracePeriod(token) {
 				log.Printf("Warning: Using expired token during grace period due to refresh failure: %v", err)
+				auth.recordGracePeriodUse()
 				auth.updateCache(token)
 				return token, nil
 			}
@@ -329,6 +524,9 @@ func (auth *OAuth2Authenticator) GetValidToken(ctx context.Context) (*oauth2.Tok
 }
 
 // RefreshToken refreshes an OAuth2 token and stores the new token.
+// With a store implementing storage.TokenUpdater, such as the filesystem stores, the
+// store stays locked during the refresh, and a token that another process sharing the
+// store refreshed in the meantime is returned instead of refreshing it again.
 func (auth *OAuth2Authenticator) RefreshToken(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
 	if token.RefreshToken == "" {
 		return nil, &AuthError{
@@ -337,11 +535,57 @@ func (auth *OAuth2Authenticator) RefreshToken(ctx context.Context, token *oauth2
 		}
 	}
 
+	updater, ok := auth.store.(storage.TokenUpdater)
+	if !ok {
+		newToken, err := auth.exchangeRefreshToken(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if err := auth.store.StoreToken(newToken); err != nil {
+			return nil, &AuthError{
+				Op:      "store_token",
+				Message: "failed to store refreshed token",
+				Err:     err,
+			}
+		}
+		return newToken, nil
+	}
+
+	var newToken *oauth2.Token
+	err := updater.UpdateToken(func(current *oauth2.Token) (*oauth2.Token, error) {
+		if current != nil && current.AccessToken != token.AccessToken && !IsTokenExpired(current) {
+			newToken = current
+			return current, nil
+		}
+		refreshed, err := auth.exchangeRefreshToken(ctx, token)
+		newToken = refreshed
+		return refreshed, err
+	})
+	if err != nil {
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			return nil, err
+		}
+		return nil, &AuthError{
+			Op:      "store_token",
+			Message: "failed to store refreshed token",
+			Err:     err,
+		}
+	}
+	return newToken, nil
+}
+
+// exchangeRefreshToken obtains a new token for the refresh token of token.
+func (auth *OAuth2Authenticator) exchangeRefreshToken(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
 	// Add timeout to refresh operation
 	ctx, cancel := context.WithTimeout(ctx, constants.TokenRefreshTimeout)
 	defer cancel()
 
-	tokenSource := auth.config.TokenSource(ctx, token)
+	// The token source returns tokens that have not expired as they are, so mark the
+	// token as expired to refresh it ahead of its expiry in the background
+	expired := *token
+	expired.Expiry = time.Now().Add(-time.Second)
+	tokenSource := auth.config.TokenSource(ctx, &expired)
 	newToken, err := tokenSource.Token()
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
@@ -367,22 +611,13 @@ func (auth *OAuth2Authenticator) RefreshToken(ctx context.Context, token *oauth2
 		}
 	}
 
-	// Store the refreshed token
-	if err := auth.store.StoreToken(newToken); err != nil {
-		return nil, &AuthError{
-			Op:      "store_token",
-			Message: "failed to store refreshed token",
-			Err:     err,
-		}
-	}
-
 	return newToken, nil
 }
 
 // AuthenticateWithBrowser performs browser-based OAuth2 authentication flow.
 // This opens a browser window for user authentication and stores the resulting token.
 func (auth *OAuth2Authenticator) AuthenticateWithBrowser(ctx context.Context) error {
-	browserAuth := browser.NewBrowserAuth(auth.config)
+	browserAuth := browser.NewBrowserAuthWithConfig(auth.config, auth.browserConfig)
 
 	token, err := browserAuth.Authenticate(ctx)
 	if err != nil {
@@ -393,7 +628,46 @@ func (auth *OAuth2Authenticator) AuthenticateWithBrowser(ctx context.Context) er
 		}
 	}
 
-	// Store the token
+	return auth.storeNewToken(token)
+}
+
+// AuthenticateManual performs browser-based OAuth2 authentication without a local
+// callback server, for remote machines where the browser opens on another host. It
+// prints the authorization URL, reads the code or the redirect URL the user pastes into
+// codeReader, typically os.Stdin, and stores the resulting token.
+func (auth *OAuth2Authenticator) AuthenticateManual(ctx context.Context, codeReader io.Reader) error {
+	token, err := browser.NewBrowserAuth(auth.config).AuthenticateManual(ctx, codeReader)
+	if err != nil {
+		return &AuthError{
+			Op:      "manual_auth",
+			Message: "manual authentication failed",
+			Err:     err,
+		}
+	}
+
+	return auth.storeNewToken(token)
+}
+
+// AuthenticateWithDeviceCode performs the OAuth2 device authorization flow for environments
+// without a local browser. It prints a code and a URL to open on any device with a
+// browser, waits until the user has authorized the request and stores the resulting
+// token. Errors wrap device.ErrExpiredToken or device.ErrAccessDenied if the code
+// expired or the user denied the request.
+func (auth *OAuth2Authenticator) AuthenticateWithDeviceCode(ctx context.Context) error {
+	token, err := device.NewDeviceAuth(auth.config).Authenticate(ctx)
+	if err != nil {
+		return &AuthError{
+			Op:      "device_auth",
+			Message: "device authentication failed",
+			Err:     err,
+		}
+	}
+
+	return auth.storeNewToken(token)
+}
+
+// storeNewToken stores the token of a new sign-in.
+func (auth *OAuth2Authenticator) storeNewToken(token *oauth2.Token) error {
 	if err := auth.store.StoreToken(token); err != nil {
 		return &AuthError{
 			Op:      "store_token",
@@ -402,9 +676,33 @@ func (auth *OAuth2Authenticator) AuthenticateWithBrowser(ctx context.Context) er
 		}
 	}
 
+	// The persisted project may belong to the previous credentials
+	auth.clearStoredProject()
+
 	return nil
 }
 
+// InvalidateCache discards the cached token and makes the next GetValidToken refresh
+// the current access token even though it has not expired, for example after the
+// server rejected it because it was revoked. If that refresh fails, GetValidToken
+// fails without falling back to the grace period, and later calls use the token again.
+// A token refreshed in the meantime by another process sharing the store is used
+// instead of refreshing again.
+func (auth *OAuth2Authenticator) InvalidateCache() {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	token := auth.cachedToken
+	if token == nil {
+		token, _ = auth.store.LoadToken()
+	}
+	if token != nil {
+		auth.invalidAccessToken = token.AccessToken
+	}
+	auth.cachedToken = nil
+	auth.cachedTokenTime = time.Time{}
+}
+
 // GetAuthenticatedClient returns an HTTP client configured with OAuth2 authentication.
 func (auth *OAuth2Authenticator) GetAuthenticatedClient(ctx context.Context) (*http.Client, error) {
 	token, err := auth.GetValidToken(ctx)
@@ -437,10 +735,92 @@ func (auth *OAuth2Authenticator) ClearAuthentication() error {
 	auth.refreshMu.Lock()
 	auth.refreshState = &RefreshState{}
 	auth.refreshMu.Unlock()
+	auth.clearRefreshState()
+
+	auth.clearStoredProject()
 
 	return nil
 }
 
+// RevokeToken revokes the stored credentials with the OAuth2 provider, so that they can no
+// longer be used anywhere, and then removes them locally like ClearAuthentication.
+// The refresh token is revoked if there is one, which also invalidates its access
+// tokens; otherwise the access token is revoked. If revocation fails, the local
+// credentials are kept.
+func (auth *OAuth2Authenticator) RevokeToken(ctx context.Context) error {
+	token, err := auth.store.LoadToken()
+	if err != nil || token == nil {
+		return &AuthError{
+			Op:      "revoke_token",
+			Message: "no token stored - nothing to revoke",
+			Err:     err,
+		}
+	}
+
+	value := token.RefreshToken
+	if value == "" {
+		value = token.AccessToken
+	}
+	if value == "" {
+		return &AuthError{
+			Op:      "revoke_token",
+			Message: "stored token has neither a refresh nor an access token",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, constants.TokenRevokeTimeout)
+	defer cancel()
+
+	form := url.Values{"token": {value}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, auth.revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return &AuthError{
+			Op:      "revoke_token",
+			Message: "failed to create revocation request",
+			Err:     err,
+		}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &AuthError{
+			Op:      "revoke_token",
+			Message: "revocation request failed",
+			Err:     err,
+		}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, constants.MaxErrorBodySize))
+		return &AuthError{
+			Op:      "revoke_token",
+			Message: fmt.Sprintf("revocation rejected with status %s: %s", resp.Status, strings.TrimSpace(string(body))),
+		}
+	}
+
+	return auth.ClearAuthentication()
+}
+
+// clearStoredProject removes the persisted CodeAssist project, if the store keeps one.
+func (auth *OAuth2Authenticator) clearStoredProject() {
+	if ps, ok := auth.store.(storage.ProjectStore); ok {
+		_ = ps.ClearProject() // A stale project is also rejected by its credential ID
+	}
+}
+
+// credentialID returns an identifier of the stored credentials that does not reveal them,
+// or an empty string if there are no credentials with a refresh token.
+func (auth *OAuth2Authenticator) credentialID() string {
+	token, err := auth.store.LoadToken()
+	if err != nil || token == nil || token.RefreshToken == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token.RefreshToken))
+	return hex.EncodeToString(sum[:8])
+}
+
 // AuthStatus represents the current authentication status.
 type AuthStatus struct {
 	Authenticated   bool          `json:"authenticated"`
@@ -451,6 +831,10 @@ type AuthStatus struct {
 	HasRefreshToken bool          `json:"hasRefreshToken,omitempty"`
 	StoragePath     string        `json:"storagePath,omitempty"`
 	Error           string        `json:"error,omitempty"`
+
+	// Email and Subject identify the Google account the token belongs to, if known
+	Email   string `json:"email,omitempty"`
+	Subject string `json:"subject,omitempty"`
 }
 
 // AuthError represents an authentication error.
@@ -531,8 +915,9 @@ func (auth *OAuth2Authenticator) refreshTokenWithRetry(ctx context.Context, toke
 	}
 
 	// Mark as refreshing
+	start := time.Now()
 	auth.refreshState.IsRefreshing = true
-	auth.refreshState.LastRefreshAttempt = time.Now()
+	auth.refreshState.LastRefreshAttempt = start
 	auth.refreshMu.Unlock()
 
 	defer func() {
@@ -541,44 +926,96 @@ func (auth *OAuth2Authenticator) refreshTokenWithRetry(ctx context.Context, toke
 		auth.refreshMu.Unlock()
 	}()
 
-	var lastErr error
-	for attempt := 0; attempt < auth.refreshConfig.RetryMaxAttempts; attempt++ {
-		if attempt > 0 {
-			// Calculate delay with exponential backoff and jitter
-			delay := auth.calculateBackoffDelay(attempt)
-			log.Printf("Token refresh attempt %d failed, retrying in %v: %v", attempt, delay, lastErr)
-
-			select {
-			case <-time.After(delay):
-				// Continue to retry
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			}
-		}
+	policy := auth.refreshConfig.RetryPolicy()
+	attempts := 0
+	var refreshedToken *oauth2.Token
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		attempts++
 
-		refreshedToken, err := auth.RefreshToken(ctx, token)
-		if err == nil {
+		newToken, err := auth.RefreshToken(ctx, token)
+		if err != nil {
 			auth.refreshMu.Lock()
-			auth.refreshState.LastRefreshSuccess = time.Now()
-			auth.refreshState.RefreshAttempts = 0
-			auth.refreshState.LastError = nil
+			auth.refreshState.RefreshAttempts++
+			auth.refreshState.LastError = err
 			auth.refreshMu.Unlock()
-			return refreshedToken, nil
-		}
 
-		lastErr = err
-		auth.refreshMu.Lock()
-		auth.refreshState.RefreshAttempts++
-		auth.refreshState.LastError = err
-		auth.refreshMu.Unlock()
+			if attempts < policy.Attempts() && policy.IsRetryable(err) {
+				log.Printf("Token refresh attempt %d failed, retrying: %v", attempts, err)
+			}
+			return err
+		}
 
-		// Check if this is a non-retryable error
-		if !auth.isRetryableError(err) {
-			break
+		refreshedToken = newToken
+		return nil
+	})
+	auth.recordRefreshDuration(time.Since(start), err)
+	if err != nil {
+		auth.persistRefreshState()
+		if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+			return nil, err
 		}
+		return nil, fmt.Errorf("token refresh failed after %d attempts: %w", attempts, err)
 	}
 
-	return nil, fmt.Errorf("token refresh failed after %d attempts: %w", auth.refreshConfig.RetryMaxAttempts, lastErr)
+	auth.refreshMu.Lock()
+	auth.refreshState.LastRefreshSuccess = time.Now()
+	auth.refreshState.RefreshAttempts = 0
+	auth.refreshState.LastError = nil
+	if auth.refreshConfig.OnRefresh != nil {
+		auth.pendingRefreshes = append(auth.pendingRefreshes, refreshEvent{old: token, new: refreshedToken})
+	}
+	auth.refreshMu.Unlock()
+	auth.persistRefreshState()
+	return refreshedToken, nil
+}
+
+// refreshEvent is a successful refresh to report to RefreshConfig.OnRefresh.
+type refreshEvent struct {
+	old, new *oauth2.Token
+}
+
+// notifyRefreshes calls RefreshConfig.OnRefresh for the refreshes completed since the
+// last call. It must be called without holding auth.mu or auth.refreshMu.
+func (auth *OAuth2Authenticator) notifyRefreshes() {
+	auth.refreshMu.Lock()
+	events := auth.pendingRefreshes
+	auth.pendingRefreshes = nil
+	auth.refreshMu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+
+	auth.mu.RLock()
+	onRefresh := auth.refreshConfig.OnRefresh
+	auth.mu.RUnlock()
+	if onRefresh == nil {
+		return
+	}
+	for _, event := range events {
+		onRefresh(event.old, event.new)
+	}
+}
+
+// recordRefreshDuration records the duration of a refresh and reports it to the metrics.
+func (auth *OAuth2Authenticator) recordRefreshDuration(duration time.Duration, err error) {
+	auth.refreshMu.Lock()
+	auth.refreshState.LastRefreshDuration = duration
+	auth.refreshMu.Unlock()
+
+	if metrics := auth.refreshConfig.Metrics; metrics != nil {
+		metrics.ObserveRefresh(duration, err)
+	}
+}
+
+// recordGracePeriodUse counts a use of an expired token and reports it to the metrics.
+func (auth *OAuth2Authenticator) recordGracePeriodUse() {
+	auth.refreshMu.Lock()
+	auth.refreshState.GracePeriodUses++
+	auth.refreshMu.Unlock()
+
+	if metrics := auth.refreshConfig.Metrics; metrics != nil {
+		metrics.ObserveGracePeriodUse()
+	}
 }
 
 // waitForRefresh waits for an ongoing refresh operation to complete.
@@ -606,60 +1043,6 @@ func (auth *OAuth2Authenticator) waitForRefresh(ctx context.Context) (*oauth2.To
 	}
 }
 
-// calculateBackoffDelay calculates the delay for exponential backoff with jitter.
-func (auth *OAuth2Authenticator) calculateBackoffDelay(attempt int) time.Duration {
-	// Exponential backoff: baseDelay * multiplier^attempt
-	delay := float64(auth.refreshConfig.RetryBaseDelay) * math.Pow(auth.refreshConfig.RetryMultiplier, float64(attempt))
-
-	// Cap at max delay
-	if delay > float64(auth.refreshConfig.RetryMaxDelay) {
-		delay = float64(auth.refreshConfig.RetryMaxDelay)
-	}
-
-	// Add jitter to avoid thundering herd
-	jitter := delay * auth.refreshConfig.JitterPercent * (rand.Float64()*2 - 1) // -jitter to +jitter
-	finalDelay := time.Duration(delay + jitter)
-
-	// Ensure minimum delay
-	if finalDelay < auth.refreshConfig.RetryBaseDelay {
-		finalDelay = auth.refreshConfig.RetryBaseDelay
-	}
-
-	return finalDelay
-}
-
-// isRetryableError determines if an error is retryable.
-func (auth *OAuth2Authenticator) isRetryableError(err error) bool {
-	if err == nil {
-		return false
-	}
-
-	errorStr := strings.ToLower(err.Error())
-
-	// Retry on network errors, timeouts, and temporary failures
-	retryableErrors := []string{
-		"timeout",
-		"connection",
-		"network",
-		"temporary",
-		"unavailable",
-		"rate limit",
-		"429", // Too Many Requests
-		"500", // Internal Server Error
-		"502", // Bad Gateway
-		"503", // Service Unavailable
-		"504", // Gateway Timeout
-	}
-
-	for _, retryable := range retryableErrors {
-		if strings.Contains(errorStr, retryable) {
-			return true
-		}
-	}
-
-	return false
-}
-
 // canUseTokenDuringGracePeriod checks if we can use an expired token during grace period.
 func (auth *OAuth2Authenticator) canUseTokenDuringGracePeriod(token *oauth2.Token) bool {
 	if token == nil || token.Expiry.IsZero() {
@@ -702,8 +1085,16 @@ func (auth *OAuth2Authenticator) backgroundRefreshLoop() {
 
 // checkAndRefreshToken checks if a token needs background refresh and performs it.
 func (auth *OAuth2Authenticator) checkAndRefreshToken() {
-	// Use a short timeout for background operations
-	ctx, cancel := context.WithTimeout(auth.backgroundCtx, 30*time.Second)
+	auth.mu.RLock()
+	pool := auth.refreshConfig.BackgroundPool
+	timeout := auth.refreshConfig.BackgroundRefreshTimeout
+	auth.mu.RUnlock()
+	if timeout <= 0 {
+		timeout = constants.BackgroundRefreshTimeout
+	}
+
+	// Derive from backgroundCtx so that Shutdown cancels an ongoing refresh
+	ctx, cancel := context.WithTimeout(auth.backgroundCtx, timeout)
 	defer cancel()
 
 	token, err := auth.store.LoadToken()
@@ -711,7 +1102,19 @@ func (auth *OAuth2Authenticator) checkAndRefreshToken() {
 		return // No token to refresh
 	}
 
-	if auth.shouldBackgroundRefresh(token) {
+	if !auth.shouldBackgroundRefresh(token) {
+		return
+	}
+
+	// A dedicated sub-context lets CancelBackgroundRefresh interrupt this refresh alone
+	ctx, cancelRefresh := context.WithCancel(ctx)
+	done := make(chan struct{})
+	auth.refreshMu.Lock()
+	auth.backgroundRefreshCancel = cancelRefresh
+	auth.backgroundRefreshDone = done
+	auth.refreshMu.Unlock()
+
+	err = pool.Do(ctx, func(ctx context.Context) {
 		log.Printf("Starting background token refresh")
 		_, err := auth.refreshTokenWithRetry(ctx, token)
 		if err != nil {
@@ -719,7 +1122,19 @@ func (auth *OAuth2Authenticator) checkAndRefreshToken() {
 		} else {
 			log.Printf("Background token refresh completed successfully")
 		}
+	})
+	if err != nil {
+		log.Printf("Background token refresh skipped: %v", err)
 	}
+
+	auth.refreshMu.Lock()
+	auth.backgroundRefreshCancel = nil
+	auth.backgroundRefreshDone = nil
+	auth.refreshMu.Unlock()
+	cancelRefresh()
+	close(done)
+
+	auth.notifyRefreshes()
 }
 
 // shouldBackgroundRefresh determines if a token should be refreshed in the background.
@@ -768,6 +1183,25 @@ func (auth *OAuth2Authenticator) Shutdown() {
 	auth.backgroundWg.Wait()
 }
 
+// CancelBackgroundRefresh interrupts the ongoing background refresh, if any, and waits
+// until it has stopped and IsRefreshing is reset. The background loop keeps running and
+// checks the token again at its next interval. On-demand refreshes are not affected;
+// a background check waiting for one of them only stops waiting. It reports whether a
+// background refresh was in progress.
+func (auth *OAuth2Authenticator) CancelBackgroundRefresh() bool {
+	auth.refreshMu.Lock()
+	cancel := auth.backgroundRefreshCancel
+	done := auth.backgroundRefreshDone
+	auth.refreshMu.Unlock()
+	if cancel == nil {
+		return false
+	}
+
+	cancel()
+	<-done
+	return true
+}
+
 // GetRefreshState returns the current refresh state for monitoring.
 func (auth *OAuth2Authenticator) GetRefreshState() *RefreshState {
 	auth.refreshMu.Lock()
@@ -780,6 +1214,9 @@ func (auth *OAuth2Authenticator) GetRefreshState() *RefreshState {
 		LastRefreshSuccess: auth.refreshState.LastRefreshSuccess,
 		RefreshAttempts:    auth.refreshState.RefreshAttempts,
 		LastError:          auth.refreshState.LastError,
+
+		LastRefreshDuration: auth.refreshState.LastRefreshDuration,
+		GracePeriodUses:     auth.refreshState.GracePeriodUses,
 	}
 }
 
@@ -805,6 +1242,12 @@ func (auth *OAuth2Authenticator) GetRefreshConfig() *RefreshConfig {
 		JitterPercent:              auth.refreshConfig.JitterPercent,
 		GracePeriod:                auth.refreshConfig.GracePeriod,
 		BackgroundRefreshInterval:  auth.refreshConfig.BackgroundRefreshInterval,
+		BackgroundRefreshTimeout:   auth.refreshConfig.BackgroundRefreshTimeout,
 		RefreshLockTimeout:         auth.refreshConfig.RefreshLockTimeout,
+		BackgroundPool:             auth.refreshConfig.BackgroundPool,
+		RetryClassifier:            auth.refreshConfig.RetryClassifier,
+		Metrics:                    auth.refreshConfig.Metrics,
+		OnRefresh:                  auth.refreshConfig.OnRefresh,
+		PersistState:               auth.refreshConfig.PersistState,
 	}
 }
@@ -0,0 +1,681 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/d-kuro/geminiwebtools/pkg/retry"
+	"github.com/d-kuro/geminiwebtools/pkg/storage"
+)
+
+func TestRefreshConfigApplyRetryPolicy(t *testing.T) {
+	config := DefaultRefreshConfig()
+	config.ApplyRetryPolicy(&retry.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, Multiplier: 2})
+	if got := config.RetryPolicy().Attempts(); got != 5 {
+		t.Errorf("Expected 5 attempts, got %d", got)
+	}
+
+	config.ApplyRetryPolicy(nil)
+	if got := config.RetryPolicy().Attempts(); got != 5 {
+		t.Errorf("Expected a nil policy to keep the refresh retries, got %d attempts", got)
+	}
+}
+
+func TestBackgroundRefreshTimeout(t *testing.T) {
+	// A token halfway through its lifetime with a refresh token is due for background refresh
+	store := storage.NewInMemoryStore()
+	if err := store.StoreToken(&oauth2.Token{
+		AccessToken:  "test-access-token",
+		RefreshToken: "test-refresh-token",
+		Expiry:       time.Now().Add(10 * time.Minute),
+	}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+
+	// Hold the only pool slot so the refresh waits until its context is done
+	pool := NewBackgroundPool(1)
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		_ = pool.Do(context.Background(), func(context.Context) { <-release })
+	}()
+	for pool.Running() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	refreshConfig := DefaultRefreshConfig()
+	refreshConfig.BackgroundPool = pool
+	refreshConfig.BackgroundRefreshInterval = time.Hour
+	refreshConfig.BackgroundRefreshTimeout = 50 * time.Millisecond
+	auth := NewOAuth2AuthenticatorWithConfig(OAuth2Config{}, store, refreshConfig)
+	defer auth.Shutdown()
+
+	start := time.Now()
+	auth.checkAndRefreshToken()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Expected the background refresh to give up after the configured timeout, took %v", elapsed)
+	}
+
+	// Shutdown cancels a refresh well before a long timeout expires
+	refreshConfig.BackgroundRefreshTimeout = time.Hour
+	done := make(chan struct{})
+	go func() {
+		auth.checkAndRefreshToken()
+		close(done)
+	}()
+	auth.Shutdown()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Shutdown to cancel the background refresh")
+	}
+}
+
+func TestCancelBackgroundRefresh(t *testing.T) {
+	// The token endpoint stalls until the request is cancelled, then answers normally
+	var stall atomic.Bool
+	stall.Store(true)
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body is read first so that the server notices the client going away
+		_ = r.ParseForm()
+		if stall.Load() {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"access_token": "on-demand-access-token", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer tokenServer.Close()
+
+	store := storage.NewInMemoryStore()
+	token := &oauth2.Token{
+		AccessToken:  "test-access-token",
+		RefreshToken: "test-refresh-token",
+		Expiry:       time.Now().Add(10 * time.Minute),
+	}
+	if err := store.StoreToken(token); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+
+	refreshConfig := DefaultRefreshConfig()
+	refreshConfig.RetryMaxAttempts = 1
+	refreshConfig.BackgroundRefreshInterval = time.Hour
+	refreshConfig.BackgroundRefreshTimeout = time.Hour
+	auth := NewOAuth2AuthenticatorWithConfig(OAuth2Config{TokenURL: tokenServer.URL}, store, refreshConfig)
+	defer auth.Shutdown()
+
+	if auth.CancelBackgroundRefresh() {
+		t.Error("Expected no background refresh to cancel before one starts")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		auth.checkAndRefreshToken()
+		close(done)
+	}()
+	for !auth.GetRefreshState().IsRefreshing {
+		time.Sleep(time.Millisecond)
+	}
+
+	if !auth.CancelBackgroundRefresh() {
+		t.Error("Expected the ongoing background refresh to be cancelled")
+	}
+	if auth.GetRefreshState().IsRefreshing {
+		t.Error("Expected IsRefreshing to be reset once the background refresh is cancelled")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the background refresh to stop")
+	}
+	select {
+	case <-auth.backgroundCtx.Done():
+		t.Error("Expected the background loop to keep running")
+	default:
+	}
+
+	// An on-demand refresh proceeds after the cancellation
+	stall.Store(false)
+	refreshed, err := auth.refreshTokenWithRetry(context.Background(), token)
+	if err != nil {
+		t.Fatalf("refreshTokenWithRetry returned error: %v", err)
+	}
+	if refreshed.AccessToken != "on-demand-access-token" {
+		t.Errorf("Expected the refreshed access token, got %q", refreshed.AccessToken)
+	}
+}
+
+// recordingMetrics is a RefreshMetrics that records what it observes.
+type recordingMetrics struct {
+	mu              sync.Mutex
+	durations       []time.Duration
+	errs            []error
+	gracePeriodUses int
+}
+
+func (m *recordingMetrics) ObserveRefresh(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.durations = append(m.durations, duration)
+	m.errs = append(m.errs, err)
+}
+
+func (m *recordingMetrics) ObserveGracePeriodUse() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gracePeriodUses++
+}
+
+func TestRefreshMetrics(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		wantRefreshed bool
+	}{
+		{name: "successful refresh", status: http.StatusOK, wantRefreshed: true},
+		{name: "failed refresh within grace period", status: http.StatusBadRequest, wantRefreshed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(20 * time.Millisecond)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				if tt.status == http.StatusOK {
+					_, _ = w.Write([]byte(`{"access_token": "new-access-token", "token_type": "Bearer", "expires_in": 3600}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+			}))
+			defer tokenServer.Close()
+
+			// An expired token is refreshed on use and stays usable within the grace period
+			store := storage.NewInMemoryStore()
+			if err := store.StoreToken(&oauth2.Token{
+				AccessToken:  "test-access-token",
+				RefreshToken: "test-refresh-token",
+				Expiry:       time.Now().Add(-time.Second),
+			}); err != nil {
+				t.Fatalf("StoreToken returned error: %v", err)
+			}
+
+			metrics := &recordingMetrics{}
+			refreshConfig := DefaultRefreshConfig()
+			refreshConfig.RetryMaxAttempts = 1
+			refreshConfig.BackgroundRefreshInterval = time.Hour
+			refreshConfig.Metrics = metrics
+			auth := NewOAuth2AuthenticatorWithConfig(OAuth2Config{TokenURL: tokenServer.URL}, store, refreshConfig)
+			defer auth.Shutdown()
+
+			token, err := auth.GetValidToken(context.Background())
+			if err != nil {
+				t.Fatalf("GetValidToken returned error: %v", err)
+			}
+			if refreshed := token.AccessToken == "new-access-token"; refreshed != tt.wantRefreshed {
+				t.Errorf("Access token = %q, refreshed = %v, want %v", token.AccessToken, refreshed, tt.wantRefreshed)
+			}
+
+			state := auth.GetRefreshState()
+			if state.LastRefreshDuration < 20*time.Millisecond {
+				t.Errorf("Expected LastRefreshDuration of at least 20ms, got %v", state.LastRefreshDuration)
+			}
+			wantGraceUses := 0
+			if !tt.wantRefreshed {
+				wantGraceUses = 1
+			}
+			if state.GracePeriodUses != wantGraceUses {
+				t.Errorf("GracePeriodUses = %d, want %d", state.GracePeriodUses, wantGraceUses)
+			}
+
+			metrics.mu.Lock()
+			defer metrics.mu.Unlock()
+			if len(metrics.durations) != 1 || metrics.durations[0] != state.LastRefreshDuration {
+				t.Errorf("Expected one observed refresh of %v, got %v", state.LastRefreshDuration, metrics.durations)
+			}
+			if (metrics.errs[0] == nil) != tt.wantRefreshed {
+				t.Errorf("Observed refresh error = %v", metrics.errs[0])
+			}
+			if metrics.gracePeriodUses != wantGraceUses {
+				t.Errorf("Observed grace period uses = %d, want %d", metrics.gracePeriodUses, wantGraceUses)
+			}
+		})
+	}
+}
+
+func TestOnRefreshCallback(t *testing.T) {
+	var issued atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token": "access-token-%d", "token_type": "Bearer", "expires_in": 3600}`, issued.Add(1))
+	}))
+	defer tokenServer.Close()
+
+	store := storage.NewInMemoryStore()
+	if err := store.StoreToken(&oauth2.Token{
+		AccessToken:  "test-access-token",
+		RefreshToken: "test-refresh-token",
+		Expiry:       time.Now().Add(-time.Second),
+	}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+
+	var auth *OAuth2Authenticator
+	var calls atomic.Int32
+	refreshConfig := DefaultRefreshConfig()
+	refreshConfig.RetryMaxAttempts = 1
+	refreshConfig.BackgroundRefreshInterval = time.Hour
+	refreshConfig.OnRefresh = func(old, new *oauth2.Token) {
+		calls.Add(1)
+		if old.AccessToken == new.AccessToken {
+			t.Errorf("Expected a new access token, both are %q", new.AccessToken)
+		}
+		// The callback may re-enter the authenticator without deadlocking
+		if _, err := auth.GetValidToken(context.Background()); err != nil {
+			t.Errorf("GetValidToken in the callback returned error: %v", err)
+		}
+	}
+	auth = NewOAuth2AuthenticatorWithConfig(OAuth2Config{TokenURL: tokenServer.URL}, store, refreshConfig)
+	defer auth.Shutdown()
+
+	if _, err := auth.GetValidToken(context.Background()); err != nil {
+		t.Fatalf("GetValidToken returned error: %v", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected one callback after a foreground refresh, got %d", got)
+	}
+
+	// A cached token does not trigger a refresh or a callback
+	if _, err := auth.GetValidToken(context.Background()); err != nil {
+		t.Fatalf("GetValidToken returned error: %v", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected no callback without a refresh, got %d", got)
+	}
+
+	// A token due for background refresh is refreshed by the background check
+	if err := store.StoreToken(&oauth2.Token{
+		AccessToken:  "background-access-token",
+		RefreshToken: "test-refresh-token",
+		Expiry:       time.Now().Add(10 * time.Minute),
+	}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	auth.checkAndRefreshToken()
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected a second callback after a background refresh, got %d", got)
+	}
+}
+
+func TestPersistRefreshState(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "secret-detail"}`))
+	}))
+	defer tokenServer.Close()
+
+	store := storage.NewInMemoryStore()
+	if err := store.StoreToken(&oauth2.Token{
+		AccessToken:  "test-access-token",
+		RefreshToken: "test-refresh-token",
+		Expiry:       time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+
+	newAuth := func(persist bool) *OAuth2Authenticator {
+		refreshConfig := DefaultRefreshConfig()
+		refreshConfig.RetryMaxAttempts = 1
+		refreshConfig.BackgroundRefreshInterval = time.Hour
+		refreshConfig.PersistState = persist
+		auth := NewOAuth2AuthenticatorWithConfig(OAuth2Config{TokenURL: tokenServer.URL}, store, refreshConfig)
+		t.Cleanup(auth.Shutdown)
+		return auth
+	}
+
+	first := newAuth(true)
+	if _, err := first.GetValidToken(context.Background()); err == nil {
+		t.Fatal("Expected the refresh to fail")
+	}
+	failed := first.GetRefreshState()
+
+	// A new authenticator, as after a restart, knows the refresh has been failing
+	restored := newAuth(true).GetRefreshState()
+	if restored.RefreshAttempts != failed.RefreshAttempts || restored.RefreshAttempts == 0 {
+		t.Errorf("RefreshAttempts = %d, want %d", restored.RefreshAttempts, failed.RefreshAttempts)
+	}
+	if !restored.LastRefreshAttempt.Equal(failed.LastRefreshAttempt) {
+		t.Errorf("LastRefreshAttempt = %v, want %v", restored.LastRefreshAttempt, failed.LastRefreshAttempt)
+	}
+	if restored.LastError == nil || !strings.Contains(restored.LastError.Error(), "invalid_grant") {
+		t.Errorf("Expected the restored error to name the error code, got %v", restored.LastError)
+	}
+	if strings.Contains(restored.LastError.Error(), "secret-detail") {
+		t.Errorf("Expected the restored error to be redacted, got %v", restored.LastError)
+	}
+
+	if state := newAuth(false).GetRefreshState(); state.RefreshAttempts != 0 || state.LastError != nil {
+		t.Errorf("Expected no restored state without PersistState, got %+v", state)
+	}
+
+	if err := first.ClearAuthentication(); err != nil {
+		t.Fatalf("ClearAuthentication returned error: %v", err)
+	}
+	if _, err := store.LoadRefreshState(); !errors.Is(err, storage.ErrStorageNotFound) {
+		t.Errorf("Expected ClearAuthentication to clear the refresh state, got %v", err)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     *oauth2.Token
+		status    int
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:      "refresh token",
+			token:     &oauth2.Token{AccessToken: "test-access-token", RefreshToken: "test-refresh-token"},
+			status:    http.StatusOK,
+			wantToken: "test-refresh-token",
+		},
+		{
+			name:      "access token only",
+			token:     &oauth2.Token{AccessToken: "test-access-token"},
+			status:    http.StatusOK,
+			wantToken: "test-access-token",
+		},
+		{
+			name:      "rejected",
+			token:     &oauth2.Token{AccessToken: "test-access-token", RefreshToken: "test-refresh-token"},
+			status:    http.StatusBadRequest,
+			wantToken: "test-refresh-token",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revoked string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					t.Errorf("Method = %s, want POST", r.Method)
+				}
+				revoked = r.FormValue("token")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"error": "invalid_token"}`))
+			}))
+			defer server.Close()
+
+			store := storage.NewInMemoryStore()
+			if err := store.StoreToken(tt.token); err != nil {
+				t.Fatalf("StoreToken returned error: %v", err)
+			}
+			auth := NewOAuth2Authenticator(OAuth2Config{RevokeURL: server.URL}, store)
+			defer auth.Shutdown()
+
+			err := auth.RevokeToken(context.Background())
+			if revoked != tt.wantToken {
+				t.Errorf("Revoked token = %q, want %q", revoked, tt.wantToken)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error for a rejected revocation")
+				}
+				if !store.HasToken() {
+					t.Error("Expected the local token to be kept when revocation fails")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RevokeToken returned error: %v", err)
+			}
+			if store.HasToken() {
+				t.Error("Expected the local token to be cleared after revocation")
+			}
+		})
+	}
+}
+
+func TestRevokeTokenWithoutStoredToken(t *testing.T) {
+	auth := NewOAuth2Authenticator(OAuth2Config{RevokeURL: "http://127.0.0.1:0"}, storage.NewInMemoryStore())
+	defer auth.Shutdown()
+
+	err := auth.RevokeToken(context.Background())
+	var authErr *AuthError
+	if !errors.As(err, &authErr) || authErr.Op != "revoke_token" {
+		t.Fatalf("Expected revoke_token AuthError, got %v", err)
+	}
+	if !errors.Is(err, storage.ErrStorageNotFound) {
+		t.Errorf("Expected the error to wrap ErrStorageNotFound, got %v", err)
+	}
+}
+
+func TestStatusForStores(t *testing.T) {
+	signedIn := storage.NewInMemoryStore()
+	expiry := time.Now().Add(-time.Minute)
+	if err := signedIn.StoreToken(&oauth2.Token{
+		AccessToken:  "test-access-token",
+		TokenType:    "Bearer",
+		RefreshToken: "test-refresh-token",
+		Expiry:       expiry,
+	}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	signedOut := storage.NewInMemoryStore()
+
+	statuses := StatusForStores(map[string]storage.CredentialStore{
+		"work":     signedIn,
+		"personal": signedOut,
+	})
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 statuses, got %d", len(statuses))
+	}
+
+	work := statuses["work"]
+	if work == nil || !work.Authenticated || !work.IsExpired || !work.HasRefreshToken || work.TokenType != "Bearer" {
+		t.Errorf("Unexpected status of the signed in account: %+v", work)
+	}
+	// Statuses are reported without refreshing the expired token
+	token, err := signedIn.LoadToken()
+	if err != nil || token.AccessToken != "test-access-token" || !token.Expiry.Equal(expiry) {
+		t.Errorf("Expected the stored token to be unchanged, got %+v, %v", token, err)
+	}
+
+	if personal := statuses["personal"]; personal == nil || personal.Authenticated || personal.Error == "" {
+		t.Errorf("Unexpected status of the signed out account: %+v", personal)
+	}
+}
+
+func TestGetAuthStatusAccount(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"email": "user@example.com", "sub": "1234567890"}`))
+	var userInfoRequests atomic.Int32
+	userInfoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userInfoRequests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer test-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"email": "info@example.com", "sub": "987654321"}`))
+	}))
+	defer userInfoServer.Close()
+
+	tests := []struct {
+		name             string
+		idToken          string
+		wantEmail        string
+		wantSubject      string
+		wantUserInfoCall bool
+	}{
+		{name: "ID token", idToken: "header." + claims + ".signature", wantEmail: "user@example.com", wantSubject: "1234567890"},
+		{name: "padded ID token", idToken: "header." + claims + "==.signature", wantEmail: "user@example.com", wantSubject: "1234567890"},
+		{name: "malformed ID token", idToken: "not-a-jwt"},
+		{name: "ID token with invalid claims", idToken: "header.!!!.signature"},
+		{name: "no ID token", wantEmail: "info@example.com", wantSubject: "987654321", wantUserInfoCall: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userInfoRequests.Store(0)
+			token := &oauth2.Token{
+				AccessToken:  "test-access-token",
+				RefreshToken: "test-refresh-token",
+				Expiry:       time.Now().Add(time.Hour),
+			}
+			if tt.idToken != "" {
+				token = token.WithExtra(map[string]any{"id_token": tt.idToken})
+			}
+			store := storage.NewInMemoryStore()
+			if err := store.StoreToken(token); err != nil {
+				t.Fatalf("StoreToken returned error: %v", err)
+			}
+			auth := NewOAuth2AuthenticatorWithConfig(OAuth2Config{UserInfoURL: userInfoServer.URL}, store, DefaultRefreshConfig())
+			defer auth.Shutdown()
+
+			for range 2 {
+				status, err := auth.GetAuthStatus()
+				if err != nil {
+					t.Fatalf("GetAuthStatus returned error: %v", err)
+				}
+				if status.Email != tt.wantEmail || status.Subject != tt.wantSubject {
+					t.Errorf("Email = %q, Subject = %q, want %q and %q", status.Email, status.Subject, tt.wantEmail, tt.wantSubject)
+				}
+			}
+			// The userinfo endpoint is asked once and only without an ID token
+			wantRequests := int32(0)
+			if tt.wantUserInfoCall {
+				wantRequests = 1
+			}
+			if got := userInfoRequests.Load(); got != wantRequests {
+				t.Errorf("Expected %d userinfo requests, got %d", wantRequests, got)
+			}
+		})
+	}
+}
+
+func TestGetAuthStatusRetriesFailedUserInfo(t *testing.T) {
+	var userInfoRequests atomic.Int32
+	userInfoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userInfoRequests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"email": "info@example.com", "sub": "987654321"}`))
+	}))
+	defer userInfoServer.Close()
+
+	store := storage.NewInMemoryStore()
+	if err := store.StoreToken(&oauth2.Token{AccessToken: "test-access-token", RefreshToken: "test-refresh-token", Expiry: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	auth := NewOAuth2AuthenticatorWithConfig(OAuth2Config{UserInfoURL: userInfoServer.URL}, store, DefaultRefreshConfig())
+	defer auth.Shutdown()
+
+	// The failure is not cached; the first successful identity is
+	wantEmails := []string{"", "info@example.com", "info@example.com"}
+	for i, want := range wantEmails {
+		status, err := auth.GetAuthStatus()
+		if err != nil {
+			t.Fatalf("GetAuthStatus returned error: %v", err)
+		}
+		if status.Email != want {
+			t.Errorf("call %d: Email = %q, want %q", i+1, status.Email, want)
+		}
+	}
+	if got := userInfoRequests.Load(); got != 2 {
+		t.Errorf("Expected 2 userinfo requests, got %d", got)
+	}
+}
+
+func TestRefreshTokenReusesTokenRefreshedElsewhere(t *testing.T) {
+	var refreshes atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "new-access-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer tokenServer.Close()
+
+	store, err := storage.NewFileSystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSystemStore returned error: %v", err)
+	}
+	expired := &oauth2.Token{
+		AccessToken:  "expired-access-token",
+		RefreshToken: "test-refresh-token",
+		Expiry:       time.Now().Add(-time.Second),
+	}
+
+	refreshConfig := DefaultRefreshConfig()
+	refreshConfig.BackgroundRefreshInterval = time.Hour
+	auth := NewOAuth2AuthenticatorWithConfig(OAuth2Config{TokenURL: tokenServer.URL}, store, refreshConfig)
+	defer auth.Shutdown()
+
+	// Another process sharing the store already refreshed the expired token
+	if err := store.StoreToken(&oauth2.Token{
+		AccessToken:  "other-process-token",
+		RefreshToken: "test-refresh-token",
+		Expiry:       time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	token, err := auth.RefreshToken(context.Background(), expired)
+	if err != nil {
+		t.Fatalf("RefreshToken returned error: %v", err)
+	}
+	if token.AccessToken != "other-process-token" || refreshes.Load() != 0 {
+		t.Errorf("Expected the token refreshed elsewhere to be reused, got %q after %d refreshes", token.AccessToken, refreshes.Load())
+	}
+
+	// Without a newer token the refresh happens and is stored
+	if err := store.StoreToken(expired); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	token, err = auth.RefreshToken(context.Background(), expired)
+	if err != nil {
+		t.Fatalf("RefreshToken returned error: %v", err)
+	}
+	stored, err := store.LoadToken()
+	if err != nil || token.AccessToken != "new-access-token" || stored.AccessToken != "new-access-token" || refreshes.Load() != 1 {
+		t.Errorf("Expected a stored refresh, got %q (stored %+v, %v) after %d refreshes", token.AccessToken, stored, err, refreshes.Load())
+	}
+}
+
+func TestAuthenticateWithBrowserUsesOpener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The fake opener records the URL instead of launching a browser, and gives up
+	var opened string
+	auth := NewOAuth2Authenticator(OAuth2Config{
+		ClientID: "opener-client",
+		AuthURL:  "https://accounts.example.com/auth",
+		OpenURLFunc: func(url string) error {
+			opened = url
+			cancel()
+			return nil
+		},
+	}, storage.NewInMemoryStore())
+
+	if err := auth.AuthenticateWithBrowser(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("AuthenticateWithBrowser returned %v, want context.Canceled", err)
+	}
+	if !strings.HasPrefix(opened, "https://accounts.example.com/auth?") || !strings.Contains(opened, "client_id=opener-client") {
+		t.Errorf("Unexpected URL passed to the opener: %q", opened)
+	}
+}
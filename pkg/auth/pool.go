@@ -0,0 +1,62 @@
+package auth
+
+import "context"
+
+// BackgroundPool bounds the number of background operations that may run concurrently
+// across all authenticators sharing it.
+//
+// Each OAuth2Authenticator owns exactly one background refresh goroutine which stays
+// idle between ticks. The pool does not limit those goroutines; it limits how many of
+// them may perform network work (such as a token refresh) at the same time. To reduce
+// the number of goroutines themselves, share a single authenticator between clients
+// with NewClientSharedAuth.
+type BackgroundPool struct {
+	sem chan struct{}
+}
+
+// NewBackgroundPool creates a pool that allows at most maxConcurrent background
+// operations to run at the same time. Values less than 1 are treated as 1.
+func NewBackgroundPool(maxConcurrent int) *BackgroundPool {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &BackgroundPool{
+		sem: make(chan struct{}, maxConcurrent),
+	}
+}
+
+// Do runs fn once a slot is available. It returns the context error without running fn
+// if the context is done before a slot could be acquired.
+// A nil pool runs fn immediately.
+func (p *BackgroundPool) Do(ctx context.Context, fn func(ctx context.Context)) error {
+	if p == nil {
+		fn(ctx)
+		return nil
+	}
+
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.sem }()
+
+	fn(ctx)
+	return nil
+}
+
+// MaxConcurrent returns the maximum number of concurrent operations allowed by the pool.
+func (p *BackgroundPool) MaxConcurrent() int {
+	if p == nil {
+		return 0
+	}
+	return cap(p.sem)
+}
+
+// Running returns the number of operations currently holding a slot in the pool.
+func (p *BackgroundPool) Running() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.sem)
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackgroundPoolBoundsConcurrency(t *testing.T) {
+	pool := NewBackgroundPool(2)
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = pool.Do(context.Background(), func(ctx context.Context) {
+				n := running.Add(1)
+				for {
+					current := maxRunning.Load()
+					if n <= current || maxRunning.CompareAndSwap(current, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				running.Add(-1)
+			})
+		}()
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got > 2 {
+		t.Errorf("Expected at most 2 concurrent operations, got %d", got)
+	}
+	if pool.Running() != 0 {
+		t.Errorf("Expected no running operations, got %d", pool.Running())
+	}
+}
+
+func TestBackgroundPoolContextCanceled(t *testing.T) {
+	pool := NewBackgroundPool(1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = pool.Do(context.Background(), func(ctx context.Context) {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := pool.Do(ctx, func(ctx context.Context) { called = true })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if called {
+		t.Error("Function should not run when no slot could be acquired")
+	}
+}
+
+func TestBackgroundPoolNil(t *testing.T) {
+	var pool *BackgroundPool
+
+	called := false
+	if err := pool.Do(context.Background(), func(ctx context.Context) { called = true }); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !called {
+		t.Error("Nil pool should run the function immediately")
+	}
+	if pool.MaxConcurrent() != 0 {
+		t.Errorf("Expected nil pool MaxConcurrent 0, got %d", pool.MaxConcurrent())
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// RateLimitConfig limits the API calls of a CodeAssistClient, to stay within the
+// per-minute quota of the CodeAssist Server under load. Calls beyond the limits wait
+// until they may proceed or their context is done. Zero values disable each limit.
+type RateLimitConfig struct {
+	// RequestsPerMinute is the sustained rate of API calls, enforced as a token bucket
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+
+	// Burst is the number of API calls that may be made at once before the rate
+	// applies. If zero, constants.DefaultRateLimitBurst is used.
+	Burst int `json:"burst,omitempty"`
+
+	// MaxConcurrent is the maximum number of API calls in flight at the same time
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+}
+
+// rateLimiter enforces a RateLimitConfig. A nil limiter allows every call.
+type rateLimiter struct {
+	// sem holds a slot for each call in flight; nil means no concurrency limit
+	sem chan struct{}
+
+	// interval is the time between calls at the sustained rate; zero means no rate limit
+	interval time.Duration
+	burst    int
+
+	// mu protects tat, the theoretical arrival time of the next call at the sustained rate
+	mu  sync.Mutex
+	tat time.Time
+}
+
+// newRateLimiter returns a limiter for the configuration, or nil if it sets no limit.
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+	if config == nil || (config.RequestsPerMinute <= 0 && config.MaxConcurrent <= 0) {
+		return nil
+	}
+
+	limiter := &rateLimiter{burst: config.Burst}
+	if limiter.burst <= 0 {
+		limiter.burst = constants.DefaultRateLimitBurst
+	}
+	if config.RequestsPerMinute > 0 {
+		limiter.interval = constants.RateLimitWindow / time.Duration(config.RequestsPerMinute)
+	}
+	if config.MaxConcurrent > 0 {
+		limiter.sem = make(chan struct{}, config.MaxConcurrent)
+	}
+	return limiter
+}
+
+// acquire waits for a concurrency slot and then for the rate to allow another call.
+// The returned function releases the slot once the call is done. It returns the
+// context error if the context is done first.
+func (l *rateLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	release := func() {}
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		release = func() { <-l.sem }
+	}
+
+	if err := l.wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// wait reserves the next call at the sustained rate and sleeps until it is due.
+// A reservation abandoned because the context is done is given back.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.interval <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	tat := l.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	l.tat = tat.Add(l.interval)
+	delay := l.tat.Add(-time.Duration(l.burst) * l.interval).Sub(now)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tat = l.tat.Add(-l.interval)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitSerializesCalls(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	// 1200 calls per minute allow one call every 50ms
+	client := NewCodeAssistClient(nil, server.URL, "test-model")
+	client.SetRateLimit(&RateLimitConfig{RequestsPerMinute: 1200})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.callAPI(context.Background(), server.Client(), "listModels", map[string]string{}); err != nil {
+				t.Errorf("callAPI returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(arrivals) != 4 {
+		t.Fatalf("Expected 4 calls, got %d", len(arrivals))
+	}
+	for i := 1; i < len(arrivals); i++ {
+		if gap := arrivals[i].Sub(arrivals[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("Expected calls at least 50ms apart, call %d came after %v", i, gap)
+		}
+	}
+}
+
+func TestRateLimitBoundsConcurrency(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		for {
+			current := maxRunning.Load()
+			if n <= current || maxRunning.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := NewCodeAssistClient(nil, server.URL, "test-model")
+	client.SetRateLimit(&RateLimitConfig{MaxConcurrent: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.callAPI(context.Background(), server.Client(), "listModels", map[string]string{}); err != nil {
+				t.Errorf("callAPI returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got != 2 {
+		t.Errorf("Expected at most 2 calls in flight and the limit reached, got %d", got)
+	}
+}
+
+func TestRateLimitContextCanceled(t *testing.T) {
+	limiter := newRateLimiter(&RateLimitConfig{RequestsPerMinute: 1, MaxConcurrent: 1})
+
+	release, err := limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire returned error: %v", err)
+	}
+	release()
+
+	// The next call is due in a minute, so it waits until its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to end the wait, got %v", err)
+	}
+
+	// The abandoned reservation and the concurrency slot are given back
+	limiter.mu.Lock()
+	due := time.Until(limiter.tat)
+	limiter.mu.Unlock()
+	if due > time.Minute {
+		t.Errorf("Expected the abandoned reservation to be given back, next call due in %v", due)
+	}
+	if len(limiter.sem) != 0 {
+		t.Errorf("Expected no held slots, got %d", len(limiter.sem))
+	}
+
+	if newRateLimiter(nil) != nil || newRateLimiter(&RateLimitConfig{}) != nil {
+		t.Error("Expected no limiter without limits")
+	}
+}
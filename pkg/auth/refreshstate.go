@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/storage"
+)
+
+// refreshStateStore returns the store persisting the refresh state, or nil if
+// persistence is disabled or the credential store cannot persist it. Refreshes run
+// while auth.mu is held, so the refresh configuration is read without it, like in
+// refreshTokenWithRetry.
+func (auth *OAuth2Authenticator) refreshStateStore() storage.RefreshStateStore {
+	if !auth.refreshConfig.PersistState {
+		return nil
+	}
+	rs, _ := auth.store.(storage.RefreshStateStore)
+	return rs
+}
+
+// restoreRefreshState loads the persisted refresh state, so that the authenticator
+// knows it has been failing to refresh before the restart.
+func (auth *OAuth2Authenticator) restoreRefreshState() {
+	rs := auth.refreshStateStore()
+	if rs == nil {
+		return
+	}
+	record, err := rs.LoadRefreshState()
+	if err != nil || record == nil {
+		return
+	}
+
+	auth.refreshMu.Lock()
+	defer auth.refreshMu.Unlock()
+	auth.refreshState.LastRefreshAttempt = record.LastRefreshAttempt
+	auth.refreshState.LastRefreshSuccess = record.LastRefreshSuccess
+	auth.refreshState.RefreshAttempts = record.RefreshAttempts
+	if record.LastError != "" {
+		auth.refreshState.LastError = errors.New(record.LastError)
+	}
+}
+
+// persistRefreshState stores the current refresh state, if persistence is enabled.
+// It must be called without holding auth.refreshMu.
+func (auth *OAuth2Authenticator) persistRefreshState() {
+	rs := auth.refreshStateStore()
+	if rs == nil {
+		return
+	}
+
+	auth.refreshMu.Lock()
+	record := &storage.RefreshStateRecord{
+		LastRefreshAttempt: auth.refreshState.LastRefreshAttempt,
+		LastRefreshSuccess: auth.refreshState.LastRefreshSuccess,
+		RefreshAttempts:    auth.refreshState.RefreshAttempts,
+		LastError:          redactRefreshError(auth.refreshState.LastError),
+		UpdatedAt:          time.Now(),
+	}
+	auth.refreshMu.Unlock()
+
+	_ = rs.StoreRefreshState(record) // The state is diagnostic only
+}
+
+// clearRefreshState removes the persisted refresh state, if the store keeps one.
+func (auth *OAuth2Authenticator) clearRefreshState() {
+	if rs, ok := auth.store.(storage.RefreshStateStore); ok {
+		_ = rs.ClearRefreshState()
+	}
+}
+
+// redactRefreshError returns a description of a refresh error that is safe to persist.
+// Token endpoint errors are reduced to their status and error code, since their
+// response body is not under our control; other errors keep their first line only.
+func redactRefreshError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		message := "oauth2: token refresh rejected"
+		if retrieveErr.Response != nil {
+			message = fmt.Sprintf("%s with status %s", message, retrieveErr.Response.Status)
+		}
+		if retrieveErr.ErrorCode != "" {
+			message = fmt.Sprintf("%s: %s", message, retrieveErr.ErrorCode)
+		}
+		return message
+	}
+
+	message, _, _ := strings.Cut(err.Error(), "\n")
+	if len(message) > constants.MaxPersistedErrorLength {
+		message = message[:constants.MaxPersistedErrorLength]
+	}
+	return message
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "context"
+
+// sessionIDKey is the context key of the session ID.
+type sessionIDKey struct{}
+
+// WithSessionID returns a context whose CodeAssist content generation requests carry
+// the given session ID instead of the client's, so that several conversations can
+// share one client. An empty ID keeps the client's session ID.
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, sessionID)
+}
+
+// SessionIDFromContext returns the session ID set with WithSessionID, or an empty
+// string if none is set.
+func SessionIDFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionIDKey{}).(string)
+	return sessionID
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+// StreamGenerateContent makes a content generation call whose response is streamed as
+// server-sent events. Each event is delivered on the returned channel as soon as it
+// arrives. The channel is closed when the stream ends, fails or ctx is done; the
+// response body is closed with it. A failure after the stream started is delivered
+// as a final chunk with Err set. Streamed calls are not retried.
+func (c *CodeAssistClient) StreamGenerateContent(ctx context.Context, req *types.GenerateContentRequest) (<-chan types.StreamChunk, error) {
+	// Ensure project is initialized
+	if err := c.InitializeProject(ctx); err != nil {
+		return nil, err
+	}
+
+	httpClient, err := c.authenticatedClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authenticated client: %w", err)
+	}
+
+	reqBytes, err := json.Marshal(c.convertToCodeAssistRequest(ctx, req))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	if len(reqBytes) > constants.MaxAPIRequestSize {
+		return nil, fmt.Errorf("request payload too large: %d bytes (max: %d)", len(reqBytes), constants.MaxAPIRequestSize)
+	}
+
+	url := fmt.Sprintf("%s/%s:streamGenerateContent?alt=sse", c.baseURL, c.apiVersion)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", constants.ContentTypeJSON)
+	httpReq.Header.Set("Accept", "text/event-stream")
+	c.logCurlCommand(ctx, httpReq, reqBytes)
+
+	// The concurrency slot is held until the stream ends
+	release, err := c.rateLimiter.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for rate limit: %w", err)
+	}
+
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		release()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("request failed: %w", ctxErr)
+		}
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := newAPIError(resp)
+		_ = resp.Body.Close()
+		release()
+		return nil, apiErr
+	}
+
+	chunks := make(chan types.StreamChunk)
+	go func() {
+		defer close(chunks)
+		defer release()
+		defer func() { _ = resp.Body.Close() }()
+
+		err := readServerSentEvents(resp.Body, func(data []byte) bool {
+			var caResp types.CodeAssistGenerateContentResponse
+			chunk := types.StreamChunk{}
+			if err := json.Unmarshal(data, &caResp); err != nil {
+				chunk.Err = fmt.Errorf("failed to decode stream event: %w", err)
+			} else {
+				chunk.Response = c.convertFromCodeAssistResponse(&caResp)
+			}
+
+			select {
+			case chunks <- chunk:
+				return chunk.Err == nil
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case chunks <- types.StreamChunk{Err: fmt.Errorf("failed to read stream: %w", err)}:
+		case <-ctx.Done():
+		}
+	}()
+
+	return chunks, nil
+}
+
+// readServerSentEvents reads a server-sent event stream and calls handle with the data
+// of each event, joining multi-line data with newlines. Reading stops when handle
+// returns false or the stream ends. Lines other than data lines are ignored.
+func readServerSentEvents(r io.Reader, handle func(data []byte) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), constants.MaxAPIResponseSize)
+
+	var data []string
+	dispatch := func() bool {
+		if len(data) == 0 {
+			return true
+		}
+		event := strings.Join(data, "\n")
+		data = data[:0]
+		return handle([]byte(event))
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if !dispatch() {
+				return nil
+			}
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// The last event may not be followed by a blank line
+	dispatch()
+	return nil
+}
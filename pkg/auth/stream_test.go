@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/storage"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
+)
+
+func TestReadServerSentEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []string
+	}{
+		{
+			name:   "single line events",
+			stream: "data: {\"a\":1}\n\ndata: {\"b\":2}\n\n",
+			want:   []string{`{"a":1}`, `{"b":2}`},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: {\"a\":\ndata: 1}\n\n",
+			want:   []string{"{\"a\":\n1}"},
+		},
+		{
+			name:   "comments and other fields are ignored",
+			stream: ": keep-alive\nevent: message\nid: 1\ndata:{\"a\":1}\n\n\n",
+			want:   []string{`{"a":1}`},
+		},
+		{
+			name:   "last event without blank line",
+			stream: "data: {\"a\":1}\n\ndata: {\"b\":2}",
+			want:   []string{`{"a":1}`, `{"b":2}`},
+		},
+		{
+			name:   "CRLF line endings",
+			stream: "data: {\"a\":1}\r\n\r\n",
+			want:   []string{`{"a":1}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := readServerSentEvents(strings.NewReader(tt.stream), func(data []byte) bool {
+				got = append(got, string(data))
+				return true
+			})
+			if err != nil {
+				t.Fatalf("readServerSentEvents returned error: %v", err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamGenerateContent(t *testing.T) {
+	server := newProjectServer(t, "project")
+	server.stream = func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("Expected alt=sse, got %q", r.URL.RawQuery)
+		}
+		for _, text := range []string{"Hello", ", world"} {
+			_, _ = fmt.Fprintf(w, "data: {\"response\": {\"candidates\": [{\"content\": {\"parts\": [{\"text\": %q}]}}]}}\n\n", text)
+		}
+	}
+	client := newProjectTestClient(t, server, storage.NewInMemoryStore())
+
+	chunks, err := client.StreamGenerateContent(context.Background(), &types.GenerateContentRequest{})
+	if err != nil {
+		t.Fatalf("StreamGenerateContent returned error: %v", err)
+	}
+
+	var texts []string
+	for chunk := range chunks {
+		if chunk.Err != nil {
+			t.Fatalf("Unexpected stream error: %v", chunk.Err)
+		}
+		texts = append(texts, chunk.Response.Candidates[0].Content.Parts[0].Text)
+	}
+	if strings.Join(texts, "|") != "Hello|, world" {
+		t.Errorf("Unexpected chunks: %q", texts)
+	}
+}
+
+func TestStreamGenerateContentCancellation(t *testing.T) {
+	bodyClosed := make(chan struct{})
+	server := newProjectServer(t, "project")
+	server.stream = func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "data: {\"response\": {\"candidates\": [{\"content\": {\"parts\": [{\"text\": \"partial\"}]}}]}}\n\n")
+		w.(http.Flusher).Flush()
+
+		// Keep the stream open until the client goes away
+		select {
+		case <-r.Context().Done():
+			close(bodyClosed)
+		case <-time.After(5 * time.Second):
+		}
+	}
+	client := newProjectTestClient(t, server, storage.NewInMemoryStore())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chunks, err := client.StreamGenerateContent(ctx, &types.GenerateContentRequest{})
+	if err != nil {
+		t.Fatalf("StreamGenerateContent returned error: %v", err)
+	}
+
+	if chunk := <-chunks; chunk.Err != nil || chunk.Response == nil {
+		t.Fatalf("Expected a partial chunk, got %+v", chunk)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-chunks:
+		if ok {
+			// A chunk may have been in flight; the channel must still close
+			if _, ok := <-chunks; ok {
+				t.Error("Expected the channel to be closed after cancellation")
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Channel was not closed after cancellation")
+	}
+
+	select {
+	case <-bodyClosed:
+	case <-time.After(2 * time.Second):
+		t.Error("Response body was not closed after cancellation")
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/d-kuro/geminiwebtools/pkg/constants"
@@ -24,49 +26,118 @@ type AuthResult struct {
 }
 
 // BrowserAuth handles OAuth2 browser authentication flow.
+// The flow uses PKCE (RFC 7636) in addition to the state parameter, so that an
+// intercepted authorization code cannot be exchanged without the code verifier.
 type BrowserAuth struct {
-	config *oauth2.Config
-	state  string
-	server *http.Server
+	config       *oauth2.Config
+	redirectPort int
+	successHTML  string
+	failureHTML  string
+	successURL   string
+	failureURL   string
+	openURL      BrowserOpener
+	state        string
+	verifier     string
+	server       *http.Server
 }
 
+// BrowserAuthConfig holds optional settings of the browser authentication flow.
+type BrowserAuthConfig struct {
+	// RedirectPort is the local port of the OAuth2 callback server, for OAuth apps that
+	// only allow a fixed redirect URI such as http://localhost:8085/oauth2callback.
+	// If zero, an available port is chosen.
+	RedirectPort int
+
+	// InlineSuccessPage serves a small page that tries to close itself after a
+	// successful authentication, instead of redirecting to constants.AuthSuccessURL.
+	InlineSuccessPage bool
+
+	// SuccessHTML and FailureHTML are served by the callback as the page shown after a
+	// successful or failed authentication, instead of redirecting. SuccessHTML takes
+	// precedence over InlineSuccessPage.
+	SuccessHTML string
+	FailureHTML string
+
+	// SuccessURL and FailureURL replace constants.AuthSuccessURL and
+	// constants.AuthFailureURL as the pages the callback redirects to.
+	SuccessURL string
+	FailureURL string
+
+	// OpenURLFunc opens the authorization URL, replacing the $BROWSER command and the
+	// command of the operating system. If nil, OpenBrowser is used.
+	OpenURLFunc BrowserOpener
+}
+
+// BrowserOpener opens a URL in a browser. It should return once the browser is
+// launched, without waiting for it to exit.
+type BrowserOpener func(url string) error
+
 // NewBrowserAuth creates a new browser authentication handler.
 func NewBrowserAuth(config *oauth2.Config) *BrowserAuth {
+	return NewBrowserAuthWithConfig(config, BrowserAuthConfig{})
+}
+
+// NewBrowserAuthWithConfig creates a new browser authentication handler with custom settings.
+func NewBrowserAuthWithConfig(config *oauth2.Config, authConfig BrowserAuthConfig) *BrowserAuth {
 	state := generateState()
+	successHTML := authConfig.SuccessHTML
+	if successHTML == "" && authConfig.InlineSuccessPage {
+		successHTML = constants.AuthSuccessPage
+	}
+	successURL := authConfig.SuccessURL
+	if successURL == "" {
+		successURL = getSuccessURL()
+	}
+	failureURL := authConfig.FailureURL
+	if failureURL == "" {
+		failureURL = getFailureURL()
+	}
+	openURL := authConfig.OpenURLFunc
+	if openURL == nil {
+		openURL = OpenBrowser
+	}
 	return &BrowserAuth{
-		config: config,
-		state:  state,
+		config:       config,
+		redirectPort: authConfig.RedirectPort,
+		successHTML:  successHTML,
+		failureHTML:  authConfig.FailureHTML,
+		successURL:   successURL,
+		failureURL:   failureURL,
+		openURL:      openURL,
+		state:        state,
+		verifier:     oauth2.GenerateVerifier(),
 	}
 }
 
 // Authenticate performs browser-based OAuth2 authentication.
 // This matches the gemini-cli implementation.
 func (ba *BrowserAuth) Authenticate(ctx context.Context) (*oauth2.Token, error) {
-	// Find available port
-	port, err := getAvailablePort()
+	// Listen on the configured port, or an available one
+	listener, err := ba.listen()
 	if err != nil {
-		return nil, fmt.Errorf("failed to find available port: %w", err)
+		return nil, err
 	}
+	port := listener.Addr().(*net.TCPAddr).Port
 
 	// Update redirect URI
 	redirectURI := fmt.Sprintf("http://localhost:%d/oauth2callback", port)
 	ba.config.RedirectURL = redirectURI
 
 	// Generate auth URL
-	authURL := ba.config.AuthCodeURL(ba.state, oauth2.AccessTypeOffline)
+	authURL := ba.authCodeURL()
 
 	// Create result channel
 	resultChan := make(chan AuthResult, 1)
 
 	// Start local HTTP server
-	ba.startServer(port, resultChan)
+	ba.startServer(listener, resultChan)
 
 	// Open browser
 	fmt.Printf("\nGemini Web Tools authentication required.\n")
 	fmt.Printf("Opening authentication page in your browser...\n")
 	fmt.Printf("If the browser doesn't open automatically, visit:\n\n%s\n\n", authURL)
 
-	if err := openBrowser(authURL); err != nil {
+	if err := ba.openURL(authURL); err != nil {
 		fmt.Printf("Failed to open browser automatically: %v\n", err)
 		fmt.Printf("Please manually open the URL above.\n")
 	}
@@ -90,24 +161,43 @@ func (ba *BrowserAuth) Authenticate(ctx context.Context) (*oauth2.Token, error)
 	}
 }
 
-// startServer starts the local HTTP server for OAuth callback.
-func (ba *BrowserAuth) startServer(port int, resultChan chan<- AuthResult) {
+// authCodeURL returns the authorization URL with the state and the PKCE code challenge.
+func (ba *BrowserAuth) authCodeURL() string {
+	return ba.config.AuthCodeURL(ba.state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(ba.verifier))
+}
+
+// listen opens the listener of the OAuth callback server. A fixed redirect port that
+// is already in use is an error, since the redirect URI registered for it would not match
+// any other port.
+func (ba *BrowserAuth) listen() (net.Listener, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", ba.redirectPort))
+	if err != nil {
+		if ba.redirectPort != 0 {
+			return nil, fmt.Errorf("redirect port %d is not available: %w", ba.redirectPort, err)
+		}
+		return nil, fmt.Errorf("failed to find available port: %w", err)
+	}
+	return listener, nil
+}
+
+// startServer starts the local HTTP server for OAuth callback on the listener.
+func (ba *BrowserAuth) startServer(listener net.Listener, resultChan chan<- AuthResult) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/oauth2callback", ba.handleCallback(resultChan))
 
 	ba.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
 		Handler: mux,
 	}
 
 	go func() {
-		if err := ba.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := ba.server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			resultChan <- AuthResult{Error: fmt.Errorf("server error: %w", err)}
 		}
 	}()
 }
 
-// handleCallback handles the OAuth2 callback.
+// handleCallback handles the OAuth2 callback. The response is written before the result
+// is sent, since Authenticate shuts the server down as soon as it receives it.
 func (ba *BrowserAuth) handleCallback(resultChan chan<- AuthResult) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Parse query parameters
@@ -115,37 +205,62 @@ func (ba *BrowserAuth) handleCallback(resultChan chan<- AuthResult) http.Handler
 
 		// Check for error
 		if errMsg := query.Get("error"); errMsg != "" {
+			ba.writeLandingPage(w, r, false)
 			resultChan <- AuthResult{Error: fmt.Errorf("authentication error: %s", errMsg)}
-			http.Redirect(w, r, getFailureURL(), http.StatusFound)
 			return
 		}
 
 		// Verify state parameter (CSRF protection)
 		if state := query.Get("state"); state != ba.state {
-			resultChan <- AuthResult{Error: fmt.Errorf("state mismatch, possible CSRF attack")}
 			http.Error(w, "State mismatch. Possible CSRF attack", http.StatusBadRequest)
+			flush(w)
+			resultChan <- AuthResult{Error: fmt.Errorf("state mismatch, possible CSRF attack")}
 			return
 		}
 
 		// Get authorization code
 		code := query.Get("code")
 		if code == "" {
-			resultChan <- AuthResult{Error: fmt.Errorf("no authorization code received")}
 			http.Error(w, "No authorization code found", http.StatusBadRequest)
+			flush(w)
+			resultChan <- AuthResult{Error: fmt.Errorf("no authorization code received")}
 			return
 		}
 
 		// Exchange code for token
-		token, err := ba.config.Exchange(context.Background(), code)
+		token, err := ba.config.Exchange(context.Background(), code, oauth2.VerifierOption(ba.verifier))
 		if err != nil {
+			ba.writeLandingPage(w, r, false)
 			resultChan <- AuthResult{Error: fmt.Errorf("failed to exchange token: %w", err)}
-			http.Redirect(w, r, getFailureURL(), http.StatusFound)
 			return
 		}
 
 		// Send success response
+		ba.writeLandingPage(w, r, true)
 		resultChan <- AuthResult{Token: token}
-		http.Redirect(w, r, getSuccessURL(), http.StatusFound)
+	}
+}
+
+// writeLandingPage serves the page shown after a successful or failed authentication,
+// or redirects to it, and flushes the response.
+func (ba *BrowserAuth) writeLandingPage(w http.ResponseWriter, r *http.Request, success bool) {
+	page, target := ba.successHTML, ba.successURL
+	if !success {
+		page, target = ba.failureHTML, ba.failureURL
+	}
+	if page != "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprint(w, page)
+	} else {
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+	flush(w)
+}
+
+// flush sends the buffered response to the browser.
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
 	}
 }
 
@@ -158,20 +273,6 @@ func (ba *BrowserAuth) shutdown() {
 	}
 }
 
-// getAvailablePort finds an available port for the local server.
-func getAvailablePort() (int, error) {
-	listener, err := net.Listen("tcp", ":0")
-	if err != nil {
-		return 0, err
-	}
-	defer func() {
-		_ = listener.Close() // Ignore error during close
-	}()
-
-	addr := listener.Addr().(*net.TCPAddr)
-	return addr.Port, nil
-}
-
 // generateState generates a random state parameter for CSRF protection.
 func generateState() string {
 	bytes := make([]byte, constants.StateRandomBytes)
@@ -182,22 +283,48 @@ func generateState() string {
 	return hex.EncodeToString(bytes)
 }
 
-// openBrowser opens the given URL in the default browser.
-func openBrowser(url string) error {
+// OpenBrowser opens the given URL with the $BROWSER command, or else the default
+// browser of the operating system. It is the default BrowserOpener; custom openers
+// can fall back to it.
+func OpenBrowser(url string) error {
+	cmd, args := browserCommand(os.Getenv(constants.BrowserEnvVar), runtime.GOOS, url)
+	return exec.Command(cmd, args...).Start()
+}
+
+// browserCommand returns the command opening url: the command of the $BROWSER value
+// browserEnv if set, or else the command of the operating system goos.
+func browserCommand(browserEnv, goos, url string) (string, []string) {
+	if goos != "windows" {
+		// Windows paths contain colons, so the list is only split elsewhere
+		browserEnv, _, _ = strings.Cut(browserEnv, ":")
+	}
+	if fields := strings.Fields(browserEnv); len(fields) > 0 {
+		args := fields[1:]
+		substituted := false
+		for i, arg := range args {
+			if strings.Contains(arg, "%s") {
+				args[i] = strings.ReplaceAll(arg, "%s", url)
+				substituted = true
+			}
+		}
+		if !substituted {
+			args = append(args, url)
+		}
+		return fields[0], args
+	}
+
 	var cmd string
 	var args []string
-
-	if commands, exists := constants.BrowserCommands[runtime.GOOS]; exists {
+	if commands, exists := constants.BrowserCommands[goos]; exists {
 		cmd = commands[0]
 		if len(commands) > 1 {
-			args = commands[1:]
+			args = append(args, commands[1:]...)
 		}
 	} else {
 		// Fallback for unsupported OS
 		cmd = "xdg-open"
 	}
-	args = append(args, url)
-	return exec.Command(cmd, args...).Start()
+	return cmd, append(args, url)
 }
 
 // getSuccessURL returns the success URL to redirect to after authentication.
@@ -0,0 +1,378 @@
+package browser
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+func TestAuthCodeURLIncludesPKCEChallenge(t *testing.T) {
+	ba := NewBrowserAuth(&oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth"},
+	})
+
+	authURL, err := url.Parse(ba.authCodeURL())
+	if err != nil {
+		t.Fatalf("Failed to parse auth URL: %v", err)
+	}
+	query := authURL.Query()
+
+	if got := query.Get("code_challenge_method"); got != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", got)
+	}
+	sum := sha256.Sum256([]byte(ba.verifier))
+	if got, want := query.Get("code_challenge"), base64.RawURLEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("code_challenge = %q, want %q", got, want)
+	}
+	if query.Get("state") != ba.state {
+		t.Errorf("state = %q, want %q", query.Get("state"), ba.state)
+	}
+
+	if other := NewBrowserAuth(&oauth2.Config{}); other.verifier == ba.verifier {
+		t.Error("Expected a new code verifier for each flow")
+	}
+}
+
+func TestHandleCallbackSendsCodeVerifier(t *testing.T) {
+	var verifier string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		verifier = r.PostForm.Get("code_verifier")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "test-access-token", "token_type": "Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	ba := NewBrowserAuth(&oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL},
+	})
+
+	resultChan := make(chan AuthResult, 1)
+	req := httptest.NewRequest(http.MethodGet, "/oauth2callback?code=abc&state="+ba.state, nil)
+	ba.handleCallback(resultChan)(httptest.NewRecorder(), req)
+
+	result := <-resultChan
+	if result.Error != nil {
+		t.Fatalf("Expected successful exchange, got %v", result.Error)
+	}
+	if result.Token.AccessToken != "test-access-token" {
+		t.Errorf("AccessToken = %q, want test-access-token", result.Token.AccessToken)
+	}
+	if verifier != ba.verifier {
+		t.Errorf("Token request code_verifier = %q, want %q", verifier, ba.verifier)
+	}
+}
+
+func TestHandleCallbackSuccessPage(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "test-access-token", "token_type": "Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	tests := []struct {
+		name       string
+		inline     bool
+		wantStatus int
+	}{
+		{name: "redirect by default", inline: false, wantStatus: http.StatusFound},
+		{name: "inline page", inline: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ba := NewBrowserAuthWithConfig(&oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL},
+			}, BrowserAuthConfig{InlineSuccessPage: tt.inline})
+
+			resultChan := make(chan AuthResult, 1)
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/oauth2callback?code=abc&state="+ba.state, nil)
+			ba.handleCallback(resultChan)(recorder, req)
+
+			if result := <-resultChan; result.Error != nil {
+				t.Fatalf("Expected successful exchange, got %v", result.Error)
+			}
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+
+			if !tt.inline {
+				if location := recorder.Header().Get("Location"); location != constants.AuthSuccessURL {
+					t.Errorf("Location = %q, want %q", location, constants.AuthSuccessURL)
+				}
+				return
+			}
+			if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+				t.Errorf("Content-Type = %q, want text/html", contentType)
+			}
+			body := recorder.Body.String()
+			for _, want := range []string{"window.close()", "return to the terminal"} {
+				if !strings.Contains(body, want) {
+					t.Errorf("Expected inline page to contain %q, got:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleCallbackLandingPages(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("code") == "bad" {
+			http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "test-access-token", "token_type": "Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	tests := []struct {
+		name         string
+		config       BrowserAuthConfig
+		query        string
+		wantBody     string
+		wantLocation string
+	}{
+		{
+			name:     "success HTML",
+			config:   BrowserAuthConfig{SuccessHTML: "<p>Signed in to Acme</p>", InlineSuccessPage: true},
+			query:    "code=abc",
+			wantBody: "Signed in to Acme",
+		},
+		{
+			name:     "failure HTML on exchange error",
+			config:   BrowserAuthConfig{FailureHTML: "<p>Acme sign-in failed</p>"},
+			query:    "code=bad",
+			wantBody: "Acme sign-in failed",
+		},
+		{
+			name:     "failure HTML on error response",
+			config:   BrowserAuthConfig{SuccessHTML: "<p>ok</p>", FailureHTML: "<p>Acme sign-in failed</p>"},
+			query:    "error=access_denied",
+			wantBody: "Acme sign-in failed",
+		},
+		{
+			name:         "success URL",
+			config:       BrowserAuthConfig{SuccessURL: "https://acme.example.com/signed-in"},
+			query:        "code=abc",
+			wantLocation: "https://acme.example.com/signed-in",
+		},
+		{
+			name:         "default failure URL",
+			config:       BrowserAuthConfig{SuccessHTML: "<p>ok</p>"},
+			query:        "code=bad",
+			wantLocation: constants.AuthFailureURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ba := NewBrowserAuthWithConfig(&oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL},
+			}, tt.config)
+
+			resultChan := make(chan AuthResult, 1)
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/oauth2callback?state="+ba.state+"&"+tt.query, nil)
+			ba.handleCallback(resultChan)(recorder, req)
+			<-resultChan
+
+			if !recorder.Flushed {
+				t.Error("Expected the response to be flushed before the result is sent")
+			}
+			if tt.wantLocation != "" {
+				if location := recorder.Header().Get("Location"); recorder.Code != http.StatusFound || location != tt.wantLocation {
+					t.Errorf("Got status %d and Location %q, want a redirect to %q", recorder.Code, location, tt.wantLocation)
+				}
+				return
+			}
+			if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), tt.wantBody) {
+				t.Errorf("Got status %d and body %q, want %q", recorder.Code, recorder.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestListenRedirectPort(t *testing.T) {
+	ephemeral, err := NewBrowserAuth(&oauth2.Config{}).listen()
+	if err != nil {
+		t.Fatalf("Expected an available port, got %v", err)
+	}
+	port := ephemeral.Addr().(*net.TCPAddr).Port
+
+	// The port is now in use, so a fixed redirect port must not fall back to another one
+	_, err = NewBrowserAuthWithConfig(&oauth2.Config{}, BrowserAuthConfig{RedirectPort: port}).listen()
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("redirect port %d is not available", port)) {
+		t.Errorf("Expected error for a port in use, got %v", err)
+	}
+
+	_ = ephemeral.Close()
+	fixed, err := NewBrowserAuthWithConfig(&oauth2.Config{}, BrowserAuthConfig{RedirectPort: port}).listen()
+	if err != nil {
+		t.Fatalf("Expected fixed port %d to be available, got %v", port, err)
+	}
+	defer func() { _ = fixed.Close() }()
+	if got := fixed.Addr().(*net.TCPAddr).Port; got != port {
+		t.Errorf("Listening on port %d, want %d", got, port)
+	}
+}
+
+func TestAuthenticateManual(t *testing.T) {
+	var code, redirectURI string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		code = r.PostForm.Get("code")
+		redirectURI = r.PostForm.Get("redirect_uri")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "test-access-token", "token_type": "Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	newAuth := func() *BrowserAuth {
+		return NewBrowserAuth(&oauth2.Config{
+			ClientID: "client",
+			Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL},
+		})
+	}
+
+	tests := []struct {
+		name     string
+		input    func(state string) string
+		wantCode string
+		wantErr  string
+	}{
+		{name: "bare code", input: func(string) string { return "  pasted-code\n" }, wantCode: "pasted-code"},
+		{name: "code without newline", input: func(string) string { return "pasted-code" }, wantCode: "pasted-code"},
+		{
+			name: "redirect URL",
+			input: func(state string) string {
+				return "http://localhost:8085/oauth2callback?code=url-code&state=" + state + "\n"
+			},
+			wantCode: "url-code",
+		},
+		{
+			name:    "redirect URL with another state",
+			input:   func(string) string { return "http://localhost:8085/oauth2callback?code=url-code&state=forged\n" },
+			wantErr: "state mismatch",
+		},
+		{
+			name:    "redirect URL with error",
+			input:   func(string) string { return "http://localhost:8085/oauth2callback?error=access_denied\n" },
+			wantErr: "access_denied",
+		},
+		{name: "empty input", input: func(string) string { return "\n" }, wantErr: "no authorization code"},
+		{name: "closed input", input: func(string) string { return "" }, wantErr: "failed to read authorization code"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, redirectURI = "", ""
+			ba := newAuth()
+			token, err := ba.AuthenticateManual(context.Background(), strings.NewReader(tt.input(ba.state)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if code != "" {
+					t.Errorf("Expected no token exchange, got code %q", code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AuthenticateManual returned error: %v", err)
+			}
+			if token.AccessToken != "test-access-token" || code != tt.wantCode {
+				t.Errorf("Token %q exchanged for code %q, want code %q", token.AccessToken, code, tt.wantCode)
+			}
+			if redirectURI != constants.ManualAuthRedirectURL {
+				t.Errorf("redirect_uri = %q, want %q", redirectURI, constants.ManualAuthRedirectURL)
+			}
+		})
+	}
+}
+
+func TestBrowserCommand(t *testing.T) {
+	const target = "https://accounts.example.com/auth?state=x"
+	tests := []struct {
+		browserEnv string
+		goos       string
+		want       string
+	}{
+		{"", "linux", "xdg-open " + target},
+		{"", "darwin", "open " + target},
+		{"", "windows", "cmd /c start " + target},
+		{"", "plan9", "xdg-open " + target},
+		{"wslview", "linux", "wslview " + target},
+		{"firefox --new-tab", "linux", "firefox --new-tab " + target},
+		{"lynx -dump %s", "linux", "lynx -dump " + target},
+		{"wslview:xdg-open", "linux", "wslview " + target},
+		{"   ", "darwin", "open " + target},
+	}
+	for _, tt := range tests {
+		cmd, args := browserCommand(tt.browserEnv, tt.goos, target)
+		if got := strings.Join(append([]string{cmd}, args...), " "); got != tt.want {
+			t.Errorf("browserCommand(%q, %q) = %q, want %q", tt.browserEnv, tt.goos, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticateUsesOpenURLFunc(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "test-access-token", "token_type": "Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	opened := make(chan string, 1)
+	ba := NewBrowserAuthWithConfig(&oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth", TokenURL: tokenServer.URL},
+	}, BrowserAuthConfig{
+		SuccessHTML: "<p>done</p>",
+		OpenURLFunc: func(url string) error {
+			opened <- url
+			return nil
+		},
+	})
+
+	// The fake browser signs in by calling back with a code
+	go func() {
+		authURL, err := url.Parse(<-opened)
+		if err != nil {
+			t.Errorf("Failed to parse opened URL: %v", err)
+			return
+		}
+		query := authURL.Query()
+		resp, err := http.Get(query.Get("redirect_uri") + "?code=abc&state=" + query.Get("state"))
+		if err != nil {
+			t.Errorf("Callback failed: %v", err)
+			return
+		}
+		_ = resp.Body.Close()
+	}()
+
+	token, err := ba.Authenticate(context.Background())
+	if err != nil {
+		t.Fatalf("Authenticate returned error: %v", err)
+	}
+	if token.AccessToken != "test-access-token" {
+		t.Errorf("AccessToken = %q, want test-access-token", token.AccessToken)
+	}
+}
@@ -0,0 +1,93 @@
+package browser
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// AuthenticateManual performs OAuth2 authentication without a local callback server,
+// for remote machines where the browser runs on another host. It prints the
+// authorization URL and reads the code the user pastes into codeReader, either the code
+// shown after signing in or the whole URL the browser was redirected to. The state of a
+// pasted URL is checked like the state of a callback.
+func (ba *BrowserAuth) AuthenticateManual(ctx context.Context, codeReader io.Reader) (*oauth2.Token, error) {
+	ba.config.RedirectURL = constants.ManualAuthRedirectURL
+	authURL := ba.authCodeURL()
+
+	fmt.Printf("\nGemini Web Tools authentication required.\n")
+	fmt.Printf("Open this URL in a browser on any device:\n\n%s\n\n", authURL)
+	fmt.Print("Then paste the authorization code or the URL you were redirected to: ")
+
+	// The read cannot be interrupted, so it is left behind when ctx is done
+	type readResult struct {
+		line string
+		err  error
+	}
+	lines := make(chan readResult, 1)
+	go func() {
+		line, err := bufio.NewReader(codeReader).ReadString('\n')
+		if err == io.EOF && strings.TrimSpace(line) != "" {
+			err = nil
+		}
+		lines <- readResult{line: line, err: err}
+	}()
+
+	var input string
+	select {
+	case result := <-lines:
+		if result.err != nil {
+			return nil, fmt.Errorf("failed to read authorization code: %w", result.err)
+		}
+		input = result.line
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(constants.AuthTimeout):
+		return nil, fmt.Errorf("authentication timeout")
+	}
+
+	code, err := ba.pastedCode(input)
+	if err != nil {
+		return nil, err
+	}
+	token, err := ba.config.Exchange(ctx, code, oauth2.VerifierOption(ba.verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange token: %w", err)
+	}
+	return token, nil
+}
+
+// pastedCode returns the authorization code of the user's input. A redirect URL must
+// carry the state of the flow; a bare code has none to check.
+func (ba *BrowserAuth) pastedCode(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", errors.New("no authorization code received")
+	}
+
+	redirect, err := url.Parse(input)
+	if err != nil || redirect.Scheme == "" || redirect.Host == "" {
+		return input, nil
+	}
+	query := redirect.Query()
+	if errMsg := query.Get("error"); errMsg != "" {
+		return "", fmt.Errorf("authentication error: %s", errMsg)
+	}
+	if state := query.Get("state"); state != ba.state {
+		return "", errors.New("state mismatch, possible CSRF attack")
+	}
+	code := query.Get("code")
+	if code == "" {
+		return "", errors.New("no authorization code received")
+	}
+	return code, nil
+}
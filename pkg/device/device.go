@@ -0,0 +1,106 @@
+// Package device provides the OAuth2 device authorization grant (RFC 8628) for
+// environments without a local browser, such as servers, containers and SSH sessions.
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+var (
+	// ErrExpiredToken is returned when the device code expires before the user
+	// completes the authorization.
+	ErrExpiredToken = errors.New("device code expired before authorization was completed")
+
+	// ErrAccessDenied is returned when the user denies the authorization request.
+	ErrAccessDenied = errors.New("authorization request was denied")
+)
+
+// DeviceAuth handles the OAuth2 device authorization flow. The user code and the
+// verification URL are printed, and the user completes the authorization on any
+// device with a browser while the token endpoint is polled.
+type DeviceAuth struct {
+	config *oauth2.Config
+	output io.Writer
+}
+
+// DeviceAuthConfig holds optional settings of the device authorization flow.
+type DeviceAuthConfig struct {
+	// Output receives the instructions for the user. If nil, os.Stdout is used.
+	Output io.Writer
+}
+
+// NewDeviceAuth creates a new device authorization handler. The device authorization
+// endpoint is config.Endpoint.DeviceAuthURL.
+func NewDeviceAuth(config *oauth2.Config) *DeviceAuth {
+	return NewDeviceAuthWithConfig(config, DeviceAuthConfig{})
+}
+
+// NewDeviceAuthWithConfig creates a new device authorization handler with custom settings.
+func NewDeviceAuthWithConfig(config *oauth2.Config, authConfig DeviceAuthConfig) *DeviceAuth {
+	output := authConfig.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	return &DeviceAuth{
+		config: config,
+		output: output,
+	}
+}
+
+// Authenticate performs the device authorization flow and returns the token once the
+// user has authorized the request. It fails with ErrExpiredToken if the device code
+// expires first and with ErrAccessDenied if the user denies the request.
+func (da *DeviceAuth) Authenticate(ctx context.Context) (*oauth2.Token, error) {
+	resp, err := da.config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(da.output, "\nGemini Web Tools authentication required.\n")
+	_, _ = fmt.Fprintf(da.output, "On a device with a browser, visit:\n\n%s\n\n", resp.VerificationURI)
+	_, _ = fmt.Fprintf(da.output, "and enter the code: %s\n\n", resp.UserCode)
+	if resp.VerificationURIComplete != "" {
+		_, _ = fmt.Fprintf(da.output, "Or open this URL, which includes the code:\n\n%s\n\n", resp.VerificationURIComplete)
+	}
+	_, _ = fmt.Fprintln(da.output, "Waiting for authorization...")
+
+	return da.poll(ctx, resp)
+}
+
+// poll waits until the user completes the authorization, the device code expires or
+// the context is done. The token endpoint is polled by oauth2.Config.DeviceAccessToken,
+// which honors the interval named by the server and its slow_down responses, through
+// the HTTP client of the context like the device authorization request.
+func (da *DeviceAuth) poll(ctx context.Context, resp *oauth2.DeviceAuthResponse) (*oauth2.Token, error) {
+	expiry := resp.Expiry
+	if expiry.IsZero() {
+		expiry = time.Now().Add(constants.AuthTimeout)
+	}
+	ctx, cancel := context.WithDeadline(ctx, expiry)
+	defer cancel()
+
+	token, err := da.config.DeviceAccessToken(ctx, resp)
+	if err == nil {
+		return token, nil
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	switch {
+	case errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "expired_token":
+		return nil, ErrExpiredToken
+	case errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "access_denied":
+		return nil, ErrAccessDenied
+	case errors.Is(err, context.DeadlineExceeded) && !time.Now().Before(expiry):
+		return nil, ErrExpiredToken
+	}
+	return nil, fmt.Errorf("token request failed: %w", err)
+}
@@ -0,0 +1,122 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// newTestServer returns a server for the device authorization and token endpoints.
+// The token endpoint answers with the given responses in turn, repeating the last one.
+func newTestServer(t *testing.T, responses ...string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var polls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm returned error: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/device":
+			_, _ = io.WriteString(w, `{"device_code":"dev-code","user_code":"ABCD-EFGH","verification_url":"https://example.com/device","expires_in":60,"interval":1}`)
+		case "/token":
+			if got := r.PostForm.Get("device_code"); got != "dev-code" {
+				t.Errorf("device_code = %q, want dev-code", got)
+			}
+			n := int(polls.Add(1))
+			response := responses[min(n, len(responses))-1]
+			if strings.Contains(response, `"error"`) {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			_, _ = io.WriteString(w, response)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &polls
+}
+
+func newTestDeviceAuth(server *httptest.Server) *DeviceAuth {
+	return NewDeviceAuthWithConfig(&oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{
+			DeviceAuthURL: server.URL + "/device",
+			TokenURL:      server.URL + "/token",
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+	}, DeviceAuthConfig{Output: io.Discard})
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestAuthenticate(t *testing.T) {
+	success := `{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expires_in":3600,"id_token":"header.payload.signature"}`
+	server, polls := newTestServer(t, `{"error":"authorization_pending"}`, success)
+
+	// Both the authorization request and the polling use the HTTP client of the context
+	var requests atomic.Int32
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			requests.Add(1)
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+	})
+
+	token, err := newTestDeviceAuth(server).Authenticate(ctx)
+	if err != nil {
+		t.Fatalf("Authenticate returned error: %v", err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" || token.Expiry.IsZero() {
+		t.Errorf("Unexpected token: %+v", token)
+	}
+	if idToken, _ := token.Extra("id_token").(string); idToken != "header.payload.signature" {
+		t.Errorf("Expected the ID token to be kept, got %q", idToken)
+	}
+	if got := polls.Load(); got != 2 {
+		t.Errorf("Expected 2 polls, got %d", got)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("Expected 3 requests through the context's HTTP client, got %d", got)
+	}
+}
+
+func TestAuthenticateErrors(t *testing.T) {
+	tests := []struct {
+		response string
+		want     error
+	}{
+		{`{"error":"expired_token"}`, ErrExpiredToken},
+		{`{"error":"access_denied"}`, ErrAccessDenied},
+		{`{"error":"invalid_client","error_description":"bad client"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.response, func(t *testing.T) {
+			t.Parallel()
+			server, _ := newTestServer(t, tt.response)
+			_, err := newTestDeviceAuth(server).Authenticate(context.Background())
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Authenticate() returned %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (errors.Is(err, ErrExpiredToken) || errors.Is(err, ErrAccessDenied)) {
+				t.Errorf("Expected a generic error, got %v", err)
+			}
+		})
+	}
+}
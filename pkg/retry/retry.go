@@ -0,0 +1,179 @@
+// Package retry provides a retry policy with exponential backoff shared by the
+// token refresh, CodeAssist API and HTTP fallback layers.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// Classifier reports whether an error is worth retrying.
+type Classifier func(err error) bool
+
+// RetryPolicy describes how failed operations are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values less than 1 are treated as 1.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+
+	// BaseDelay is the base delay for exponential backoff.
+	BaseDelay time.Duration `json:"baseDelay,omitempty"`
+
+	// MaxDelay is the maximum delay between attempts.
+	MaxDelay time.Duration `json:"maxDelay,omitempty"`
+
+	// Multiplier is the multiplier for exponential backoff.
+	Multiplier float64 `json:"multiplier,omitempty"`
+
+	// JitterPercent is the jitter percentage to avoid thundering herd (0.0-1.0).
+	JitterPercent float64 `json:"jitterPercent,omitempty"`
+
+	// Retryable classifies errors as retryable. If nil, DefaultClassifier is used.
+	Retryable Classifier `json:"-"`
+}
+
+// DefaultRetryPolicy returns the default retry policy.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:   constants.RefreshRetryMaxAttempts,
+		BaseDelay:     constants.RefreshRetryBaseDelay,
+		MaxDelay:      constants.RefreshRetryMaxDelay,
+		Multiplier:    constants.RefreshRetryMultiplier,
+		JitterPercent: constants.RefreshJitterPercent,
+	}
+}
+
+// Attempts returns the total number of attempts allowed by the policy.
+// A nil policy allows a single attempt.
+func (p *RetryPolicy) Attempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Delay calculates the delay before the given retry attempt using exponential
+// backoff with jitter. The delay is never shorter than BaseDelay.
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	// Exponential backoff: baseDelay * multiplier^attempt
+	delay := float64(p.BaseDelay) * math.Pow(p.Multiplier, float64(attempt))
+
+	// Cap at max delay
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	// Add jitter to avoid thundering herd
+	jitter := delay * p.JitterPercent * (rand.Float64()*2 - 1) // -jitter to +jitter
+	finalDelay := time.Duration(delay + jitter)
+
+	// Ensure minimum delay
+	if finalDelay < p.BaseDelay {
+		finalDelay = p.BaseDelay
+	}
+
+	return finalDelay
+}
+
+// IsRetryable reports whether err should be retried according to the policy's classifier.
+func (p *RetryPolicy) IsRetryable(err error) bool {
+	if p != nil && p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return DefaultClassifier(err)
+}
+
+// Do calls fn until it succeeds, returns a non-retryable error, or the attempts are
+// exhausted. It returns the last error from fn, or the context error if the context
+// is done while waiting between attempts. A nil policy calls fn once.
+func (p *RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < p.Attempts(); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(p.Delay(attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		err = fn(ctx)
+		if err == nil || !p.IsRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// retryableMessages are error message fragments treated as retryable by DefaultClassifier.
+var retryableMessages = []string{
+	"timeout",
+	"connection",
+	"network",
+	"temporary",
+	"unavailable",
+	"rate limit",
+	"429", // Too Many Requests
+	"500", // Internal Server Error
+	"502", // Bad Gateway
+	"503", // Service Unavailable
+	"504", // Gateway Timeout
+}
+
+// DefaultClassifier classifies errors as retryable.
+// Context cancellation is never retryable. Errors implementing
+// interface{ IsRetryable() bool } decide for themselves, network timeouts are
+// retryable, and other errors are classified by their message.
+func DefaultClassifier(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var classified interface{ IsRetryable() bool }
+	if errors.As(err, &classified) {
+		return classified.IsRetryable()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	errorStr := strings.ToLower(err.Error())
+	for _, retryable := range retryableMessages {
+		if strings.Contains(errorStr, retryable) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsRetryableStatus reports whether an HTTP status code indicates a transient failure.
+func IsRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
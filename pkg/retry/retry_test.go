@@ -0,0 +1,164 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type classifiedError struct {
+	retryable bool
+}
+
+func (e *classifiedError) Error() string     { return "classified error" }
+func (e *classifiedError) IsRetryable() bool { return e.retryable }
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    1 * time.Second,
+		Multiplier:  2.0,
+	}
+
+	expected := []time.Duration{
+		100 * time.Millisecond, // attempt 0: base
+		200 * time.Millisecond, // attempt 1: base * 2
+		400 * time.Millisecond, // attempt 2: base * 4
+		800 * time.Millisecond, // attempt 3: base * 8
+		1 * time.Second,        // attempt 4: capped at max delay
+		1 * time.Second,        // attempt 5: capped at max delay
+	}
+
+	for attempt, want := range expected {
+		if got := policy.Delay(attempt); got != want {
+			t.Errorf("Delay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyDelayJitter(t *testing.T) {
+	policy := &RetryPolicy{
+		BaseDelay:     100 * time.Millisecond,
+		MaxDelay:      10 * time.Second,
+		Multiplier:    2.0,
+		JitterPercent: 0.1,
+	}
+
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(3) // 800ms +/- 10%
+		if delay < 720*time.Millisecond || delay > 880*time.Millisecond {
+			t.Fatalf("Delay(3) = %v, want within 720ms-880ms", delay)
+		}
+	}
+}
+
+func TestDefaultClassifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil error", err: nil, expected: false},
+		{name: "context canceled", err: context.Canceled, expected: false},
+		{name: "wrapped deadline exceeded", err: fmt.Errorf("request: %w", context.DeadlineExceeded), expected: false},
+		{name: "classified retryable", err: fmt.Errorf("wrapped: %w", &classifiedError{retryable: true}), expected: true},
+		{name: "classified non-retryable", err: &classifiedError{retryable: false}, expected: false},
+		{name: "service unavailable message", err: errors.New("503 Service Unavailable"), expected: true},
+		{name: "connection reset message", err: errors.New("connection reset by peer"), expected: true},
+		{name: "invalid grant message", err: errors.New("invalid_grant"), expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultClassifier(tt.err); got != tt.expected {
+				t.Errorf("DefaultClassifier(%v) = %v, want %v", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	retryable := []int{429, 500, 502, 503, 504}
+	nonRetryable := []int{200, 400, 401, 403, 404, 501}
+
+	for _, code := range retryable {
+		if !IsRetryableStatus(code) {
+			t.Errorf("Expected status %d to be retryable", code)
+		}
+	}
+	for _, code := range nonRetryable {
+		if IsRetryableStatus(code) {
+			t.Errorf("Expected status %d not to be retryable", code)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Multiplier: 1}
+	retryableErr := &classifiedError{retryable: true}
+
+	t.Run("retries until success", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(context.Background(), func(ctx context.Context) error {
+			calls++
+			if calls < 2 {
+				return retryableErr
+			}
+			return nil
+		})
+		if err != nil || calls != 2 {
+			t.Errorf("Do() = %v after %d calls, want nil after 2 calls", err, calls)
+		}
+	})
+
+	t.Run("stops after max attempts", func(t *testing.T) {
+		calls := 0
+		err := policy.Do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return retryableErr
+		})
+		if !errors.Is(err, retryableErr) || calls != 3 {
+			t.Errorf("Do() = %v after %d calls, want retryable error after 3 calls", err, calls)
+		}
+	})
+
+	t.Run("does not retry non-retryable errors", func(t *testing.T) {
+		calls := 0
+		nonRetryable := &classifiedError{retryable: false}
+		err := policy.Do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return nonRetryable
+		})
+		if !errors.Is(err, nonRetryable) || calls != 1 {
+			t.Errorf("Do() = %v after %d calls, want non-retryable error after 1 call", err, calls)
+		}
+	})
+
+	t.Run("custom classifier", func(t *testing.T) {
+		custom := *policy
+		custom.Retryable = func(err error) bool { return false }
+		calls := 0
+		_ = custom.Do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return retryableErr
+		})
+		if calls != 1 {
+			t.Errorf("Expected custom classifier to prevent retries, got %d calls", calls)
+		}
+	})
+
+	t.Run("nil policy runs once", func(t *testing.T) {
+		var nilPolicy *RetryPolicy
+		calls := 0
+		_ = nilPolicy.Do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return retryableErr
+		})
+		if calls != 1 {
+			t.Errorf("Expected nil policy to run once, got %d calls", calls)
+		}
+	})
+}
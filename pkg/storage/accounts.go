@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// MultiAccountStore manages the credentials of several named accounts in one base
+// directory. The default account, constants.DefaultAccountName, uses the token file of
+// the base directory itself, so it shares its credentials with a FileSystemStore of
+// that directory and with gemini-cli. Every other account has its own subdirectory
+// under "accounts", holding its token and its CodeAssist project.
+type MultiAccountStore struct {
+	baseDir string
+}
+
+// NewMultiAccountStore creates a store for the accounts in baseDir.
+// If baseDir is empty, the default directory (~/.gemini or equivalent) is used.
+func NewMultiAccountStore(baseDir string) (*MultiAccountStore, error) {
+	if baseDir == "" {
+		var err error
+		baseDir, err = getDefaultStorageDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &MultiAccountStore{baseDir: baseDir}, nil
+}
+
+// Account returns the credential store of the named account, creating its directory if
+// needed. An empty name selects the default account. Names must not contain path
+// separators or start with a dot.
+func (ms *MultiAccountStore) Account(name string) (*FileSystemStore, error) {
+	if name == "" || name == constants.DefaultAccountName {
+		return NewFileSystemStore(ms.baseDir)
+	}
+	if err := validateAccountName(name); err != nil {
+		return nil, err
+	}
+	return NewFileSystemStore(filepath.Join(ms.baseDir, constants.AccountsDirName, name))
+}
+
+// Accounts returns the sorted names of the accounts that have a stored token,
+// including constants.DefaultAccountName if the default account has one.
+func (ms *MultiAccountStore) Accounts() ([]string, error) {
+	var accounts []string
+	if fileExists(ms.baseDir + constants.TokenFileName) {
+		accounts = append(accounts, constants.DefaultAccountName)
+	}
+
+	accountsDir := filepath.Join(ms.baseDir, constants.AccountsDirName)
+	entries, err := os.ReadDir(accountsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list accounts in %s: %w", accountsDir, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || validateAccountName(name) != nil {
+			continue
+		}
+		if fileExists(filepath.Join(accountsDir, name) + constants.TokenFileName) {
+			accounts = append(accounts, name)
+		}
+	}
+
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// GetStoragePath returns the base directory of the accounts.
+func (ms *MultiAccountStore) GetStoragePath() string {
+	return ms.baseDir
+}
+
+// validateAccountName returns an error if the account name cannot be used as the name
+// of its directory.
+func validateAccountName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\:\x00") {
+		return fmt.Errorf("invalid account name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"golang.org/x/oauth2"
+)
+
+func TestMultiAccountStore(t *testing.T) {
+	dir := t.TempDir()
+	accounts, err := NewMultiAccountStore(dir)
+	if err != nil {
+		t.Fatalf("NewMultiAccountStore returned error: %v", err)
+	}
+
+	if names, err := accounts.Accounts(); err != nil || len(names) != 0 {
+		t.Errorf("Expected no accounts in an empty directory, got %v, %v", names, err)
+	}
+
+	for _, name := range []string{"", constants.DefaultAccountName, "work", "me@example.com"} {
+		store, err := accounts.Account(name)
+		if err != nil {
+			t.Fatalf("Account(%q) returned error: %v", name, err)
+		}
+		if name == "work" {
+			// An account without a token is not listed
+			continue
+		}
+		if err := store.StoreToken(&oauth2.Token{AccessToken: "token-" + name}); err != nil {
+			t.Fatalf("StoreToken returned error: %v", err)
+		}
+	}
+
+	names, err := accounts.Accounts()
+	if err != nil {
+		t.Fatalf("Accounts returned error: %v", err)
+	}
+	if want := []string{constants.DefaultAccountName, "me@example.com"}; !slices.Equal(names, want) {
+		t.Errorf("Accounts() = %v, want %v", names, want)
+	}
+
+	// The default account keeps using the token file of the base directory
+	single, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSystemStore returned error: %v", err)
+	}
+	if token, err := single.LoadToken(); err != nil || token.AccessToken != "token-"+constants.DefaultAccountName {
+		t.Errorf("Expected the default account in the base token file, got %+v, %v", token, err)
+	}
+
+	for _, name := range []string{"..", ".hidden", "a/b", `a\b`} {
+		if _, err := accounts.Account(name); err == nil {
+			t.Errorf("Account(%q) expected an error", name)
+		}
+	}
+}
+
+func TestMultiAccountStoreSwitching(t *testing.T) {
+	accounts, err := NewMultiAccountStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewMultiAccountStore returned error: %v", err)
+	}
+	work, err := accounts.Account("work")
+	if err != nil {
+		t.Fatalf("Account returned error: %v", err)
+	}
+	personal, err := accounts.Account("personal")
+	if err != nil {
+		t.Fatalf("Account returned error: %v", err)
+	}
+
+	if err := work.StoreToken(&oauth2.Token{AccessToken: "work-token"}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	if personal.HasToken() {
+		t.Error("Expected the token of one account to be invisible to another")
+	}
+	if err := personal.StoreToken(&oauth2.Token{AccessToken: "personal-token"}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+
+	// Switching back finds each account's own token and project
+	if err := work.StoreProject(&ProjectInfo{ProjectID: "work-project"}); err != nil {
+		t.Fatalf("StoreProject returned error: %v", err)
+	}
+	again, err := accounts.Account("work")
+	if err != nil {
+		t.Fatalf("Account returned error: %v", err)
+	}
+	if token, err := again.LoadToken(); err != nil || token.AccessToken != "work-token" {
+		t.Errorf("Expected the work token, got %+v, %v", token, err)
+	}
+	if info, err := again.LoadProject(); err != nil || info.ProjectID != "work-project" {
+		t.Errorf("Expected the work project, got %+v, %v", info, err)
+	}
+	if _, err := personal.LoadProject(); err == nil {
+		t.Error("Expected the personal account to have no project")
+	}
+	if got, want := again.GetStoragePath(), filepath.Join(accounts.GetStoragePath(), constants.AccountsDirName, "work"); got != want {
+		t.Errorf("GetStoragePath() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"golang.org/x/oauth2"
+)
+
+// EncryptedFileSystemStore implements CredentialStore using the same file layout as
+// FileSystemStore, but encrypts the token with AES-256-GCM before writing it, so that
+// the token cannot be read without the key. The key is never stored; callers keep it
+// elsewhere, for example in the OS keychain, or derive it from a passphrase.
+//
+// Token files written by FileSystemStore cannot be read by this store and vice versa.
+type EncryptedFileSystemStore struct {
+	FileSystemStore
+	aead cipher.AEAD
+}
+
+// NewEncryptedFileSystemStore creates a new encrypted filesystem-based credential store.
+// The key must be 32 bytes long. If baseDir is empty, the default directory is used.
+func NewEncryptedFileSystemStore(baseDir string, key []byte) (*EncryptedFileSystemStore, error) {
+	if len(key) != constants.EncryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key size: %d bytes (want %d)", len(key), constants.EncryptionKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	fs, err := NewFileSystemStore(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptedFileSystemStore{
+		FileSystemStore: *fs,
+		aead:            aead,
+	}, nil
+}
+
+// LoadToken implements CredentialStore.LoadToken.
+// It returns ErrStorageCorrupted if the token file was not encrypted with the store's key
+// or has been modified.
+func (es *EncryptedFileSystemStore) LoadToken() (*oauth2.Token, error) {
+	return es.loadToken()
+}
+
+// StoreToken implements CredentialStore.StoreToken.
+// The file holds a random nonce followed by the encrypted token JSON.
+func (es *EncryptedFileSystemStore) StoreToken(token *oauth2.Token) error {
+	return storeLocked(es.getLockPath(), func() error {
+		return es.storeToken(token)
+	})
+}
+
+// UpdateToken implements TokenUpdater.UpdateToken.
+func (es *EncryptedFileSystemStore) UpdateToken(update func(current *oauth2.Token) (*oauth2.Token, error)) error {
+	return updateLocked(es.getLockPath(), es.getTokenPath(), es.loadToken, es.storeToken, update)
+}
+
+// loadToken reads and decrypts the token file without locking.
+func (es *EncryptedFileSystemStore) loadToken() (*oauth2.Token, error) {
+	path := es.getTokenPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("token file does not exist at %s: %w", path, ErrStorageNotFound)
+		}
+		return nil, fmt.Errorf("failed to read token file at %s: %w", path, err)
+	}
+
+	nonceSize := es.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("encrypted token at %s is truncated: %w", path, ErrStorageCorrupted)
+	}
+	plaintext, err := es.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token at %s: %w", path, ErrStorageCorrupted)
+	}
+
+	var token storedToken
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token JSON at %s: %w", path, ErrStorageCorrupted)
+	}
+
+	return token.oauth2Token(), nil
+}
+
+// storeToken encrypts and writes the token file without locking.
+func (es *EncryptedFileSystemStore) storeToken(token *oauth2.Token) error {
+	path := es.getTokenPath()
+	if token == nil {
+		return fmt.Errorf("cannot store a nil token at %s", path)
+	}
+	plaintext, err := json.Marshal(newStoredToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to marshal token to JSON for %s: %w", path, err)
+	}
+
+	nonce := make([]byte, es.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	data := es.aead.Seal(nonce, nonce, plaintext, nil)
+
+	// Ensure the directory exists
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	// Write with restricted permissions
+	if err := writeFileAtomic(path, data, constants.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write token file at %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"golang.org/x/oauth2"
+)
+
+var _ CredentialStore = (*EncryptedFileSystemStore)(nil)
+
+func TestEncryptedFileSystemStore(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{1}, constants.EncryptionKeySize)
+
+	store, err := NewEncryptedFileSystemStore(dir, key)
+	if err != nil {
+		t.Fatalf("NewEncryptedFileSystemStore returned error: %v", err)
+	}
+	if _, err := store.LoadToken(); !errors.Is(err, ErrStorageNotFound) {
+		t.Errorf("Expected ErrStorageNotFound, got %v", err)
+	}
+
+	token := &oauth2.Token{AccessToken: "secret-access-token", RefreshToken: "secret-refresh-token"}
+	if err := store.StoreToken(token); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	if !store.HasToken() {
+		t.Error("Expected store to have a token")
+	}
+
+	path := dir + constants.TokenFileName
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read token file: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("Expected token file to be encrypted")
+	}
+
+	loaded, err := store.LoadToken()
+	if err != nil {
+		t.Fatalf("LoadToken returned error: %v", err)
+	}
+	if loaded.AccessToken != token.AccessToken || loaded.RefreshToken != token.RefreshToken {
+		t.Errorf("LoadToken() = %+v, want %+v", loaded, token)
+	}
+
+	otherKey := bytes.Repeat([]byte{2}, constants.EncryptionKeySize)
+	other, err := NewEncryptedFileSystemStore(dir, otherKey)
+	if err != nil {
+		t.Fatalf("NewEncryptedFileSystemStore returned error: %v", err)
+	}
+	if _, err := other.LoadToken(); !errors.Is(err, ErrStorageCorrupted) {
+		t.Errorf("Expected ErrStorageCorrupted with the wrong key, got %v", err)
+	}
+
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, constants.FilePermissions); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	if _, err := store.LoadToken(); !errors.Is(err, ErrStorageCorrupted) {
+		t.Errorf("Expected ErrStorageCorrupted for a modified file, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("short"), constants.FilePermissions); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	if _, err := store.LoadToken(); !errors.Is(err, ErrStorageCorrupted) {
+		t.Errorf("Expected ErrStorageCorrupted for a truncated file, got %v", err)
+	}
+
+	if err := store.ClearToken(); err != nil {
+		t.Fatalf("ClearToken returned error: %v", err)
+	}
+	if store.HasToken() {
+		t.Error("Expected store to be empty after ClearToken")
+	}
+}
+
+func TestNewEncryptedFileSystemStoreKeySize(t *testing.T) {
+	for _, size := range []int{0, 16, 31, 33} {
+		if _, err := NewEncryptedFileSystemStore(t.TempDir(), make([]byte, size)); err == nil {
+			t.Errorf("Expected error for a %d byte key", size)
+		}
+	}
+}
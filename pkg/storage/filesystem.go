@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -30,6 +31,30 @@ func ensureDir(dir string) error {
 	return nil
 }
 
+// storedToken is the JSON form of a stored token. Besides the fields of oauth2.Token it
+// keeps the ID token of the token response, which oauth2.Token only holds as an extra
+// field, in the same place as gemini-cli.
+type storedToken struct {
+	oauth2.Token
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// newStoredToken returns the JSON form of a token.
+func newStoredToken(token *oauth2.Token) *storedToken {
+	stored := &storedToken{Token: *token}
+	stored.IDToken, _ = token.Extra(constants.IDTokenField).(string)
+	return stored
+}
+
+// oauth2Token returns the stored token with its ID token as an extra field.
+func (st *storedToken) oauth2Token() *oauth2.Token {
+	token := st.Token
+	if st.IDToken == "" {
+		return &token
+	}
+	return token.WithExtra(map[string]any{constants.IDTokenField: st.IDToken})
+}
+
 // loadTokenFromFile loads an OAuth2 token from a JSON file.
 func loadTokenFromFile(path string) (*oauth2.Token, error) {
 	data, err := os.ReadFile(path)
@@ -40,17 +65,20 @@ func loadTokenFromFile(path string) (*oauth2.Token, error) {
 		return nil, fmt.Errorf("failed to read token file at %s: %w", path, err)
 	}
 
-	var token oauth2.Token
+	var token storedToken
 	if err := json.Unmarshal(data, &token); err != nil {
 		return nil, fmt.Errorf("failed to parse token JSON at %s: %w", path, ErrStorageCorrupted)
 	}
 
-	return &token, nil
+	return token.oauth2Token(), nil
 }
 
 // storeTokenToFile stores an OAuth2 token to a JSON file.
 func storeTokenToFile(path string, token *oauth2.Token) error {
-	data, err := json.MarshalIndent(token, "", "  ")
+	if token == nil {
+		return fmt.Errorf("cannot store a nil token at %s", path)
+	}
+	data, err := json.MarshalIndent(newStoredToken(token), "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal token to JSON for %s: %w", path, err)
 	}
@@ -62,17 +90,140 @@ func storeTokenToFile(path string, token *oauth2.Token) error {
 	}
 
 	// Write with restricted permissions
-	if err := os.WriteFile(path, data, constants.FilePermissions); err != nil {
+	if err := writeFileAtomic(path, data, constants.FilePermissions); err != nil {
 		return fmt.Errorf("failed to write token file at %s: %w", path, err)
 	}
 
 	return nil
 }
 
+// loadProjectFromFile loads project information from a JSON file.
+func loadProjectFromFile(path string) (*ProjectInfo, error) {
+	var info ProjectInfo
+	if err := loadJSONFile(path, "project", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// storeProjectToFile stores project information to a JSON file.
+func storeProjectToFile(path string, info *ProjectInfo) error {
+	return storeJSONFile(path, "project", info)
+}
+
+// loadRefreshStateFromFile loads the refresh state from a JSON file.
+func loadRefreshStateFromFile(path string) (*RefreshStateRecord, error) {
+	var record RefreshStateRecord
+	if err := loadJSONFile(path, "refresh state", &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// loadJSONFile parses the JSON file at path, holding the named kind of data, into v.
+func loadJSONFile(path, kind string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s file does not exist at %s: %w", kind, path, ErrStorageNotFound)
+		}
+		return fmt.Errorf("failed to read %s file at %s: %w", kind, path, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s JSON at %s: %w", kind, path, ErrStorageCorrupted)
+	}
+
+	return nil
+}
+
+// storeJSONFile writes v, holding the named kind of data, to a JSON file at path.
+func storeJSONFile(path, kind string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s to JSON for %s: %w", kind, path, err)
+	}
+
+	// Ensure the directory exists
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, data, constants.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s file at %s: %w", kind, path, err)
+	}
+
+	return nil
+}
+
+// storeLocked calls store while holding an exclusive lock on lockPath.
+func storeLocked(lockPath string, store func() error) error {
+	unlock, err := lockFile(lockPath)
+	if err != nil {
+		return fmt.Errorf("failed to lock %s: %w", lockPath, err)
+	}
+	defer unlock()
+	return store()
+}
+
+// updateLocked implements TokenUpdater.UpdateToken for the token file at path with the
+// given unlocked load and store functions, holding an exclusive lock on lockPath.
+func updateLocked(lockPath, path string, load func() (*oauth2.Token, error), store func(*oauth2.Token) error, update func(*oauth2.Token) (*oauth2.Token, error)) error {
+	return storeLocked(lockPath, func() error {
+		current, err := load()
+		if err != nil && !errors.Is(err, ErrStorageNotFound) {
+			return err
+		}
+		token, err := update(current)
+		if err != nil {
+			return err
+		}
+		if token == current {
+			return nil
+		}
+		if token == nil {
+			return fmt.Errorf("cannot store a nil token at %s", path)
+		}
+		return store(token)
+	})
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path, syncs it
+// and renames it over path, so that readers see either the old or the new content
+// and a crash never leaves a partially written file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		// Removing fails harmlessly once the file has been renamed
+		_ = os.Remove(tmpPath)
+	}()
+
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
 // removeFile removes a file.
 func removeFile(path string) error {
 	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove token file at %s: %w", path, err)
+		return fmt.Errorf("failed to remove file at %s: %w", path, err)
 	}
 	return nil
 }
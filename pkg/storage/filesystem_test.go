@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"golang.org/x/oauth2"
+)
+
+var (
+	_ ProjectStore      = (*FileSystemStore)(nil)
+	_ RefreshStateStore = (*FileSystemStore)(nil)
+	_ RefreshStateStore = (*InMemoryStore)(nil)
+)
+
+func TestFileSystemStoreProject(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSystemStore returned error: %v", err)
+	}
+
+	if _, err := store.LoadProject(); !errors.Is(err, ErrStorageNotFound) {
+		t.Errorf("Expected ErrStorageNotFound, got %v", err)
+	}
+
+	info := &ProjectInfo{
+		ProjectID:    "project",
+		Endpoint:     "https://example.com",
+		CredentialID: "id",
+		UpdatedAt:    time.Now().Truncate(time.Second),
+	}
+	if err := store.StoreProject(info); err != nil {
+		t.Fatalf("StoreProject returned error: %v", err)
+	}
+
+	loaded, err := store.LoadProject()
+	if err != nil {
+		t.Fatalf("LoadProject returned error: %v", err)
+	}
+	if loaded.ProjectID != info.ProjectID || loaded.Endpoint != info.Endpoint ||
+		loaded.CredentialID != info.CredentialID || !loaded.UpdatedAt.Equal(info.UpdatedAt) {
+		t.Errorf("LoadProject() = %+v, want %+v", loaded, info)
+	}
+
+	stat, err := os.Stat(dir + constants.ProjectFileName)
+	if err != nil {
+		t.Fatalf("Expected project file next to the credentials: %v", err)
+	}
+	if stat.Mode().Perm() != constants.FilePermissions {
+		t.Errorf("Project file permissions = %v, want %v", stat.Mode().Perm(), os.FileMode(constants.FilePermissions))
+	}
+
+	if err := store.ClearProject(); err != nil {
+		t.Fatalf("ClearProject returned error: %v", err)
+	}
+	if _, err := store.LoadProject(); !errors.Is(err, ErrStorageNotFound) {
+		t.Errorf("Expected project to be cleared, got %v", err)
+	}
+	if err := store.ClearProject(); err != nil {
+		t.Errorf("Expected clearing a missing project to succeed, got %v", err)
+	}
+}
+
+func TestFileSystemStoreRefreshState(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSystemStore returned error: %v", err)
+	}
+
+	if _, err := store.LoadRefreshState(); !errors.Is(err, ErrStorageNotFound) {
+		t.Errorf("Expected ErrStorageNotFound, got %v", err)
+	}
+
+	record := &RefreshStateRecord{
+		LastRefreshAttempt: time.Now().Truncate(time.Second),
+		RefreshAttempts:    3,
+		LastError:          "oauth2: token refresh rejected: invalid_grant",
+		UpdatedAt:          time.Now().Truncate(time.Second),
+	}
+	if err := store.StoreRefreshState(record); err != nil {
+		t.Fatalf("StoreRefreshState returned error: %v", err)
+	}
+	loaded, err := store.LoadRefreshState()
+	if err != nil {
+		t.Fatalf("LoadRefreshState returned error: %v", err)
+	}
+	if loaded.RefreshAttempts != record.RefreshAttempts || loaded.LastError != record.LastError ||
+		!loaded.LastRefreshAttempt.Equal(record.LastRefreshAttempt) || !loaded.LastRefreshSuccess.IsZero() {
+		t.Errorf("LoadRefreshState() = %+v, want %+v", loaded, record)
+	}
+	if _, err := os.Stat(dir + constants.RefreshStateFileName); err != nil {
+		t.Errorf("Expected refresh state file next to the credentials: %v", err)
+	}
+
+	if err := store.ClearRefreshState(); err != nil {
+		t.Fatalf("ClearRefreshState returned error: %v", err)
+	}
+	if _, err := store.LoadRefreshState(); !errors.Is(err, ErrStorageNotFound) {
+		t.Errorf("Expected refresh state to be cleared, got %v", err)
+	}
+}
+
+func TestFileSystemStoreAtomicWrites(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSystemStore returned error: %v", err)
+	}
+
+	// Tokens of very different sizes make a partially written file easy to spot
+	tokens := []*oauth2.Token{
+		{AccessToken: "short"},
+		{AccessToken: strings.Repeat("long", 4096), RefreshToken: strings.Repeat("refresh", 1024)},
+	}
+	if err := store.StoreToken(tokens[0]); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := range 200 {
+			if err := store.StoreToken(tokens[i%2]); err != nil {
+				t.Errorf("StoreToken returned error: %v", err)
+				return
+			}
+		}
+	}()
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				token, err := store.LoadToken()
+				if err != nil {
+					t.Errorf("LoadToken observed a partial write: %v", err)
+					return
+				}
+				if token.AccessToken != tokens[0].AccessToken && token.AccessToken != tokens[1].AccessToken {
+					t.Errorf("LoadToken returned an unexpected token of length %d", len(token.AccessToken))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir returned error: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("Temporary file %s was left behind", entry.Name())
+		}
+	}
+}
+
+func TestStoresKeepIDToken(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSystemStore returned error: %v", err)
+	}
+	encryptedStore, err := NewEncryptedFileSystemStore(t.TempDir(), make([]byte, constants.EncryptionKeySize))
+	if err != nil {
+		t.Fatalf("NewEncryptedFileSystemStore returned error: %v", err)
+	}
+
+	token := (&oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}).WithExtra(map[string]any{"id_token": "header.claims.signature"})
+	for _, store := range []CredentialStore{fileStore, encryptedStore} {
+		if err := store.StoreToken(token); err != nil {
+			t.Fatalf("StoreToken returned error: %v", err)
+		}
+		loaded, err := store.LoadToken()
+		if err != nil {
+			t.Fatalf("LoadToken returned error: %v", err)
+		}
+		if loaded.AccessToken != "access" || loaded.RefreshToken != "refresh" || loaded.Extra("id_token") != "header.claims.signature" {
+			t.Errorf("LoadToken() = %+v with ID token %v", loaded, loaded.Extra("id_token"))
+		}
+	}
+
+	// The ID token is stored where gemini-cli keeps it
+	data, err := os.ReadFile(fileStore.getTokenPath())
+	if err != nil {
+		t.Fatalf("Failed to read token file: %v", err)
+	}
+	if !strings.Contains(string(data), `"id_token": "header.claims.signature"`) {
+		t.Errorf("Token file does not contain the ID token: %s", data)
+	}
+}
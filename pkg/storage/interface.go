@@ -34,8 +34,25 @@ type CredentialStore interface {
 	GetStoragePath() string
 }
 
+// TokenUpdater is implemented by credential stores that can replace the stored token
+// based on its current value without other processes sharing the store interfering,
+// such as two processes refreshing the same token.
+type TokenUpdater interface {
+	// UpdateToken calls update with the stored token, or nil if none is stored, and
+	// stores the token it returns. Nothing is stored if update returns an error, which
+	// is then returned as is, or if it returns the token it was given.
+	UpdateToken(update func(current *oauth2.Token) (*oauth2.Token, error)) error
+}
+
 // FileSystemStore implements CredentialStore using the filesystem.
 // This is compatible with the gemini-cli credential storage format.
+//
+// Token writes and updates take an exclusive advisory lock on a sibling ".lock" file,
+// so that processes sharing the directory do not interleave an update with their own.
+// Reads do not lock, as files are replaced atomically and never seen half written.
+// POSIX systems use flock(2) and Windows uses LockFileEx; both only lock the lock file,
+// so programs that do not take the lock, such as gemini-cli, can still read and write
+// the token file. Other platforms do not lock.
 type FileSystemStore struct {
 	baseDir string
 }
@@ -78,12 +95,29 @@ func (fs *FileSystemStore) LoadToken() (*oauth2.Token, error) {
 
 // StoreToken implements CredentialStore.StoreToken.
 func (fs *FileSystemStore) StoreToken(token *oauth2.Token) error {
-	return storeTokenToFile(fs.getTokenPath(), token)
+	path := fs.getTokenPath()
+	return storeLocked(fs.getLockPath(), func() error {
+		return storeTokenToFile(path, token)
+	})
+}
+
+// UpdateToken implements TokenUpdater.UpdateToken.
+// The lock is held from reading the token until the updated token is written.
+func (fs *FileSystemStore) UpdateToken(update func(current *oauth2.Token) (*oauth2.Token, error)) error {
+	path := fs.getTokenPath()
+	return updateLocked(fs.getLockPath(), path, func() (*oauth2.Token, error) {
+		return loadTokenFromFile(path)
+	}, func(token *oauth2.Token) error {
+		return storeTokenToFile(path, token)
+	}, update)
 }
 
 // ClearToken implements CredentialStore.ClearToken.
 func (fs *FileSystemStore) ClearToken() error {
-	return removeFile(fs.getTokenPath())
+	path := fs.getTokenPath()
+	return storeLocked(fs.getLockPath(), func() error {
+		return removeFile(path)
+	})
 }
 
 // HasToken implements CredentialStore.HasToken.
@@ -96,14 +130,66 @@ func (fs *FileSystemStore) GetStoragePath() string {
 	return fs.baseDir
 }
 
+// LoadProject implements ProjectStore.LoadProject.
+func (fs *FileSystemStore) LoadProject() (*ProjectInfo, error) {
+	return loadProjectFromFile(fs.getProjectPath())
+}
+
+// StoreProject implements ProjectStore.StoreProject.
+func (fs *FileSystemStore) StoreProject(info *ProjectInfo) error {
+	return storeProjectToFile(fs.getProjectPath(), info)
+}
+
+// ClearProject implements ProjectStore.ClearProject.
+func (fs *FileSystemStore) ClearProject() error {
+	return removeFile(fs.getProjectPath())
+}
+
+// LoadRefreshState implements RefreshStateStore.LoadRefreshState.
+func (fs *FileSystemStore) LoadRefreshState() (*RefreshStateRecord, error) {
+	return loadRefreshStateFromFile(fs.getRefreshStatePath())
+}
+
+// StoreRefreshState implements RefreshStateStore.StoreRefreshState.
+func (fs *FileSystemStore) StoreRefreshState(record *RefreshStateRecord) error {
+	if record == nil {
+		return errors.New("refresh state cannot be nil")
+	}
+	return storeJSONFile(fs.getRefreshStatePath(), "refresh state", record)
+}
+
+// ClearRefreshState implements RefreshStateStore.ClearRefreshState.
+func (fs *FileSystemStore) ClearRefreshState() error {
+	return removeFile(fs.getRefreshStatePath())
+}
+
 // getTokenPath returns the full path to the token file.
 func (fs *FileSystemStore) getTokenPath() string {
 	return fs.baseDir + constants.TokenFileName
 }
 
+// getLockPath returns the full path to the lock file guarding the token file.
+func (fs *FileSystemStore) getLockPath() string {
+	return fs.getTokenPath() + constants.LockFileSuffix
+}
+
+// getProjectPath returns the full path to the project file, next to the token file.
+func (fs *FileSystemStore) getProjectPath() string {
+	return fs.baseDir + constants.ProjectFileName
+}
+
+// getRefreshStatePath returns the full path to the refresh state file, next to the token file.
+func (fs *FileSystemStore) getRefreshStatePath() string {
+	return fs.baseDir + constants.RefreshStateFileName
+}
+
 // Sentinel errors for storage operations
 var (
 	ErrStorageNotFound   = errors.New("storage item not found")
 	ErrStorageCorrupted  = errors.New("storage data corrupted")
 	ErrStoragePermission = errors.New("storage permission denied")
+
+	// ErrKeyringUnavailable is returned by KeyringStore when the OS keyring cannot be
+	// used, for example because no Secret Service is running
+	ErrKeyringUnavailable = errors.New("keyring not available")
 )
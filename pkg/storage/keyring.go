@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+// KeyringStore implements CredentialStore using the OS keyring: the macOS Keychain,
+// the Windows Credential Manager or the Secret Service on Linux. The token is stored
+// as JSON in a single entry identified by a service name and an account.
+//
+// The CodeAssist project is not cached by this store.
+type KeyringStore struct {
+	service string
+	account string
+}
+
+// NewKeyringStore creates a credential store using the keyring entry of the given
+// service and account. Both must be non-empty. Whether a keyring backend is available
+// is only known once the store is used; operations then fail with ErrKeyringUnavailable.
+func NewKeyringStore(service, account string) (*KeyringStore, error) {
+	if service == "" || account == "" {
+		return nil, errors.New("keyring service and account must not be empty")
+	}
+	return &KeyringStore{service: service, account: account}, nil
+}
+
+// LoadToken implements CredentialStore.LoadToken.
+func (ks *KeyringStore) LoadToken() (*oauth2.Token, error) {
+	path := ks.GetStoragePath()
+	data, err := keyring.Get(ks.service, ks.account)
+	if err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return nil, fmt.Errorf("no token in keyring at %s: %w", path, ErrStorageNotFound)
+		}
+		return nil, fmt.Errorf("failed to read token from keyring at %s: %w: %v", path, ErrKeyringUnavailable, err)
+	}
+
+	var token storedToken
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token JSON at %s: %w", path, ErrStorageCorrupted)
+	}
+
+	return token.oauth2Token(), nil
+}
+
+// StoreToken implements CredentialStore.StoreToken.
+func (ks *KeyringStore) StoreToken(token *oauth2.Token) error {
+	path := ks.GetStoragePath()
+	if token == nil {
+		return fmt.Errorf("cannot store a nil token at %s", path)
+	}
+	data, err := json.Marshal(newStoredToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to marshal token to JSON for %s: %w", path, err)
+	}
+
+	if err := keyring.Set(ks.service, ks.account, string(data)); err != nil {
+		if errors.Is(err, keyring.ErrSetDataTooBig) {
+			return fmt.Errorf("token is too large for the keyring at %s: %w", path, err)
+		}
+		return fmt.Errorf("failed to write token to keyring at %s: %w: %v", path, ErrKeyringUnavailable, err)
+	}
+
+	return nil
+}
+
+// ClearToken implements CredentialStore.ClearToken.
+// Clearing a store without a token succeeds.
+func (ks *KeyringStore) ClearToken() error {
+	if err := keyring.Delete(ks.service, ks.account); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("failed to remove token from keyring at %s: %w: %v", ks.GetStoragePath(), ErrKeyringUnavailable, err)
+	}
+	return nil
+}
+
+// HasToken implements CredentialStore.HasToken.
+// It reports whether the keyring entry exists without decoding it, and false if the
+// keyring is not available.
+func (ks *KeyringStore) HasToken() bool {
+	_, err := keyring.Get(ks.service, ks.account)
+	return err == nil
+}
+
+// GetStoragePath implements CredentialStore.GetStoragePath.
+// It returns the entry as keyring://service/account.
+func (ks *KeyringStore) GetStoragePath() string {
+	return constants.KeyringStoragePrefix + ks.service + "/" + ks.account
+}
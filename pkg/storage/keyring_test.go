@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+var _ CredentialStore = (*KeyringStore)(nil)
+
+func TestKeyringStore(t *testing.T) {
+	keyring.MockInit()
+
+	if _, err := NewKeyringStore("", "user"); err == nil {
+		t.Error("Expected an error for an empty service")
+	}
+	store, err := NewKeyringStore("geminiwebtools", "user@example.com")
+	if err != nil {
+		t.Fatalf("NewKeyringStore returned error: %v", err)
+	}
+	if got := store.GetStoragePath(); got != "keyring://geminiwebtools/user@example.com" {
+		t.Errorf("GetStoragePath() = %q", got)
+	}
+
+	if store.HasToken() {
+		t.Error("Expected new store to be empty")
+	}
+	if _, err := store.LoadToken(); !errors.Is(err, ErrStorageNotFound) {
+		t.Errorf("Expected ErrStorageNotFound, got %v", err)
+	}
+
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer"}
+	if err := store.StoreToken(token); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	if !store.HasToken() {
+		t.Error("Expected store to have a token")
+	}
+	loaded, err := store.LoadToken()
+	if err != nil {
+		t.Fatalf("LoadToken returned error: %v", err)
+	}
+	if loaded.AccessToken != "access" || loaded.RefreshToken != "refresh" || loaded.TokenType != "Bearer" {
+		t.Errorf("Unexpected token: %+v", loaded)
+	}
+
+	if err := keyring.Set("geminiwebtools", "user@example.com", "not json"); err != nil {
+		t.Fatalf("keyring.Set returned error: %v", err)
+	}
+	if _, err := store.LoadToken(); !errors.Is(err, ErrStorageCorrupted) {
+		t.Errorf("Expected ErrStorageCorrupted, got %v", err)
+	}
+
+	if err := store.ClearToken(); err != nil {
+		t.Fatalf("ClearToken returned error: %v", err)
+	}
+	if store.HasToken() {
+		t.Error("Expected store to be empty after ClearToken")
+	}
+	if err := store.ClearToken(); err != nil {
+		t.Errorf("Expected clearing an empty store to succeed, got %v", err)
+	}
+}
+
+func TestKeyringStoreUnavailable(t *testing.T) {
+	keyring.MockInitWithError(keyring.ErrUnsupportedPlatform)
+	defer keyring.MockInit()
+
+	store, err := NewKeyringStore("geminiwebtools", "user")
+	if err != nil {
+		t.Fatalf("NewKeyringStore returned error: %v", err)
+	}
+
+	if store.HasToken() {
+		t.Error("Expected HasToken to be false without a keyring")
+	}
+	if _, err := store.LoadToken(); !errors.Is(err, ErrKeyringUnavailable) {
+		t.Errorf("LoadToken: expected ErrKeyringUnavailable, got %v", err)
+	}
+	if err := store.StoreToken(&oauth2.Token{AccessToken: "access"}); !errors.Is(err, ErrKeyringUnavailable) {
+		t.Errorf("StoreToken: expected ErrKeyringUnavailable, got %v", err)
+	}
+	if err := store.ClearToken(); !errors.Is(err, ErrKeyringUnavailable) {
+		t.Errorf("ClearToken: expected ErrKeyringUnavailable, got %v", err)
+	}
+}
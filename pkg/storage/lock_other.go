@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package storage
+
+// lockFile does not lock on platforms without file locking support.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// lockHelperDirEnv names the store directory of a subprocess started by
+// TestFileSystemStoreConcurrentUpdates.
+const lockHelperDirEnv = "GEMINIWEBTOOLS_LOCK_HELPER_DIR"
+
+const lockTestUpdates = 50
+
+var _ TokenUpdater = (*FileSystemStore)(nil)
+var _ TokenUpdater = (*EncryptedFileSystemStore)(nil)
+
+// incrementToken increments the counter kept in the access token of the store.
+func incrementToken(store *FileSystemStore) error {
+	return store.UpdateToken(func(current *oauth2.Token) (*oauth2.Token, error) {
+		n := 0
+		if current != nil {
+			n, _ = strconv.Atoi(current.AccessToken)
+		}
+		return &oauth2.Token{AccessToken: strconv.Itoa(n + 1)}, nil
+	})
+}
+
+// TestFileSystemStoreLockHelper increments the counter of the store named by
+// lockHelperDirEnv when run as a subprocess; otherwise it does nothing.
+func TestFileSystemStoreLockHelper(t *testing.T) {
+	dir := os.Getenv(lockHelperDirEnv)
+	if dir == "" {
+		t.Skip("only run as a subprocess")
+	}
+	store, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSystemStore returned error: %v", err)
+	}
+	for range lockTestUpdates {
+		if err := incrementToken(store); err != nil {
+			t.Fatalf("UpdateToken returned error: %v", err)
+		}
+	}
+}
+
+func TestFileSystemStoreConcurrentUpdates(t *testing.T) {
+	const goroutines, processes = 4, 2
+	dir := t.TempDir()
+	store, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSystemStore returned error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range processes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestFileSystemStoreLockHelper$")
+			cmd.Env = append(os.Environ(), lockHelperDirEnv+"="+dir)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("Helper process failed: %v\n%s", err, out)
+			}
+		}()
+	}
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range lockTestUpdates {
+				if err := incrementToken(store); err != nil {
+					t.Errorf("UpdateToken returned error: %v", err)
+					return
+				}
+				if _, err := store.LoadToken(); err != nil {
+					t.Errorf("LoadToken returned error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every increment is kept only if no update overwrote another
+	token, err := store.LoadToken()
+	if err != nil {
+		t.Fatalf("LoadToken returned error: %v", err)
+	}
+	if want := strconv.Itoa((goroutines + processes) * lockTestUpdates); token.AccessToken != want {
+		t.Errorf("Counter = %s, want %s", token.AccessToken, want)
+	}
+}
+
+func TestUpdateTokenKeepsCurrent(t *testing.T) {
+	store, err := NewFileSystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSystemStore returned error: %v", err)
+	}
+	if err := store.StoreToken(&oauth2.Token{AccessToken: "current"}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	info, err := os.Stat(store.getTokenPath())
+	if err != nil {
+		t.Fatalf("Stat returned error: %v", err)
+	}
+
+	err = store.UpdateToken(func(current *oauth2.Token) (*oauth2.Token, error) {
+		return current, nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateToken returned error: %v", err)
+	}
+	after, err := os.Stat(store.getTokenPath())
+	if err != nil || !os.SameFile(info, after) {
+		t.Errorf("Expected returning the current token not to rewrite the file")
+	}
+}
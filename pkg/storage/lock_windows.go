@@ -0,0 +1,32 @@
+//go:build windows
+
+package storage
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// lockFile exclusively locks the first byte of the file at path with LockFileEx,
+// creating the file if needed, and returns the function that releases it.
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, constants.FilePermissions)
+	if err != nil {
+		return nil, err
+	}
+
+	handle := windows.Handle(f.Fd())
+	overlapped := new(windows.Overlapped)
+	if err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, overlapped); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = windows.UnlockFileEx(handle, 0, 1, 0, overlapped)
+		_ = f.Close()
+	}, nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"golang.org/x/oauth2"
+)
+
+// InMemoryStore implements CredentialStore in memory, so credentials never touch the disk.
+// It is intended for tests and short-lived tools, and is safe for concurrent use.
+type InMemoryStore struct {
+	mu      sync.RWMutex
+	token   *oauth2.Token
+	project *ProjectInfo
+	refresh *RefreshStateRecord
+}
+
+// NewInMemoryStore creates a new, empty in-memory credential store.
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{}
+}
+
+// LoadToken implements CredentialStore.LoadToken.
+// It returns a copy of the stored token, so callers may modify it freely.
+func (ms *InMemoryStore) LoadToken() (*oauth2.Token, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if ms.token == nil {
+		return nil, fmt.Errorf("no token in memory store: %w", ErrStorageNotFound)
+	}
+	token := *ms.token
+	return &token, nil
+}
+
+// StoreToken implements CredentialStore.StoreToken.
+// It stores a copy of the token, so later changes by the caller are not visible.
+func (ms *InMemoryStore) StoreToken(token *oauth2.Token) error {
+	if token == nil {
+		return errors.New("token cannot be nil")
+	}
+
+	stored := *token
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.token = &stored
+	return nil
+}
+
+// ClearToken implements CredentialStore.ClearToken.
+func (ms *InMemoryStore) ClearToken() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.token = nil
+	return nil
+}
+
+// HasToken implements CredentialStore.HasToken.
+func (ms *InMemoryStore) HasToken() bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.token != nil
+}
+
+// GetStoragePath implements CredentialStore.GetStoragePath.
+func (ms *InMemoryStore) GetStoragePath() string {
+	return constants.MemoryStoragePath
+}
+
+// LoadProject implements ProjectStore.LoadProject.
+func (ms *InMemoryStore) LoadProject() (*ProjectInfo, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if ms.project == nil {
+		return nil, fmt.Errorf("no project in memory store: %w", ErrStorageNotFound)
+	}
+	info := *ms.project
+	return &info, nil
+}
+
+// StoreProject implements ProjectStore.StoreProject.
+func (ms *InMemoryStore) StoreProject(info *ProjectInfo) error {
+	if info == nil {
+		return errors.New("project info cannot be nil")
+	}
+
+	stored := *info
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.project = &stored
+	return nil
+}
+
+// ClearProject implements ProjectStore.ClearProject.
+func (ms *InMemoryStore) ClearProject() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.project = nil
+	return nil
+}
+
+// LoadRefreshState implements RefreshStateStore.LoadRefreshState.
+func (ms *InMemoryStore) LoadRefreshState() (*RefreshStateRecord, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if ms.refresh == nil {
+		return nil, fmt.Errorf("no refresh state in memory store: %w", ErrStorageNotFound)
+	}
+	record := *ms.refresh
+	return &record, nil
+}
+
+// StoreRefreshState implements RefreshStateStore.StoreRefreshState.
+func (ms *InMemoryStore) StoreRefreshState(record *RefreshStateRecord) error {
+	if record == nil {
+		return errors.New("refresh state cannot be nil")
+	}
+
+	stored := *record
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.refresh = &stored
+	return nil
+}
+
+// ClearRefreshState implements RefreshStateStore.ClearRefreshState.
+func (ms *InMemoryStore) ClearRefreshState() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.refresh = nil
+	return nil
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"golang.org/x/oauth2"
+)
+
+var _ CredentialStore = (*InMemoryStore)(nil)
+
+func TestInMemoryStore(t *testing.T) {
+	store := NewInMemoryStore()
+
+	if store.HasToken() {
+		t.Error("Expected new store to be empty")
+	}
+	if _, err := store.LoadToken(); !errors.Is(err, ErrStorageNotFound) {
+		t.Errorf("Expected ErrStorageNotFound, got %v", err)
+	}
+	if err := store.StoreToken(nil); err == nil {
+		t.Error("Expected error storing a nil token")
+	}
+	if got := store.GetStoragePath(); got != constants.MemoryStoragePath {
+		t.Errorf("GetStoragePath() = %q, want %q", got, constants.MemoryStoragePath)
+	}
+
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}
+	if err := store.StoreToken(token); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	token.AccessToken = "changed"
+
+	loaded, err := store.LoadToken()
+	if err != nil {
+		t.Fatalf("LoadToken returned error: %v", err)
+	}
+	if loaded.AccessToken != "access" || loaded.RefreshToken != "refresh" {
+		t.Errorf("Expected stored copy of the token, got %+v", loaded)
+	}
+	loaded.AccessToken = "changed"
+	if again, _ := store.LoadToken(); again.AccessToken != "access" {
+		t.Error("Expected LoadToken to return a copy of the token")
+	}
+	if !store.HasToken() {
+		t.Error("Expected store to have a token")
+	}
+
+	if err := store.ClearToken(); err != nil {
+		t.Fatalf("ClearToken returned error: %v", err)
+	}
+	if store.HasToken() {
+		t.Error("Expected store to be empty after ClearToken")
+	}
+	if err := store.ClearToken(); err != nil {
+		t.Errorf("Expected clearing an empty store to succeed, got %v", err)
+	}
+}
+
+func TestInMemoryStoreConcurrentAccess(t *testing.T) {
+	store := NewInMemoryStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.StoreToken(&oauth2.Token{AccessToken: fmt.Sprintf("token-%d", i)}); err != nil {
+				t.Errorf("StoreToken returned error: %v", err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if token, err := store.LoadToken(); err == nil && token.AccessToken == "" {
+				t.Error("Loaded token without access token")
+			}
+			store.HasToken()
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if i%10 == 0 {
+				_ = store.ClearToken()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := store.StoreToken(&oauth2.Token{AccessToken: "final"}); err != nil {
+		t.Fatalf("StoreToken returned error: %v", err)
+	}
+	if token, err := store.LoadToken(); err != nil || token.AccessToken != "final" {
+		t.Errorf("Expected final token, got %+v, %v", token, err)
+	}
+}
@@ -0,0 +1,31 @@
+package storage
+
+import "time"
+
+// ProjectInfo records the last CodeAssist project that was initialized successfully,
+// so that later runs can skip project discovery and onboarding.
+type ProjectInfo struct {
+	ProjectID string `json:"projectId"`
+	Endpoint  string `json:"endpoint"`
+
+	// CredentialID identifies the credentials the project was discovered with,
+	// without revealing them.
+	CredentialID string `json:"credentialId"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ProjectStore is implemented by credential stores that can also persist the last
+// initialized CodeAssist project. It is optional: stores that do not implement it
+// simply run project discovery on every start.
+type ProjectStore interface {
+	// LoadProject loads the stored project information.
+	// Returns ErrStorageNotFound if none is stored.
+	LoadProject() (*ProjectInfo, error)
+
+	// StoreProject stores the project information.
+	StoreProject(info *ProjectInfo) error
+
+	// ClearProject removes the stored project information.
+	ClearProject() error
+}
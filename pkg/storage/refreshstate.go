@@ -0,0 +1,33 @@
+package storage
+
+import "time"
+
+// RefreshStateRecord is a compact record of the token refresh state, persisted so that
+// an authenticator knows after a restart that refreshes have been failing. It holds no
+// credentials, and LastError is redacted before it is stored.
+type RefreshStateRecord struct {
+	LastRefreshAttempt time.Time `json:"lastRefreshAttempt,omitempty"`
+	LastRefreshSuccess time.Time `json:"lastRefreshSuccess,omitempty"`
+
+	// RefreshAttempts is the number of consecutive failed refresh attempts
+	RefreshAttempts int `json:"refreshAttempts"`
+
+	LastError string `json:"lastError,omitempty"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// RefreshStateStore is implemented by credential stores that can also persist the
+// refresh state. It is optional: with other stores, the refresh state starts empty on
+// every start.
+type RefreshStateStore interface {
+	// LoadRefreshState loads the stored refresh state.
+	// Returns ErrStorageNotFound if none is stored.
+	LoadRefreshState() (*RefreshStateRecord, error)
+
+	// StoreRefreshState stores the refresh state.
+	StoreRefreshState(record *RefreshStateRecord) error
+
+	// ClearRefreshState removes the stored refresh state.
+	ClearRefreshState() error
+}
@@ -0,0 +1,207 @@
+package types
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MarshalSnakeCase returns the JSON encoding of v with snake_case keys instead of the
+// camelCase names of the json struct tags, for consumers that interoperate with
+// snake_case APIs. For example, the processingTimeMs field of WebSearchMetadata is
+// encoded as processing_time_ms.
+//
+// Only struct field names are renamed; map keys, such as header names, are kept as
+// they are. Tag options and values are encoded as by encoding/json, except that
+// embedded structs are encoded as regular fields rather than promoted.
+func MarshalSnakeCase(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeSnakeCase(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// encodeSnakeCase appends the snake_case JSON encoding of v to buf.
+func encodeSnakeCase(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteString("null")
+		return nil
+	}
+
+	// Types with their own encoding, such as time.Time, are encoded as they are
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return appendJSON(buf, v.Interface())
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return encodeSnakeCase(buf, v.Elem())
+	case reflect.Struct:
+		return encodeStruct(buf, v)
+	case reflect.Map:
+		return encodeMap(buf, v)
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendJSON(buf, v.Interface())
+		}
+		return encodeList(buf, v)
+	case reflect.Array:
+		return encodeList(buf, v)
+	default:
+		return appendJSON(buf, v.Interface())
+	}
+}
+
+func encodeStruct(buf *bytes.Buffer, v reflect.Value) error {
+	buf.WriteByte('{')
+	first := true
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		value := v.Field(i)
+		if hasTagOption(opts, "omitempty") && isEmptyValue(value) {
+			continue
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		if err := appendJSON(buf, toSnakeCase(name)); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := encodeSnakeCase(buf, value); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func encodeMap(buf *bytes.Buffer, v reflect.Value) error {
+	if v.IsNil() {
+		buf.WriteString("null")
+		return nil
+	}
+
+	// Keys are sorted like encoding/json does; only string keys occur in the result types
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := appendJSON(buf, key.String()); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := encodeSnakeCase(buf, v.MapIndex(key)); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func encodeList(buf *bytes.Buffer, v reflect.Value) error {
+	buf.WriteByte('[')
+	for i := range v.Len() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encodeSnakeCase(buf, v.Index(i)); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+// appendJSON appends the encoding/json encoding of v to buf.
+func appendJSON(buf *bytes.Buffer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+// hasTagOption reports whether the comma-separated json tag options contain option.
+func hasTagOption(opts, option string) bool {
+	for opts != "" {
+		var current string
+		current, opts, _ = strings.Cut(opts, ",")
+		if current == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyValue reports whether v is empty in the sense of the omitempty tag option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// toSnakeCase converts a camelCase name to snake_case. A run of capitals is treated
+// as one word, so "faviconURL" becomes "favicon_url" and "URLContext" "url_context".
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				prev := runes[i-1]
+				nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+					sb.WriteByte('_')
+				}
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
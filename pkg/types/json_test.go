@@ -0,0 +1,75 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalSnakeCase(t *testing.T) {
+	result := &WebFetchResult{
+		Summary:     "Fetched page",
+		Content:     "content",
+		DisplayText: "display",
+		Metadata: WebFetchMetadata{
+			URL:              "https://example.com",
+			ProcessingTimeMs: 42,
+			APIUsed:          "http",
+			UsedFallback:     true,
+			RequestHeaders:   map[string]string{"User-Agent": "test"},
+			TokenUsage:       &UsageMetadata{PromptTokenCount: 1, CandidatesTokenCount: 2, TotalTokenCount: 3},
+		},
+	}
+
+	camel, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	snake, err := MarshalSnakeCase(result)
+	if err != nil {
+		t.Fatalf("MarshalSnakeCase returned error: %v", err)
+	}
+
+	// Both encodings carry the same values under their respective key styles
+	want := `{"summary":"Fetched page","content":"content","display_text":"display","metadata":{"url":"https://example.com","prompt":"","processing_time_ms":42,"api_used":"http","has_grounding":false,"used_fallback":true,"token_usage":{"prompt_token_count":1,"candidates_token_count":2,"total_token_count":3},"request_headers":{"User-Agent":"test"}}}`
+	if string(snake) != want {
+		t.Errorf("MarshalSnakeCase() =\n%s\nwant\n%s", snake, want)
+	}
+	for _, key := range []string{`"displayText"`, `"processingTimeMs"`, `"apiUsed"`, `"tokenUsage"`, `"requestHeaders"`} {
+		if !strings.Contains(string(camel), key) {
+			t.Errorf("Expected the default encoding to keep camelCase key %s, got %s", key, camel)
+		}
+	}
+
+	var camelValue, snakeValue map[string]any
+	if err := json.Unmarshal(camel, &camelValue); err != nil {
+		t.Fatalf("Failed to decode camelCase JSON: %v", err)
+	}
+	if err := json.Unmarshal(snake, &snakeValue); err != nil {
+		t.Fatalf("Failed to decode snake_case JSON: %v", err)
+	}
+	if len(camelValue) != len(snakeValue) || len(camelValue["metadata"].(map[string]any)) != len(snakeValue["metadata"].(map[string]any)) {
+		t.Errorf("Expected the same fields in both encodings:\n%s\n%s", camel, snake)
+	}
+	if !reflect.DeepEqual(camelValue["metadata"].(map[string]any)["tokenUsage"], map[string]any{"promptTokenCount": 1.0, "candidatesTokenCount": 2.0, "totalTokenCount": 3.0}) {
+		t.Errorf("Unexpected camelCase token usage: %s", camel)
+	}
+}
+
+func TestToSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"summary":          "summary",
+		"displayText":      "display_text",
+		"processingTimeMs": "processing_time_ms",
+		"faviconURL":       "favicon_url",
+		"URLContext":       "url_context",
+		"chunk2Count":      "chunk2_count",
+		"Web":              "web",
+	}
+	for name, want := range tests {
+		if got := toSnakeCase(name); got != want {
+			t.Errorf("toSnakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
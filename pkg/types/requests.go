@@ -7,6 +7,18 @@ package types
 type GenerateContentRequest struct {
 	Contents []Content `json:"contents"`
 	Tools    []Tool    `json:"tools,omitempty"`
+
+	// GenerationConfig controls how the answer is generated; nil uses the model defaults
+	GenerationConfig *GenerationConfig `json:"generationConfig,omitempty"`
+
+	// Model overrides the client's model for this request; it is not part of the request body
+	Model string `json:"-"`
+}
+
+// GenerationConfig represents options of content generation.
+type GenerationConfig struct {
+	// CandidateCount is the number of candidate answers to generate; zero means one
+	CandidateCount int `json:"candidateCount,omitempty"`
 }
 
 // Content represents a piece of content in a conversation.
@@ -52,6 +64,17 @@ type CodeAssistVertexContentRequest struct {
 	Contents  []CodeAssistContent `json:"contents"`
 	Tools     []CodeAssistTool    `json:"tools,omitempty"`
 	SessionID string              `json:"session_id,omitempty"`
+
+	// GenerationConfig controls how the answer is generated
+	GenerationConfig *CodeAssistGenerationConfig `json:"generationConfig,omitempty"`
+
+	// Labels are key-value metadata attached to the request, such as the end-user ID
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// CodeAssistGenerationConfig represents generation options in CodeAssist format.
+type CodeAssistGenerationConfig struct {
+	CandidateCount int `json:"candidateCount,omitempty"`
 }
 
 // CodeAssistContent represents content in CodeAssist format.
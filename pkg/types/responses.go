@@ -1,8 +1,46 @@
 package types
 
+import (
+	"net/url"
+	"strings"
+)
+
 // GenerateContentResponse represents a response from content generation.
 type GenerateContentResponse struct {
-	Candidates []Candidate `json:"candidates"`
+	Candidates    []Candidate    `json:"candidates"`
+	UsageMetadata *UsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+// UsageMetadata represents the token counts of a content generation request.
+type UsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// Add returns the sum of both token counts, for operations that make several requests.
+// Either side may be nil; the result is nil only if both are.
+func (u *UsageMetadata) Add(other *UsageMetadata) *UsageMetadata {
+	if u == nil && other == nil {
+		return nil
+	}
+	var sum UsageMetadata
+	for _, usage := range []*UsageMetadata{u, other} {
+		if usage != nil {
+			sum.PromptTokenCount += usage.PromptTokenCount
+			sum.CandidatesTokenCount += usage.CandidatesTokenCount
+			sum.TotalTokenCount += usage.TotalTokenCount
+		}
+	}
+	return &sum
+}
+
+// StreamChunk is one event of a streamed content generation. The candidates of
+// Response hold the text generated since the previous chunk, and grounding and usage
+// metadata once the server reports them. A chunk with Err set ends a failed stream.
+type StreamChunk struct {
+	Response *GenerateContentResponse
+	Err      error
 }
 
 // Candidate represents a candidate response from the AI.
@@ -52,6 +90,87 @@ type GroundingChunk struct {
 		Title  string `json:"title"`
 		Domain string `json:"domain,omitempty"`
 	} `json:"web"`
+
+	// FaviconURL is the derived icon URL for the source domain. It is not fetched.
+	FaviconURL string `json:"faviconUrl,omitempty"`
+
+	// Snippets holds the response text segments supported by this source,
+	// taken from the grounding supports that reference it.
+	Snippets []string `json:"snippets,omitempty"`
+
+	// Fetched marks a source that is the page fetched directly by the HTTP fallback,
+	// rather than one reported by the model's grounding metadata.
+	Fetched bool `json:"fetched,omitempty"`
+}
+
+// groundingRedirectHost is the host used by Google Search grounding for
+// redirect URIs; it never identifies the actual source domain.
+const groundingRedirectHost = "vertexaisearch.cloud.google.com"
+
+// SourceDomain returns the domain the chunk was sourced from.
+// It prefers the explicit Domain field, then the URI host. Grounding redirect
+// URIs carry no domain information, so for those the title is used when it
+// looks like a domain name (which is how Google Search grounding reports it).
+func (c GroundingChunk) SourceDomain() string {
+	if c.Web.Domain != "" {
+		return strings.TrimPrefix(strings.ToLower(c.Web.Domain), "www.")
+	}
+
+	if parsed, err := url.Parse(c.Web.URI); err == nil {
+		host := strings.ToLower(parsed.Hostname())
+		if host != "" && host != groundingRedirectHost {
+			return strings.TrimPrefix(host, "www.")
+		}
+	}
+
+	return titleDomain(c.Web.Title)
+}
+
+// fileExtensions are common file name extensions. A title ending in one of them
+// names a file (such as "README.md") rather than a domain.
+var fileExtensions = map[string]bool{
+	"c": true, "cpp": true, "css": true, "csv": true, "gif": true, "go": true,
+	"gz": true, "h": true, "htm": true, "html": true, "java": true, "jpg": true,
+	"js": true, "json": true, "md": true, "pdf": true, "php": true, "png": true,
+	"py": true, "rb": true, "rs": true, "sh": true, "svg": true, "tar": true,
+	"ts": true, "txt": true, "xml": true, "yaml": true, "yml": true, "zip": true,
+}
+
+// titleDomain returns the title as a domain name if it is a lowercase host name
+// with an alphabetic top-level label that is not a common file extension.
+// Otherwise it returns an empty string.
+func titleDomain(title string) string {
+	title = strings.TrimSpace(title)
+	if title == "" || title != strings.ToLower(title) {
+		return ""
+	}
+
+	labels := strings.Split(title, ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return ""
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return ""
+			}
+		}
+	}
+
+	tld := labels[len(labels)-1]
+	if len(tld) < 2 || fileExtensions[tld] {
+		return ""
+	}
+	for _, r := range tld {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+
+	return strings.TrimPrefix(title, "www.")
 }
 
 // GroundingSupport represents grounding support with segment information.
@@ -73,7 +192,15 @@ type CodeAssistGenerateContentResponse struct {
 
 // CodeAssistVertexContentResponse represents the inner response from CodeAssist.
 type CodeAssistVertexContentResponse struct {
-	Candidates []CodeAssistCandidate `json:"candidates"`
+	Candidates    []CodeAssistCandidate    `json:"candidates"`
+	UsageMetadata *CodeAssistUsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+// CodeAssistUsageMetadata represents token counts in CodeAssist format.
+type CodeAssistUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
 }
 
 // CodeAssistCandidate represents a candidate in CodeAssist format.
@@ -119,8 +246,9 @@ type CodeAssistSearchEntryPoint struct {
 // CodeAssistGroundingChunk represents grounding chunk in CodeAssist format.
 type CodeAssistGroundingChunk struct {
 	Web struct {
-		URI   string `json:"uri"`
-		Title string `json:"title"`
+		URI    string `json:"uri"`
+		Title  string `json:"title"`
+		Domain string `json:"domain,omitempty"`
 	} `json:"web"`
 }
 
@@ -133,3 +261,29 @@ type CodeAssistGroundingSupport struct {
 	} `json:"segment"`
 	GroundingChunkIndices []int `json:"groundingChunkIndices"`
 }
+
+// ModelInfo describes a model available to the account.
+type ModelInfo struct {
+	// Name is the model name accepted by SetModel, without a "models/" prefix
+	Name string `json:"name"`
+
+	// DisplayName is the human-readable name of the model
+	DisplayName string `json:"displayName,omitempty"`
+
+	// Description is a short description of the model
+	Description string `json:"description,omitempty"`
+
+	// InputTokenLimit is the maximum number of input tokens, or zero if unknown
+	InputTokenLimit int `json:"inputTokenLimit,omitempty"`
+
+	// OutputTokenLimit is the maximum number of output tokens, or zero if unknown
+	OutputTokenLimit int `json:"outputTokenLimit,omitempty"`
+
+	// SupportedGenerationMethods lists the methods the model supports, such as "generateContent"
+	SupportedGenerationMethods []string `json:"supportedGenerationMethods,omitempty"`
+}
+
+// ListModelsResponse is the response of the CodeAssist listModels method.
+type ListModelsResponse struct {
+	Models []ModelInfo `json:"models"`
+}
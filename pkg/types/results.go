@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // WebFetchResult represents the result of a web fetch operation.
 // This structure is compatible with the gemini-cli ToolResult interface.
 type WebFetchResult struct {
@@ -34,10 +36,109 @@ type WebSearchResult struct {
 	// Sources contains the search result sources with citations
 	Sources []GroundingChunk `json:"sources,omitempty"`
 
+	// Candidates contains every answer returned for a search with
+	// SearchOptions.CandidateCount above one, best first. The other fields
+	// describe the first candidate.
+	Candidates []SearchCandidate `json:"candidates,omitempty"`
+
 	// Metadata contains additional information about the search operation
 	Metadata WebSearchMetadata `json:"metadata"`
 }
 
+// SearchCandidate is one of several answers to a web search, with its own sources.
+type SearchCandidate struct {
+	// Content is the text of the answer
+	Content string `json:"content"`
+
+	// DisplayText is the answer formatted with its citations and sources
+	DisplayText string `json:"displayText"`
+
+	// Sources contains the sources the answer is grounded in
+	Sources []GroundingChunk `json:"sources,omitempty"`
+
+	// FinishReason is the reason the model stopped generating the answer, such as STOP
+	FinishReason string `json:"finishReason,omitempty"`
+}
+
+// SearchDelta is an increment of the display text of a streamed web search.
+type SearchDelta struct {
+	// DisplayText is the display text added since the previous delta
+	DisplayText string `json:"displayText,omitempty"`
+
+	// Err is set on the last delta if the search failed after streaming started
+	Err error `json:"-"`
+}
+
+// EventType identifies a progress event of an operation.
+type EventType string
+
+// Progress events, in the order they are emitted. An operation emits either EventDone
+// or EventError last; EventFirstByte and EventGrounding only precede EventDone.
+const (
+	// EventStarted is emitted when the operation starts
+	EventStarted EventType = "started"
+
+	// EventFirstByte is emitted when the first part of the answer is available
+	EventFirstByte EventType = "first_byte"
+
+	// EventGrounding is emitted when the sources are available, if there are any
+	EventGrounding EventType = "grounding"
+
+	// EventDone is emitted with the result when the operation succeeds
+	EventDone EventType = "done"
+
+	// EventError is emitted with the error when the operation fails
+	EventError EventType = "error"
+)
+
+// SearchEvent is a progress event of a web search.
+type SearchEvent struct {
+	// Type is the kind of the event
+	Type EventType `json:"type"`
+
+	// Time is when the event occurred
+	Time time.Time `json:"time"`
+
+	// Sources contains the sources of an EventGrounding event
+	Sources []GroundingChunk `json:"sources,omitempty"`
+
+	// Result is the result of an EventDone event
+	Result *WebSearchResult `json:"result,omitempty"`
+
+	// Err is the error of an EventError event
+	Err error `json:"-"`
+}
+
+// FetchEvent is a progress event of a web fetch.
+type FetchEvent struct {
+	// Type is the kind of the event
+	Type EventType `json:"type"`
+
+	// Time is when the event occurred
+	Time time.Time `json:"time"`
+
+	// Sources contains the sources of an EventGrounding event
+	Sources []GroundingChunk `json:"sources,omitempty"`
+
+	// Result is the result of an EventDone event
+	Result *WebFetchResult `json:"result,omitempty"`
+
+	// Err is the error of an EventError event
+	Err error `json:"-"`
+}
+
+// Section is a part of a page delimited by an HTML heading.
+type Section struct {
+	// Level is the heading level (1-6), or 0 for content without a heading
+	Level int `json:"level"`
+
+	// Heading is the heading text
+	Heading string `json:"heading,omitempty"`
+
+	// Content is the text under the heading, up to the next heading
+	Content string `json:"content"`
+}
+
 // WebFetchMetadata contains metadata about a web fetch operation.
 type WebFetchMetadata struct {
 	// URL is the original URL that was fetched
@@ -52,10 +153,21 @@ type WebFetchMetadata struct {
 	// ContentSize is the size of the original content in bytes
 	ContentSize int `json:"contentSize,omitempty"`
 
+	// DetectedCharset is the charset the fallback fetch transcoded the content from.
+	// It is empty when no transcoding was needed, such as for UTF-8 or non-text content.
+	DetectedCharset string `json:"detectedCharset,omitempty"`
+
+	// CharsetConfidence tells how DetectedCharset was determined: CharsetDeclared,
+	// CharsetDetected or CharsetGuessed. It is empty when DetectedCharset is.
+	CharsetConfidence string `json:"charsetConfidence,omitempty"`
+
 	// ProcessingTime is the time taken to process the request
 	ProcessingTime string `json:"processingTime,omitempty"`
 
-	// APIUsed indicates which API was used (codeassist, gemini, fallback)
+	// ProcessingTimeMs is ProcessingTime in milliseconds, for aggregation
+	ProcessingTimeMs int64 `json:"processingTimeMs,omitempty"`
+
+	// APIUsed indicates which API was used (codeassist, gemini, fallback, http)
 	APIUsed string `json:"apiUsed"`
 
 	// HasGrounding indicates if grounding metadata was available
@@ -70,10 +182,76 @@ type WebFetchMetadata struct {
 	// UsedFallback indicates if fallback processing was used
 	UsedFallback bool `json:"usedFallback,omitempty"`
 
+	// ChunkCount is the number of chunks the page content was split into in large page mode
+	ChunkCount int `json:"chunkCount,omitempty"`
+
+	// PagesFetched is the number of pages fetched by WebFetcher.FetchPaginated
+	PagesFetched int `json:"pagesFetched,omitempty"`
+
+	// TokenUsage is the token usage reported by the API, summed over all requests of the fetch
+	TokenUsage *UsageMetadata `json:"tokenUsage,omitempty"`
+
+	// StatusCode is the HTTP status code returned by the fallback fetch, if any
+	StatusCode int `json:"statusCode,omitempty"`
+
+	// Protocol is the protocol of the fallback fetch response, such as "HTTP/2.0" or
+	// "HTTP/1.1"
+	Protocol string `json:"protocol,omitempty"`
+
+	// TLSVersion is the TLS version negotiated by the fallback fetch, such as "TLS 1.3".
+	// It is empty for requests without TLS.
+	TLSVersion string `json:"tlsVersion,omitempty"`
+
+	// TLSCipherSuite is the cipher suite negotiated by the fallback fetch, such as
+	// "TLS_AES_128_GCM_SHA256". It is empty for requests without TLS.
+	TLSCipherSuite string `json:"tlsCipherSuite,omitempty"`
+
+	// Partial reports that the fallback fetch timed out while reading the body and the
+	// content is only what was received until then
+	Partial bool `json:"partial,omitempty"`
+
+	// NoContent reports that the fallback fetch received a 204 No Content response,
+	// so the content is empty
+	NoContent bool `json:"noContent,omitempty"`
+
+	// DuplicateURLs are the URLs whose near-duplicate content was dropped from the
+	// results of WebFetcher.FetchMultiple in favor of this result
+	DuplicateURLs []string `json:"duplicateUrls,omitempty"`
+
+	// CacheHit reports that the result was served from the client's result cache
+	CacheHit bool `json:"cacheHit,omitempty"`
+
+	// AuditID is the audit ID of the request context, echoed for the caller's records.
+	// It is never sent upstream. See geminiwebtools.ContextWithAuditID.
+	AuditID string `json:"auditId,omitempty"`
+
+	// RequestHeaders are the request header fields sent by the fallback fetch, with
+	// sensitive values redacted. Only set when WebFetchConfig.DebugHeaders is enabled.
+	RequestHeaders map[string]string `json:"requestHeaders,omitempty"`
+
 	// Error contains error information if the operation failed
 	Error string `json:"error,omitempty"`
 }
 
+// Values of WebFetchMetadata.CharsetConfidence.
+const (
+	// CharsetDeclared means the charset was declared in the Content-Type header
+	CharsetDeclared = "declared"
+
+	// CharsetDetected means the charset was auto-detected from a byte order mark or a
+	// <meta> declaration in the content
+	CharsetDetected = "detected"
+
+	// CharsetGuessed means no charset was found and a default was assumed
+	CharsetGuessed = "guessed"
+)
+
+// SetProcessingTime sets ProcessingTime and ProcessingTimeMs from the same duration.
+func (m *WebFetchMetadata) SetProcessingTime(d time.Duration) {
+	m.ProcessingTime = d.String()
+	m.ProcessingTimeMs = d.Milliseconds()
+}
+
 // WebSearchMetadata contains metadata about a web search operation.
 type WebSearchMetadata struct {
 	// Query is the original search query
@@ -88,9 +266,15 @@ type WebSearchMetadata struct {
 	// BlockedDomains are the domains that were blocked from results
 	BlockedDomains []string `json:"blockedDomains,omitempty"`
 
+	// PreferredDomains are the domains that were ranked ahead of other sources
+	PreferredDomains []string `json:"preferredDomains,omitempty"`
+
 	// ProcessingTime is the time taken to process the search
 	ProcessingTime string `json:"processingTime,omitempty"`
 
+	// ProcessingTimeMs is ProcessingTime in milliseconds, for aggregation
+	ProcessingTimeMs int64 `json:"processingTimeMs,omitempty"`
+
 	// APIUsed indicates which API was used (codeassist, gemini, fallback)
 	APIUsed string `json:"apiUsed"`
 
@@ -106,10 +290,29 @@ type WebSearchMetadata struct {
 	// WebSearchQueries are the actual search queries used by the AI
 	WebSearchQueries []string `json:"webSearchQueries,omitempty"`
 
+	// TokenUsage is the token usage reported by the API, summed over all requests of the search
+	TokenUsage *UsageMetadata `json:"tokenUsage,omitempty"`
+
+	// RetriedOnEmpty indicates the search was retried because the first response was empty or ungrounded
+	RetriedOnEmpty bool `json:"retriedOnEmpty,omitempty"`
+
+	// CacheHit reports that the result was served from the client's result cache
+	CacheHit bool `json:"cacheHit,omitempty"`
+
+	// AuditID is the audit ID of the request context, echoed for the caller's records.
+	// It is never sent upstream. See geminiwebtools.ContextWithAuditID.
+	AuditID string `json:"auditId,omitempty"`
+
 	// Error contains error information if the search failed
 	Error string `json:"error,omitempty"`
 }
 
+// SetProcessingTime sets ProcessingTime and ProcessingTimeMs from the same duration.
+func (m *WebSearchMetadata) SetProcessingTime(d time.Duration) {
+	m.ProcessingTime = d.String()
+	m.ProcessingTimeMs = d.Milliseconds()
+}
+
 // SearchOptions contains options for web search operations.
 type SearchOptions struct {
 	// AllowedDomains restricts search results to these domains
@@ -123,6 +326,10 @@ type SearchOptions struct {
 
 	// SearchRegion specifies the region for search (if supported)
 	SearchRegion string `json:"searchRegion,omitempty"`
+
+	// CandidateCount asks the model for this many alternative answers, which are
+	// returned ranked in WebSearchResult.Candidates. Zero or one asks for one answer.
+	CandidateCount int `json:"candidateCount,omitempty"`
 }
 
 // FetchOptions contains options for web fetch operations.
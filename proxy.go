@@ -0,0 +1,69 @@
+package geminiwebtools
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyDefaultPorts are the ports used for proxy URLs without an explicit port.
+var proxyDefaultPorts = map[string]string{
+	"http":    "80",
+	"https":   "443",
+	"socks5":  "1080",
+	"socks5h": "1080",
+}
+
+// parseProxyURL parses a proxy URL and checks that its scheme is supported.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if _, ok := proxyDefaultPorts[parsed.Scheme]; !ok {
+		return nil, fmt.Errorf("unsupported proxy scheme %q (supported: http, https, socks5)", parsed.Scheme)
+	}
+	if parsed.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL has no host: %s", proxyURL)
+	}
+	return parsed, nil
+}
+
+// proxyAddr returns the host:port address dialed to reach the proxy.
+func proxyAddr(proxy *url.URL) string {
+	port := proxy.Port()
+	if port == "" {
+		port = proxyDefaultPorts[proxy.Scheme]
+	}
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
+
+// newProxyFunc returns an http.Transport Proxy function that sends requests through
+// proxyURL. Hosts matched by the NO_PROXY environment variable, read when the function
+// is created, and loopback addresses are connected to directly.
+func newProxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if _, err := parseProxyURL(proxyURL); err != nil {
+		return nil, err
+	}
+
+	proxyConfig := &httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    httpproxy.FromEnvironment().NoProxy,
+	}
+	proxyForURL := proxyConfig.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyForURL(req.URL)
+	}, nil
+}
+
+// failingProxy returns a Proxy function that fails every request with err, so that a
+// misconfigured proxy is reported instead of silently connecting directly.
+func failingProxy(err error) func(*http.Request) (*url.URL, error) {
+	return func(*http.Request) (*url.URL, error) {
+		return nil, err
+	}
+}
@@ -0,0 +1,119 @@
+package geminiwebtools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newRecordingProxy starts a fake forward proxy that answers every request itself and
+// records the absolute URLs it was asked for.
+func newRecordingProxy(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requested []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.String())
+		mu.Unlock()
+		_, _ = w.Write([]byte("proxied content"))
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requested...)
+	}
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	proxy, requested := newRecordingProxy(t)
+
+	// The proxy runs on a loopback address, which is allowed even without AllowPrivateIPs
+	config := DefaultHTTPClientConfig()
+	config.ProxyURL = proxy.URL
+	client := NewHTTPClient(config)
+
+	content, _, _, err := client.FetchContent(context.Background(), "http://proxied.invalid/page")
+	if err != nil {
+		t.Fatalf("FetchContent returned error: %v", err)
+	}
+	if content != "proxied content" {
+		t.Errorf("Content = %q, want the proxy's response", content)
+	}
+	if got := requested(); len(got) != 1 || got[0] != "http://proxied.invalid/page" {
+		t.Errorf("Expected the proxy to receive the request, got %v", got)
+	}
+
+	// Targets at private IPs are still rejected before reaching the proxy
+	if _, _, _, err := client.FetchContent(context.Background(), "http://10.0.0.1/"); err == nil || !strings.Contains(err.Error(), "private IP") {
+		t.Errorf("Expected a private target to be rejected, got %v", err)
+	}
+	if got := requested(); len(got) != 1 {
+		t.Errorf("Expected no further proxied requests, got %v", got)
+	}
+}
+
+func TestHTTPClientProxyNoProxy(t *testing.T) {
+	t.Setenv("NO_PROXY", "direct.invalid")
+	proxy, requested := newRecordingProxy(t)
+
+	config := DefaultHTTPClientConfig()
+	config.ProxyURL = proxy.URL
+	config.UserAgent = "no-proxy-test"
+	if _, _, _, err := NewHTTPClient(config).FetchContent(context.Background(), "http://direct.invalid/"); err == nil {
+		t.Error("Expected the direct connection to an unresolvable host to fail")
+	}
+	if got := requested(); len(got) != 0 {
+		t.Errorf("Expected NO_PROXY hosts to bypass the proxy, got %v", got)
+	}
+}
+
+func TestHTTPClientInvalidProxy(t *testing.T) {
+	config := DefaultHTTPClientConfig()
+	config.ProxyURL = "ftp://proxy.example.com"
+	_, _, _, err := NewHTTPClient(config).FetchContent(context.Background(), "http://example.com/")
+	if err == nil || !strings.Contains(err.Error(), "unsupported proxy scheme") {
+		t.Errorf("Expected an invalid proxy to fail the request, got %v", err)
+	}
+
+	cfg, err := NewConfigE(WithProxy("ftp://proxy.example.com"), WithCredentialStore(&mockTokenStore{}))
+	if err != nil {
+		t.Fatalf("NewConfigE returned error: %v", err)
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "ProxyURL") {
+		t.Errorf("Expected Validate to reject the proxy URL, got %v", err)
+	}
+}
+
+func TestNewProxyFunc(t *testing.T) {
+	t.Setenv("NO_PROXY", "")
+	for _, proxyURL := range []string{"http://proxy.example.com:3128", "https://proxy.example.com", "socks5://127.0.0.1:1080"} {
+		proxy, err := newProxyFunc(proxyURL)
+		if err != nil {
+			t.Fatalf("newProxyFunc(%q) returned error: %v", proxyURL, err)
+		}
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+		got, err := proxy(req)
+		if err != nil || got == nil || got.String() != proxyURL {
+			t.Errorf("Proxy for %q = %v, %v", proxyURL, got, err)
+		}
+	}
+}
+
+func TestConfigKeyIncludesProxy(t *testing.T) {
+	pool := &ClientPool{clients: make(map[string]*http.Client)}
+	direct := DefaultHTTPClientConfig()
+	proxied := DefaultHTTPClientConfig()
+	proxied.ProxyURL = "http://proxy.example.com:3128"
+
+	if pool.configKey(direct) == pool.configKey(proxied) {
+		t.Error("Expected differently proxied configurations to have different pool keys")
+	}
+	if pool.getOrCreateClient(direct) == pool.getOrCreateClient(proxied) {
+		t.Error("Expected differently proxied configurations to get different clients")
+	}
+}
@@ -0,0 +1,55 @@
+package geminiwebtools
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// parseRefresh returns the URL of a Refresh header value or a meta refresh content
+// attribute, such as "0; url=https://example.com/". Values without a URL only reload
+// the page and yield an empty string.
+func parseRefresh(value string) string {
+	value = strings.TrimLeft(value, " \t\n\f\r")
+	// The delay, whose value does not matter here
+	value = strings.TrimLeft(value, "0123456789.")
+	value = strings.TrimLeft(value, " \t\n\f\r")
+	if value == "" || (value[0] != ';' && value[0] != ',') {
+		return ""
+	}
+	value = strings.TrimLeft(value[1:], " \t\n\f\r")
+
+	// The URL may be given as url=... or on its own
+	if len(value) >= 3 && strings.EqualFold(value[:3], "url") {
+		if rest := strings.TrimLeft(value[3:], " \t\n\f\r"); strings.HasPrefix(rest, "=") {
+			value = strings.TrimLeft(rest[1:], " \t\n\f\r")
+		}
+	}
+	if value != "" && (value[0] == '"' || value[0] == '\'') {
+		quote := value[0]
+		value = value[1:]
+		if i := strings.IndexByte(value, quote); i >= 0 {
+			value = value[:i]
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// metaRefresh returns the URL of the first <meta http-equiv="refresh"> element of an
+// HTML document, or an empty string if it has none or it only reloads the page.
+func metaRefresh(htmlContent string) string {
+	doc, err := html.Parse(strings.NewReader(htmlContent))
+	if err != nil {
+		return ""
+	}
+	meta := findMatching(doc, func(n *html.Node) bool {
+		equiv, _ := attrValue(n, "http-equiv")
+		return n.DataAtom == atom.Meta && strings.EqualFold(strings.TrimSpace(equiv), "refresh")
+	})
+	if meta == nil {
+		return ""
+	}
+	content, _ := attrValue(meta, "content")
+	return parseRefresh(content)
+}
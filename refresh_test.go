@@ -0,0 +1,24 @@
+package geminiwebtools
+
+import "testing"
+
+func TestParseRefresh(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"0; url=https://example.com/next", "https://example.com/next"},
+		{"5;URL='/next'", "/next"},
+		{`3, url = "/next" `, "/next"},
+		{"0; /next", "/next"},
+		{"1.5; url=next?page=2", "next?page=2"},
+		{"30", ""},
+		{"", ""},
+		{"soon; url=/next", ""},
+	}
+	for _, tt := range tests {
+		if got := parseRefresh(tt.value); got != tt.want {
+			t.Errorf("parseRefresh(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package geminiwebtools
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// lookupIP resolves a host name; replaced by tests to resolve made-up hosts.
+var lookupIP = net.DefaultResolver.LookupIP
+
+// hostResolver resolves the hosts dialed by a pooled client, bounding the number of
+// lookups in flight so that a burst of fetches to many distinct hosts does not pile
+// up on the system resolver.
+type hostResolver struct {
+	sem chan struct{}
+}
+
+// newHostResolver creates a resolver allowing limit concurrent lookups, or
+// constants.DefaultMaxConcurrentDNSLookups if limit is zero or less.
+func newHostResolver(limit int) *hostResolver {
+	if limit <= 0 {
+		limit = constants.DefaultMaxConcurrentDNSLookups
+	}
+	return &hostResolver{sem: make(chan struct{}, limit)}
+}
+
+// lookup resolves host once a lookup slot is available. It returns the context error
+// if the context is done first.
+func (r *hostResolver) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	select {
+	case r.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a DNS lookup slot: %w", ctx.Err())
+	}
+	defer func() { <-r.sem }()
+
+	return lookupIP(ctx, "ip", host)
+}
@@ -0,0 +1,132 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchContentBoundsDNSLookups(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	// Every made-up host resolves to the test server after a short delay
+	var running, maxRunning atomic.Int32
+	original := lookupIP
+	lookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		if !strings.HasSuffix(host, ".test") {
+			return original(ctx, network, host)
+		}
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			current := maxRunning.Load()
+			if n <= current || maxRunning.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+	}
+	defer func() { lookupIP = original }()
+
+	config := DefaultHTTPClientConfig()
+	config.AllowPrivateIPs = true
+	config.MaxConcurrentDNSLookups = 3
+	hc := NewHTTPClient(config)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content, _, _, err := hc.FetchContent(context.Background(), fmt.Sprintf("http://host-%d.test:%s/", i, port))
+			if err != nil || content != "ok" {
+				t.Errorf("FetchContent of host %d returned %q, %v", i, content, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got != 3 {
+		t.Errorf("Expected at most 3 lookups in flight and the limit reached, got %d", got)
+	}
+}
+
+func TestRedirectsBoundDNSLookups(t *testing.T) {
+	var port string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, fmt.Sprintf("http://redirect%s.test:%s/", r.URL.Path[1:], port), http.StatusFound)
+	}))
+	defer server.Close()
+	_, port, _ = net.SplitHostPort(server.Listener.Addr().String())
+
+	// Redirect hosts resolve through the bounded resolver, which no system lookup would
+	var running, maxRunning, lookups atomic.Int32
+	original := lookupIP
+	lookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		if !strings.HasSuffix(host, ".test") {
+			return original(ctx, network, host)
+		}
+		lookups.Add(1)
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			current := maxRunning.Load()
+			if n <= current || maxRunning.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+	}
+	defer func() { lookupIP = original }()
+
+	config := DefaultHTTPClientConfig()
+	config.AllowPrivateIPs = true
+	config.MaxConcurrentDNSLookups = 2
+	hc := NewHTTPClient(config)
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Redirects to private addresses are rejected after resolving the host
+			_, _, _, err := hc.FetchContent(context.Background(), fmt.Sprintf("%s/%d", server.URL, i))
+			if err == nil || !strings.Contains(err.Error(), "redirect to private IP not allowed") {
+				t.Errorf("FetchContent %d returned %v, want a private redirect error", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := lookups.Load(); got != 10 {
+		t.Errorf("Expected 10 redirect lookups, got %d", got)
+	}
+	if got := maxRunning.Load(); got > 2 {
+		t.Errorf("Expected at most 2 lookups in flight, got %d", got)
+	}
+}
+
+func TestHostResolverContextCanceled(t *testing.T) {
+	resolver := newHostResolver(1)
+	resolver.sem <- struct{}{} // Hold the only slot
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := resolver.lookup(ctx, "localhost"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait for a lookup slot to end with the context, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/d-kuro/geminiwebtools/pkg/constants"
+)
+
+// RobotsDisallowedError is returned by the HTTP fallback when WebFetchConfig.RespectRobotsTxt
+// is enabled and the site's robots.txt disallows fetching the URL.
+type RobotsDisallowedError struct {
+	URL string
+}
+
+// Error implements the error interface.
+func (e *RobotsDisallowedError) Error() string {
+	return fmt.Sprintf("fetching %s is disallowed by robots.txt for user agent %s", e.URL, constants.RobotsTxtUserAgent)
+}
+
+// robotsRule is an Allow or Disallow rule of a robots.txt group.
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// robotsRules holds the rules of robots.txt that apply to this library's user agent.
+// A nil value allows every path.
+type robotsRules struct {
+	rules []robotsRule
+}
+
+// parseRobotsTxt parses a robots.txt file as specified by RFC 9309 and returns the
+// rules of the groups for userAgent, or of the "*" groups if none matches it.
+func parseRobotsTxt(content, userAgent string) *robotsRules {
+	var specific, wildcard []robotsRule
+	var matchesAgent, matchesWildcard bool
+	inAgentLines := false
+
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines start one group for all of them
+			if !inAgentLines {
+				matchesAgent, matchesWildcard = false, false
+				inAgentLines = true
+			}
+			if value == "*" {
+				matchesWildcard = true
+			} else if strings.EqualFold(value, userAgent) {
+				matchesAgent = true
+			}
+		case "allow", "disallow":
+			inAgentLines = false
+			// An empty disallow rule allows everything, like no rule at all
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{pattern: value, allow: key == "allow"}
+			if matchesAgent {
+				specific = append(specific, rule)
+			}
+			if matchesWildcard {
+				wildcard = append(wildcard, rule)
+			}
+		default:
+			// Other records, such as sitemap or crawl-delay, do not end the user-agent lines
+		}
+	}
+
+	if specific != nil {
+		return &robotsRules{rules: specific}
+	}
+	return &robotsRules{rules: wildcard}
+}
+
+// allowed reports whether the path, including any query, may be fetched. The most
+// specific matching rule wins, and allow rules win ties.
+func (r *robotsRules) allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+
+	allow, longest := true, -1
+	for _, rule := range r.rules {
+		if !robotsPatternMatches(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allow, longest = rule.allow, len(rule.pattern)
+		}
+	}
+	return allow
+}
+
+// robotsPatternMatches reports whether a robots.txt path pattern matches the path.
+// A "*" matches any sequence of characters and a trailing "$" anchors the pattern at
+// the end of the path; otherwise the pattern matches path prefixes.
+func robotsPatternMatches(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		// The last part of an anchored pattern must end the path
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+	return !anchored || rest == ""
+}
+
+// robotsEntry is a cached robots.txt of a site.
+type robotsEntry struct {
+	rules   *robotsRules
+	expires time.Time
+}
+
+// robotsCache caches the parsed robots.txt of each site for a TTL.
+type robotsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]robotsEntry
+}
+
+// newRobotsCache creates a cache whose entries expire after ttl, or after
+// constants.DefaultRobotsTxtCacheTTL if ttl is not positive.
+func newRobotsCache(ttl time.Duration) *robotsCache {
+	if ttl <= 0 {
+		ttl = constants.DefaultRobotsTxtCacheTTL
+	}
+	return &robotsCache{
+		ttl:     ttl,
+		entries: make(map[string]robotsEntry),
+	}
+}
+
+// get returns the cached rules of a site, if they have not expired.
+func (c *robotsCache) get(site string) (*robotsRules, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[site]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.entries, site)
+		return nil, false
+	}
+	return entry.rules, true
+}
+
+// put caches the rules of a site.
+func (c *robotsCache) put(site string, rules *robotsRules) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[site] = robotsEntry{rules: rules, expires: time.Now().Add(c.ttl)}
+}
+
+// checkRobotsTxt returns a RobotsDisallowedError if the robots.txt of the URL's site
+// disallows fetching it. The robots.txt is fetched with the fallback HTTP client and
+// cached per site. A robots.txt that does not exist, or any other client error
+// response, allows every URL; other failures to fetch it are returned as errors.
+func (wf *WebFetcher) checkRobotsTxt(ctx context.Context, pageURL string) error {
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL format: %w", err)
+	}
+	site := parsedURL.Scheme + "://" + parsedURL.Host
+
+	rules, ok := wf.robots.get(site)
+	if !ok {
+		rules, err = wf.fetchRobotsTxt(ctx, site)
+		if err != nil {
+			return err
+		}
+		wf.robots.put(site, rules)
+	}
+
+	path := parsedURL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if parsedURL.RawQuery != "" {
+		path += "?" + parsedURL.RawQuery
+	}
+	if !rules.allowed(path) {
+		return &RobotsDisallowedError{URL: pageURL}
+	}
+	return nil
+}
+
+// fetchRobotsTxt fetches and parses the robots.txt of a site.
+func (wf *WebFetcher) fetchRobotsTxt(ctx context.Context, site string) (*robotsRules, error) {
+	content, _, _, err := wf.httpClient.FetchContent(ctx, site+constants.RobotsTxtPath)
+	if err != nil {
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode >= http.StatusBadRequest && statusErr.StatusCode < http.StatusInternalServerError {
+			return nil, nil
+		}
+		// An oversized robots.txt is used up to the truncation point
+		if content == "" {
+			return nil, fmt.Errorf("failed to fetch robots.txt: %w", err)
+		}
+	}
+	if len(content) > constants.RobotsTxtMaxSize {
+		content = content[:constants.RobotsTxtMaxSize]
+	}
+	return parseRobotsTxt(content, constants.RobotsTxtUserAgent), nil
+}
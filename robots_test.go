@@ -0,0 +1,114 @@
+package geminiwebtools
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRobotsTxt = `# Rules for all crawlers
+User-agent: *
+Disallow: /private
+Disallow: /*.pdf$
+
+User-agent: other-bot
+User-agent: GeminiWebTools
+Disallow: /secret
+Allow: /secret/public
+Disallow: /search?q=
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestRobotsRulesAllowed(t *testing.T) {
+	specific := parseRobotsTxt(testRobotsTxt, "geminiwebtools")
+	wildcard := parseRobotsTxt(testRobotsTxt, "unknown-bot")
+
+	tests := []struct {
+		rules *robotsRules
+		path  string
+		want  bool
+	}{
+		{specific, "/", true},
+		{specific, "/secret", false},
+		{specific, "/secret/page", false},
+		{specific, "/secret/public/page", true},
+		{specific, "/search?q=go", false},
+		{specific, "/private", true}, // The specific group replaces the "*" group
+		{wildcard, "/private/page", false},
+		{wildcard, "/docs/file.pdf", false},
+		{wildcard, "/docs/file.pdf?download=1", true},
+		{wildcard, "/secret", true},
+		{nil, "/anything", true},
+		{parseRobotsTxt("User-agent: *\nDisallow:\n", "geminiwebtools"), "/", true},
+	}
+	for _, tt := range tests {
+		if got := tt.rules.allowed(tt.path); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFetchWithHTTPRespectsRobotsTxt(t *testing.T) {
+	var robotsFetches atomic.Int32
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches.Add(1)
+			_, _ = w.Write([]byte(testRobotsTxt))
+			return
+		}
+		_, _ = w.Write([]byte("page content"))
+	}))
+	defer page.Close()
+
+	fetcher, err := NewWebFetcher(newTestConfig("", WithRespectRobotsTxt(true)))
+	if err != nil {
+		t.Fatalf("NewWebFetcher returned error: %v", err)
+	}
+	baseURL := useTestPageServer(fetcher, page)
+
+	result, err := fetcher.fetchWithHTTP(context.Background(), baseURL+"/secret/page", "", time.Now())
+	var robotsErr *RobotsDisallowedError
+	if !errors.As(err, &robotsErr) {
+		t.Fatalf("Expected a RobotsDisallowedError, got %v", err)
+	}
+	if !strings.Contains(result.Metadata.Error, "disallowed by robots.txt") || result.Content != "" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	result, err = fetcher.fetchWithHTTP(context.Background(), baseURL+"/secret/public/page", "", time.Now())
+	if err != nil {
+		t.Fatalf("Expected an allowed path to be fetched, got %v", err)
+	}
+	if result.Content != "page content" {
+		t.Errorf("Content = %q, want the page content", result.Content)
+	}
+	if got := robotsFetches.Load(); got != 1 {
+		t.Errorf("Expected robots.txt to be fetched once and cached, got %d fetches", got)
+	}
+}
+
+func TestFetchWithHTTPMissingRobotsTxt(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("page content"))
+	}))
+	defer page.Close()
+
+	fetcher, err := NewWebFetcher(newTestConfig("", WithRespectRobotsTxt(true)))
+	if err != nil {
+		t.Fatalf("NewWebFetcher returned error: %v", err)
+	}
+	baseURL := useTestPageServer(fetcher, page)
+
+	if _, err := fetcher.fetchWithHTTP(context.Background(), baseURL+"/secret", "", time.Now()); err != nil {
+		t.Errorf("Expected a missing robots.txt to allow every path, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package geminiwebtools
+
+import (
+	"strings"
+	"unicode"
+)
+
+// codeBlocks selects which code blocks sanitizeText leaves untouched.
+// Values can be combined.
+type codeBlocks int
+
+const (
+	// markdownCodeBlocks preserves fenced markdown code blocks.
+	markdownCodeBlocks codeBlocks = 1 << iota
+	// htmlCodeBlocks preserves the content of <pre> and <code> elements in raw HTML.
+	htmlCodeBlocks
+
+	// noCodeBlocks preserves nothing.
+	noCodeBlocks codeBlocks = 0
+)
+
+// htmlCodeTags are the elements whose content htmlCodeBlocks preserves.
+var htmlCodeTags = []string{"pre", "code"}
+
+// sanitizeText cleans extracted text before it is returned to the caller.
+// It strips control characters (except tab and newline) and zero-width characters,
+// normalizes Unicode whitespace to plain spaces, trims trailing whitespace and collapses
+// runs of blank lines into a single blank line.
+// Lines inside the code blocks selected by preserve keep their whitespace and blank
+// lines as-is.
+func sanitizeText(text string, preserve codeBlocks) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Map(sanitizeRune, text)
+
+	lines := strings.Split(text, "\n")
+	result := make([]string, 0, len(lines))
+	inCodeBlock := false
+	htmlDepth := 0
+	blankRun := 0
+
+	for _, line := range lines {
+		if preserve&htmlCodeBlocks != 0 {
+			depthBefore := htmlDepth
+			htmlDepth = htmlCodeDepth(line, htmlDepth)
+			if depthBefore > 0 || htmlDepth > 0 {
+				result = append(result, line)
+				blankRun = 0
+				continue
+			}
+		}
+
+		isFence := preserve&markdownCodeBlocks != 0 && strings.HasPrefix(strings.TrimSpace(line), "```")
+
+		if inCodeBlock && !isFence {
+			result = append(result, line)
+			continue
+		}
+		if isFence {
+			inCodeBlock = !inCodeBlock
+		}
+
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" {
+			blankRun++
+			if blankRun > 1 {
+				continue
+			}
+		} else {
+			blankRun = 0
+		}
+		result = append(result, line)
+	}
+
+	return strings.Trim(strings.Join(result, "\n"), "\n")
+}
+
+// htmlCodeDepth returns the nesting depth of preserved HTML code elements after line,
+// given the depth before it.
+func htmlCodeDepth(line string, depth int) int {
+	lower := strings.ToLower(line)
+	for i := 0; i < len(lower); i++ {
+		if lower[i] != '<' {
+			continue
+		}
+		rest := lower[i+1:]
+		closing := strings.HasPrefix(rest, "/")
+		rest = strings.TrimPrefix(rest, "/")
+		for _, tag := range htmlCodeTags {
+			if len(rest) <= len(tag) || !strings.HasPrefix(rest, tag) || !isTagNameEnd(rest[len(tag)]) {
+				continue
+			}
+			if !closing {
+				depth++
+			} else if depth > 0 {
+				depth--
+			}
+			break
+		}
+	}
+	return depth
+}
+
+// isTagNameEnd reports whether c terminates an HTML tag name.
+func isTagNameEnd(c byte) bool {
+	return c == '>' || c == '/' || isSpaceByte(c)
+}
+
+// sanitizeRune maps a single rune for sanitizeText.
+// It returns -1 for runes that should be dropped.
+func sanitizeRune(r rune) rune {
+	switch r {
+	case '\t', '\n':
+		return r
+	case '\r', '\u0085', '\u2028', '\u2029':
+		return '\n'
+	case '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff', '\u00ad':
+		// Zero-width characters and soft hyphens
+		return -1
+	}
+
+	if unicode.IsControl(r) {
+		return -1
+	}
+	if unicode.IsSpace(r) {
+		return ' '
+	}
+	return r
+}
@@ -0,0 +1,123 @@
+package geminiwebtools
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		preserve codeBlocks
+		expected string
+	}{
+		{
+			name:     "strips control characters",
+			input:    "hello\x00\x07 world\x1b",
+			expected: "hello world",
+		},
+		{
+			name:     "keeps tabs and newlines",
+			input:    "a\tb\nc",
+			expected: "a\tb\nc",
+		},
+		{
+			name:     "strips zero-width characters",
+			input:    "zero\u200bwidth\u200c\u200d\ufeff text",
+			expected: "zerowidth text",
+		},
+		{
+			name:     "normalizes unicode whitespace",
+			input:    "non\u00a0breaking\u3000space here",
+			expected: "non breaking space here",
+		},
+		{
+			name:     "normalizes line endings",
+			input:    "line1\r\nline2\rline3\u2028line4",
+			expected: "line1\nline2\nline3\nline4",
+		},
+		{
+			name:     "collapses blank lines",
+			input:    "para1\n\n\n\n \t\npara2\n\n\n",
+			expected: "para1\n\npara2",
+		},
+		{
+			name:     "trims trailing whitespace",
+			input:    "text   \nmore\t",
+			expected: "text\nmore",
+		},
+		{
+			name:     "preserves code block whitespace",
+			input:    "intro\n\n\n```go\nfunc main() {\n\n\n\tx := 1   \n}\n```\n\n\n\noutro",
+			preserve: markdownCodeBlocks,
+			expected: "intro\n\n```go\nfunc main() {\n\n\n\tx := 1   \n}\n```\n\noutro",
+		},
+		{
+			name:     "strips zero-width characters in code blocks",
+			input:    "```\nx\u200b := 1\x00\n```",
+			preserve: markdownCodeBlocks,
+			expected: "```\nx := 1\n```",
+		},
+		{
+			name:     "ignores code fences without markdown conversion",
+			input:    "```\na   \n\n\n\nb\n```",
+			expected: "```\na\n\nb\n```",
+		},
+		{
+			name:     "preserves pre blocks in raw HTML",
+			input:    "<p>intro</p>   \n\n\n<pre>func main() {\n\n\n\tx := 1   \n}</pre>\n\n\n<p>outro</p>",
+			preserve: htmlCodeBlocks,
+			expected: "<p>intro</p>\n\n<pre>func main() {\n\n\n\tx := 1   \n}</pre>\n\n<p>outro</p>",
+		},
+		{
+			name:     "preserves multi-line code elements in raw HTML",
+			input:    "<CODE class=\"go\">a   \n\n\nb</CODE>\ntext   ",
+			preserve: htmlCodeBlocks,
+			expected: "<CODE class=\"go\">a   \n\n\nb</CODE>\ntext",
+		},
+		{
+			name:     "ignores pre blocks without HTML preservation",
+			input:    "<pre>a   \n\n\nb</pre>",
+			expected: "<pre>a\n\nb</pre>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := sanitizeText(tt.input, tt.preserve)
+			if result != tt.expected {
+				t.Errorf("sanitizeText(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProcessHTTPResponsePreservesPreBlocks(t *testing.T) {
+	page := "<html><body>\n<p>Example</p>   \n\n\n<pre>\nline 1   \n\n\nline 2\n</pre>\n</body></html>"
+	wantBlocks := map[bool]string{
+		true:  "```\nline 1   \n\n\nline 2\n```",
+		false: "<pre>\nline 1   \n\n\nline 2\n</pre>",
+	}
+
+	for convertHTML, wantBlock := range wantBlocks {
+		config := newTestConfig("")
+		config.WebFetch.ConvertHTML = convertHTML
+		fetcher, err := NewWebFetcher(config)
+		if err != nil {
+			t.Fatalf("Failed to create fetcher: %v", err)
+		}
+
+		result, err := fetcher.processHTTPResponse(page, "text/html", len(page), "https://example.com", "", time.Now())
+		if err != nil {
+			t.Fatalf("processHTTPResponse returned error: %v", err)
+		}
+		if !strings.Contains(result.Content, wantBlock) {
+			t.Errorf("ConvertHTML=%v: expected pre block to be preserved, got %q", convertHTML, result.Content)
+		}
+		if strings.Contains(result.Content, "</p>   ") || strings.Contains(result.Content, "Example   ") {
+			t.Errorf("ConvertHTML=%v: expected trailing whitespace outside pre to be trimmed, got %q", convertHTML, result.Content)
+		}
+	}
+}
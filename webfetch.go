@@ -2,7 +2,10 @@ package geminiwebtools
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"mime"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
@@ -13,10 +16,14 @@ import (
 	"github.com/d-kuro/geminiwebtools/pkg/types"
 )
 
-// extractUrls extracts URLs from a string using regex
-func extractUrls(text string) []string {
+// extractUrls extracts up to limit URLs from a string using regex, in document order.
+// A limit of zero or less extracts all URLs.
+func extractUrls(text string, limit int) []string {
+	if limit <= 0 {
+		limit = -1
+	}
 	urlRegex := regexp.MustCompile(constants.URLRegexPattern)
-	return urlRegex.FindAllString(text, -1)
+	return urlRegex.FindAllString(text, limit)
 }
 
 // validateURL performs comprehensive URL validation
@@ -67,6 +74,15 @@ func convertGitHubBlobURL(url string) string {
 	return url
 }
 
+// directFetchURL returns the URL to download for a page fetched directly over HTTP.
+// GitHub blob URLs are rewritten to their raw file URLs unless disabled in the configuration.
+func (wf *WebFetcher) directFetchURL(pageURL string) string {
+	if !wf.config.WebFetch.RewriteGitHubBlob {
+		return pageURL
+	}
+	return convertGitHubBlobURL(pageURL)
+}
+
 // WebFetcher provides web content fetching functionality using Google's AI with OAuth2 authentication.
 type WebFetcher struct {
 	config     *Config
@@ -74,34 +90,68 @@ type WebFetcher struct {
 	codeAssist *auth.CodeAssistClient
 	grounding  *GroundingProcessor
 	httpClient *HTTPClient
+
+	// robots caches robots.txt rules for the HTTP fallback
+	robots *robotsCache
+
+	// cache holds the results of earlier fetches, or is nil if caching is disabled
+	cache *resultCache[*types.WebFetchResult]
 }
 
 // NewWebFetcher creates a new web fetcher with the provided configuration.
 func NewWebFetcher(config *Config) (*WebFetcher, error) {
 	if config == nil {
-		config = NewConfig()
+		var err error
+		if config, err = NewConfigE(); err != nil {
+			return nil, err
+		}
 	}
 
-	// Create OAuth2 authenticator and wrap with shared authenticator
-	oauth2Auth := auth.NewOAuth2Authenticator(config.OAuth2Config, config.CredentialStore)
+	oauth2Auth := newOAuth2Authenticator(config)
+	return newWebFetcher(config, oauth2Auth, newCodeAssistClient(config, oauth2Auth))
+}
+
+// newWebFetcher creates a web fetcher that uses the given OAuth2 authenticator and CodeAssist client.
+func newWebFetcher(config *Config, oauth2Auth *auth.OAuth2Authenticator, codeAssist *auth.CodeAssistClient) (*WebFetcher, error) {
 	sharedAuth := auth.NewSharedAuthenticator(oauth2Auth)
 
-	// Create CodeAssist client
-	codeAssist := auth.NewCodeAssistClient(
-		oauth2Auth,
-		config.CodeAssistEndpoint,
-		config.DefaultModel,
-	)
+	if config.WebFetch.AllowFileScheme && config.Logger != nil {
+		config.Logger.Warn("file:// URLs are enabled for fetches; use this only for testing with trusted prompts", "root", config.WebFetch.FileSchemeRoot)
+	}
 
 	// Create grounding processor
 	grounding := NewGroundingProcessor()
+	grounding.SetInsertCitations(config.WebSearch.InsertCitations)
 
 	// Create HTTP client for fallback
-	httpClient := NewHTTPClient(&HTTPClientConfig{
-		Timeout:         constants.DefaultHTTPTimeout,
-		FollowRedirects: true,
-		AllowPrivateIPs: false,
-	})
+	userAgent := config.WebFetch.UserAgent
+	if userAgent == "" {
+		userAgent = constants.DefaultUserAgent
+	}
+	httpConfig := &HTTPClientConfig{
+		Timeout:             constants.DefaultHTTPTimeout,
+		UserAgent:           userAgent,
+		FollowRedirects:     true,
+		AllowPrivateIPs:     false,
+		PinnedCertHashes:    config.WebFetch.PinnedCertHashes,
+		ProxyURL:            config.ProxyURL,
+		AllowedDomains:      config.WebFetch.AllowedDomains,
+		BlockedDomains:      config.WebFetch.BlockedDomains,
+		HonorRefresh:        config.WebFetch.HonorRefresh,
+		CookieJar:           config.WebFetch.CookieJar,
+		AllowHTTP2Downgrade: config.WebFetch.AllowHTTP2Downgrade,
+		Logger:              config.Logger,
+
+		MaxConcurrentDNSLookups: config.WebFetch.MaxConcurrentDNSLookups,
+		MaxDecompressionRatio:   config.WebFetch.MaxDecompressionRatio,
+		MaxDecompressedSize:     config.WebFetch.MaxDecompressedSize,
+	}
+	var httpClient *HTTPClient
+	if config.HTTPClient != nil {
+		httpClient = NewHTTPClientWith(config.HTTPClient, httpConfig)
+	} else {
+		httpClient = NewHTTPClient(httpConfig)
+	}
 
 	return &WebFetcher{
 		config:     config,
@@ -109,81 +159,306 @@ func NewWebFetcher(config *Config) (*WebFetcher, error) {
 		codeAssist: codeAssist,
 		grounding:  grounding,
 		httpClient: httpClient,
+		robots:     newRobotsCache(config.WebFetch.RobotsTxtCacheTTL),
+		cache:      newConfiguredCache[*types.WebFetchResult](config),
 	}, nil
 }
 
+// Grounding returns the grounding processor used to add citations to results.
+// Its settings can be adjusted at runtime and apply to subsequent calls.
+func (wf *WebFetcher) Grounding() *GroundingProcessor {
+	return wf.grounding
+}
+
 // Fetch retrieves and processes web content using AI, with fallback to direct HTTP.
 // Follows gemini-cli interface: accepts a prompt containing URLs and processing instructions.
+// The fallback only fetches the first URL of the prompt; use FetchMultiple to fetch all of them.
 func (wf *WebFetcher) Fetch(ctx context.Context, prompt string) (*types.WebFetchResult, error) {
+	return wf.fetch(ctx, prompt, "")
+}
+
+// FetchWithModel is like Fetch but uses the given model for this fetch only.
+func (wf *WebFetcher) FetchWithModel(ctx context.Context, prompt, model string) (*types.WebFetchResult, error) {
+	if err := validateModel(model); err != nil {
+		return nil, err
+	}
+	return wf.fetch(ctx, prompt, model)
+}
+
+// fetch retrieves the first URL of the prompt with the given model, or the client's model if empty.
+func (wf *WebFetcher) fetch(ctx context.Context, prompt, model string) (*types.WebFetchResult, error) {
 	startTime := time.Now()
+	ctx, cancel := wf.config.operationContext(ctx)
+	defer cancel()
 
 	// Extract URLs from prompt
-	urls := extractUrls(prompt)
+	urls := wf.extractURLs(prompt)
 	if len(urls) == 0 {
-		return &types.WebFetchResult{
-			Summary:     "No URLs found in prompt",
-			Content:     "",
-			DisplayText: "Error: No URLs found in the prompt",
-			Metadata: types.WebFetchMetadata{
-				URL:            "",
-				Prompt:         prompt,
-				ProcessingTime: time.Since(startTime).String(),
-				APIUsed:        "none",
-				HasGrounding:   false,
-				Error:          "No URLs found in prompt",
-			},
-		}, fmt.Errorf("no URLs found in prompt")
+		return noURLsResult(prompt, startTime), fmt.Errorf("no URLs found in prompt")
 	}
 
 	// Validate the first URL
-	if err := validateURL(urls[0]); err != nil {
-		return &types.WebFetchResult{
-			Summary:     "Invalid URL",
-			Content:     "",
-			DisplayText: fmt.Sprintf("Error: %v", err),
-			Metadata: types.WebFetchMetadata{
-				URL:            urls[0],
-				Prompt:         prompt,
-				ProcessingTime: time.Since(startTime).String(),
-				APIUsed:        "none",
-				HasGrounding:   false,
-				Error:          err.Error(),
-			},
-		}, err
+	if err := wf.validateURL(urls[0]); err != nil {
+		return invalidURLResult("Invalid URL", urls[0], prompt, err, startTime), err
+	}
+
+	// Complete results are cached by URL and prompt, per conversation
+	if model == "" {
+		model = wf.codeAssist.Model()
+	}
+	key := cacheKey("fetch", model, auth.SessionIDFromContext(ctx), normalizeCacheURL(urls[0]), strings.TrimSpace(prompt))
+	if cached, ok := wf.cache.get(key); ok {
+		result := cloneFetchResult(cached)
+		result.Metadata.CacheHit = true
+		result.Metadata.AuditID = AuditIDFromContext(ctx)
+		result.Metadata.SetProcessingTime(time.Since(startTime))
+		return result, nil
+	}
+
+	result, err := wf.fetchURL(ctx, urls[0], prompt, model, startTime)
+	wf.limitDisplayText(result)
+	if err == nil && result != nil && !result.Metadata.Partial {
+		wf.cache.put(key, cloneFetchResult(result))
+	}
+	if result != nil {
+		result.Metadata.AuditID = AuditIDFromContext(ctx)
+	}
+	return result, err
+}
+
+// ClearCache removes every cached fetch result.
+func (wf *WebFetcher) ClearCache() {
+	wf.cache.clear()
+}
+
+// FetchMultiple retrieves every URL in the prompt, up to WebFetchConfig.MaxURLs.
+// The combined prompt is sent to the AI first; an accepted AI result answers the whole
+// prompt and is returned as the only result. Otherwise each URL is fetched directly over
+// HTTP and one result per URL is returned, in the order the URLs appear in the prompt,
+// except near duplicates dropped with WebFetchConfig.DeduplicateContent.
+// The returned error joins the failures of individual URLs; the results of the other
+// URLs are returned as well. A prompt with a single URL is handled like Fetch.
+func (wf *WebFetcher) FetchMultiple(ctx context.Context, prompt string) ([]*types.WebFetchResult, error) {
+	results, err := wf.fetchMultiple(ctx, prompt)
+	for _, result := range results {
+		wf.limitDisplayText(result)
+		if result != nil {
+			result.Metadata.AuditID = AuditIDFromContext(ctx)
+		}
+	}
+	return results, err
+}
+
+func (wf *WebFetcher) fetchMultiple(ctx context.Context, prompt string) ([]*types.WebFetchResult, error) {
+	startTime := time.Now()
+	ctx, cancel := wf.config.operationContext(ctx)
+	defer cancel()
+
+	urls := wf.extractURLs(prompt)
+	if len(urls) == 0 {
+		return []*types.WebFetchResult{noURLsResult(prompt, startTime)}, fmt.Errorf("no URLs found in prompt")
+	}
+
+	// Validate all URLs before any of them is fetched
+	for _, pageURL := range urls {
+		if err := wf.validateURL(pageURL); err != nil {
+			return []*types.WebFetchResult{invalidURLResult("Invalid URL", pageURL, prompt, err, startTime)}, err
+		}
+	}
+
+	if len(urls) == 1 {
+		result, err := wf.fetchURL(ctx, urls[0], prompt, "", startTime)
+		return []*types.WebFetchResult{result}, err
+	}
+
+	// The AI cannot read local files, so prompts with file:// URLs are fetched directly
+	if !hasFileURL(urls) {
+		result, err := wf.fetchWithAI(ctx, prompt, "", startTime)
+		if err == nil && wf.acceptResult(result) {
+			return []*types.WebFetchResult{result}, nil
+		}
+
+		// Do not fall back when the caller cancelled the request or its deadline passed
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return []*types.WebFetchResult{wf.contextErrorResult(ctxErr, "", prompt, startTime)}, ctxErr
+		}
+	}
+
+	results := make([]*types.WebFetchResult, 0, len(urls))
+	var errs []error
+	for _, pageURL := range urls {
+		result, err := wf.fetchFallback(ctx, pageURL, prompt, time.Now())
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// Stop fetching the remaining URLs once the context is done
+			if result != nil {
+				results = append(results, result)
+			}
+			return results, ctxErr
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", pageURL, err))
+		}
+		results = append(results, result)
+	}
+
+	return wf.deduplicateResults(results), errors.Join(errs...)
+}
+
+// fetchURL fetches a validated URL using AI, falling back to direct HTTP if the AI
+// fetch fails or its result is not usable. An empty model uses the client's model.
+func (wf *WebFetcher) fetchURL(ctx context.Context, pageURL, prompt, model string, startTime time.Time) (*types.WebFetchResult, error) {
+	if isFileURL(pageURL) {
+		return wf.fetchFile(ctx, pageURL, prompt, startTime)
 	}
 
 	// First try AI-powered fetch using CodeAssist
-	result, err := wf.fetchWithAI(ctx, prompt, startTime)
-	if err == nil {
+	var result *types.WebFetchResult
+	var err error
+	if wf.config.WebFetch.LargePages {
+		result, err = wf.fetchLargePage(ctx, pageURL, prompt, model, startTime)
+	} else {
+		result, err = wf.fetchWithAI(ctx, prompt, model, startTime)
+	}
+	if err == nil && wf.acceptResult(result) {
 		return result, nil
 	}
 
-	// If AI fetch fails, try direct HTTP fallback
+	// Do not fall back when the caller cancelled the request or its deadline passed
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return wf.contextErrorResult(ctxErr, pageURL, prompt, startTime), ctxErr
+	}
+
+	// If AI fetch fails or its result is not usable, try direct HTTP fallback
+	return wf.fetchFallback(ctx, pageURL, prompt, startTime)
+}
+
+// fetchFallback fetches a validated URL directly over HTTP.
+func (wf *WebFetcher) fetchFallback(ctx context.Context, pageURL, prompt string, startTime time.Time) (*types.WebFetchResult, error) {
+	if isFileURL(pageURL) {
+		return wf.fetchFile(ctx, pageURL, prompt, startTime)
+	}
+
 	// Convert GitHub blob URL for fallback
-	fallbackURL := convertGitHubBlobURL(urls[0])
+	fallbackURL := wf.directFetchURL(pageURL)
 
 	// Validate fallback URL if it's different
-	if fallbackURL != urls[0] {
-		if err := validateURL(fallbackURL); err != nil {
-			return &types.WebFetchResult{
-				Summary:     "Invalid fallback URL",
-				Content:     "",
-				DisplayText: fmt.Sprintf("Error: %v", err),
-				Metadata: types.WebFetchMetadata{
-					URL:            fallbackURL,
-					Prompt:         prompt,
-					ProcessingTime: time.Since(startTime).String(),
-					APIUsed:        "none",
-					HasGrounding:   false,
-					Error:          err.Error(),
-				},
-			}, err
+	if fallbackURL != pageURL {
+		if err := wf.validateURL(fallbackURL); err != nil {
+			return invalidURLResult("Invalid fallback URL", fallbackURL, prompt, err, startTime), err
 		}
 	}
 
 	return wf.fetchWithHTTP(ctx, fallbackURL, prompt, startTime)
 }
 
+// noURLsResult builds the result returned when a prompt contains no URLs.
+func noURLsResult(prompt string, startTime time.Time) *types.WebFetchResult {
+	return timedFetchResult(&types.WebFetchResult{
+		Summary:     "No URLs found in prompt",
+		Content:     "",
+		DisplayText: "Error: No URLs found in the prompt",
+		Metadata: types.WebFetchMetadata{
+			URL:          "",
+			Prompt:       prompt,
+			APIUsed:      "none",
+			HasGrounding: false,
+			Error:        "No URLs found in prompt",
+		},
+	}, startTime)
+}
+
+// invalidURLResult builds the result returned when a URL fails validation. URLs
+// rejected by the domain policy are summarized as blocked.
+func invalidURLResult(summary, url, prompt string, err error, startTime time.Time) *types.WebFetchResult {
+	var policyErr *DomainPolicyError
+	if errors.As(err, &policyErr) {
+		summary = "URL blocked by domain policy"
+	}
+	return timedFetchResult(&types.WebFetchResult{
+		Summary:     summary,
+		Content:     "",
+		DisplayText: fmt.Sprintf("Error: %v", err),
+		Metadata: types.WebFetchMetadata{
+			URL:          url,
+			Prompt:       prompt,
+			APIUsed:      "none",
+			HasGrounding: false,
+			Error:        err.Error(),
+		},
+	}, startTime)
+}
+
+// FetchOutline fetches a page directly over HTTP and splits it into sections by its
+// HTML heading hierarchy. Each section holds the heading level, the heading text and
+// the content up to the next heading. Pages without headings, and non-HTML content,
+// are returned as a single section.
+func (wf *WebFetcher) FetchOutline(ctx context.Context, pageURL string) ([]types.Section, *types.WebFetchMetadata, error) {
+	startTime := time.Now()
+	ctx, cancelOperation := wf.config.operationContext(ctx)
+	defer cancelOperation()
+	metadata := &types.WebFetchMetadata{
+		URL:     pageURL,
+		APIUsed: "http",
+		AuditID: AuditIDFromContext(ctx),
+	}
+
+	err := validateURL(pageURL)
+	if err == nil {
+		err = wf.checkDomainPolicy(pageURL)
+	}
+	if err != nil {
+		metadata.Error = err.Error()
+		return nil, metadata, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, constants.HTTPFetchTimeout)
+	defer cancel()
+
+	var fetched fetchedContent
+	err = wf.config.RetryPolicy.Do(fetchCtx, func(ctx context.Context) error {
+		var fetchErr error
+		fetched, fetchErr = wf.httpClient.fetch(ctx, wf.directFetchURL(pageURL), nil)
+		return fetchErr
+	})
+	metadata.SetProcessingTime(time.Since(startTime))
+	if err != nil {
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) {
+			metadata.StatusCode = statusErr.StatusCode
+		}
+		metadata.Error = err.Error()
+		return nil, metadata, fmt.Errorf("HTTP fetch failed: %w", err)
+	}
+
+	metadata.ContentType = fetched.contentType
+	metadata.ContentSize = fetched.size
+	metadata.StatusCode = fetched.statusCode
+	metadata.NoContent = fetched.statusCode == http.StatusNoContent
+
+	var sections []types.Section
+	if isHTMLContent(fetched.contentType) {
+		sections = extractOutline(fetched.content)
+	} else {
+		sections = []types.Section{{Content: strings.TrimSpace(fetched.content)}}
+	}
+
+	if wf.config.WebFetch.Sanitize {
+		for i := range sections {
+			sections[i].Heading = sanitizeText(sections[i].Heading, noCodeBlocks)
+			sections[i].Content = sanitizeText(sections[i].Content, noCodeBlocks)
+		}
+	}
+
+	metadata.SetProcessingTime(time.Since(startTime))
+	return sections, metadata, nil
+}
+
+// ResetHTTPClient closes the idle connections of the client used for direct HTTP
+// fetches and rebuilds its transport. Call it after network, proxy or DNS changes.
+func (wf *WebFetcher) ResetHTTPClient() {
+	wf.httpClient.Reset()
+}
+
 // IsAuthenticated checks if the fetcher has valid authentication.
 func (wf *WebFetcher) IsAuthenticated() bool {
 	return wf.auth.IsAuthenticated()
@@ -199,8 +474,40 @@ func (wf *WebFetcher) ClearAuthentication() error {
 	return wf.auth.ClearAuthentication()
 }
 
+// contextErrorResult builds the result returned when an AI fetch stops because its
+// context was cancelled or timed out.
+func (wf *WebFetcher) contextErrorResult(err error, url, prompt string, startTime time.Time) *types.WebFetchResult {
+	return timedFetchResult(&types.WebFetchResult{
+		Summary:     fmt.Sprintf("Fetch %s", contextErrorLabel(err)),
+		Content:     "",
+		DisplayText: fmt.Sprintf("Error: AI %s", contextErrorMessage(err)),
+		Metadata: types.WebFetchMetadata{
+			URL:          url,
+			Prompt:       prompt,
+			APIUsed:      "codeassist",
+			HasGrounding: false,
+			Error:        contextErrorMessage(err),
+		},
+	}, startTime)
+}
+
+// DefaultResultAcceptor accepts AI fetch results that contain non-empty content.
+func DefaultResultAcceptor(result *types.WebFetchResult) bool {
+	return result != nil && strings.TrimSpace(result.Content) != ""
+}
+
+// acceptResult reports whether an AI fetch result is usable according to the configured acceptor.
+func (wf *WebFetcher) acceptResult(result *types.WebFetchResult) bool {
+	acceptor := wf.config.WebFetch.ResultAcceptor
+	if acceptor == nil {
+		acceptor = DefaultResultAcceptor
+	}
+	return acceptor(result)
+}
+
 // fetchWithAI performs web fetch using the AI model with URLContext tool.
-func (wf *WebFetcher) fetchWithAI(ctx context.Context, prompt string, startTime time.Time) (*types.WebFetchResult, error) {
+// An empty model uses the client's model.
+func (wf *WebFetcher) fetchWithAI(ctx context.Context, prompt, model string, startTime time.Time) (*types.WebFetchResult, error) {
 	// Check if context is already cancelled
 	select {
 	case <-ctx.Done():
@@ -210,72 +517,35 @@ func (wf *WebFetcher) fetchWithAI(ctx context.Context, prompt string, startTime
 
 	// Create URL context request
 	req := wf.codeAssist.CreateURLContextRequest("", prompt)
+	req.Model = model
 
 	// Create a timeout context that respects the parent context cancellation
 	timeoutCtx, cancel := context.WithTimeout(ctx, constants.AIRequestTimeout)
 	defer cancel()
 
-	// Use a channel to handle the response and enable proper cancellation
-	type result struct {
-		resp *types.GenerateContentResponse
-		err  error
-	}
-
-	resultChan := make(chan result, 1)
-
-	// Run the AI request in a goroutine to allow for cancellation
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				resultChan <- result{nil, fmt.Errorf("panic in AI request: %v", r)}
-			}
-		}()
-
-		resp, err := wf.codeAssist.GenerateContent(timeoutCtx, req)
-		select {
-		case resultChan <- result{resp, err}:
-		case <-timeoutCtx.Done():
-			// Context was cancelled, don't send result
-		}
-	}()
-
-	// Wait for either result or cancellation
-	select {
-	case res := <-resultChan:
-		if res.err != nil {
-			return &types.WebFetchResult{
-				Summary:     "Fetch failed",
-				Content:     "",
-				DisplayText: fmt.Sprintf("Error fetching content: %v", res.err),
-				Metadata: types.WebFetchMetadata{
-					URL:            "",
-					Prompt:         prompt,
-					ProcessingTime: time.Since(startTime).String(),
-					APIUsed:        "codeassist",
-					HasGrounding:   false,
-					Error:          res.err.Error(),
-				},
-			}, fmt.Errorf("web fetch failed: %w", res.err)
+	// The CodeAssist client honors the context, so the call returns as soon as it is done
+	resp, err := wf.codeAssist.GenerateContent(timeoutCtx, req)
+	if err != nil {
+		if ctxErr := timeoutCtx.Err(); ctxErr != nil {
+			return wf.contextErrorResult(ctxErr, "", prompt, startTime), ctxErr
 		}
 
-		// Process the response
-		return wf.processFetchResponse(res.resp, prompt, startTime, false)
-
-	case <-timeoutCtx.Done():
-		return &types.WebFetchResult{
-			Summary:     "Fetch timeout",
+		return timedFetchResult(&types.WebFetchResult{
+			Summary:     "Fetch failed",
 			Content:     "",
-			DisplayText: "Error: AI request timed out or was cancelled",
+			DisplayText: fmt.Sprintf("Error fetching content: %v", err),
 			Metadata: types.WebFetchMetadata{
-				URL:            "",
-				Prompt:         prompt,
-				ProcessingTime: time.Since(startTime).String(),
-				APIUsed:        "codeassist",
-				HasGrounding:   false,
-				Error:          timeoutCtx.Err().Error(),
+				URL:          "",
+				Prompt:       prompt,
+				APIUsed:      "codeassist",
+				HasGrounding: false,
+				Error:        err.Error(),
 			},
-		}, timeoutCtx.Err()
+		}, startTime), fmt.Errorf("web fetch failed: %w", err)
 	}
+
+	// Process the response
+	return wf.processFetchResponse(resp, prompt, startTime, false)
 }
 
 // fetchWithHTTP performs fallback web fetch using direct HTTP.
@@ -291,80 +561,151 @@ func (wf *WebFetcher) fetchWithHTTP(ctx context.Context, url, prompt string, sta
 	timeoutCtx, cancel := context.WithTimeout(ctx, constants.HTTPFetchTimeout)
 	defer cancel()
 
-	// Use a channel to handle the response and enable proper cancellation
-	type httpResult struct {
-		content     string
-		contentType string
-		contentSize int
-		err         error
+	if wf.config.WebFetch.RespectRobotsTxt {
+		if err := wf.checkRobotsTxt(timeoutCtx, url); err != nil {
+			return timedFetchResult(&types.WebFetchResult{
+				Summary:     fmt.Sprintf("robots.txt check failed: %s", url),
+				Content:     "",
+				DisplayText: fmt.Sprintf("Error fetching content via HTTP: %v", err),
+				Metadata: types.WebFetchMetadata{
+					URL:          url,
+					Prompt:       prompt,
+					APIUsed:      "fallback",
+					HasGrounding: false,
+					UsedFallback: true,
+					Error:        err.Error(),
+				},
+			}, startTime), fmt.Errorf("HTTP fetch failed: %w", err)
+		}
 	}
 
-	resultChan := make(chan httpResult, 1)
+	// Record the request headers actually sent when debugging is enabled
+	var recorder *headerRecorder
+	fetchCtx := timeoutCtx
+	if wf.config.WebFetch.DebugHeaders {
+		recorder = &headerRecorder{}
+		fetchCtx = recorder.withTrace(timeoutCtx)
+	}
 
-	// Run the HTTP request in a goroutine to allow for cancellation
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				resultChan <- httpResult{"", "", 0, fmt.Errorf("panic in HTTP request: %v", r)}
+	// The HTTP client and retry policy honor the context, so the call returns as soon as it is done
+	var fetched fetchedContent
+	err := wf.config.RetryPolicy.Do(fetchCtx, func(ctx context.Context) error {
+		var fetchErr error
+		fetched, fetchErr = wf.httpClient.fetch(ctx, url, nil)
+		return fetchErr
+	})
+	if err != nil {
+		if ctxErr := timeoutCtx.Err(); ctxErr != nil {
+			if wf.config.WebFetch.ReturnPartialOnTimeout && errors.Is(ctxErr, context.DeadlineExceeded) && fetched.size > 0 {
+				return wf.partialHTTPResult(fetched, url, prompt, recorder, startTime)
 			}
-		}()
-
-		content, contentType, contentSize, err := wf.httpClient.FetchContent(timeoutCtx, url)
-		select {
-		case resultChan <- httpResult{content, contentType, contentSize, err}:
-		case <-timeoutCtx.Done():
-			// Context was cancelled, don't send result
-		}
-	}()
-
-	// Wait for either result or cancellation
-	select {
-	case res := <-resultChan:
-		if res.err != nil {
-			return &types.WebFetchResult{
-				Summary:     fmt.Sprintf("HTTP fetch failed: %s", url),
+			return timedFetchResult(&types.WebFetchResult{
+				Summary:     fmt.Sprintf("HTTP fetch %s: %s", contextErrorLabel(ctxErr), url),
 				Content:     "",
-				DisplayText: fmt.Sprintf("Error fetching content via HTTP: %v", res.err),
+				DisplayText: fmt.Sprintf("Error: HTTP %s", contextErrorMessage(ctxErr)),
 				Metadata: types.WebFetchMetadata{
-					URL:            url,
-					Prompt:         prompt,
-					ProcessingTime: time.Since(startTime).String(),
-					APIUsed:        "fallback",
-					HasGrounding:   false,
-					UsedFallback:   true,
-					Error:          res.err.Error(),
+					URL:          url,
+					Prompt:       prompt,
+					APIUsed:      "fallback",
+					HasGrounding: false,
+					UsedFallback: true,
+					Error:        contextErrorMessage(ctxErr),
 				},
-			}, fmt.Errorf("HTTP fetch failed: %w", res.err)
+			}, startTime), ctxErr
 		}
 
-		// Continue with successful response processing...
-		return wf.processHTTPResponse(res.content, res.contentType, res.contentSize, url, prompt, startTime)
+		var statusCode int
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) {
+			statusCode = statusErr.StatusCode
+		}
 
-	case <-timeoutCtx.Done():
-		return &types.WebFetchResult{
-			Summary:     fmt.Sprintf("HTTP fetch timeout: %s", url),
+		return timedFetchResult(&types.WebFetchResult{
+			Summary:     fmt.Sprintf("HTTP fetch failed: %s", url),
 			Content:     "",
-			DisplayText: "Error: HTTP request timed out or was cancelled",
+			DisplayText: fmt.Sprintf("Error fetching content via HTTP: %v", err),
 			Metadata: types.WebFetchMetadata{
 				URL:            url,
 				Prompt:         prompt,
-				ProcessingTime: time.Since(startTime).String(),
 				APIUsed:        "fallback",
 				HasGrounding:   false,
 				UsedFallback:   true,
-				Error:          timeoutCtx.Err().Error(),
+				StatusCode:     statusCode,
+				RequestHeaders: recorder.recordedHeaders(),
+				Error:          err.Error(),
 			},
-		}, timeoutCtx.Err()
+		}, startTime), fmt.Errorf("HTTP fetch failed: %w", err)
+	}
+
+	// Continue with successful response processing...
+	result, err := wf.processHTTPResponse(fetched.content, fetched.contentType, fetched.size, url, prompt, startTime)
+	if result != nil {
+		result.Metadata.RequestHeaders = recorder.recordedHeaders()
+		result.Metadata.StatusCode = fetched.statusCode
+		result.Metadata.NoContent = fetched.statusCode == http.StatusNoContent
+		result.Metadata.DetectedCharset = fetched.charset.name
+		result.Metadata.CharsetConfidence = fetched.charset.confidence
+		setConnectionMetadata(&result.Metadata, fetched.connection)
 	}
+	return result, err
 }
 
-// processHTTPResponse processes the successful HTTP response.
+// partialHTTPResult builds the result of an HTTP fallback fetch whose deadline expired
+// while the body was being read, from the content received until then.
+func (wf *WebFetcher) partialHTTPResult(fetched fetchedContent, url, prompt string, recorder *headerRecorder, startTime time.Time) (*types.WebFetchResult, error) {
+	result, err := wf.processHTTPResponse(fetched.content, fetched.contentType, fetched.size, url, prompt, startTime)
+	if result != nil {
+		result.Summary = fmt.Sprintf("Fetched partial content from: %s (timed out)", url)
+		result.Metadata.StatusCode = fetched.statusCode
+		result.Metadata.Partial = true
+		result.Metadata.RequestHeaders = recorder.recordedHeaders()
+		result.Metadata.DetectedCharset = fetched.charset.name
+		result.Metadata.CharsetConfidence = fetched.charset.confidence
+		setConnectionMetadata(&result.Metadata, fetched.connection)
+	}
+	return result, err
+}
+
+// setConnectionMetadata records the protocol and TLS parameters of the fallback response.
+func setConnectionMetadata(metadata *types.WebFetchMetadata, connection connectionInfo) {
+	metadata.Protocol = connection.protocol
+	metadata.TLSVersion = connection.tlsVersion
+	metadata.TLSCipherSuite = connection.cipherSuite
+}
+
+// prepareContent converts and sanitizes fetched content according to the configuration.
+func (wf *WebFetcher) prepareContent(content, contentType string) string {
+	if wf.config.WebFetch.ExtractPDF && isPDFContent(contentType) {
+		content = extractPDFText(content)
+	}
+
+	preserve := noCodeBlocks
+	if isHTMLContent(contentType) {
+		preserve = htmlCodeBlocks
+		if wf.config.WebFetch.ConvertHTML {
+			content = convertHTMLToMarkdown(content)
+			preserve = markdownCodeBlocks
+		}
+	}
+	if wf.config.WebFetch.Sanitize {
+		content = sanitizeText(content, preserve)
+	}
+	return content
+}
+
+// processHTTPResponse processes the successful HTTP response. The fetched page is
+// returned as the only source, marked as fetched; HasGrounding stays false because
+// the model provided no grounding.
 func (wf *WebFetcher) processHTTPResponse(content, contentType string, contentSize int, url, prompt string, startTime time.Time) (*types.WebFetchResult, error) {
-	// Apply default content processing (use config defaults)
-	processedContent := content
+	source := types.GroundingChunk{Fetched: true}
+	source.Web.URI = url
 	if isHTMLContent(contentType) {
-		processedContent = convertHTMLToMarkdown(content)
+		source.Web.Title = htmlTitle(content)
 	}
+	sources := applyFavicons([]types.GroundingChunk{source}, wf.config.WebSearch.FaviconURL)
+
+	// Apply default content processing (use config defaults)
+	processedContent := wf.prepareContent(content, contentType)
 	// Apply default truncation from config
 	maxLength := constants.DefaultTruncateLength // Default from gemini-cli
 	if len(processedContent) > maxLength {
@@ -373,46 +714,48 @@ func (wf *WebFetcher) processHTTPResponse(content, contentType string, contentSi
 
 	// Create result with processed content
 	displayText := processedContent
-	if prompt != "" {
+	if prompt != "" && wf.config.WebFetch.IncludePromptInDisplay {
 		displayText = fmt.Sprintf("Content from %s:\n\n%s\n\nUser request: %s", url, processedContent, prompt)
 	}
 
-	return &types.WebFetchResult{
+	return timedFetchResult(&types.WebFetchResult{
 		Summary:     fmt.Sprintf("Fetched content from: %s", url),
 		Content:     processedContent,
 		DisplayText: displayText,
+		Sources:     sources,
 		Metadata: types.WebFetchMetadata{
-			URL:            url,
-			Prompt:         prompt,
-			ContentType:    contentType,
-			ContentSize:    contentSize,
-			ProcessingTime: time.Since(startTime).String(),
-			APIUsed:        "fallback",
-			HasGrounding:   false,
-			UsedFallback:   true,
+			URL:          url,
+			Prompt:       prompt,
+			ContentType:  contentType,
+			ContentSize:  contentSize,
+			APIUsed:      "fallback",
+			HasGrounding: false,
+			SourceCount:  len(sources),
+			UsedFallback: true,
+			StatusCode:   http.StatusOK,
 		},
-	}, nil
+	}, startTime), nil
 }
 
 // processFetchResponse processes the AI response into a structured fetch result.
 func (wf *WebFetcher) processFetchResponse(resp *types.GenerateContentResponse, prompt string, startTime time.Time, usedFallback bool) (*types.WebFetchResult, error) {
-	// Extract URLs from prompt for metadata
-	urls := extractUrls(prompt)
+	// Extract the first URL from prompt for metadata
+	urls := extractUrls(prompt, 1)
 	firstUrl := ""
 	if len(urls) > 0 {
 		firstUrl = urls[0]
 	}
 
-	result := &types.WebFetchResult{
+	result := timedFetchResult(&types.WebFetchResult{
 		Summary: "Processed web content from prompt",
 		Metadata: types.WebFetchMetadata{
-			URL:            firstUrl,
-			Prompt:         prompt,
-			ProcessingTime: time.Since(startTime).String(),
-			APIUsed:        "codeassist",
-			UsedFallback:   usedFallback,
+			URL:          firstUrl,
+			Prompt:       prompt,
+			APIUsed:      "codeassist",
+			UsedFallback: usedFallback,
+			TokenUsage:   resp.UsageMetadata,
 		},
-	}
+	}, startTime)
 
 	// Extract content from the first candidate
 	if len(resp.Candidates) > 0 {
@@ -434,7 +777,8 @@ func (wf *WebFetcher) processFetchResponse(resp *types.GenerateContentResponse,
 
 			// Process grounding chunks as sources
 			if len(candidate.GroundingMetadata.GroundingChunks) > 0 {
-				result.Sources = candidate.GroundingMetadata.GroundingChunks
+				result.Sources = applyFavicons(candidate.GroundingMetadata.GroundingChunks, wf.config.WebSearch.FaviconURL)
+				attachSnippets(result.Sources, candidate.GroundingMetadata.GroundingSupports)
 				result.Metadata.SourceCount = len(candidate.GroundingMetadata.GroundingChunks)
 			}
 
@@ -456,15 +800,33 @@ func (wf *WebFetcher) processFetchResponse(resp *types.GenerateContentResponse,
 
 // Helper functions
 
-// isHTMLContent checks if the content type indicates HTML content.
+// limitDisplayText truncates the display text of a result to Config.MaxDisplayLength.
+func (wf *WebFetcher) limitDisplayText(result *types.WebFetchResult) {
+	if result != nil {
+		result.DisplayText = truncateRunes(result.DisplayText, wf.config.MaxDisplayLength)
+	}
+}
+
+// isHTMLContent checks if the content type indicates HTML content, ignoring case and
+// parameters such as the charset.
 func isHTMLContent(contentType string) bool {
-	return contentType == constants.ContentTypeHTML || contentType == constants.ContentTypeXHTML
+	mediaType := parseMediaType(contentType)
+	return mediaType == constants.ContentTypeHTML || mediaType == constants.ContentTypeXHTML
+}
+
+// parseMediaType returns the lowercase media type of a Content-Type value without its
+// parameters, or an empty string if it cannot be parsed. A media type followed by
+// malformed parameters is still returned.
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil && !errors.Is(err, mime.ErrInvalidMediaParameter) {
+		return ""
+	}
+	return mediaType
 }
 
-// convertHTMLToMarkdown converts HTML content to markdown format.
-// This is a simplified implementation - in practice, you might want to use a proper HTML to Markdown converter.
-func convertHTMLToMarkdown(htmlContent string) string {
-	// For now, return as-is. In a real implementation, you would use a library like goquery or html2text
-	// to properly convert HTML to markdown format.
-	return htmlContent
+// timedFetchResult records the time elapsed since start in the result metadata.
+func timedFetchResult(result *types.WebFetchResult, start time.Time) *types.WebFetchResult {
+	result.Metadata.SetProcessingTime(time.Since(start))
+	return result
 }
@@ -1,12 +1,113 @@
 package geminiwebtools
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
 
 	"github.com/d-kuro/geminiwebtools/pkg/constants"
+	"github.com/d-kuro/geminiwebtools/pkg/retry"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
 )
 
+// mockTokenStore is a credential store holding a valid, unexpired token.
+type mockTokenStore struct{}
+
+func (m *mockTokenStore) LoadToken() (*oauth2.Token, error) {
+	return &oauth2.Token{
+		AccessToken: "test-access-token",
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Hour),
+	}, nil
+}
+
+func (m *mockTokenStore) StoreToken(token *oauth2.Token) error { return nil }
+func (m *mockTokenStore) ClearToken() error                    { return nil }
+func (m *mockTokenStore) HasToken() bool                       { return true }
+func (m *mockTokenStore) GetStoragePath() string               { return "/tmp/test-storage" }
+
+// newFakeCodeAssistServer starts a fake CodeAssist server that handles project
+// initialization and delegates generateContent and streamGenerateContent calls to
+// the given handler.
+func newFakeCodeAssistServer(t *testing.T, generate http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1internal:loadCodeAssist", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"cloudaicompanionProject": "test-project"})
+	})
+	mux.HandleFunc("/v1internal:onboardUser", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	})
+	mux.HandleFunc("/v1internal:generateContent", generate)
+	mux.HandleFunc("/v1internal:streamGenerateContent", generate)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// writeCodeAssistText writes a CodeAssist generateContent response with the given text.
+func writeCodeAssistText(w http.ResponseWriter, text string) {
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"response": map[string]any{
+			"candidates": []any{
+				map[string]any{
+					"content": map[string]any{
+						"role":  "model",
+						"parts": []any{map[string]any{"text": text}},
+					},
+					"finishReason": "STOP",
+				},
+			},
+		},
+	})
+}
+
+// newTestConfig returns a configuration pointing at the given fake CodeAssist server
+// that retries without noticeable delays.
+func newTestConfig(codeAssistURL string, opts ...ConfigOption) *Config {
+	defaults := []ConfigOption{
+		WithCredentialStore(&mockTokenStore{}),
+		WithRetryPolicy(&retry.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Multiplier: 2}),
+	}
+	config := NewConfig(append(defaults, opts...)...)
+	config.CodeAssistEndpoint = codeAssistURL
+	return config
+}
+
+// useTestPageServer routes every request of the fetcher's fallback HTTP client to the
+// given local test server, so that tests can fetch public URLs without relaxing the
+// localhost and private IP checks. It returns the base URL to fetch.
+func useTestPageServer(fetcher *WebFetcher, server *httptest.Server) string {
+	addr := server.Listener.Addr().String()
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	config := DefaultHTTPClientConfig()
+	config.UserAgent = fetcher.httpClient.config.UserAgent
+	fetcher.httpClient = &HTTPClient{
+		client: &http.Client{Transport: transport},
+		config: config,
+	}
+	return "http://example.com"
+}
+
 func TestExtractUrls(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -72,7 +173,7 @@ func TestExtractUrls(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := extractUrls(tt.input)
+			result := extractUrls(tt.input, 0)
 
 			if len(result) != len(tt.expected) {
 				t.Errorf("extractUrls() = %v, want %v", result, tt.expected)
@@ -88,6 +189,27 @@ func TestExtractUrls(t *testing.T) {
 	}
 }
 
+func TestExtractUrlsLimit(t *testing.T) {
+	var prompt strings.Builder
+	for i := 0; i < constants.DefaultMaxPromptURLs+50; i++ {
+		fmt.Fprintf(&prompt, "see https://example.com/%d ", i)
+	}
+
+	urls := extractUrls(prompt.String(), constants.DefaultMaxPromptURLs)
+	if len(urls) != constants.DefaultMaxPromptURLs {
+		t.Fatalf("Expected %d URLs, got %d", constants.DefaultMaxPromptURLs, len(urls))
+	}
+	for i, url := range urls {
+		if want := fmt.Sprintf("https://example.com/%d", i); url != want {
+			t.Fatalf("urls[%d] = %s, want %s", i, url, want)
+		}
+	}
+
+	if got := len(extractUrls(prompt.String(), 0)); got != constants.DefaultMaxPromptURLs+50 {
+		t.Errorf("Expected no limit for zero, got %d URLs", got)
+	}
+}
+
 func TestValidateURL(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -277,6 +399,42 @@ func TestConvertGitHubBlobURL(t *testing.T) {
 	}
 }
 
+func TestRewriteGitHubBlob(t *testing.T) {
+	var requested atomic.Value
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(r.Host + r.URL.Path)
+		_, _ = fmt.Fprint(w, "# README")
+	}))
+	defer page.Close()
+
+	const blobURL = "http://github.com/owner/repo/blob/main/README.md"
+	tests := []struct {
+		name    string
+		enabled bool
+		want    string
+	}{
+		{name: "enabled", enabled: true, want: "raw.githubusercontent.com/owner/repo/main/README.md"},
+		{name: "disabled", enabled: false, want: "github.com/owner/repo/blob/main/README.md"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher, err := NewWebFetcher(newTestConfig("", WithRewriteGitHubBlob(tt.enabled)))
+			if err != nil {
+				t.Fatalf("Failed to create fetcher: %v", err)
+			}
+			useTestPageServer(fetcher, page)
+
+			if _, _, err := fetcher.FetchOutline(context.Background(), blobURL); err != nil {
+				t.Fatalf("FetchOutline returned error: %v", err)
+			}
+			if got := requested.Load(); got != tt.want {
+				t.Errorf("Requested %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestIsHTMLContent(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -296,7 +454,17 @@ func TestIsHTMLContent(t *testing.T) {
 		{
 			name:        "HTML with charset",
 			contentType: "text/html; charset=utf-8",
-			expected:    false, // The function does exact string comparison
+			expected:    true,
+		},
+		{
+			name:        "XHTML with charset",
+			contentType: "application/xhtml+xml; charset=utf-8",
+			expected:    true,
+		},
+		{
+			name:        "HTML with malformed parameters",
+			contentType: "text/html; charset",
+			expected:    true,
 		},
 		{
 			name:        "plain text",
@@ -321,7 +489,12 @@ func TestIsHTMLContent(t *testing.T) {
 		{
 			name:        "mixed case HTML",
 			contentType: "TEXT/HTML",
-			expected:    false, // The function does exact string comparison
+			expected:    true,
+		},
+		{
+			name:        "mixed case XHTML with charset",
+			contentType: "Application/XHTML+XML; Charset=UTF-8",
+			expected:    true,
 		},
 		{
 			name:        "image content type",
@@ -408,3 +581,743 @@ func TestNewWebFetcher(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchFallsBackOnUnacceptableAIResult(t *testing.T) {
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeCodeAssistText(w, "   ")
+	})
+
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprint(w, "real page content")
+	}))
+	defer page.Close()
+
+	tests := []struct {
+		name         string
+		acceptor     func(*types.WebFetchResult) bool
+		wantFallback bool
+	}{
+		{
+			name:         "default acceptor rejects empty content",
+			acceptor:     nil,
+			wantFallback: true,
+		},
+		{
+			name:         "custom acceptor accepts everything",
+			acceptor:     func(*types.WebFetchResult) bool { return true },
+			wantFallback: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher, err := NewWebFetcher(newTestConfig(codeAssist.URL, WithResultAcceptor(tt.acceptor)))
+			if err != nil {
+				t.Fatalf("Failed to create fetcher: %v", err)
+			}
+			pageURL := useTestPageServer(fetcher, page) + "/page"
+
+			result, err := fetcher.Fetch(context.Background(), "Summarize "+pageURL)
+			if err != nil {
+				t.Fatalf("Fetch returned error: %v", err)
+			}
+
+			if result.Metadata.UsedFallback != tt.wantFallback {
+				t.Errorf("UsedFallback = %v, want %v", result.Metadata.UsedFallback, tt.wantFallback)
+			}
+			if tt.wantFallback && result.Content != "real page content" {
+				t.Errorf("Expected fallback page content, got %q", result.Content)
+			}
+		})
+	}
+}
+
+func TestFetchFallbackSource(t *testing.T) {
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head><title>Example Page</title></head><body><p>Body</p></body></html>"))
+	}))
+	defer page.Close()
+
+	fetcher, err := NewWebFetcher(newTestConfig(codeAssist.URL))
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	pageURL := useTestPageServer(fetcher, page) + "/page"
+
+	result, err := fetcher.Fetch(context.Background(), "Summarize "+pageURL)
+	if err != nil {
+		t.Fatalf("Fetch returned error: %v", err)
+	}
+	if !result.Metadata.UsedFallback || result.Metadata.HasGrounding {
+		t.Fatalf("Expected an ungrounded fallback result, got %+v", result.Metadata)
+	}
+	if len(result.Sources) != 1 || result.Metadata.SourceCount != 1 {
+		t.Fatalf("Expected the page as the only source, got %+v", result.Sources)
+	}
+	source := result.Sources[0]
+	if source.Web.URI != pageURL || source.Web.Title != "Example Page" || !source.Fetched {
+		t.Errorf("Unexpected source: %+v", source)
+	}
+	if source.FaviconURL != "https://example.com/favicon.ico" {
+		t.Errorf("FaviconURL = %q, want the page's favicon", source.FaviconURL)
+	}
+}
+
+func TestFetchWithHTTPStatusError(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		wantRequests int32
+	}{
+		{name: "not found", statusCode: http.StatusNotFound, wantRequests: 1},
+		{name: "internal server error", statusCode: http.StatusInternalServerError, wantRequests: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer page.Close()
+
+			fetcher, err := NewWebFetcher(newTestConfig(page.URL))
+			if err != nil {
+				t.Fatalf("Failed to create fetcher: %v", err)
+			}
+
+			pageURL := useTestPageServer(fetcher, page) + "/missing"
+			result, err := fetcher.fetchWithHTTP(context.Background(), pageURL, "", time.Now())
+			if err == nil {
+				t.Fatal("Expected error for non-200 response")
+			}
+
+			var statusErr *HTTPStatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("Expected HTTPStatusError, got %T: %v", err, err)
+			}
+			if statusErr.StatusCode != tt.statusCode {
+				t.Errorf("StatusCode = %d, want %d", statusErr.StatusCode, tt.statusCode)
+			}
+			if statusErr.URL != pageURL {
+				t.Errorf("URL = %q, want %q", statusErr.URL, pageURL)
+			}
+			if !strings.HasPrefix(statusErr.Error(), fmt.Sprintf("HTTP error: %d", tt.statusCode)) {
+				t.Errorf("Unexpected error message: %q", statusErr.Error())
+			}
+			if result.Metadata.StatusCode != tt.statusCode {
+				t.Errorf("Metadata.StatusCode = %d, want %d", result.Metadata.StatusCode, tt.statusCode)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("Expected %d requests with retry policy, got %d", tt.wantRequests, got)
+			}
+		})
+	}
+}
+
+func TestFetchWithHTTPReturnPartialOnTimeout(t *testing.T) {
+	received := strings.Repeat("partial page content\n", 100)
+	release := make(chan struct{})
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Announce more content than is sent before stalling
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", fmt.Sprint(len(received)+100))
+		_, _ = w.Write([]byte(received))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer page.Close()
+	defer close(release)
+
+	for _, returnPartial := range []bool{true, false} {
+		fetcher, err := NewWebFetcher(newTestConfig("", WithReturnPartialOnTimeout(returnPartial)))
+		if err != nil {
+			t.Fatalf("Failed to create fetcher: %v", err)
+		}
+		pageURL := useTestPageServer(fetcher, page)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		result, err := fetcher.fetchWithHTTP(ctx, pageURL, "", time.Now())
+		cancel()
+
+		if !returnPartial {
+			if !errors.Is(err, context.DeadlineExceeded) || result.Content != "" {
+				t.Errorf("Expected the timeout to discard the content, got %v and %q", err, result.Content)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("fetchWithHTTP returned error: %v", err)
+		}
+		if !strings.Contains(result.Content, strings.TrimSpace(received)) {
+			t.Errorf("Content = %q, want the received content", result.Content)
+		}
+		if !result.Metadata.Partial || result.Metadata.ContentSize != len(received) || result.Metadata.StatusCode != http.StatusOK {
+			t.Errorf("Unexpected metadata: %+v", result.Metadata)
+		}
+	}
+}
+
+func TestFetchOutline(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, `<html><body><h1>Title</h1><p>Body</p><h2>Child</h2><p>Nested</p></body></html>`)
+	}))
+	defer page.Close()
+
+	fetcher, err := NewWebFetcher(newTestConfig(page.URL))
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+
+	sections, metadata, err := fetcher.FetchOutline(context.Background(), useTestPageServer(fetcher, page)+"/docs")
+	if err != nil {
+		t.Fatalf("FetchOutline returned error: %v", err)
+	}
+
+	expected := []types.Section{
+		{Level: 1, Heading: "Title", Content: "Body"},
+		{Level: 2, Heading: "Child", Content: "Nested"},
+	}
+	if len(sections) != len(expected) {
+		t.Fatalf("Expected %d sections, got %d: %#v", len(expected), len(sections), sections)
+	}
+	for i := range expected {
+		if sections[i] != expected[i] {
+			t.Errorf("section %d = %#v, want %#v", i, sections[i], expected[i])
+		}
+	}
+
+	if metadata.StatusCode != http.StatusOK || metadata.ContentType != "text/html" {
+		t.Errorf("Unexpected metadata: %+v", metadata)
+	}
+
+	if _, _, err := fetcher.FetchOutline(context.Background(), "ftp://example.com"); err == nil {
+		t.Error("Expected error for invalid URL")
+	}
+}
+
+func TestFetchDoesNotFallBackWhenCancelled(t *testing.T) {
+	codeAssist := newFakeCodeAssistServer(t, blockUntilCancelled)
+
+	var pageRequests atomic.Int32
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pageRequests.Add(1)
+		_, _ = fmt.Fprint(w, "page content")
+	}))
+	defer page.Close()
+
+	fetcher, err := NewWebFetcher(newTestConfig(codeAssist.URL))
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	pageURL := useTestPageServer(fetcher, page)
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		result, err := fetcher.Fetch(ctx, "Summarize "+pageURL)
+		if !errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+		if result.Summary != "Fetch cancelled" || !strings.Contains(result.Metadata.Error, "cancelled") {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		result, err := fetcher.Fetch(ctx, "Summarize "+pageURL)
+		if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		if result.Summary != "Fetch timeout" || !strings.Contains(result.Metadata.Error, "timed out") {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+	})
+
+	if got := pageRequests.Load(); got != 0 {
+		t.Errorf("Expected no fallback requests, got %d", got)
+	}
+}
+
+func TestProcessFetchResponseFavicons(t *testing.T) {
+	fetcher, err := NewWebFetcher(newTestConfig("", WithFaviconURL(GoogleFaviconURL)))
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+
+	resp := &types.GenerateContentResponse{
+		Candidates: []types.Candidate{{
+			Content: types.CandidateContent{Parts: []types.CandidatePart{{Text: "Summary"}}},
+			GroundingMetadata: &types.GroundingMetadata{
+				GroundingChunks: []types.GroundingChunk{newTestChunk("Go", "https://go.dev/doc")},
+			},
+		}},
+	}
+
+	result, err := fetcher.processFetchResponse(resp, "Summarize https://go.dev/doc", time.Now(), false)
+	if err != nil {
+		t.Fatalf("processFetchResponse returned error: %v", err)
+	}
+	if len(result.Sources) != 1 {
+		t.Fatalf("Expected 1 source, got %d", len(result.Sources))
+	}
+	if want := "https://www.google.com/s2/favicons?sz=64&domain=go.dev"; result.Sources[0].FaviconURL != want {
+		t.Errorf("FaviconURL = %q, want %q", result.Sources[0].FaviconURL, want)
+	}
+}
+
+func TestFetchWrapsUntrustedContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []ConfigOption
+		wantWrap bool
+	}{
+		{name: "default", wantWrap: true},
+		{name: "disabled", opts: []ConfigOption{WithWrapUntrustedContent(false)}, wantWrap: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body atomic.Value
+			codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				body.Store(string(data))
+				writeCodeAssistText(w, "summary")
+			})
+
+			fetcher, err := NewWebFetcher(newTestConfig(codeAssist.URL, tt.opts...))
+			if err != nil {
+				t.Fatalf("Failed to create fetcher: %v", err)
+			}
+			if _, err := fetcher.Fetch(context.Background(), "Summarize https://example.com"); err != nil {
+				t.Fatalf("Fetch returned error: %v", err)
+			}
+
+			sent, _ := body.Load().(string)
+			if got := strings.Contains(sent, "untrusted_web_content"); got != tt.wantWrap {
+				t.Errorf("Expected untrusted content framing %v, request body: %s", tt.wantWrap, sent)
+			}
+		})
+	}
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFetchWithHTTPUserAgent(t *testing.T) {
+	var userAgent string
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		_, _ = fmt.Fprint(w, "page content")
+	}))
+	defer page.Close()
+
+	for _, want := range []string{"my-agent/1.0 (+https://example.com/bot)", constants.DefaultUserAgent} {
+		var opts []ConfigOption
+		if want != constants.DefaultUserAgent {
+			opts = append(opts, WithUserAgent(want))
+		}
+		fetcher, err := NewWebFetcher(newTestConfig("", opts...))
+		if err != nil {
+			t.Fatalf("Failed to create fetcher: %v", err)
+		}
+		pageURL := useTestPageServer(fetcher, page) + "/page"
+
+		if _, err := fetcher.fetchWithHTTP(context.Background(), pageURL, "", time.Now()); err != nil {
+			t.Fatalf("fetchWithHTTP returned error: %v", err)
+		}
+		if userAgent != want {
+			t.Errorf("User-Agent = %q, want %q", userAgent, want)
+		}
+	}
+}
+
+func TestFetchWithHTTPDebugHeaders(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "page content")
+	}))
+	defer page.Close()
+
+	for _, debug := range []bool{true, false} {
+		config := newTestConfig("")
+		config.WebFetch.DebugHeaders = debug
+		fetcher, err := NewWebFetcher(config)
+		if err != nil {
+			t.Fatalf("Failed to create fetcher: %v", err)
+		}
+		pageURL := useTestPageServer(fetcher, page) + "/page"
+
+		// Add credentials below the fetcher, as a proxy or custom transport might
+		base := fetcher.httpClient.client.Transport
+		fetcher.httpClient.client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set("Authorization", "Bearer secret-token")
+			req.Header.Set("Cookie", "session=secret")
+			return base.RoundTrip(req)
+		})
+
+		result, err := fetcher.fetchWithHTTP(context.Background(), pageURL, "", time.Now())
+		if err != nil {
+			t.Fatalf("fetchWithHTTP returned error: %v", err)
+		}
+
+		headers := result.Metadata.RequestHeaders
+		if !debug {
+			if headers != nil {
+				t.Errorf("Expected no request headers without DebugHeaders, got %v", headers)
+			}
+			continue
+		}
+
+		if headers["User-Agent"] != constants.DefaultUserAgent {
+			t.Errorf("User-Agent = %q, want %q", headers["User-Agent"], constants.DefaultUserAgent)
+		}
+		if headers["Referrer-Policy"] != "no-referrer" {
+			t.Errorf("Expected Referrer-Policy to be captured, got %v", headers)
+		}
+		for _, name := range []string{"Authorization", "Cookie"} {
+			if headers[name] != constants.RedactedHeaderValue {
+				t.Errorf("%s = %q, want redacted", name, headers[name])
+			}
+		}
+		for name, value := range headers {
+			if strings.Contains(value, "secret") {
+				t.Errorf("Header %s leaks a secret: %q", name, value)
+			}
+		}
+	}
+}
+
+// waitForGoroutines waits for the number of goroutines to drop back to at most limit,
+// calling closeIdle on each check to drop connections that are not leaks.
+func waitForGoroutines(t *testing.T, limit int, closeIdle func()) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for closeIdle(); runtime.NumGoroutine() > limit; closeIdle() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Goroutines leaked: %d running, want at most %d", runtime.NumGoroutine(), limit)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// settledGoroutineCount returns the number of goroutines once it has stopped changing.
+func settledGoroutineCount() int {
+	count := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		time.Sleep(20 * time.Millisecond)
+		next := runtime.NumGoroutine()
+		if next == count {
+			break
+		}
+		count = next
+	}
+	return count
+}
+
+func TestCancellationDoesNotLeakGoroutines(t *testing.T) {
+	codeAssist := newFakeCodeAssistServer(t, blockUntilCancelled)
+	page := httptest.NewServer(http.HandlerFunc(blockUntilCancelled))
+	defer page.Close()
+
+	config := newTestConfig(codeAssist.URL)
+	searcher, err := NewWebSearcher(config)
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+	fetcher, err := NewWebFetcher(config)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	pageURL := useTestPageServer(fetcher, page)
+
+	calls := map[string]func(ctx context.Context) error{
+		"search": func(ctx context.Context) error {
+			_, err := searcher.Search(ctx, "golang")
+			return err
+		},
+		"fetch": func(ctx context.Context) error {
+			_, err := fetcher.Fetch(ctx, "Summarize "+pageURL)
+			return err
+		},
+		"http fallback": func(ctx context.Context) error {
+			_, err := fetcher.fetchWithHTTP(ctx, pageURL, "Summarize", time.Now())
+			return err
+		},
+	}
+
+	// Warm up so that background token refresh and setup connections are already running
+	for _, call := range calls {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_ = call(ctx)
+		cancel()
+	}
+
+	// Idle keep-alive connections are not leaks, so close them before counting
+	closeConnections := func() {
+		codeAssist.CloseClientConnections()
+		page.CloseClientConnections()
+	}
+	closeConnections()
+	baseline := settledGoroutineCount()
+
+	for name, call := range calls {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(time.Duration(i%5)*time.Millisecond, cancel)
+				if err := call(ctx); !errors.Is(err, context.Canceled) {
+					t.Errorf("%s: expected context.Canceled, got %v", name, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	waitForGoroutines(t, baseline, closeConnections)
+}
+
+func TestFetchMultiple(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "page %s", r.URL.Path)
+	}))
+	defer page.Close()
+
+	tests := []struct {
+		name       string
+		aiText     string
+		prompt     string
+		want       []string
+		wantErrFor string
+	}{
+		{
+			name:   "AI answers the combined prompt",
+			aiText: "Both pages describe Go.",
+			prompt: "compare http://example.com/a and http://example.com/b",
+			want:   []string{"Both pages describe Go."},
+		},
+		{
+			name:   "fallback fetches each URL",
+			prompt: "compare http://example.com/a and http://example.com/b",
+			want:   []string{"page /a", "page /b"},
+		},
+		{
+			name:       "fallback keeps results of other URLs",
+			prompt:     "compare http://example.com/missing and http://example.com/b",
+			want:       []string{"", "page /b"},
+			wantErrFor: "http://example.com/missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prompts []string
+			var mu sync.Mutex
+			codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				prompts = append(prompts, string(body))
+				mu.Unlock()
+				writeCodeAssistText(w, tt.aiText)
+			})
+
+			fetcher, err := NewWebFetcher(newTestConfig(codeAssist.URL))
+			if err != nil {
+				t.Fatalf("Failed to create fetcher: %v", err)
+			}
+			useTestPageServer(fetcher, page)
+
+			results, err := fetcher.FetchMultiple(context.Background(), tt.prompt)
+			if tt.wantErrFor == "" && err != nil {
+				t.Fatalf("FetchMultiple returned error: %v", err)
+			}
+			if tt.wantErrFor != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErrFor)) {
+				t.Fatalf("Expected error for %s, got %v", tt.wantErrFor, err)
+			}
+
+			if len(results) != len(tt.want) {
+				t.Fatalf("Expected %d results, got %d", len(tt.want), len(results))
+			}
+			for i, want := range tt.want {
+				if got := strings.TrimSpace(results[i].Content); got != want {
+					t.Errorf("results[%d].Content = %q, want %q", i, got, want)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(prompts) != 1 || !strings.Contains(prompts[0], tt.prompt) {
+				t.Errorf("Expected the combined prompt to be sent to the AI once, got %d requests", len(prompts))
+			}
+		})
+	}
+}
+
+func TestProcessHTTPResponseIncludePromptInDisplay(t *testing.T) {
+	const pageURL = "https://example.com"
+	const prompt = "Summarize https://example.com"
+
+	tests := []struct {
+		name    string
+		include bool
+		want    string
+	}{
+		{
+			name:    "included",
+			include: true,
+			want:    "Content from https://example.com:\n\nHello\n\nUser request: Summarize https://example.com",
+		},
+		{
+			name:    "content only",
+			include: false,
+			want:    "Hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher, err := NewWebFetcher(newTestConfig("", WithIncludePromptInDisplay(tt.include)))
+			if err != nil {
+				t.Fatalf("Failed to create fetcher: %v", err)
+			}
+
+			result, err := fetcher.processHTTPResponse("Hello", "text/plain", 5, pageURL, prompt, time.Now())
+			if err != nil {
+				t.Fatalf("processHTTPResponse returned error: %v", err)
+			}
+			if result.DisplayText != tt.want {
+				t.Errorf("DisplayText = %q, want %q", result.DisplayText, tt.want)
+			}
+			if result.Content != "Hello" {
+				t.Errorf("Content = %q, want %q", result.Content, "Hello")
+			}
+		})
+	}
+}
+
+func TestProcessingTimeFields(t *testing.T) {
+	fetcher, err := NewWebFetcher(newTestConfig(""))
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	searcher, err := NewWebSearcher(newTestConfig(""))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+	startTime := time.Now().Add(-1500 * time.Millisecond)
+
+	fetchResult, err := fetcher.processHTTPResponse("Hello", "text/plain", 5, "https://example.com", "", startTime)
+	if err != nil {
+		t.Fatalf("processHTTPResponse returned error: %v", err)
+	}
+	searchResult, err := searcher.processSearchResponse(&types.GenerateContentResponse{}, "golang", types.SearchOptions{}, startTime)
+	if err != nil {
+		t.Fatalf("processSearchResponse returned error: %v", err)
+	}
+	errorResult := noURLsResult("", startTime)
+
+	tests := []struct {
+		name string
+		text string
+		ms   int64
+	}{
+		{"fetch", fetchResult.Metadata.ProcessingTime, fetchResult.Metadata.ProcessingTimeMs},
+		{"fetch error", errorResult.Metadata.ProcessingTime, errorResult.Metadata.ProcessingTimeMs},
+		{"search", searchResult.Metadata.ProcessingTime, searchResult.Metadata.ProcessingTimeMs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := time.ParseDuration(tt.text)
+			if err != nil {
+				t.Fatalf("ProcessingTime %q is not a duration: %v", tt.text, err)
+			}
+			if tt.ms < 1500 || tt.ms != d.Milliseconds() {
+				t.Errorf("ProcessingTimeMs = %d, want %d from ProcessingTime %q", tt.ms, d.Milliseconds(), tt.text)
+			}
+		})
+	}
+}
+
+func TestFetchMaxOperationTime(t *testing.T) {
+	const budget = 100 * time.Millisecond
+	release := make(chan struct{})
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	codeAssist := newFakeCodeAssistServer(t, slow)
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		case <-time.After(time.Second):
+		}
+		_, _ = w.Write([]byte("page content"))
+	}))
+	defer page.Close()
+	defer close(release)
+
+	fetcher, err := NewWebFetcher(newTestConfig(codeAssist.URL, WithMaxOperationTime(budget)))
+	if err != nil {
+		t.Fatalf("NewWebFetcher returned error: %v", err)
+	}
+	pageURL := useTestPageServer(fetcher, page)
+
+	start := time.Now()
+	result, err := fetcher.Fetch(context.Background(), "Summarize "+pageURL)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the operation budget to be exceeded, got %v", err)
+	}
+	if result == nil || result.Metadata.Error == "" {
+		t.Errorf("Expected a result describing the timeout, got %+v", result)
+	}
+	// The slow AI and the slow fallback alone would take two seconds
+	if elapsed > budget+500*time.Millisecond {
+		t.Errorf("Fetch took %v, expected it to be cut off at %v", elapsed, budget)
+	}
+
+	searcher, err := NewWebSearcher(newTestConfig(codeAssist.URL, WithMaxOperationTime(budget)))
+	if err != nil {
+		t.Fatalf("NewWebSearcher returned error: %v", err)
+	}
+	start = time.Now()
+	if _, err := searcher.Search(context.Background(), "query"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the search budget to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > budget+500*time.Millisecond {
+		t.Errorf("Search took %v, expected it to be cut off at %v", elapsed, budget)
+	}
+}
@@ -4,7 +4,10 @@ package geminiwebtools
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/d-kuro/geminiwebtools/pkg/auth"
@@ -18,40 +21,192 @@ type WebSearcher struct {
 	auth       *auth.SharedAuthenticator
 	codeAssist *auth.CodeAssistClient
 	grounding  *GroundingProcessor
+
+	// cache holds the results of earlier searches, or is nil if caching is disabled
+	cache *resultCache[*types.WebSearchResult]
 }
 
 // NewWebSearcher creates a new web searcher with the provided configuration.
 func NewWebSearcher(config *Config) (*WebSearcher, error) {
 	if config == nil {
-		config = NewConfig()
+		var err error
+		if config, err = NewConfigE(); err != nil {
+			return nil, err
+		}
 	}
 
-	// Create OAuth2 authenticator and wrap with shared authenticator
-	oauth2Auth := auth.NewOAuth2Authenticator(config.OAuth2Config, config.CredentialStore)
-	sharedAuth := auth.NewSharedAuthenticator(oauth2Auth)
+	oauth2Auth := newOAuth2Authenticator(config)
+	return newWebSearcher(config, oauth2Auth, newCodeAssistClient(config, oauth2Auth))
+}
 
-	// Create CodeAssist client
-	codeAssist := auth.NewCodeAssistClient(
-		oauth2Auth,
-		config.CodeAssistEndpoint,
-		config.DefaultModel,
-	)
+// newWebSearcher creates a web searcher that uses the given OAuth2 authenticator and CodeAssist client.
+func newWebSearcher(config *Config, oauth2Auth *auth.OAuth2Authenticator, codeAssist *auth.CodeAssistClient) (*WebSearcher, error) {
+	sharedAuth := auth.NewSharedAuthenticator(oauth2Auth)
 
 	// Create grounding processor
 	grounding := NewGroundingProcessor()
+	grounding.SetInsertCitations(config.WebSearch.InsertCitations)
 
 	return &WebSearcher{
 		config:     config,
 		auth:       sharedAuth,
 		codeAssist: codeAssist,
 		grounding:  grounding,
+		cache:      newConfiguredCache[*types.WebSearchResult](config),
 	}, nil
 }
 
+// Grounding returns the grounding processor used to add citations to results.
+// Its settings can be adjusted at runtime and apply to subsequent calls.
+func (ws *WebSearcher) Grounding() *GroundingProcessor {
+	return ws.grounding
+}
+
 // Search performs a web search using the configured AI model and returns processed results.
 // Follows gemini-cli interface: accepts a simple query string.
 func (ws *WebSearcher) Search(ctx context.Context, query string) (*types.WebSearchResult, error) {
+	return ws.search(ctx, nil, query, "", types.SearchOptions{})
+}
+
+// SearchWithModel is like Search but uses the given model for this search only.
+func (ws *WebSearcher) SearchWithModel(ctx context.Context, query, model string) (*types.WebSearchResult, error) {
+	if err := validateModel(model); err != nil {
+		return nil, err
+	}
+	return ws.search(ctx, nil, query, model, types.SearchOptions{})
+}
+
+// SearchWithOptions is like Search but applies the given options to this search.
+// Sources are filtered after the response is received: sources from BlockedDomains are
+// dropped and, if AllowedDomains is set, only sources from those domains are kept.
+// Domains match their subdomains too, as with WebSearchConfig.PreferredDomains.
+// A positive MaxResults caps the number of sources. SearchRegion asks the model to
+// prefer results relevant to that region; it is a preference, not a filter.
+// The options are reported in the result metadata.
+func (ws *WebSearcher) SearchWithOptions(ctx context.Context, query string, opts types.SearchOptions) (*types.WebSearchResult, error) {
+	return ws.search(ctx, nil, query, "", opts)
+}
+
+// SearchWithHistory is like Search but sends the prior turns of a conversation, user
+// and model turns in turn, before the query so that the query can refer to them. It
+// returns an error without sending the request if a turn has a role other than "user"
+// or "model", or if the history and query do not end with a user turn.
+func (ws *WebSearcher) SearchWithHistory(ctx context.Context, history []types.Content, query string) (*types.WebSearchResult, error) {
+	if err := auth.ValidateContents(ws.codeAssist.CreateSearchRequestWithHistory(history, query).Contents); err != nil {
+		return nil, fmt.Errorf("invalid conversation history: %w", err)
+	}
+	return ws.search(ctx, history, query, "", types.SearchOptions{})
+}
+
+// SearchStream is like Search but streams the display text as the model generates it.
+// Each delta on the returned channel continues the display text; the sources list is
+// sent last, once the grounding metadata has arrived. The channel is closed when the
+// search ends, fails or ctx is done. A failure after streaming started is sent as a
+// final delta with Err set. RetryOnEmpty and MaxDisplayLength do not apply to streams.
+func (ws *WebSearcher) SearchStream(ctx context.Context, query string) (<-chan types.SearchDelta, error) {
+	ctx, cancel := ws.config.operationContext(ctx)
+	req := ws.codeAssist.CreateSearchRequest(ws.buildSearchQuery(query, ""))
+	chunks, err := ws.codeAssist.StreamGenerateContent(ctx, req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("web search failed: %w", err)
+	}
+
+	deltas := make(chan types.SearchDelta)
+	go func() {
+		defer close(deltas)
+		defer cancel()
+
+		send := func(delta types.SearchDelta) bool {
+			select {
+			case deltas <- delta:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var groundingMetadata *types.GroundingMetadata
+		for chunk := range chunks {
+			if chunk.Err != nil {
+				send(types.SearchDelta{Err: fmt.Errorf("web search failed: %w", chunk.Err)})
+				return
+			}
+			if text := responseText(chunk.Response); text != "" && !send(types.SearchDelta{DisplayText: text}) {
+				return
+			}
+			if len(chunk.Response.Candidates) > 0 && chunk.Response.Candidates[0].GroundingMetadata != nil {
+				groundingMetadata = chunk.Response.Candidates[0].GroundingMetadata
+			}
+		}
+
+		// The chunks channel is also closed when ctx is done; the stream is then incomplete
+		if ctx.Err() != nil || groundingMetadata == nil || ws.grounding == nil {
+			return
+		}
+
+		// The grounding processor only appends to the text, so the sources alone continue it
+		groundingMetadata = prioritizeDomains(groundingMetadata, ws.config.WebSearch.PreferredDomains)
+		if sources := ws.grounding.ProcessGrounding("", groundingMetadata); sources != "" {
+			send(types.SearchDelta{DisplayText: sources})
+		}
+	}()
+
+	return deltas, nil
+}
+
+// search performs a web search with the given model, or the client's model if empty,
+// after the prior turns of history, if any, and tags the result with the audit ID of ctx.
+func (ws *WebSearcher) search(ctx context.Context, history []types.Content, query, model string, opts types.SearchOptions) (*types.WebSearchResult, error) {
+	result, err := ws.cachedSearch(ctx, history, query, model, opts)
+	if result != nil {
+		result.Metadata.AuditID = AuditIDFromContext(ctx)
+	}
+	return result, err
+}
+
+// cachedSearch performs a search like searchUncached. Successful searches are served
+// from the result cache, if enabled.
+func (ws *WebSearcher) cachedSearch(ctx context.Context, history []types.Content, query, model string, opts types.SearchOptions) (*types.WebSearchResult, error) {
+	if ws.cache == nil {
+		return ws.searchUncached(ctx, history, query, model, opts)
+	}
+
 	startTime := time.Now()
+	if model == "" {
+		model = ws.codeAssist.Model()
+	}
+	optsKey, _ := json.Marshal(opts)
+	key := cacheKey("search", model, auth.SessionIDFromContext(ctx), strings.TrimSpace(query), string(optsKey))
+	if len(history) > 0 {
+		historyKey, _ := json.Marshal(history)
+		key = cacheKey(key, string(historyKey))
+	}
+	if cached, ok := ws.cache.get(key); ok {
+		result := cloneSearchResult(cached)
+		result.Metadata.CacheHit = true
+		result.Metadata.SetProcessingTime(time.Since(startTime))
+		return result, nil
+	}
+
+	result, err := ws.searchUncached(ctx, history, query, model, opts)
+	if err == nil && result != nil {
+		ws.cache.put(key, cloneSearchResult(result))
+	}
+	return result, err
+}
+
+// ClearCache removes every cached search result.
+func (ws *WebSearcher) ClearCache() {
+	ws.cache.clear()
+}
+
+// searchUncached performs a web search with the given model, or the client's model if
+// empty, after the prior turns of history, if any.
+func (ws *WebSearcher) searchUncached(ctx context.Context, history []types.Content, query, model string, opts types.SearchOptions) (*types.WebSearchResult, error) {
+	startTime := time.Now()
+	ctx, cancel := ws.config.operationContext(ctx)
+	defer cancel()
 
 	// Check if context is already cancelled
 	select {
@@ -61,71 +216,91 @@ func (ws *WebSearcher) Search(ctx context.Context, query string) (*types.WebSear
 	}
 
 	// Create search request
-	req := ws.codeAssist.CreateSearchRequest(query)
+	req := ws.codeAssist.CreateSearchRequestWithHistory(history, ws.buildSearchQuery(query, opts.SearchRegion))
+	req.Model = model
+	if opts.CandidateCount > 1 {
+		req.GenerationConfig = &types.GenerationConfig{CandidateCount: opts.CandidateCount}
+	}
 
 	// Create a timeout context for the search request
 	searchCtx, cancel := context.WithTimeout(ctx, constants.AIRequestTimeout)
 	defer cancel()
 
-	// Use a channel to handle the response and enable proper cancellation
-	type searchResult struct {
-		resp *types.GenerateContentResponse
-		err  error
+	// The CodeAssist client honors the context, so the call returns as soon as it is done
+	resp, err := ws.codeAssist.GenerateContent(searchCtx, req)
+	retried := false
+	if err == nil && ws.config.WebSearch.RetryOnEmpty && !isGroundedAnswer(resp) {
+		retried = true
+		resp, err = ws.retryEmptySearch(searchCtx, req, resp)
 	}
-
-	resultChan := make(chan searchResult, 1)
-
-	// Run the search request in a goroutine to allow for cancellation
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				resultChan <- searchResult{nil, fmt.Errorf("panic in search request: %v", r)}
-			}
-		}()
-
-		resp, err := ws.codeAssist.GenerateContent(searchCtx, req)
-		select {
-		case resultChan <- searchResult{resp, err}:
-		case <-searchCtx.Done():
-			// Context was cancelled, don't send result
-		}
-	}()
-
-	// Wait for either result or cancellation
-	select {
-	case res := <-resultChan:
-		if res.err != nil {
-			return &types.WebSearchResult{
-				Summary:     fmt.Sprintf("Search failed: %s", query),
+	if err != nil {
+		if ctxErr := searchCtx.Err(); ctxErr != nil {
+			return timedSearchResult(&types.WebSearchResult{
+				Summary:     fmt.Sprintf("Search %s: %s", contextErrorLabel(ctxErr), query),
 				Content:     "",
-				DisplayText: fmt.Sprintf("Error performing search: %v", res.err),
+				DisplayText: fmt.Sprintf("Error: Search %s", contextErrorMessage(ctxErr)),
 				Metadata: types.WebSearchMetadata{
-					Query:          query,
-					ProcessingTime: time.Since(startTime).String(),
-					APIUsed:        "codeassist",
-					HasGrounding:   false,
-					Error:          res.err.Error(),
+					Query:        query,
+					APIUsed:      "codeassist",
+					HasGrounding: false,
+					Error:        contextErrorMessage(ctxErr),
 				},
-			}, fmt.Errorf("web search failed: %w", res.err)
+			}, startTime), ctxErr
 		}
 
-		// Process the response
-		return ws.processSearchResponse(res.resp, query, startTime)
-
-	case <-searchCtx.Done():
-		return &types.WebSearchResult{
-			Summary:     fmt.Sprintf("Search timeout: %s", query),
+		return timedSearchResult(&types.WebSearchResult{
+			Summary:     fmt.Sprintf("Search failed: %s", query),
 			Content:     "",
-			DisplayText: "Error: Search request timed out or was cancelled",
+			DisplayText: fmt.Sprintf("Error performing search: %v", err),
 			Metadata: types.WebSearchMetadata{
-				Query:          query,
-				ProcessingTime: time.Since(startTime).String(),
-				APIUsed:        "codeassist",
-				HasGrounding:   false,
-				Error:          searchCtx.Err().Error(),
+				Query:        query,
+				APIUsed:      "codeassist",
+				HasGrounding: false,
+				Error:        err.Error(),
 			},
-		}, searchCtx.Err()
+		}, startTime), fmt.Errorf("web search failed: %w", err)
+	}
+
+	// Process the response
+	result, err := ws.processSearchResponse(resp, query, opts, startTime)
+	if result != nil {
+		result.Metadata.RetriedOnEmpty = retried
+		result.DisplayText = truncateRunes(result.DisplayText, ws.config.MaxDisplayLength)
+		for i := range result.Candidates {
+			result.Candidates[i].DisplayText = truncateRunes(result.Candidates[i].DisplayText, ws.config.MaxDisplayLength)
+		}
 	}
+	return result, err
+}
+
+// retryEmptySearch sends the search request once more after a short backoff.
+// The first response is kept if the retry fails, unless the context is done.
+// The token usage of the retry includes the first request.
+func (ws *WebSearcher) retryEmptySearch(ctx context.Context, req *types.GenerateContentRequest, first *types.GenerateContentResponse) (*types.GenerateContentResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(constants.SearchRetryOnEmptyDelay):
+	}
+
+	resp, err := ws.codeAssist.GenerateContent(ctx, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return first, nil
+	}
+	resp.UsageMetadata = first.UsageMetadata.Add(resp.UsageMetadata)
+	return resp, nil
+}
+
+// isGroundedAnswer reports whether a search response has text and grounding sources.
+func isGroundedAnswer(resp *types.GenerateContentResponse) bool {
+	if strings.TrimSpace(responseText(resp)) == "" {
+		return false
+	}
+	grounding := resp.Candidates[0].GroundingMetadata
+	return grounding != nil && len(grounding.GroundingChunks) > 0
 }
 
 // IsAuthenticated checks if the searcher has valid authentication.
@@ -150,56 +325,130 @@ func (ws *WebSearcher) ClearAuthentication() error {
 	return ws.auth.ClearAuthentication()
 }
 
+// buildSearchQuery augments the query with the configured search preferences.
+func (ws *WebSearcher) buildSearchQuery(query, region string) string {
+	if len(ws.config.WebSearch.PreferredDomains) > 0 {
+		query += fmt.Sprintf(constants.PreferredDomainsInstruction, strings.Join(ws.config.WebSearch.PreferredDomains, ", "))
+	}
+	if region = strings.TrimSpace(region); region != "" {
+		query += fmt.Sprintf(constants.SearchRegionInstruction, region)
+	}
+	return query
+}
+
 // processSearchResponse processes the AI response into a structured search result.
-func (ws *WebSearcher) processSearchResponse(resp *types.GenerateContentResponse, query string, startTime time.Time) (*types.WebSearchResult, error) {
-	result := &types.WebSearchResult{
+func (ws *WebSearcher) processSearchResponse(resp *types.GenerateContentResponse, query string, opts types.SearchOptions, startTime time.Time) (*types.WebSearchResult, error) {
+	result := timedSearchResult(&types.WebSearchResult{
 		Summary: fmt.Sprintf("Web search for: %s", query),
 		Metadata: types.WebSearchMetadata{
-			Query:          query,
-			ProcessingTime: time.Since(startTime).String(),
-			APIUsed:        "codeassist",
+			Query:            query,
+			SearchRegion:     opts.SearchRegion,
+			AllowedDomains:   opts.AllowedDomains,
+			BlockedDomains:   opts.BlockedDomains,
+			APIUsed:          "codeassist",
+			PreferredDomains: ws.config.WebSearch.PreferredDomains,
+			TokenUsage:       resp.UsageMetadata,
 		},
-	}
+	}, startTime)
 
-	// No options processing needed for simplified interface
+	if len(resp.Candidates) == 0 {
+		return result, nil
+	}
 
-	// Extract content from the first candidate
-	if len(resp.Candidates) > 0 {
-		candidate := resp.Candidates[0]
+	// Describe the first candidate, or the best of several requested ones
+	answer := ws.processCandidate(resp.Candidates[0], opts)
+	if opts.CandidateCount > 1 {
+		answers := make([]searchAnswer, 0, len(resp.Candidates))
+		answers = append(answers, answer)
+		for _, candidate := range resp.Candidates[1:] {
+			answers = append(answers, ws.processCandidate(candidate, opts))
+		}
+		rankAnswers(answers)
 
-		// Build content from parts
-		var contentBuilder, displayBuilder string
-		for _, part := range candidate.Content.Parts {
-			contentBuilder += part.Text
-			displayBuilder += part.Text
+		answer = answers[0]
+		result.Candidates = make([]types.SearchCandidate, 0, len(answers))
+		for _, a := range answers {
+			result.Candidates = append(result.Candidates, a.SearchCandidate)
 		}
+	}
 
-		result.Content = contentBuilder
-		result.DisplayText = displayBuilder
+	result.Content = answer.Content
+	result.DisplayText = answer.DisplayText
+	result.Sources = answer.Sources
+	if grounding := answer.grounding; grounding != nil {
+		result.Metadata.HasGrounding = true
+		result.Metadata.WebSearchQueries = grounding.WebSearchQueries
+		result.Metadata.SourceCount = len(grounding.GroundingChunks)
+		result.Metadata.SupportCount = len(grounding.GroundingSupports)
+	}
 
-		// Process grounding metadata if available
-		if candidate.GroundingMetadata != nil {
-			result.Metadata.HasGrounding = true
-			result.Metadata.WebSearchQueries = candidate.GroundingMetadata.WebSearchQueries
+	return result, nil
+}
 
-			// Process grounding chunks as sources
-			if len(candidate.GroundingMetadata.GroundingChunks) > 0 {
-				result.Sources = candidate.GroundingMetadata.GroundingChunks
-				result.Metadata.SourceCount = len(candidate.GroundingMetadata.GroundingChunks)
-			}
+// searchAnswer is a candidate of a search response processed into an answer.
+type searchAnswer struct {
+	types.SearchCandidate
 
-			// Count grounding supports
-			if len(candidate.GroundingMetadata.GroundingSupports) > 0 {
-				result.Metadata.SupportCount = len(candidate.GroundingMetadata.GroundingSupports)
-			}
+	// grounding is the grounding metadata of the answer after filtering, or nil
+	grounding *types.GroundingMetadata
+}
 
-			// Apply grounding processing for better formatting
-			if ws.grounding != nil {
-				processed := ws.grounding.ProcessGrounding(result.DisplayText, candidate.GroundingMetadata)
-				result.DisplayText = processed
-			}
+// processCandidate processes a candidate of a search response into an answer with
+// its own sources and citations.
+func (ws *WebSearcher) processCandidate(candidate types.Candidate, opts types.SearchOptions) searchAnswer {
+	var content strings.Builder
+	for _, part := range candidate.Content.Parts {
+		content.WriteString(part.Text)
+	}
+	answer := searchAnswer{SearchCandidate: types.SearchCandidate{
+		Content:      content.String(),
+		DisplayText:  content.String(),
+		FinishReason: candidate.FinishReason,
+	}}
+	if candidate.GroundingMetadata == nil {
+		return answer
+	}
+
+	// Drop sources excluded by the options, then rank sources from preferred domains first
+	groundingMetadata := filterDomains(candidate.GroundingMetadata, opts.AllowedDomains, opts.BlockedDomains)
+	groundingMetadata = prioritizeDomains(groundingMetadata, ws.config.WebSearch.PreferredDomains)
+	groundingMetadata = limitGroundingChunks(groundingMetadata, opts.MaxResults)
+	answer.grounding = groundingMetadata
+
+	// Process grounding chunks as sources
+	if len(groundingMetadata.GroundingChunks) > 0 {
+		answer.Sources = applyFavicons(groundingMetadata.GroundingChunks, ws.config.WebSearch.FaviconURL)
+		attachSnippets(answer.Sources, groundingMetadata.GroundingSupports)
+	}
+
+	// Apply grounding processing for better formatting
+	if ws.grounding != nil {
+		answer.DisplayText = ws.grounding.ProcessGrounding(answer.DisplayText, groundingMetadata)
+	}
+	return answer
+}
+
+// rankAnswers orders answers best first: completed answers before those cut short,
+// for example by the token limit or a safety filter, then grounded answers before
+// those without sources. Otherwise the order of the response is kept.
+func rankAnswers(answers []searchAnswer) {
+	rank := func(a searchAnswer) int {
+		r := 0
+		if a.FinishReason != "" && a.FinishReason != constants.FinishReasonStop {
+			r += 2
 		}
+		if len(a.Sources) == 0 {
+			r++
+		}
+		return r
 	}
+	slices.SortStableFunc(answers, func(a, b searchAnswer) int {
+		return rank(a) - rank(b)
+	})
+}
 
-	return result, nil
+// timedSearchResult records the time elapsed since start in the result metadata.
+func timedSearchResult(result *types.WebSearchResult, start time.Time) *types.WebSearchResult {
+	result.Metadata.SetProcessingTime(time.Since(start))
+	return result
 }
@@ -1,11 +1,23 @@
 package geminiwebtools
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/oauth2"
 
 	"github.com/d-kuro/geminiwebtools/pkg/storage"
+	"github.com/d-kuro/geminiwebtools/pkg/types"
 )
 
 // Mock credential store for websearch testing
@@ -207,3 +219,565 @@ func TestWebSearcherComponents(t *testing.T) {
 		t.Error("Config should be initialized")
 	}
 }
+
+// newTestChunk creates a grounding chunk with the given title and URI.
+func newTestChunk(title, uri string) types.GroundingChunk {
+	var chunk types.GroundingChunk
+	chunk.Web.Title = title
+	chunk.Web.URI = uri
+	return chunk
+}
+
+func TestProcessSearchResponsePreferredDomains(t *testing.T) {
+	searcher, err := NewWebSearcher(NewConfig(
+		WithCredentialStore(&mockWebSearchCredentialStore{}),
+		WithPreferredDomains("go.dev"),
+	))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+
+	support := types.GroundingSupport{GroundingChunkIndices: []int{1, 3}}
+	support.Segment.Text = "Go is an open source language"
+
+	resp := &types.GenerateContentResponse{
+		Candidates: []types.Candidate{
+			{
+				Content: types.CandidateContent{Parts: []types.CandidatePart{{Text: "Go is an open source language."}}},
+				GroundingMetadata: &types.GroundingMetadata{
+					GroundingChunks: []types.GroundingChunk{
+						newTestChunk("Example", "https://example.com/go"),
+						newTestChunk("Go", "https://go.dev/doc"),
+						newTestChunk("Other", "https://other.org/go"),
+						newTestChunk("Go Blog", "https://blog.go.dev/intro"),
+					},
+					GroundingSupports: []types.GroundingSupport{support},
+				},
+			},
+		},
+	}
+
+	result, err := searcher.processSearchResponse(resp, "golang", types.SearchOptions{}, time.Now())
+	if err != nil {
+		t.Fatalf("processSearchResponse returned error: %v", err)
+	}
+
+	expectedOrder := []string{
+		"https://go.dev/doc",
+		"https://blog.go.dev/intro",
+		"https://example.com/go",
+		"https://other.org/go",
+	}
+	if len(result.Sources) != len(expectedOrder) {
+		t.Fatalf("Expected %d sources, got %d", len(expectedOrder), len(result.Sources))
+	}
+	for i, uri := range expectedOrder {
+		if result.Sources[i].Web.URI != uri {
+			t.Errorf("Sources[%d] = %s, want %s", i, result.Sources[i].Web.URI, uri)
+		}
+	}
+
+	if len(result.Metadata.PreferredDomains) != 1 || result.Metadata.PreferredDomains[0] != "go.dev" {
+		t.Errorf("Expected preferred domains to be recorded in metadata, got %v", result.Metadata.PreferredDomains)
+	}
+
+	// The support referenced chunks 1 and 3, which now sit at positions 0 and 1
+	reordered := prioritizeDomains(resp.Candidates[0].GroundingMetadata, []string{"go.dev"})
+	indices := reordered.GroundingSupports[0].GroundingChunkIndices
+	if len(indices) != 2 || indices[0] != 0 || indices[1] != 1 {
+		t.Errorf("Expected support indices to be remapped to [0 1], got %v", indices)
+	}
+
+	query := searcher.buildSearchQuery("golang", "")
+	if !strings.Contains(query, "go.dev") {
+		t.Errorf("Expected search query to mention preferred domains, got %q", query)
+	}
+}
+
+func TestApplyFavicons(t *testing.T) {
+	sources := []types.GroundingChunk{
+		newTestChunk("Go", "https://www.go.dev/doc"),
+		newTestChunk("example.com", "https://vertexaisearch.cloud.google.com/grounding-api-redirect/abc"),
+		newTestChunk("Unknown", "https://vertexaisearch.cloud.google.com/grounding-api-redirect/def"),
+		newTestChunk("README.md", "https://vertexaisearch.cloud.google.com/grounding-api-redirect/ghi"),
+		newTestChunk("readme.md", "https://vertexaisearch.cloud.google.com/grounding-api-redirect/jkl"),
+		newTestChunk("v1.2", "https://vertexaisearch.cloud.google.com/grounding-api-redirect/mno"),
+	}
+
+	tests := []struct {
+		name       string
+		faviconURL FaviconURLFunc
+		expected   []string
+	}{
+		{
+			name:       "default direct favicon",
+			faviconURL: nil,
+			expected: []string{
+				"https://go.dev/favicon.ico",
+				"https://example.com/favicon.ico",
+				"", "", "", "",
+			},
+		},
+		{
+			name:       "google favicon service",
+			faviconURL: GoogleFaviconURL,
+			expected: []string{
+				"https://www.google.com/s2/favicons?sz=64&domain=go.dev",
+				"https://www.google.com/s2/favicons?sz=64&domain=example.com",
+				"", "", "", "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := applyFavicons(sources, tt.faviconURL)
+			for i, source := range result {
+				if source.FaviconURL != tt.expected[i] {
+					t.Errorf("source %d: FaviconURL = %q, want %q", i, source.FaviconURL, tt.expected[i])
+				}
+			}
+			for i, source := range sources {
+				if source.FaviconURL != "" {
+					t.Errorf("source %d: input should not be modified", i)
+				}
+			}
+		})
+	}
+}
+
+// blockUntilCancelled is a generateContent handler that never responds before the client gives up.
+// The request body is drained first so that the server notices when the client disconnects.
+func blockUntilCancelled(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(io.Discard, r.Body)
+	select {
+	case <-r.Context().Done():
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func TestSearchCancellationVersusTimeout(t *testing.T) {
+	codeAssist := newFakeCodeAssistServer(t, blockUntilCancelled)
+
+	searcher, err := NewWebSearcher(newTestConfig(codeAssist.URL))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		newContext func() (context.Context, context.CancelFunc)
+		wantErr    error
+		otherErr   error
+		wantText   string
+	}{
+		{
+			name: "cancelled",
+			newContext: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantErr:  context.Canceled,
+			otherErr: context.DeadlineExceeded,
+			wantText: "cancelled",
+		},
+		{
+			name: "timed out",
+			newContext: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			wantErr:  context.DeadlineExceeded,
+			otherErr: context.Canceled,
+			wantText: "timed out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.newContext()
+			defer cancel()
+
+			result, err := searcher.Search(ctx, "golang")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error to match %v, got %v", tt.wantErr, err)
+			}
+			if errors.Is(err, tt.otherErr) {
+				t.Errorf("Error should not match %v: %v", tt.otherErr, err)
+			}
+			if result == nil || !strings.Contains(result.Metadata.Error, tt.wantText) {
+				t.Errorf("Expected metadata error to mention %q, got %+v", tt.wantText, result)
+			}
+		})
+	}
+}
+
+// writeGroundedCodeAssistText writes a CodeAssist generateContent response with the
+// given text, a single go.dev grounding source and token usage of 15 tokens.
+func writeGroundedCodeAssistText(w http.ResponseWriter, text string) {
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"response": map[string]any{
+			"candidates": []any{
+				map[string]any{
+					"content": map[string]any{
+						"role":  "model",
+						"parts": []any{map[string]any{"text": text}},
+					},
+					"groundingMetadata": map[string]any{
+						"groundingChunks": []any{
+							map[string]any{"web": map[string]any{"uri": "https://go.dev", "title": "go.dev"}},
+						},
+					},
+				},
+			},
+			"usageMetadata": map[string]any{"promptTokenCount": 10, "candidatesTokenCount": 5, "totalTokenCount": 15},
+		},
+	})
+}
+
+func TestSearchRetryOnEmpty(t *testing.T) {
+	tests := []struct {
+		name         string
+		retryOnEmpty bool
+		wantCalls    int32
+		wantRetried  bool
+		wantContent  string
+		wantSources  int
+	}{
+		{name: "retry enabled", retryOnEmpty: true, wantCalls: 2, wantRetried: true, wantContent: "Go is a programming language.", wantSources: 1},
+		{name: "retry disabled", retryOnEmpty: false, wantCalls: 1, wantRetried: false, wantContent: "", wantSources: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					writeCodeAssistText(w, "")
+					return
+				}
+				writeGroundedCodeAssistText(w, "Go is a programming language.")
+			})
+
+			searcher, err := NewWebSearcher(newTestConfig(codeAssist.URL, WithRetryOnEmpty(tt.retryOnEmpty)))
+			if err != nil {
+				t.Fatalf("Failed to create searcher: %v", err)
+			}
+
+			result, err := searcher.Search(context.Background(), "golang")
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("Expected %d generateContent calls, got %d", tt.wantCalls, got)
+			}
+			if result.Metadata.RetriedOnEmpty != tt.wantRetried {
+				t.Errorf("Expected RetriedOnEmpty %v, got %v", tt.wantRetried, result.Metadata.RetriedOnEmpty)
+			}
+			if result.Content != tt.wantContent {
+				t.Errorf("Expected content %q, got %q", tt.wantContent, result.Content)
+			}
+			if len(result.Sources) != tt.wantSources {
+				t.Errorf("Expected %d sources, got %d", tt.wantSources, len(result.Sources))
+			}
+			if tt.wantRetried && (result.Metadata.TokenUsage == nil || result.Metadata.TokenUsage.TotalTokenCount != 15) {
+				t.Errorf("Expected token usage of the retry, got %+v", result.Metadata.TokenUsage)
+			}
+		})
+	}
+}
+
+func TestSearchMaxDisplayLength(t *testing.T) {
+	answer := strings.Repeat("Go is fast. ", 20)
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeGroundedCodeAssistText(w, answer)
+	})
+
+	searcher, err := NewWebSearcher(newTestConfig(codeAssist.URL, WithMaxDisplayLength(30)))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+
+	result, err := searcher.Search(context.Background(), "golang")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if got := utf8.RuneCountInString(result.DisplayText); got != 30 {
+		t.Errorf("Expected display text of 30 runes, got %d: %q", got, result.DisplayText)
+	}
+	if !strings.HasSuffix(result.DisplayText, "...") {
+		t.Errorf("Expected truncated display text to end with an ellipsis, got %q", result.DisplayText)
+	}
+	if result.Content != answer {
+		t.Errorf("Expected content to be kept in full, got %q", result.Content)
+	}
+	if len(result.Sources) != 1 {
+		t.Errorf("Expected sources to be kept, got %d", len(result.Sources))
+	}
+}
+
+func TestSearchStream(t *testing.T) {
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		events := []string{
+			`{"response": {"candidates": [{"content": {"parts": [{"text": "Go is "}]}}]}}`,
+			`{"response": {"candidates": [{"content": {"parts": [{"text": "fast."}]}, "groundingMetadata": {"groundingChunks": [{"web": {"uri": "https://go.dev", "title": "go.dev"}}]}}]}}`,
+		}
+		for _, event := range events {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	})
+
+	searcher, err := NewWebSearcher(newTestConfig(codeAssist.URL))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+
+	deltas, err := searcher.SearchStream(context.Background(), "golang")
+	if err != nil {
+		t.Fatalf("SearchStream failed: %v", err)
+	}
+
+	var texts []string
+	for delta := range deltas {
+		if delta.Err != nil {
+			t.Fatalf("Unexpected stream error: %v", delta.Err)
+		}
+		texts = append(texts, delta.DisplayText)
+	}
+
+	if len(texts) != 3 || texts[0] != "Go is " || texts[1] != "fast." {
+		t.Fatalf("Expected two text deltas and the sources, got %q", texts)
+	}
+	if !strings.Contains(texts[2], "[go.dev](https://go.dev)") {
+		t.Errorf("Expected the last delta to list the sources, got %q", texts[2])
+	}
+}
+
+func TestProcessSearchResponseOptions(t *testing.T) {
+	searcher, err := NewWebSearcher(NewConfig(WithCredentialStore(&mockWebSearchCredentialStore{})))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+
+	support := types.GroundingSupport{GroundingChunkIndices: []int{0, 2}}
+	support.Segment.Text = "Go is an open source language."
+	resp := &types.GenerateContentResponse{
+		Candidates: []types.Candidate{
+			{
+				Content: types.CandidateContent{Parts: []types.CandidatePart{{Text: "Go is an open source language."}}},
+				GroundingMetadata: &types.GroundingMetadata{
+					GroundingChunks: []types.GroundingChunk{
+						newTestChunk("Example", "https://example.com/go"),
+						newTestChunk("Go", "https://go.dev/doc"),
+						newTestChunk("Go Blog", "https://blog.go.dev/intro"),
+						newTestChunk("Other", "https://other.org/go"),
+					},
+					GroundingSupports: []types.GroundingSupport{support},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		opts types.SearchOptions
+		want []string
+	}{
+		{
+			name: "no options",
+			want: []string{"https://example.com/go", "https://go.dev/doc", "https://blog.go.dev/intro", "https://other.org/go"},
+		},
+		{
+			name: "blocked domains",
+			opts: types.SearchOptions{BlockedDomains: []string{"example.com", "other.org"}},
+			want: []string{"https://go.dev/doc", "https://blog.go.dev/intro"},
+		},
+		{
+			name: "allowed domains",
+			opts: types.SearchOptions{AllowedDomains: []string{"go.dev"}},
+			want: []string{"https://go.dev/doc", "https://blog.go.dev/intro"},
+		},
+		{
+			name: "allowed and blocked domains",
+			opts: types.SearchOptions{AllowedDomains: []string{"go.dev"}, BlockedDomains: []string{"blog.go.dev"}},
+			want: []string{"https://go.dev/doc"},
+		},
+		{
+			name: "max results",
+			opts: types.SearchOptions{MaxResults: 2, SearchRegion: "JP"},
+			want: []string{"https://example.com/go", "https://go.dev/doc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := searcher.processSearchResponse(resp, "golang", tt.opts, time.Now())
+			if err != nil {
+				t.Fatalf("processSearchResponse returned error: %v", err)
+			}
+
+			var got []string
+			for _, source := range result.Sources {
+				got = append(got, source.Web.URI)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("Sources = %v, want %v", got, tt.want)
+			}
+			if result.Metadata.SourceCount != len(tt.want) {
+				t.Errorf("SourceCount = %d, want %d", result.Metadata.SourceCount, len(tt.want))
+			}
+			if strings.Contains(result.DisplayText, "other.org") != slices.Contains(tt.want, "https://other.org/go") {
+				t.Errorf("Expected citations to match the filtered sources, got %q", result.DisplayText)
+			}
+
+			metadata := result.Metadata
+			if !slices.Equal(metadata.AllowedDomains, tt.opts.AllowedDomains) || !slices.Equal(metadata.BlockedDomains, tt.opts.BlockedDomains) || metadata.SearchRegion != tt.opts.SearchRegion {
+				t.Errorf("Expected options to be reported in metadata, got %+v", metadata)
+			}
+		})
+	}
+
+	if query := searcher.buildSearchQuery("golang", "JP"); !strings.Contains(query, "region: JP") {
+		t.Errorf("Expected search query to mention the region, got %q", query)
+	}
+}
+
+func TestSearchCandidates(t *testing.T) {
+	// candidate returns a CodeAssist candidate whose text cites all of its sources
+	candidate := func(text, finishReason string, uris ...string) map[string]any {
+		c := map[string]any{
+			"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+			"finishReason": finishReason,
+		}
+		if len(uris) > 0 {
+			var chunks []any
+			var indices []int
+			for i, uri := range uris {
+				chunks = append(chunks, map[string]any{"web": map[string]any{"uri": uri, "title": "Source " + uri}})
+				indices = append(indices, i)
+			}
+			c["groundingMetadata"] = map[string]any{
+				"groundingChunks": chunks,
+				"groundingSupports": []any{map[string]any{
+					"segment":               map[string]any{"startIndex": 0, "endIndex": len(text), "text": text},
+					"groundingChunkIndices": indices,
+				}},
+			}
+		}
+		return c
+	}
+
+	var candidateCount int
+	server := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Request struct {
+				GenerationConfig struct {
+					CandidateCount int `json:"candidateCount"`
+				} `json:"generationConfig"`
+			} `json:"request"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		candidateCount = req.Request.GenerationConfig.CandidateCount
+		_ = json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{"candidates": []any{
+			candidate("Cut short", "MAX_TOKENS", "https://a.example.com/"),
+			candidate("Ungrounded answer", "STOP"),
+			candidate("Grounded answer", "STOP", "https://b.example.com/", "https://c.example.com/"),
+		}}})
+	})
+
+	searcher, err := NewWebSearcher(newTestConfig(server.URL))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+	result, err := searcher.SearchWithOptions(context.Background(), "golang", types.SearchOptions{CandidateCount: 3})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned error: %v", err)
+	}
+	if candidateCount != 3 {
+		t.Errorf("Expected candidateCount 3 in the request, got %d", candidateCount)
+	}
+
+	// Completed and grounded answers rank first
+	var order []string
+	for _, c := range result.Candidates {
+		order = append(order, c.Content)
+	}
+	if want := []string{"Grounded answer", "Ungrounded answer", "Cut short"}; !slices.Equal(order, want) {
+		t.Fatalf("Candidates = %q, want %q", order, want)
+	}
+
+	// Each candidate keeps its own sources and citations
+	best, ungrounded, cut := result.Candidates[0], result.Candidates[1], result.Candidates[2]
+	if len(best.Sources) != 2 || best.Sources[0].Web.URI != "https://b.example.com/" || best.FinishReason != "STOP" {
+		t.Errorf("Unexpected best candidate: %+v", best)
+	}
+	if !strings.Contains(best.DisplayText, "b.example.com") || strings.Contains(best.DisplayText, "a.example.com") {
+		t.Errorf("Unexpected display text of the best candidate: %q", best.DisplayText)
+	}
+	if len(ungrounded.Sources) != 0 || ungrounded.DisplayText != "Ungrounded answer" {
+		t.Errorf("Unexpected ungrounded candidate: %+v", ungrounded)
+	}
+	if len(cut.Sources) != 1 || cut.Sources[0].Web.URI != "https://a.example.com/" || !strings.Contains(cut.DisplayText, "a.example.com") {
+		t.Errorf("Unexpected cut short candidate: %+v", cut)
+	}
+
+	// The primary fields describe the best candidate
+	if result.Content != best.Content || result.DisplayText != best.DisplayText || len(result.Sources) != 2 || result.Metadata.SourceCount != 2 {
+		t.Errorf("Primary fields do not match the best candidate: %+v", result)
+	}
+
+	// Without a candidate count, a single answer is requested and described
+	result, err = searcher.Search(context.Background(), "golang")
+	if err != nil {
+		t.Fatalf("Search returned error: %v", err)
+	}
+	if candidateCount != 0 || result.Candidates != nil || result.Content != "Cut short" {
+		t.Errorf("Expected a single-answer search, got candidateCount %d and result %+v", candidateCount, result)
+	}
+}
+
+func TestSearchWithHistory(t *testing.T) {
+	var calls atomic.Int32
+	var contents []types.CodeAssistContent
+	codeAssist := newFakeCodeAssistServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req types.CodeAssistGenerateContentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		contents = req.Request.Contents
+		writeGroundedCodeAssistText(w, "Go 1.24 added generic type aliases.")
+	})
+
+	searcher, err := NewWebSearcher(newTestConfig(codeAssist.URL))
+	if err != nil {
+		t.Fatalf("Failed to create searcher: %v", err)
+	}
+
+	history := []types.Content{
+		{Role: "user", Parts: []types.Part{{Text: "What is new in Go 1.24?"}}},
+		{Role: "model", Parts: []types.Part{{Text: "Go 1.24 was released in February 2025."}}},
+	}
+	if _, err := searcher.SearchWithHistory(context.Background(), history, "Which changes affect generics?"); err != nil {
+		t.Fatalf("SearchWithHistory failed: %v", err)
+	}
+	if len(contents) != 3 || contents[0].Parts[0].Text != history[0].Parts[0].Text || contents[1].Role != "model" ||
+		contents[2].Role != "user" || contents[2].Parts[0].Text != "Which changes affect generics?" {
+		t.Errorf("Unexpected contents: %+v", contents)
+	}
+
+	invalid := [][]types.Content{
+		{{Role: "system", Parts: []types.Part{{Text: "Be brief."}}}},
+		{{Role: "", Parts: []types.Part{{Text: "Hello"}}}},
+	}
+	for _, history := range invalid {
+		if _, err := searcher.SearchWithHistory(context.Background(), history, "golang"); err == nil {
+			t.Errorf("Expected an error for history %+v", history)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected invalid histories not to be sent, got %d calls", got)
+	}
+}